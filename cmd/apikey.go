@@ -0,0 +1,83 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"url-shortener/internal/services"
+)
+
+const apiKeyUsage = `usage:
+  main apikey create -owner <id> [-name <label>]
+  main apikey list -owner <id>
+  main apikey revoke -owner <id> -id <key id>`
+
+// runAPIKeyCommand handles the "apikey" subcommand used to mint, list and
+// revoke API keys without going through the HTTP API.
+func runAPIKeyCommand(svc *services.APIKeyService, args []string) error {
+	if len(args) == 0 {
+		return errors.New(apiKeyUsage)
+	}
+
+	fs := flag.NewFlagSet("apikey "+args[0], flag.ContinueOnError)
+	owner := fs.String("owner", "", "owner ID the key belongs to")
+	name := fs.String("name", "", "label to recognise the key by")
+	id := fs.Int("id", 0, "ID of the key to revoke")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	if *owner == "" {
+		return fmt.Errorf("-owner is required\n%s", apiKeyUsage)
+	}
+
+	switch args[0] {
+	case "create":
+		plaintext, key, err := svc.CreateKey(*owner, *name)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Created API key %d for %s\n", key.ID, key.OwnerID)
+		fmt.Printf("Key: %s\n", plaintext)
+		fmt.Println("Store it now, it will not be shown again.")
+
+	case "list":
+		keys, err := svc.ListKeys(*owner)
+		if err != nil {
+			return err
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tNAME\tPREFIX\tCREATED\tLAST USED\tREVOKED")
+		for _, key := range keys {
+			fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\t%s\n", key.ID, key.Name, key.Prefix,
+				key.CreatedAt.Format(time.RFC3339), formatOptionalTime(key.LastUsedAt),
+				formatOptionalTime(key.RevokedAt))
+		}
+		tw.Flush()
+
+	case "revoke":
+		if *id == 0 {
+			return fmt.Errorf("-id is required\n%s", apiKeyUsage)
+		}
+		if err := svc.RevokeKey(*owner, *id); err != nil {
+			return err
+		}
+		fmt.Printf("Revoked API key %d\n", *id)
+
+	default:
+		return fmt.Errorf("unknown apikey command %q\n%s", args[0], apiKeyUsage)
+	}
+
+	return nil
+}
+
+func formatOptionalTime(t *time.Time) string {
+	if t == nil {
+		return "-"
+	}
+	return t.Format(time.RFC3339)
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"log"
+
+	"url-shortener/internal/config"
+	"url-shortener/internal/geoip"
+)
+
+// newGeoIPProvider builds the configured GeoIP provider. The returned func
+// releases its resources.
+func newGeoIPProvider(cfg config.GeoIP) (geoip.Provider, func(), error) {
+	switch cfg.EffectiveProvider() {
+	case "mmdb":
+		p, err := geoip.OpenMMDB(cfg.DBPath, cfg.ASNDBPath)
+		if err != nil {
+			return nil, nil, err
+		}
+		log.Printf("Resolving click locations from %s", cfg.DBPath)
+		return p, func() { p.Close() }, nil
+	case "ip-api":
+		log.Printf("Resolving click locations with %s", cfg.IPAPIURL)
+		return geoip.NewIPAPIProvider(cfg.IPAPIURL, cfg.IPAPITimeout), func() {}, nil
+	default:
+		return geoip.None{}, func() {}, nil
+	}
+}
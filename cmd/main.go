@@ -1,45 +1,142 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	// Embedded time zones let the analytics tz parameter work on hosts
+	// without a zoneinfo database.
+	_ "time/tzdata"
 
+	"url-shortener/internal/config"
 	"url-shortener/internal/database"
 	"url-shortener/internal/handlers"
 	"url-shortener/internal/middleware"
+	"url-shortener/internal/server"
 	"url-shortener/internal/services"
+	"url-shortener/internal/store"
 
 	"github.com/gorilla/mux"
 )
 
 func main() {
-	db, err := database.InitDB()
+	// Flags come before any subcommand, e.g. main -config app.yaml migrate up
+	cfg, args, err := config.Load(os.Args[1:])
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if len(args) > 0 && args[0] == "migrate" {
+		if err := runMigrateCommand(cfg.Database, args[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	db, err := database.InitDB(cfg.Database)
 	if err != nil {
 		log.Fatal("Failed to initialize database:", err)
 	}
 	defer db.Close()
 
-	urlService := services.NewURLService(db)
-	analyticsService := services.NewAnalyticsService(db)
-	urlHandler := handlers.NewURLHandler(urlService, analyticsService)
+	if len(args) > 0 {
+		var err error
+		switch args[0] {
+		case "apikey":
+			err = runAPIKeyCommand(services.NewAPIKeyService(db), args[1:])
+		case "user":
+			err = runUserCommand(services.NewUserService(db), args[1:])
+		case "team":
+			err = runTeamCommand(services.NewTeamService(db), args[1:])
+		default:
+			err = fmt.Errorf("unknown command %q (want migrate, apikey, user or team)", args[0])
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	var linkStore store.Store = store.New(db)
+	if cfg.Database.LinkStore == "memory" {
+		// Links and clicks are lost on restart; accounts stay in the database.
+		linkStore = store.NewMemoryStore()
+		log.Println("Keeping links and clicks in memory")
+	}
+	geo, closeGeo, err := newGeoIPProvider(cfg.GeoIP)
+	if err != nil {
+		log.Fatal("Failed to set up GeoIP:", err)
+	}
+	defer closeGeo()
+
+	apiKeyService := services.NewAPIKeyService(db)
+	userService := services.NewUserService(db)
+	teamService := services.NewTeamService(db)
+	urlService := services.NewURLService(linkStore, teamService, cfg.Links)
+	analyticsService := services.NewAnalyticsService(linkStore, linkStore, teamService, geo)
+	authMiddleware := middleware.AuthMiddleware(apiKeyService)
+	sessionMiddleware := middleware.SessionMiddleware(userService)
+	clickQueue := services.NewClickQueue(analyticsService, cfg.ClickQueue)
+	if cfg.Unlock.Secret == "" {
+		log.Println("UNLOCK_SECRET is not set; unlocked links must be unlocked again after a restart")
+	}
+	unlockService := services.NewUnlockService(cfg.Unlock)
+	urlHandler := handlers.NewURLHandler(urlService, analyticsService, clickQueue, unlockService, cfg.QR)
+	authHandler := handlers.NewAuthHandler(userService)
+	teamHandler := handlers.NewTeamHandler(teamService)
 	router := mux.NewRouter()
 
 	api := router.PathPrefix("/api/v1").Subrouter()
-	api.Use(middleware.RateLimitMiddleware)
+	api.Use(middleware.RateLimitMiddleware(cfg.RateLimit))
 	api.Use(middleware.CORSMiddleware)
+	api.Use(authMiddleware)
 
 	api.HandleFunc("/shorten", urlHandler.ShortenURL).Methods("POST")
 	api.HandleFunc("/analytics/{shortCode}", urlHandler.GetAnalytics).Methods("GET")
 	api.HandleFunc("/urls", urlHandler.GetUserURLs).Methods("GET")
+	api.HandleFunc("/urls/{shortCode}", urlHandler.UpdateURL).Methods("PATCH")
+	api.HandleFunc("/urls/{shortCode}", urlHandler.DeleteURL).Methods("DELETE")
 	api.HandleFunc("/qr/{shortCode}", urlHandler.GenerateQRCode).Methods("GET")
+	api.HandleFunc("/conversions", urlHandler.RecordConversion).Methods("POST")
+	api.HandleFunc("/conversions/pixel.gif", urlHandler.ConversionPixel).Methods("GET")
+	api.HandleFunc("/campaigns", urlHandler.GetCampaigns).Methods("GET")
+	api.HandleFunc("/teams", teamHandler.ListTeams).Methods("GET")
+	api.HandleFunc("/teams", teamHandler.CreateTeam).Methods("POST")
+	api.HandleFunc("/teams/{teamID}/members/{username}", teamHandler.SetMember).Methods("PUT")
+	api.HandleFunc("/teams/{teamID}/members/{username}", teamHandler.RemoveMember).Methods("DELETE")
 
 	router.HandleFunc("/", urlHandler.HomePage).Methods("GET")
-	router.HandleFunc("/dashboard", urlHandler.Dashboard).Methods("GET")
-	router.HandleFunc("/analytics/{shortCode}", urlHandler.AnalyticsPage).Methods("GET")
+	router.HandleFunc("/login", authHandler.LoginPage).Methods("GET")
+	router.HandleFunc("/login", authHandler.Login).Methods("POST")
+	router.HandleFunc("/logout", authHandler.Logout).Methods("POST")
+	router.Handle("/dashboard", sessionMiddleware(http.HandlerFunc(urlHandler.Dashboard))).Methods("GET")
+	router.Handle("/analytics/{shortCode}", sessionMiddleware(http.HandlerFunc(urlHandler.AnalyticsPage))).Methods("GET")
+	router.HandleFunc("/metrics", handlers.Metrics(clickQueue)).Methods("GET")
 	router.PathPrefix("/static/").Handler(http.StripPrefix("/static/", http.FileServer(http.Dir("./web/static/"))))
 	
-	router.HandleFunc("/{shortCode}", urlHandler.RedirectURL).Methods("GET")
+	router.HandleFunc("/{shortCode}", urlHandler.RedirectURL).Methods("GET", "HEAD")
+	router.HandleFunc("/{shortCode}", urlHandler.UnlockURL).Methods("POST")
+
+	// Ctrl-C or SIGTERM drains in-flight requests, then the click queue,
+	// before the deferred closes run.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	log.Println("Server starting on :8080")
-	log.Fatal(http.ListenAndServe(":8080", router))
+	serveErr := server.Run(ctx, router, cfg.Server)
+
+	drainCtx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
+	defer cancel()
+	if err := clickQueue.Close(drainCtx); err != nil {
+		log.Printf("Click queue did not drain: %v", err)
+	}
+
+	if serveErr != nil {
+		db.Close()
+		log.Fatal("Server error: ", serveErr)
+	}
+	log.Println("Server stopped")
 }
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"url-shortener/internal/models"
+	"url-shortener/internal/services"
+)
+
+const userUsage = `usage:
+  main user create -username <name> [-password <password>]`
+
+const teamUsage = `usage:
+  main team create -name <team> -admin <username>
+  main team add-member -team <id> -username <name> -role viewer|editor|admin`
+
+// runUserCommand handles the "user" subcommand. Without -password the
+// password is read from the first line of stdin so it stays out of shell
+// history.
+func runUserCommand(svc *services.UserService, args []string) error {
+	if len(args) == 0 || args[0] != "create" {
+		return errors.New(userUsage)
+	}
+
+	fs := flag.NewFlagSet("user create", flag.ContinueOnError)
+	username := fs.String("username", "", "login name, also used as owner ID")
+	password := fs.String("password", "", "password (read from stdin if empty)")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	if *password == "" {
+		fmt.Fprint(os.Stderr, "Password: ")
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			return fmt.Errorf("failed to read password: %v", err)
+		}
+		*password = strings.TrimRight(line, "\r\n")
+	}
+
+	user, err := svc.CreateUser(*username, *password)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Created user %s (id %d)\n", user.Username, user.ID)
+	return nil
+}
+
+// runTeamCommand handles the "team" subcommand used to bootstrap teams
+// before anyone can manage them through the API.
+func runTeamCommand(svc *services.TeamService, args []string) error {
+	if len(args) == 0 {
+		return errors.New(teamUsage)
+	}
+
+	fs := flag.NewFlagSet("team "+args[0], flag.ContinueOnError)
+	name := fs.String("name", "", "team name")
+	admin := fs.String("admin", "", "username of the first team admin")
+	teamID := fs.Int("team", 0, "team ID")
+	username := fs.String("username", "", "username of the member")
+	role := fs.String("role", "", "viewer, editor or admin")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "create":
+		team, err := svc.CreateTeam(*name, *admin)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Created team %s (id %d) with admin %s\n", team.Name, team.ID, *admin)
+
+	case "add-member":
+		if err := svc.SetMember(*teamID, *username, models.Role(*role), ""); err != nil {
+			return err
+		}
+		fmt.Printf("%s is now %s of team %d\n", *username, *role, *teamID)
+
+	default:
+		return fmt.Errorf("unknown team command %q\n%s", args[0], teamUsage)
+	}
+
+	return nil
+}
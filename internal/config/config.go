@@ -0,0 +1,399 @@
+// Package config loads the application settings. Values start from Default,
+// are overlaid by a YAML or TOML file, then by environment variables, then
+// by command-line flags, and are validated before use.
+package config
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+type Config struct {
+	Server     Server     `yaml:"server" toml:"server"`
+	Database   Database   `yaml:"database" toml:"database"`
+	Links      Links      `yaml:"links" toml:"links"`
+	RateLimit  RateLimit  `yaml:"rate_limit" toml:"rate_limit"`
+	GeoIP      GeoIP      `yaml:"geoip" toml:"geoip"`
+	ClickQueue ClickQueue `yaml:"click_queue" toml:"click_queue"`
+	QR         QR         `yaml:"qr" toml:"qr"`
+	Unlock     Unlock     `yaml:"unlock" toml:"unlock"`
+}
+
+// Server holds the HTTP listener settings.
+type Server struct {
+	Addr              string        `yaml:"addr" toml:"addr"`
+	ReadTimeout       time.Duration `yaml:"read_timeout" toml:"read_timeout"`
+	ReadHeaderTimeout time.Duration `yaml:"read_header_timeout" toml:"read_header_timeout"`
+	WriteTimeout      time.Duration `yaml:"write_timeout" toml:"write_timeout"`
+	IdleTimeout       time.Duration `yaml:"idle_timeout" toml:"idle_timeout"`
+	MaxHeaderBytes    int           `yaml:"max_header_bytes" toml:"max_header_bytes"`
+	ShutdownTimeout   time.Duration `yaml:"shutdown_timeout" toml:"shutdown_timeout"`
+	// TLSCertFile and TLSKeyFile enable HTTPS when both are set.
+	TLSCertFile string `yaml:"tls_cert_file" toml:"tls_cert_file"`
+	TLSKeyFile  string `yaml:"tls_key_file" toml:"tls_key_file"`
+}
+
+// Database selects the database. Path is the SQLite file, or the
+// connection string for PostgreSQL. LinkStore "memory" keeps links and
+// clicks in process memory instead.
+type Database struct {
+	Driver    string `yaml:"driver" toml:"driver"`
+	Path      string `yaml:"path" toml:"path"`
+	LinkStore string `yaml:"link_store" toml:"link_store"`
+}
+
+// Links configures generated short codes and the redirect status used
+// when a link does not choose one. FallbackURL, when set, is where
+// expired, deleted and used-up links without their own fallback send
+// visitors.
+type Links struct {
+	ShortCodeLength int    `yaml:"short_code_length" toml:"short_code_length"`
+	MaxRetries      int    `yaml:"max_retries" toml:"max_retries"`
+	RedirectType    int    `yaml:"redirect_type" toml:"redirect_type"`
+	FallbackURL     string `yaml:"fallback_url" toml:"fallback_url"`
+}
+
+// RateLimit allows Requests per Window from each client IP on the API.
+type RateLimit struct {
+	Requests int           `yaml:"requests" toml:"requests"`
+	Window   time.Duration `yaml:"window" toml:"window"`
+}
+
+// GeoIP selects the location provider: "none", "mmdb" or "ip-api". An
+// empty Provider means mmdb when DBPath is set and none otherwise.
+type GeoIP struct {
+	Provider     string        `yaml:"provider" toml:"provider"`
+	DBPath       string        `yaml:"db_path" toml:"db_path"`
+	ASNDBPath    string        `yaml:"asn_db_path" toml:"asn_db_path"`
+	IPAPIURL     string        `yaml:"ipapi_url" toml:"ipapi_url"`
+	IPAPITimeout time.Duration `yaml:"ipapi_timeout" toml:"ipapi_timeout"`
+}
+
+// ClickQueue sizes the asynchronous click pipeline.
+type ClickQueue struct {
+	Size          int           `yaml:"size" toml:"size"`
+	Workers       int           `yaml:"workers" toml:"workers"`
+	BatchSize     int           `yaml:"batch_size" toml:"batch_size"`
+	FlushInterval time.Duration `yaml:"flush_interval" toml:"flush_interval"`
+}
+
+// Unlock configures password-protected links. Secret signs the cookie
+// that remembers an unlocked link; when empty a random secret is used, so
+// visitors must unlock again after a restart. A visitor gets MaxAttempts
+// wrong passwords per link within Window before being locked out.
+// Visitors are told apart by the address they connect from, unless it is
+// one of TrustedProxies, a comma-separated list of IPs and CIDR ranges
+// whose X-Forwarded-For headers are believed.
+type Unlock struct {
+	Secret         string        `yaml:"secret" toml:"secret"`
+	CookieTTL      time.Duration `yaml:"cookie_ttl" toml:"cookie_ttl"`
+	MaxAttempts    int           `yaml:"max_attempts" toml:"max_attempts"`
+	Window         time.Duration `yaml:"window" toml:"window"`
+	TrustedProxies string        `yaml:"trusted_proxies" toml:"trusted_proxies"`
+}
+
+// QR configures generated QR codes.
+type QR struct {
+	Size int `yaml:"size" toml:"size"`
+}
+
+// Default returns the built-in settings.
+func Default() Config {
+	return Config{
+		Server: Server{
+			Addr:              ":8080",
+			ReadTimeout:       15 * time.Second,
+			ReadHeaderTimeout: 5 * time.Second,
+			WriteTimeout:      30 * time.Second,
+			IdleTimeout:       120 * time.Second,
+			MaxHeaderBytes:    1 << 20,
+			ShutdownTimeout:   15 * time.Second,
+		},
+		Database: Database{
+			Driver:    "sqlite",
+			Path:      "./url_shortener.db",
+			LinkStore: "sql",
+		},
+		Links: Links{
+			ShortCodeLength: 6,
+			MaxRetries:      5,
+			RedirectType:    302,
+		},
+		RateLimit: RateLimit{
+			Requests: 10,
+			Window:   time.Minute,
+		},
+		GeoIP: GeoIP{
+			IPAPIURL:     "http://ip-api.com/json/",
+			IPAPITimeout: 2 * time.Second,
+		},
+		ClickQueue: ClickQueue{
+			Size:          10000,
+			Workers:       4,
+			BatchSize:     100,
+			FlushInterval: time.Second,
+		},
+		QR: QR{
+			Size: 256,
+		},
+		Unlock: Unlock{
+			CookieTTL:   24 * time.Hour,
+			MaxAttempts: 5,
+			Window:      15 * time.Minute,
+		},
+	}
+}
+
+// setting ties a value to its environment variable and flag.
+type setting struct {
+	env    string
+	flag   string
+	usage  string
+	target interface{} // *string, *int or *time.Duration
+}
+
+func (c *Config) settings() []setting {
+	return []setting{
+		{"ADDR", "addr", "listen address", &c.Server.Addr},
+		{"READ_TIMEOUT", "read-timeout", "time to read a whole request", &c.Server.ReadTimeout},
+		{"READ_HEADER_TIMEOUT", "read-header-timeout", "time to read request headers", &c.Server.ReadHeaderTimeout},
+		{"WRITE_TIMEOUT", "write-timeout", "time to write a response", &c.Server.WriteTimeout},
+		{"IDLE_TIMEOUT", "idle-timeout", "keep-alive idle time", &c.Server.IdleTimeout},
+		{"MAX_HEADER_BYTES", "max-header-bytes", "largest accepted request header", &c.Server.MaxHeaderBytes},
+		{"SHUTDOWN_TIMEOUT", "shutdown-timeout", "time allowed to drain on shutdown", &c.Server.ShutdownTimeout},
+		{"TLS_CERT_FILE", "tls-cert", "TLS certificate file", &c.Server.TLSCertFile},
+		{"TLS_KEY_FILE", "tls-key", "TLS key file", &c.Server.TLSKeyFile},
+		{"DB_DRIVER", "db-driver", "database driver (sqlite or postgres)", &c.Database.Driver},
+		{"DB_PATH", "db-path", "SQLite file or PostgreSQL connection string", &c.Database.Path},
+		{"LINK_STORE", "link-store", "where links and clicks live (sql or memory)", &c.Database.LinkStore},
+		{"SHORT_CODE_LENGTH", "short-code-length", "length of generated short codes", &c.Links.ShortCodeLength},
+		{"SHORT_CODE_MAX_RETRIES", "short-code-max-retries", "attempts to find an unused short code", &c.Links.MaxRetries},
+		{"DEFAULT_REDIRECT_TYPE", "default-redirect-type", "redirect status for new links (301, 302, 307 or 308)", &c.Links.RedirectType},
+		{"FALLBACK_URL", "fallback-url", "where unusable links send visitors without their own fallback", &c.Links.FallbackURL},
+		{"RATE_LIMIT_REQUESTS", "rate-limit-requests", "API requests allowed per window and IP", &c.RateLimit.Requests},
+		{"RATE_LIMIT_WINDOW", "rate-limit-window", "rate limit window", &c.RateLimit.Window},
+		{"GEOIP_PROVIDER", "geoip-provider", "GeoIP provider (none, mmdb or ip-api)", &c.GeoIP.Provider},
+		{"GEOIP_DB", "geoip-db", "GeoIP .mmdb file", &c.GeoIP.DBPath},
+		{"GEOIP_ASN_DB", "geoip-asn-db", "GeoIP ASN .mmdb file", &c.GeoIP.ASNDBPath},
+		{"GEOIP_IPAPI_URL", "geoip-ipapi-url", "ip-api.com lookup URL", &c.GeoIP.IPAPIURL},
+		{"GEOIP_IPAPI_TIMEOUT", "geoip-ipapi-timeout", "ip-api.com request timeout", &c.GeoIP.IPAPITimeout},
+		{"CLICK_QUEUE_SIZE", "click-queue-size", "clicks buffered before dropping", &c.ClickQueue.Size},
+		{"CLICK_QUEUE_WORKERS", "click-queue-workers", "click writer goroutines", &c.ClickQueue.Workers},
+		{"CLICK_BATCH_SIZE", "click-batch-size", "clicks written per transaction", &c.ClickQueue.BatchSize},
+		{"CLICK_FLUSH_INTERVAL", "click-flush-interval", "longest a click waits for a batch", &c.ClickQueue.FlushInterval},
+		{"QR_SIZE", "qr-size", "QR code size in pixels", &c.QR.Size},
+		{"UNLOCK_SECRET", "unlock-secret", "secret signing unlock cookies of protected links", &c.Unlock.Secret},
+		{"UNLOCK_COOKIE_TTL", "unlock-cookie-ttl", "how long an unlocked link stays unlocked", &c.Unlock.CookieTTL},
+		{"UNLOCK_MAX_ATTEMPTS", "unlock-max-attempts", "wrong passwords allowed per link and visitor", &c.Unlock.MaxAttempts},
+		{"UNLOCK_WINDOW", "unlock-window", "window for counting wrong passwords", &c.Unlock.Window},
+		{"UNLOCK_TRUSTED_PROXIES", "unlock-trusted-proxies", "proxies whose X-Forwarded-For identifies visitors", &c.Unlock.TrustedProxies},
+	}
+}
+
+// Load builds the configuration from args (usually os.Args[1:]) and the
+// environment. The file comes from -config or CONFIG_FILE. It returns the
+// arguments left after the flags, such as a subcommand.
+func Load(args []string) (*Config, []string, error) {
+	cfg := Default()
+	settings := cfg.settings()
+
+	// Flags are applied last, so collect them first and replay them.
+	fs := flag.NewFlagSet("url-shortener", flag.ContinueOnError)
+	configFile := fs.String("config", os.Getenv("CONFIG_FILE"), "YAML or TOML config file")
+	var flagged []func() error
+	for _, s := range settings {
+		s := s
+		fs.Func(s.flag, s.usage+" ($"+s.env+")", func(value string) error {
+			flagged = append(flagged, func() error { return s.set(value) })
+			return nil
+		})
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, nil, err
+	}
+
+	if *configFile != "" {
+		if err := cfg.loadFile(*configFile); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	for _, s := range settings {
+		if value, ok := os.LookupEnv(s.env); ok && value != "" {
+			if err := s.set(value); err != nil {
+				return nil, nil, fmt.Errorf("%s: %v", s.env, err)
+			}
+		}
+	}
+
+	for _, apply := range flagged {
+		if err := apply(); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, nil, err
+	}
+
+	return &cfg, fs.Args(), nil
+}
+
+// loadFile overlays a .yaml, .yml or .toml file. Keys it leaves out keep
+// their current values.
+func (c *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %v", err)
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, c)
+	case ".toml":
+		_, err = toml.Decode(string(data), c)
+	default:
+		return fmt.Errorf("config file %s must end in .yaml, .yml or .toml", path)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to parse %s: %v", path, err)
+	}
+
+	return nil
+}
+
+func (s setting) set(value string) error {
+	switch target := s.target.(type) {
+	case *string:
+		*target = value
+	case *int:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("invalid number %q", value)
+		}
+		*target = n
+	case *time.Duration:
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q", value)
+		}
+		*target = d
+	}
+	return nil
+}
+
+// Validate reports every invalid setting in one error.
+func (c *Config) Validate() error {
+	var problems []string
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			problems = append(problems, fmt.Sprintf(format, args...))
+		}
+	}
+
+	check(c.Server.Addr != "", "server.addr is required")
+	check(c.Server.ReadTimeout >= 0 && c.Server.ReadHeaderTimeout >= 0 &&
+		c.Server.WriteTimeout >= 0 && c.Server.IdleTimeout >= 0, "server timeouts must not be negative")
+	check(c.Server.MaxHeaderBytes > 0, "server.max_header_bytes must be positive")
+	check(c.Server.ShutdownTimeout > 0, "server.shutdown_timeout must be positive")
+	check((c.Server.TLSCertFile == "") == (c.Server.TLSKeyFile == ""),
+		"server.tls_cert_file and server.tls_key_file must be set together")
+
+	check(c.Database.Driver == "sqlite" || c.Database.Driver == "postgres",
+		"database.driver must be sqlite or postgres, got %q", c.Database.Driver)
+	check(c.Database.Path != "", "database.path is required")
+	check(c.Database.LinkStore == "sql" || c.Database.LinkStore == "memory",
+		"database.link_store must be sql or memory, got %q", c.Database.LinkStore)
+
+	check(c.Links.ShortCodeLength >= 4 && c.Links.ShortCodeLength <= 20,
+		"links.short_code_length must be between 4 and 20")
+	check(c.Links.MaxRetries > 0, "links.max_retries must be positive")
+	switch c.Links.RedirectType {
+	case 301, 302, 307, 308:
+	default:
+		check(false, "links.redirect_type must be 301, 302, 307 or 308, got %d", c.Links.RedirectType)
+	}
+	check(c.Links.FallbackURL == "" || strings.HasPrefix(c.Links.FallbackURL, "http://") ||
+		strings.HasPrefix(c.Links.FallbackURL, "https://"), "links.fallback_url must start with http:// or https://")
+
+	check(c.RateLimit.Requests > 0, "rate_limit.requests must be positive")
+	check(c.RateLimit.Window > 0, "rate_limit.window must be positive")
+
+	switch c.GeoIP.Provider {
+	case "", "none", "ip-api":
+	case "mmdb":
+		check(c.GeoIP.DBPath != "", "geoip.db_path is required for the mmdb provider")
+	default:
+		check(false, "geoip.provider must be none, mmdb or ip-api, got %q", c.GeoIP.Provider)
+	}
+	check(c.GeoIP.IPAPITimeout > 0, "geoip.ipapi_timeout must be positive")
+
+	check(c.ClickQueue.Size > 0, "click_queue.size must be positive")
+	check(c.ClickQueue.Workers > 0, "click_queue.workers must be positive")
+	check(c.ClickQueue.BatchSize > 0, "click_queue.batch_size must be positive")
+	check(c.ClickQueue.FlushInterval > 0, "click_queue.flush_interval must be positive")
+
+	check(c.QR.Size >= 64 && c.QR.Size <= 2048, "qr.size must be between 64 and 2048")
+
+	check(c.Unlock.Secret == "" || len(c.Unlock.Secret) >= 16, "unlock.secret must be at least 16 characters")
+	check(c.Unlock.CookieTTL > 0, "unlock.cookie_ttl must be positive")
+	check(c.Unlock.MaxAttempts > 0, "unlock.max_attempts must be positive")
+	check(c.Unlock.Window > 0, "unlock.window must be positive")
+	if _, err := c.Unlock.ProxyNets(); err != nil {
+		check(false, "unlock.trusted_proxies: %v", err)
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid configuration: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// ProxyNets parses TrustedProxies. A bare IP is a network of one address.
+func (u Unlock) ProxyNets() ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, entry := range strings.Split(u.TrustedProxies, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP %q", entry)
+			}
+			bits := 128
+			if v4 := ip.To4(); v4 != nil {
+				ip, bits = v4, 32
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q", entry)
+		}
+		nets = append(nets, network)
+	}
+	return nets, nil
+}
+
+// EffectiveProvider resolves an empty Provider to mmdb or none.
+func (g GeoIP) EffectiveProvider() string {
+	if g.Provider != "" {
+		return g.Provider
+	}
+	if g.DBPath != "" {
+		return "mmdb"
+	}
+	return "none"
+}
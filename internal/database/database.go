@@ -4,92 +4,189 @@ import (
 	"database/sql"
 	"fmt"
 	"log"
-	"os"
+	"strconv"
+	"strings"
 
+	"url-shortener/internal/config"
+	"url-shortener/internal/migrations"
+
+	_ "github.com/lib/pq"
 	_ "modernc.org/sqlite"
 )
 
-// DB wraps the sql.DB connection
+// Supported values of DB_DRIVER
+const (
+	DriverSQLite   = "sqlite"
+	DriverPostgres = "postgres"
+)
+
+// DB wraps the sql.DB connection. Exec, Query and QueryRow accept queries
+// written with ? placeholders and rewrite them for the active driver.
 type DB struct {
 	*sql.DB
+	Driver string
 }
 
-// InitDB initializes the database connection and creates tables
-func InitDB() (*DB, error) {
-	db, err := initSQLite()
+// InitDB opens the database and applies any pending schema migrations
+func InitDB(cfg config.Database) (*DB, error) {
+	db, err := Open(cfg)
 	if err != nil {
 		return nil, err
 	}
 
-	wrapper := &DB{db}
+	applied, err := migrations.ForDriver(db.DB, db.Driver).Up()
+	for _, migration := range applied {
+		log.Printf("Applied migration %d (%s)", migration.Version, migration.Name)
+	}
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return db, nil
+}
+
+// Open opens the database selected by cfg.Driver without touching its schema.
+// cfg.Path is the SQLite file path, or the connection string for PostgreSQL.
+// The migrate command uses Open so it can roll back without migrating up.
+func Open(cfg config.Database) (*DB, error) {
+	driver := cfg.Driver
+
+	var db *sql.DB
+	var err error
+	switch driver {
+	case DriverSQLite:
+		db, err = initSQLite(cfg.Path)
+	case DriverPostgres:
+		db, err = initPostgres(cfg.Path)
+	default:
+		return nil, fmt.Errorf("unsupported database driver %q (want %s or %s)", driver, DriverSQLite, DriverPostgres)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %v", err)
+	}
 
-	// Create tables
-	if err := wrapper.createTables(); err != nil {
+	return &DB{DB: db, Driver: driver}, nil
+}
+
+func initSQLite(dbPath string) (*sql.DB, error) {
+	db, err := sql.Open("sqlite", dbPath)
+	if err != nil {
 		return nil, err
 	}
+	// Every connection to ":memory:" gets its own empty database, so keep
+	// a single one.
+	if dbPath == ":memory:" {
+		db.SetMaxOpenConns(1)
+	}
+	return db, nil
+}
+
+func initPostgres(dsn string) (*sql.DB, error) {
+	if dsn == "" {
+		return nil, fmt.Errorf("the database path must hold a PostgreSQL connection string")
+	}
 
-	return wrapper, nil
-}
-
-func initSQLite() (*sql.DB, error) {
-	dbPath := getEnv("DB_PATH", "./url_shortener.db")
-	return sql.Open("sqlite", dbPath)
-}
-
-func (db *DB) createTables() error {
-	urlsTable := `
-	CREATE TABLE IF NOT EXISTS urls (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		short_code VARCHAR(20) UNIQUE NOT NULL,
-		original_url TEXT NOT NULL,
-		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-		expires_at DATETIME,
-		click_count INTEGER DEFAULT 0,
-		user_ip VARCHAR(45),
-		is_custom BOOLEAN DEFAULT FALSE
-	);`
-
-	clicksTable := `
-	CREATE TABLE IF NOT EXISTS clicks (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		url_short_code VARCHAR(20) NOT NULL,
-		ip_address VARCHAR(45),
-		user_agent TEXT,
-		referer TEXT,
-		country VARCHAR(100),
-		city VARCHAR(100),
-		clicked_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-		FOREIGN KEY (url_short_code) REFERENCES urls(short_code)
-	);`
-
-	// Create indexes
-	indexes := []string{
-		"CREATE INDEX IF NOT EXISTS idx_urls_short_code ON urls(short_code);",
-		"CREATE INDEX IF NOT EXISTS idx_clicks_short_code ON clicks(url_short_code);",
-		"CREATE INDEX IF NOT EXISTS idx_clicks_clicked_at ON clicks(clicked_at);",
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, err
 	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
 
-	// Execute table creation
-	if _, err := db.Exec(urlsTable); err != nil {
-		return fmt.Errorf("failed to create urls table: %v", err)
+func (db *DB) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return db.DB.Exec(db.Rebind(query), args...)
+}
+
+func (db *DB) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return db.DB.Query(db.Rebind(query), args...)
+}
+
+func (db *DB) QueryRow(query string, args ...interface{}) *sql.Row {
+	return db.DB.QueryRow(db.Rebind(query), args...)
+}
+
+// Begin starts a transaction whose statements are rebound like the DB's.
+func (db *DB) Begin() (*Tx, error) {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return nil, err
 	}
+	return &Tx{Tx: tx, db: db}, nil
+}
+
+// InsertID runs an INSERT and returns the generated id column. PostgreSQL
+// has no LastInsertId, so the query gets a RETURNING clause there.
+func (db *DB) InsertID(query string, args ...interface{}) (int64, error) {
+	return insertID(db.Driver, db.DB, db.Rebind(query), args...)
+}
 
-	if _, err := db.Exec(clicksTable); err != nil {
-		return fmt.Errorf("failed to create clicks table: %v", err)
+// Rebind rewrites ? placeholders to $1, $2, ... for PostgreSQL. Question
+// marks inside single-quoted literals are left alone.
+func (db *DB) Rebind(query string) string {
+	if db.Driver != DriverPostgres {
+		return query
 	}
 
-	for _, index := range indexes {
-		if _, err := db.Exec(index); err != nil {
-			log.Printf("Warning: failed to create index: %v", err)
+	var b strings.Builder
+	n := 0
+	inString := false
+	for _, r := range query {
+		switch {
+		case r == '\'':
+			inString = !inString
+			b.WriteRune(r)
+		case r == '?' && !inString:
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+		default:
+			b.WriteRune(r)
 		}
 	}
+	return b.String()
+}
 
-	return nil
+// Tx wraps sql.Tx with the same placeholder rewriting as DB
+type Tx struct {
+	*sql.Tx
+	db *DB
 }
 
-func getEnv(key, defaultValue string) string {
-	if value := os.Getenv(key); value != "" {
-		return value
+func (tx *Tx) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return tx.Tx.Exec(tx.db.Rebind(query), args...)
+}
+
+func (tx *Tx) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return tx.Tx.Query(tx.db.Rebind(query), args...)
+}
+
+func (tx *Tx) QueryRow(query string, args ...interface{}) *sql.Row {
+	return tx.Tx.QueryRow(tx.db.Rebind(query), args...)
+}
+
+func (tx *Tx) InsertID(query string, args ...interface{}) (int64, error) {
+	return insertID(tx.db.Driver, tx.Tx, tx.db.Rebind(query), args...)
+}
+
+type execQuerier interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+func insertID(driver string, q execQuerier, query string, args ...interface{}) (int64, error) {
+	if driver == DriverPostgres {
+		var id int64
+		err := q.QueryRow(strings.TrimRight(strings.TrimSpace(query), ";")+" RETURNING id", args...).Scan(&id)
+		return id, err
+	}
+
+	result, err := q.Exec(query, args...)
+	if err != nil {
+		return 0, err
 	}
-	return defaultValue
+	return result.LastInsertId()
 }
@@ -0,0 +1,52 @@
+// Package geoip resolves visitor IP addresses to a location for click
+// analytics. Lookups never fail: anything that cannot be resolved is
+// reported as Unknown.
+package geoip
+
+// Unknown is reported for fields a provider cannot resolve.
+const Unknown = "Unknown"
+
+// Location is the result of a lookup. ASN is 0 when unknown. CountryCode
+// is the ISO 3166-1 alpha-2 code and ContinentCode one of AF, AN, AS, EU,
+// NA, OC or SA; both are empty when unknown.
+type Location struct {
+	Country       string
+	CountryCode   string
+	ContinentCode string
+	Region        string
+	City          string
+	ASN           uint
+	ASOrg         string
+}
+
+// UnknownLocation returns a Location with every name set to Unknown.
+func UnknownLocation() Location {
+	return Location{Country: Unknown, Region: Unknown, City: Unknown}
+}
+
+// Provider looks up the location of an IP address.
+type Provider interface {
+	Lookup(ip string) Location
+}
+
+// None is a Provider that resolves nothing. It is the default, so visitor
+// IPs never leave the server unless a provider is configured.
+type None struct{}
+
+func (None) Lookup(ip string) Location {
+	return UnknownLocation()
+}
+
+// fillUnknown replaces empty names with Unknown.
+func fillUnknown(loc Location) Location {
+	if loc.Country == "" {
+		loc.Country = Unknown
+	}
+	if loc.Region == "" {
+		loc.Region = Unknown
+	}
+	if loc.City == "" {
+		loc.City = Unknown
+	}
+	return loc
+}
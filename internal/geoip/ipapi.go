@@ -0,0 +1,77 @@
+package geoip
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// IPAPIProvider looks addresses up with the ip-api.com web service. It sends
+// visitor IPs to a third party over plain HTTP, so it is opt-in only.
+type IPAPIProvider struct {
+	baseURL string
+	client  *http.Client
+}
+
+// NewIPAPIProvider creates a provider querying baseURL followed by the IP,
+// such as http://ip-api.com/json/.
+func NewIPAPIProvider(baseURL string, timeout time.Duration) *IPAPIProvider {
+	return &IPAPIProvider{baseURL: baseURL, client: &http.Client{Timeout: timeout}}
+}
+
+// ipAPIFields asks for the continent, which ip-api.com leaves out by default.
+const ipAPIFields = "status,country,countryCode,continentCode,regionName,city,as"
+
+type ipAPIResponse struct {
+	Country       string `json:"country"`
+	CountryCode   string `json:"countryCode"`
+	ContinentCode string `json:"continentCode"`
+	RegionName    string `json:"regionName"`
+	City          string `json:"city"`
+	AS            string `json:"as"`
+	Status        string `json:"status"`
+}
+
+func (p *IPAPIProvider) Lookup(ip string) Location {
+	resp, err := p.client.Get(p.baseURL + ip + "?fields=" + ipAPIFields)
+	if err != nil {
+		fmt.Printf("Error fetching location for IP %s: %v\n", ip, err)
+		return UnknownLocation()
+	}
+	defer resp.Body.Close()
+
+	var data ipAPIResponse
+	if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
+		fmt.Printf("Error decoding response for IP %s: %v\n", ip, err)
+		return UnknownLocation()
+	}
+
+	if data.Status != "success" {
+		fmt.Printf("API returned non-success status for IP %s: %s\n", ip, data.Status)
+		return UnknownLocation()
+	}
+
+	loc := Location{
+		Country:       data.Country,
+		CountryCode:   data.CountryCode,
+		ContinentCode: data.ContinentCode,
+		Region:        data.RegionName,
+		City:          data.City,
+	}
+	loc.ASN, loc.ASOrg = parseAS(data.AS)
+
+	return fillUnknown(loc)
+}
+
+// parseAS splits ip-api's "AS15169 Google LLC" into number and organisation.
+func parseAS(as string) (uint, string) {
+	number, org, _ := strings.Cut(strings.TrimPrefix(as, "AS"), " ")
+	n, err := strconv.ParseUint(number, 10, 32)
+	if err != nil {
+		return 0, ""
+	}
+	return uint(n), org
+}
@@ -0,0 +1,109 @@
+package geoip
+
+import (
+	"fmt"
+	"log"
+	"net"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// MMDBProvider reads MaxMind-format (.mmdb) databases from disk, such as
+// GeoLite2-City and GeoLite2-ASN. No network access is needed.
+type MMDBProvider struct {
+	city *maxminddb.Reader
+	asn  *maxminddb.Reader
+}
+
+// mmdbRecord holds the fields used from City and ASN databases.
+type mmdbRecord struct {
+	Continent struct {
+		Code string `maxminddb:"code"`
+	} `maxminddb:"continent"`
+	Country struct {
+		ISOCode string            `maxminddb:"iso_code"`
+		Names   map[string]string `maxminddb:"names"`
+	} `maxminddb:"country"`
+	Subdivisions []struct {
+		ISOCode string            `maxminddb:"iso_code"`
+		Names   map[string]string `maxminddb:"names"`
+	} `maxminddb:"subdivisions"`
+	City struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"city"`
+	ASN   uint   `maxminddb:"autonomous_system_number"`
+	ASOrg string `maxminddb:"autonomous_system_organization"`
+}
+
+// OpenMMDB opens the location database at cityPath and, if asnPath is not
+// empty, a separate ASN database. A database holding both kinds of data can
+// be passed as cityPath alone.
+func OpenMMDB(cityPath, asnPath string) (*MMDBProvider, error) {
+	city, err := maxminddb.Open(cityPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open GeoIP database %s: %v", cityPath, err)
+	}
+
+	p := &MMDBProvider{city: city}
+	if asnPath != "" {
+		p.asn, err = maxminddb.Open(asnPath)
+		if err != nil {
+			city.Close()
+			return nil, fmt.Errorf("failed to open ASN database %s: %v", asnPath, err)
+		}
+	}
+
+	return p, nil
+}
+
+func (p *MMDBProvider) Lookup(ip string) Location {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return UnknownLocation()
+	}
+
+	var record mmdbRecord
+	if err := p.city.Lookup(addr, &record); err != nil {
+		log.Printf("GeoIP lookup failed for %s: %v", ip, err)
+		return UnknownLocation()
+	}
+
+	loc := Location{
+		Country:       name(record.Country.Names, record.Country.ISOCode),
+		CountryCode:   record.Country.ISOCode,
+		ContinentCode: record.Continent.Code,
+		City:          name(record.City.Names, ""),
+		ASN:           record.ASN,
+		ASOrg:         record.ASOrg,
+	}
+	if len(record.Subdivisions) > 0 {
+		loc.Region = name(record.Subdivisions[0].Names, record.Subdivisions[0].ISOCode)
+	}
+
+	if p.asn != nil {
+		var asn mmdbRecord
+		if err := p.asn.Lookup(addr, &asn); err != nil {
+			log.Printf("ASN lookup failed for %s: %v", ip, err)
+		} else {
+			loc.ASN, loc.ASOrg = asn.ASN, asn.ASOrg
+		}
+	}
+
+	return fillUnknown(loc)
+}
+
+// Close releases the database files.
+func (p *MMDBProvider) Close() error {
+	if p.asn != nil {
+		p.asn.Close()
+	}
+	return p.city.Close()
+}
+
+// name picks the English name, falling back to code.
+func name(names map[string]string, code string) string {
+	if n := names["en"]; n != "" {
+		return n
+	}
+	return code
+}
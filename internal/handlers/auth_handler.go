@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"html/template"
+	"net/http"
+	"strings"
+	"time"
+
+	"url-shortener/internal/middleware"
+	"url-shortener/internal/services"
+)
+
+type AuthHandler struct {
+	userService *services.UserService
+}
+
+func NewAuthHandler(userService *services.UserService) *AuthHandler {
+	return &AuthHandler{userService: userService}
+}
+
+// LoginPage handles GET /login
+func (h *AuthHandler) LoginPage(w http.ResponseWriter, r *http.Request) {
+	h.renderLogin(w, http.StatusOK, safeNext(r.URL.Query().Get("next")), "")
+}
+
+// Login handles POST /login and starts a dashboard session
+func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		h.renderLogin(w, http.StatusBadRequest, "/dashboard", "Invalid form submission")
+		return
+	}
+
+	next := safeNext(r.PostFormValue("next"))
+
+	token, _, err := h.userService.Login(r.PostFormValue("username"), r.PostFormValue("password"))
+	if err != nil {
+		h.renderLogin(w, http.StatusUnauthorized, next, "Invalid username or password")
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     middleware.SessionCookieName,
+		Value:    token,
+		Path:     "/",
+		Expires:  time.Now().Add(7 * 24 * time.Hour),
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	http.Redirect(w, r, next, http.StatusSeeOther)
+}
+
+// Logout handles POST /logout
+func (h *AuthHandler) Logout(w http.ResponseWriter, r *http.Request) {
+	if cookie, err := r.Cookie(middleware.SessionCookieName); err == nil {
+		h.userService.Logout(cookie.Value)
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     middleware.SessionCookieName,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+	})
+
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+func (h *AuthHandler) renderLogin(w http.ResponseWriter, code int, next, message string) {
+	tmpl := `
+<!DOCTYPE html>
+<html>
+<head>
+    <title>Log in - URL Shortener</title>
+    <meta charset="utf-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1">
+    <style>
+        body { font-family: Arial, sans-serif; max-width: 400px; margin: 0 auto; padding: 20px; }
+        input[type="text"], input[type="password"] { width: 100%; padding: 10px; margin: 5px 0 15px 0; box-sizing: border-box; }
+        button { padding: 10px 20px; background: #007bff; color: white; border: none; cursor: pointer; }
+        button:hover { background: #0056b3; }
+        .error { color: red; }
+    </style>
+</head>
+<body>
+    <h1>Log in</h1>
+    {{if .Message}}<p class="error">{{.Message}}</p>{{end}}
+    <form method="POST" action="/login">
+        <input type="hidden" name="next" value="{{.Next}}">
+        <label for="username">Username</label>
+        <input type="text" id="username" name="username" required autofocus>
+        <label for="password">Password</label>
+        <input type="password" id="password" name="password" required>
+        <button type="submit">Log in</button>
+    </form>
+    <p><a href="/">← Back to Home</a></p>
+</body>
+</html>`
+
+	data := struct {
+		Next    string
+		Message string
+	}{next, message}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(code)
+	t, _ := template.New("login").Parse(tmpl)
+	t.Execute(w, data)
+}
+
+// safeNext only allows redirects to local paths after login.
+func safeNext(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return "/dashboard"
+	}
+	return next
+}
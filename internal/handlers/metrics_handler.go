@@ -0,0 +1,23 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+
+	"url-shortener/internal/services"
+)
+
+// Metrics handles GET /metrics, reporting the click queue counters in the
+// Prometheus text format.
+func Metrics(clickQueue *services.ClickQueue) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		stats := clickQueue.Stats()
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		fmt.Fprintf(w, "# TYPE click_queue_enqueued_total counter\nclick_queue_enqueued_total %d\n", stats.Enqueued)
+		fmt.Fprintf(w, "# TYPE click_queue_dropped_total counter\nclick_queue_dropped_total %d\n", stats.Dropped)
+		fmt.Fprintf(w, "# TYPE click_queue_written_total counter\nclick_queue_written_total %d\n", stats.Written)
+		fmt.Fprintf(w, "# TYPE click_queue_failed_total counter\nclick_queue_failed_total %d\n", stats.Failed)
+		fmt.Fprintf(w, "# TYPE click_queue_pending gauge\nclick_queue_pending %d\n", stats.Pending)
+	}
+}
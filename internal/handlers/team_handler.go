@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"url-shortener/internal/middleware"
+	"url-shortener/internal/models"
+	"url-shortener/internal/services"
+
+	"github.com/gorilla/mux"
+)
+
+type TeamHandler struct {
+	teamService *services.TeamService
+}
+
+func NewTeamHandler(teamService *services.TeamService) *TeamHandler {
+	return &TeamHandler{teamService: teamService}
+}
+
+// CreateTeam handles POST /api/v1/teams
+func (h *TeamHandler) CreateTeam(w http.ResponseWriter, r *http.Request) {
+	ownerID := middleware.OwnerIDFromContext(r.Context())
+	if ownerID == "" {
+		respondWithServiceError(w, "Failed to create team", services.ErrUnauthorized)
+		return
+	}
+
+	var req models.CreateTeamRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid JSON", err.Error())
+		return
+	}
+
+	team, err := h.teamService.CreateTeam(req.Name, ownerID)
+	if err != nil {
+		respondWithServiceError(w, "Failed to create team", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, team)
+}
+
+// ListTeams handles GET /api/v1/teams
+func (h *TeamHandler) ListTeams(w http.ResponseWriter, r *http.Request) {
+	ownerID := middleware.OwnerIDFromContext(r.Context())
+	if ownerID == "" {
+		respondWithServiceError(w, "Failed to retrieve teams", services.ErrUnauthorized)
+		return
+	}
+
+	teams, err := h.teamService.ListTeams(ownerID)
+	if err != nil {
+		respondWithServiceError(w, "Failed to retrieve teams", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, teams)
+}
+
+// SetMember handles PUT /api/v1/teams/{teamID}/members/{username}
+func (h *TeamHandler) SetMember(w http.ResponseWriter, r *http.Request) {
+	ownerID := middleware.OwnerIDFromContext(r.Context())
+	if ownerID == "" {
+		respondWithServiceError(w, "Failed to update member", services.ErrUnauthorized)
+		return
+	}
+
+	vars := mux.Vars(r)
+	teamID, err := strconv.Atoi(vars["teamID"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid team ID", err.Error())
+		return
+	}
+
+	var req models.SetMemberRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid JSON", err.Error())
+		return
+	}
+
+	if err := h.teamService.SetMember(teamID, vars["username"], req.Role, ownerID); err != nil {
+		respondWithServiceError(w, "Failed to update member", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, models.TeamMember{
+		TeamID:   teamID,
+		Username: vars["username"],
+		Role:     req.Role,
+	})
+}
+
+// RemoveMember handles DELETE /api/v1/teams/{teamID}/members/{username}
+func (h *TeamHandler) RemoveMember(w http.ResponseWriter, r *http.Request) {
+	ownerID := middleware.OwnerIDFromContext(r.Context())
+	if ownerID == "" {
+		respondWithServiceError(w, "Failed to remove member", services.ErrUnauthorized)
+		return
+	}
+
+	vars := mux.Vars(r)
+	teamID, err := strconv.Atoi(vars["teamID"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid team ID", err.Error())
+		return
+	}
+
+	if err := h.teamService.RemoveMember(teamID, vars["username"], ownerID); err != nil {
+		respondWithServiceError(w, "Failed to remove member", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -2,12 +2,17 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
 	"net/http"
+	neturl "net/url"
+	"strconv"
 	"strings"
 	"time"
 
+	"url-shortener/internal/config"
+	"url-shortener/internal/middleware"
 	"url-shortener/internal/models"
 	"url-shortener/internal/services"
 
@@ -18,12 +23,24 @@ import (
 type URLHandler struct {
 	urlService       *services.URLService
 	analyticsService *services.AnalyticsService
+	clickQueue       *services.ClickQueue
+	unlocker         *services.UnlockService
+	qr               config.QR
 }
 
-func NewURLHandler(urlService *services.URLService, analyticsService *services.AnalyticsService) *URLHandler {
+// NewURLHandler creates a URLHandler. Redirects hand their clicks to
+// clickQueue; with a nil queue they are recorded before responding. A nil
+// unlocker uses the default unlock settings with a random secret.
+func NewURLHandler(urlService *services.URLService, analyticsService *services.AnalyticsService, clickQueue *services.ClickQueue, unlocker *services.UnlockService, qr config.QR) *URLHandler {
+	if unlocker == nil {
+		unlocker = services.NewUnlockService(config.Unlock{})
+	}
 	return &URLHandler{
 		urlService:       urlService,
 		analyticsService: analyticsService,
+		clickQueue:       clickQueue,
+		unlocker:         unlocker,
+		qr:               qr,
 	}
 }
 
@@ -35,25 +52,42 @@ func (h *URLHandler) ShortenURL(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if !strings.HasPrefix(req.OriginalURL, "http://") && !strings.HasPrefix(req.OriginalURL, "https://") {
+	if !isValidURL(req.OriginalURL) {
 		h.respondWithError(w, http.StatusBadRequest, "Invalid URL", "URL must start with http:// or https://")
 		return
 	}
 
 	clientIP := h.getClientIP(r)
+	ownerID := middleware.OwnerIDFromContext(r.Context())
 
-	url, err := h.urlService.ShortenURL(req, clientIP)
+	url, err := h.urlService.ShortenURL(req, ownerID, clientIP)
 	if err != nil {
-		h.respondWithError(w, http.StatusBadRequest, "Failed to shorten URL", err.Error())
+		switch {
+		case errors.Is(err, services.ErrUnauthorized), errors.Is(err, services.ErrForbidden),
+			errors.Is(err, services.ErrTeamNotFound):
+			h.respondWithServiceError(w, "Failed to shorten URL", err)
+		default:
+			h.respondWithError(w, http.StatusBadRequest, "Failed to shorten URL", err.Error())
+		}
 		return
 	}
 
 	response := models.ShortenURLResponse{
-		ShortCode:   url.ShortCode,
-		ShortURL:    fmt.Sprintf("%s://%s/%s", h.getScheme(r), r.Host, url.ShortCode),
-		OriginalURL: url.OriginalURL,
-		CreatedAt:   url.CreatedAt,
-		ExpiresAt:   url.ExpiresAt,
+		ShortCode:         url.ShortCode,
+		ShortURL:          fmt.Sprintf("%s://%s/%s", h.getScheme(r), r.Host, url.ShortCode),
+		OriginalURL:       url.OriginalURL,
+		CreatedAt:         url.CreatedAt,
+		ActivatesAt:       url.ActivatesAt,
+		ExpiresAt:         url.ExpiresAt,
+		TeamID:            url.TeamID,
+		RedirectType:      url.RedirectType,
+		PasswordProtected: url.PasswordProtected,
+		MaxClicks:         url.MaxClicks,
+		FallbackURL:       url.FallbackURL,
+		TargetingRules:    url.TargetingRules,
+		Variants:          url.Variants,
+		ClickIDParam:      url.ClickIDParam,
+		ForwardQuery:      url.ForwardQuery,
 	}
 
 	h.respondWithJSON(w, http.StatusCreated, response)
@@ -70,9 +104,15 @@ func (h *URLHandler) RedirectURL(w http.ResponseWriter, r *http.Request) {
 
 	url, err := h.urlService.GetOriginalURL(shortCode)
 	if err != nil {
-		h.respondWithError(w, http.StatusNotFound, "URL not found", err.Error())
+		h.respondWithUnavailable(w, r, shortCode, err)
+		return
+	}
+
+	if url.PasswordProtected && !h.isUnlocked(r, url) {
+		h.renderUnlock(w, http.StatusOK, shortCode, "")
 		return
 	}
+
 	//Here i added an analytics recording block
 	click := models.Click{
 		URLShortCode: shortCode,
@@ -81,19 +121,434 @@ func (h *URLHandler) RedirectURL(w http.ResponseWriter, r *http.Request) {
 		Referer:      r.Referer(),
 		ClickedAt:    time.Now(),
 	}
+	visitor := services.Visitor{Agent: h.analyticsService.ParseAgent(&click)}
+	if kind := previewKind(r); kind != "" {
+		click.Bot = true
+		if click.BotKind == "" {
+			click.BotKind = kind
+		}
+	}
+
+	// Bots do not use up a limited link, so they are not told where it
+	// leads either: anyone can send a HEAD request or a crawler's
+	// User-Agent. Other links redirect them like anyone else.
+	if click.Bot && url.MaxClicks != nil {
+		h.recordClick(click)
+		w.Header().Set("Cache-Control", redirectCacheControl(http.StatusFound))
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if !click.Bot {
+		click.Counted, err = h.urlService.ClaimClick(url)
+		if err != nil {
+			h.respondWithUnavailable(w, r, shortCode, err)
+			return
+		}
+	}
+
+	if cookie, err := r.Cookie(h.urlService.VariantCookieName(shortCode)); err == nil {
+		visitor.Variant = cookie.Value
+	}
+	geoTargeted := h.urlService.TargetsLocation(url)
+	if geoTargeted {
+		// Resolved before redirecting instead of in the click queue; the
+		// click keeps it.
+		visitor.Location = h.analyticsService.LocateClick(&click)
+	}
+	target := h.urlService.Destination(url, visitor)
+	click.TargetRule = target.Rule
+	click.Variant = target.Variant
+	h.analyticsService.ClassifyReferrer(&click, r.Host, target.URL)
+	destination := target.URL
+	if url.ForwardQuery {
+		destination = services.ForwardQuery(destination, r.URL.RawQuery)
+	}
+	click.Campaign = services.Campaign(destination)
+	if url.ClickIDParam != "" {
+		click.ClickID = services.NewClickID(shortCode)
+		destination = services.WithClickID(destination, url.ClickIDParam, click.ClickID)
+	}
+	if target.Variant != "" && target.Variant != visitor.Variant {
+		http.SetCookie(w, &http.Cookie{
+			Name:     h.urlService.VariantCookieName(shortCode),
+			Value:    target.Variant,
+			Path:     "/" + shortCode,
+			Expires:  time.Now().Add(services.VariantCookieTTL),
+			HttpOnly: true,
+			Secure:   r.TLS != nil,
+			SameSite: http.SameSiteLaxMode,
+		})
+	}
+
+	h.recordClick(click)
+
+	code := url.RedirectType
+	if code == 0 {
+		code = http.StatusFound
+	}
+	cacheControl := redirectCacheControl(code)
+	if url.PasswordProtected || url.MaxClicks != nil || geoTargeted || len(url.Variants) > 0 || url.ClickIDParam != "" {
+		// A cached redirect would skip the password check or the limit,
+		// follow a visitor to another country, keep a visitor on a variant
+		// after a winner was declared, or reuse a click ID.
+		cacheControl = redirectCacheControl(http.StatusFound)
+	}
+	w.Header().Set("Cache-Control", cacheControl)
+	if len(url.TargetingRules) > 0 {
+		w.Header().Set("Vary", "User-Agent")
+	}
+	http.Redirect(w, r, destination, code)
+}
+
+// UnlockURL handles POST /{shortCode}, the password form of a protected
+// link. Every checked submission is recorded as an unlock attempt; on
+// success the visitor gets a signed cookie and is sent back to the short
+// link, where the redirect and its click happen as usual.
+func (h *URLHandler) UnlockURL(w http.ResponseWriter, r *http.Request) {
+	shortCode := mux.Vars(r)["shortCode"]
+
+	url, err := h.urlService.GetOriginalURL(shortCode)
+	if err != nil {
+		h.respondWithUnavailable(w, r, shortCode, err)
+		return
+	}
+	if !url.PasswordProtected {
+		http.Redirect(w, r, "/"+shortCode, http.StatusSeeOther)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		h.renderUnlock(w, http.StatusBadRequest, shortCode, "Invalid form submission")
+		return
+	}
+
+	clientIP := h.unlocker.VisitorIP(r.RemoteAddr, r.Header.Get("X-Forwarded-For"))
+	err = h.unlocker.CheckPassword(url, r.PostFormValue("password"), clientIP)
+	if errors.Is(err, services.ErrTooManyAttempts) {
+		h.renderUnlock(w, http.StatusTooManyRequests, shortCode, "Too many wrong passwords. Try again later.")
+		return
+	}
+
+	attempt := models.UnlockAttempt{
+		URLShortCode: shortCode,
+		IPAddress:    clientIP,
+		Success:      err == nil,
+		AttemptedAt:  time.Now(),
+	}
+	if recordErr := h.analyticsService.RecordUnlockAttempt(attempt); recordErr != nil {
+		fmt.Printf("Failed to record unlock attempt: %v\n", recordErr)
+	}
+
+	if err != nil {
+		h.renderUnlock(w, http.StatusUnauthorized, shortCode, "Incorrect password")
+		return
+	}
+
+	expires := time.Now().Add(h.unlocker.CookieTTL())
+	http.SetCookie(w, &http.Cookie{
+		Name:     h.unlocker.CookieName(shortCode),
+		Value:    h.unlocker.Token(url, expires),
+		Path:     "/" + shortCode,
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	http.Redirect(w, r, "/"+shortCode, http.StatusSeeOther)
+}
+
+// respondWithUnavailable answers a redirect whose link cannot be used.
+// Expired, deleted and used-up links send the visitor to their fallback
+// URL when there is one. Otherwise links that used up their clicks get 410
+// Gone and scheduled links 403 Forbidden, so clients can tell them from
+// unknown codes; browsers get an error page and API clients JSON.
+func (h *URLHandler) respondWithUnavailable(w http.ResponseWriter, r *http.Request, shortCode string, err error) {
+	w.Header().Set("Cache-Control", "no-store")
+
+	if !errors.Is(err, services.ErrURLNotActive) {
+		if fallback := h.urlService.FallbackURL(shortCode); fallback != "" {
+			http.Redirect(w, r, fallback, http.StatusFound)
+			return
+		}
+	}
+
+	code, title := http.StatusNotFound, "URL not found"
+	heading, text := "Link not found", "This link does not exist or has been removed."
+	switch {
+	case errors.Is(err, services.ErrClickLimitReached):
+		code, title = http.StatusGone, "Link limit reached"
+		heading, text = title, "This link has been used as many times as it allows."
+	case errors.Is(err, services.ErrURLNotActive):
+		code, title = http.StatusForbidden, "Link not yet active"
+		heading, text = title, "This link is not active yet. Please try again later."
+	case errors.Is(err, services.ErrURLExpired):
+		heading, text = "Link expired", "This link has expired."
+	}
+
+	if prefersHTML(r) {
+		h.renderErrorPage(w, code, heading, text)
+		return
+	}
+	h.respondWithError(w, code, title, err.Error())
+}
+
+// prefersHTML reports whether the Accept header ranks text/html above
+// application/json. Ties and a missing header go to JSON, so API clients
+// sending */* keep getting JSON while browsers get a page.
+func prefersHTML(r *http.Request) bool {
+	return acceptQuality(r, "text/html") > acceptQuality(r, "application/json")
+}
+
+// acceptQuality returns the q-value the Accept header gives mediaType,
+// taken from the most specific media range that matches it.
+func acceptQuality(r *http.Request, mediaType string) float64 {
+	group, _, _ := strings.Cut(mediaType, "/")
+	quality, specificity := 0.0, -1
+
+	for _, header := range r.Header.Values("Accept") {
+		for _, accepted := range strings.Split(header, ",") {
+			params := strings.Split(accepted, ";")
+			var s int
+			switch strings.ToLower(strings.TrimSpace(params[0])) {
+			case mediaType:
+				s = 2
+			case group + "/*":
+				s = 1
+			case "*/*":
+				s = 0
+			default:
+				continue
+			}
+
+			q := 1.0
+			for _, param := range params[1:] {
+				key, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+				if strings.TrimSpace(key) == "q" {
+					if f, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil {
+						q = f
+					}
+				}
+			}
+
+			if s > specificity || (s == specificity && q > quality) {
+				quality, specificity = q, s
+			}
+		}
+	}
+
+	return quality
+}
+
+func (h *URLHandler) renderErrorPage(w http.ResponseWriter, code int, heading, text string) {
+	tmpl := `
+<!DOCTYPE html>
+<html>
+<head>
+    <title>{{.Heading}} - URL Shortener</title>
+    <meta charset="utf-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1">
+    <meta name="robots" content="noindex">
+    <style>
+        body { font-family: Arial, sans-serif; max-width: 600px; margin: 0 auto; padding: 20px; text-align: center; }
+        .code { font-size: 64px; color: #007bff; margin: 40px 0 0 0; }
+        a { color: #007bff; }
+    </style>
+</head>
+<body>
+    <p class="code">{{.Code}}</p>
+    <h1>{{.Heading}}</h1>
+    <p>{{.Text}}</p>
+    <p><a href="/">Go to URL Shortener</a></p>
+</body>
+</html>`
+
+	data := struct {
+		Code    int
+		Heading string
+		Text    string
+	}{code, heading, text}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	t, _ := template.New("error").Parse(tmpl)
+	t.Execute(w, data)
+}
+
+// isUnlocked reports whether the request carries a valid unlock cookie for url.
+func (h *URLHandler) isUnlocked(r *http.Request, url *models.URL) bool {
+	cookie, err := r.Cookie(h.unlocker.CookieName(url.ShortCode))
+	return err == nil && h.unlocker.Verify(url, cookie.Value)
+}
+
+func (h *URLHandler) renderUnlock(w http.ResponseWriter, code int, shortCode, message string) {
+	tmpl := `
+<!DOCTYPE html>
+<html>
+<head>
+    <title>Protected link - URL Shortener</title>
+    <meta charset="utf-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1">
+    <meta name="robots" content="noindex">
+    <style>
+        body { font-family: Arial, sans-serif; max-width: 400px; margin: 0 auto; padding: 20px; }
+        input[type="password"] { width: 100%; padding: 10px; margin: 5px 0 15px 0; box-sizing: border-box; }
+        button { padding: 10px 20px; background: #007bff; color: white; border: none; cursor: pointer; }
+        button:hover { background: #0056b3; }
+        .error { color: red; }
+    </style>
+</head>
+<body>
+    <h1>This link is protected</h1>
+    <p>Enter the password to continue.</p>
+    {{if .Message}}<p class="error">{{.Message}}</p>{{end}}
+    <form method="POST" action="/{{.ShortCode}}">
+        <label for="password">Password</label>
+        <input type="password" id="password" name="password" required autofocus>
+        <button type="submit">Continue</button>
+    </form>
+</body>
+</html>`
+
+	data := struct {
+		ShortCode string
+		Message   string
+	}{shortCode, message}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	t, _ := template.New("unlock").Parse(tmpl)
+	t.Execute(w, data)
+}
 
-	click.Country, click.City = h.analyticsService.GetLocationFromIP(click.IPAddress)
+// redirectCacheControl lets browsers keep permanent redirects for a day, so
+// a changed destination still takes effect, and keeps temporary ones out
+// of every cache so each click is counted.
+func redirectCacheControl(code int) string {
+	if code == http.StatusMovedPermanently || code == http.StatusPermanentRedirect {
+		return "public, max-age=86400"
+	}
+	return "private, no-cache, no-store, must-revalidate"
+}
 
-	go func() {
-		if err := h.analyticsService.RecordClick(click); err != nil {
-			fmt.Printf("Failed to record click: %v\n", err)
+// previewKind returns the bot kind of r if it fetches a link without a
+// visitor following it: a HEAD request, as link unfurlers send, or a
+// browser prefetch or preview. It returns "" for other requests.
+func previewKind(r *http.Request) string {
+	if r.Method == http.MethodHead {
+		return models.BotKindHead
+	}
+	for _, header := range []string{"Purpose", "Sec-Purpose", "X-Purpose", "X-Moz"} {
+		value := strings.ToLower(r.Header.Get(header))
+		if strings.Contains(value, "prefetch") || strings.Contains(value, "preview") {
+			return models.BotKindPrefetch
 		}
-		if err := h.urlService.IncrementClickCount(shortCode); err != nil {
-			fmt.Printf("Failed to increment click count: %v\n", err)
+	}
+	return ""
+}
+
+// recordClick queues a click, or records it synchronously without a queue.
+func (h *URLHandler) recordClick(click models.Click) {
+	if h.clickQueue != nil {
+		h.clickQueue.Enqueue(click)
+		return
+	}
+
+	h.analyticsService.EnrichClick(&click)
+	if err := h.analyticsService.RecordClicks([]models.Click{click}); err != nil {
+		fmt.Printf("Failed to record click: %v\n", err)
+	}
+}
+
+// UpdateURL handles PATCH /api/v1/urls/{shortCode}
+func (h *URLHandler) UpdateURL(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	shortCode := vars["shortCode"]
+
+	var req models.UpdateURLRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.respondWithError(w, http.StatusBadRequest, "Invalid JSON", err.Error())
+		return
+	}
+
+	if req.OriginalURL != nil && !isValidURL(*req.OriginalURL) {
+		h.respondWithError(w, http.StatusBadRequest, "Invalid URL", "URL must start with http:// or https://")
+		return
+	}
+
+	url, err := h.urlService.UpdateURL(shortCode, req, middleware.OwnerIDFromContext(r.Context()))
+	if err != nil {
+		h.respondWithServiceError(w, "Failed to update URL", err)
+		return
+	}
+
+	h.respondWithJSON(w, http.StatusOK, url)
+}
+
+// RecordConversion handles POST /api/v1/conversions
+func (h *URLHandler) RecordConversion(w http.ResponseWriter, r *http.Request) {
+	var req models.ConversionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.respondWithError(w, http.StatusBadRequest, "Invalid JSON", err.Error())
+		return
+	}
+
+	conversion, err := h.analyticsService.RecordConversion(req)
+	if err != nil {
+		h.respondWithServiceError(w, "Failed to record conversion", err)
+		return
+	}
+
+	h.respondWithJSON(w, http.StatusCreated, conversion)
+}
+
+// transparentGIF is a 1x1 transparent GIF.
+var transparentGIF = []byte{
+	'G', 'I', 'F', '8', '9', 'a', 0x01, 0x00, 0x01, 0x00, 0x80, 0x00, 0x00,
+	0x00, 0x00, 0x00, 0xff, 0xff, 0xff, 0x21, 0xf9, 0x04, 0x01, 0x00, 0x00,
+	0x00, 0x00, 0x2c, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x01, 0x00, 0x00,
+	0x02, 0x02, 0x44, 0x01, 0x00, 0x3b,
+}
+
+// ConversionPixel handles GET /api/v1/conversions/pixel.gif, for pages that
+// can only embed an image. click_id, value and currency come from the query
+// string. A click that already converted still gets the pixel, so reloading
+// a thank-you page is harmless.
+func (h *URLHandler) ConversionPixel(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	req := models.ConversionRequest{ClickID: query.Get("click_id"), Currency: query.Get("currency")}
+	if value := query.Get("value"); value != "" {
+		v, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			h.respondWithError(w, http.StatusBadRequest, "Invalid value", "value must be a number")
+			return
 		}
-	}()
+		req.Value = v
+	}
+
+	if _, err := h.analyticsService.RecordConversion(req); err != nil && !errors.Is(err, services.ErrConversionExists) {
+		h.respondWithServiceError(w, "Failed to record conversion", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/gif")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(transparentGIF)
+}
+
+// DeleteURL handles DELETE /api/v1/urls/{shortCode}
+func (h *URLHandler) DeleteURL(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	shortCode := vars["shortCode"]
+
+	if err := h.urlService.DeleteURL(shortCode, middleware.OwnerIDFromContext(r.Context())); err != nil {
+		h.respondWithServiceError(w, "Failed to delete URL", err)
+		return
+	}
 
-	http.Redirect(w, r, url.OriginalURL, http.StatusMovedPermanently)
+	w.WriteHeader(http.StatusNoContent)
 }
 
 // GetAnalytics handles GET /api/v1/analytics/{shortCode}
@@ -106,28 +561,111 @@ func (h *URLHandler) GetAnalytics(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	analytics, err := h.analyticsService.GetAnalytics(shortCode)
+	query, err := analyticsQuery(r)
+	if err != nil {
+		h.respondWithError(w, http.StatusBadRequest, "Invalid analytics query", err.Error())
+		return
+	}
+
+	analytics, err := h.analyticsService.QueryAnalytics(shortCode, middleware.OwnerIDFromContext(r.Context()), query)
 	if err != nil {
-		h.respondWithError(w, http.StatusNotFound, "Analytics not found", err.Error())
+		h.respondWithServiceError(w, "Analytics not available", err)
 		return
 	}
 
 	h.respondWithJSON(w, http.StatusOK, analytics)
 }
 
+// analyticsQuery reads the options of an analytics request from its query
+// string. Defaults and ranges are left to the analytics service.
+func analyticsQuery(r *http.Request) (models.AnalyticsQuery, error) {
+	var query models.AnalyticsQuery
+	values := r.URL.Query()
+	if value := values.Get("include_bots"); value != "" {
+		includeBots, err := strconv.ParseBool(value)
+		if err != nil {
+			return query, fmt.Errorf("include_bots must be true or false")
+		}
+		query.IncludeBots = includeBots
+	}
+
+	query.Location = time.UTC
+	if value := values.Get("tz"); value != "" {
+		loc, err := time.LoadLocation(value)
+		if err != nil {
+			return query, fmt.Errorf("tz must be a time zone name such as Europe/Oslo")
+		}
+		query.Location = loc
+	}
+
+	var err error
+	if query.From, err = analyticsTime(values.Get("from"), query.Location, false); err != nil {
+		return query, fmt.Errorf("from %v", err)
+	}
+	if query.To, err = analyticsTime(values.Get("to"), query.Location, true); err != nil {
+		return query, fmt.Errorf("to %v", err)
+	}
+	query.Granularity = strings.ToLower(values.Get("granularity"))
+
+	if value := values.Get("limit"); value != "" {
+		if query.Limit, err = strconv.Atoi(value); err != nil {
+			return query, fmt.Errorf("limit must be a number")
+		}
+	}
+	return query, nil
+}
+
+// analyticsTime parses an RFC 3339 time, or a date and time without an
+// offset in loc. A date alone is the start of that day, or its end when
+// endOfDay is set, so that to=2025-01-31 includes January 31.
+func analyticsTime(value string, loc *time.Location, endOfDay bool) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	for _, layout := range []string{"2006-01-02T15:04:05", "2006-01-02T15:04"} {
+		if t, err := time.ParseInLocation(layout, value, loc); err == nil {
+			return t, nil
+		}
+	}
+	t, err := time.ParseInLocation("2006-01-02", value, loc)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("must be a date such as 2025-01-31 or an RFC 3339 time")
+	}
+	if endOfDay {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
+}
+
 // GetUserURLs handles GET /api/v1/urls
 func (h *URLHandler) GetUserURLs(w http.ResponseWriter, r *http.Request) {
-	clientIP := h.getClientIP(r)
+	ownerID := middleware.OwnerIDFromContext(r.Context())
 
-	urls, err := h.urlService.GetUserURLs(clientIP)
+	urls, err := h.urlService.GetUserURLs(ownerID)
 	if err != nil {
-		h.respondWithError(w, http.StatusInternalServerError, "Failed to retrieve URLs", err.Error())
+		h.respondWithServiceError(w, "Failed to retrieve URLs", err)
 		return
 	}
 
 	h.respondWithJSON(w, http.StatusOK, urls)
 }
 
+// GetCampaigns handles GET /api/v1/campaigns
+func (h *URLHandler) GetCampaigns(w http.ResponseWriter, r *http.Request) {
+	campaigns, err := h.analyticsService.CampaignStats(middleware.OwnerIDFromContext(r.Context()))
+	if err != nil {
+		h.respondWithServiceError(w, "Failed to retrieve campaigns", err)
+		return
+	}
+
+	h.respondWithJSON(w, http.StatusOK, campaigns)
+}
+
+// GenerateQRCode handles GET /api/v1/qr/{shortCode}. Like RedirectURL it
+// needs no permission, since the image only encodes the public short URL.
 func (h *URLHandler) GenerateQRCode(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	shortCode := vars["shortCode"]
@@ -137,15 +675,16 @@ func (h *URLHandler) GenerateQRCode(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Scheduled links get their QR code ahead of launch.
 	_, err := h.urlService.GetOriginalURL(shortCode)
-	if err != nil {
+	if err != nil && !errors.Is(err, services.ErrURLNotActive) {
 		h.respondWithError(w, http.StatusNotFound, "URL not found", err.Error())
 		return
 	}
 
 	shortURL := fmt.Sprintf("%s://%s/%s", h.getScheme(r), r.Host, shortCode)
 
-	png, err := qrcode.Encode(shortURL, qrcode.Medium, 256)
+	png, err := qrcode.Encode(shortURL, qrcode.Medium, h.qr.Size)
 	if err != nil {
 		h.respondWithError(w, http.StatusInternalServerError, "Failed to generate QR code", err.Error())
 		return
@@ -168,7 +707,7 @@ func (h *URLHandler) HomePage(w http.ResponseWriter, r *http.Request) {
     <style>
         body { font-family: Arial, sans-serif; max-width: 800px; margin: 0 auto; padding: 20px; }
         .container { text-align: center; }
-        input[type="url"], input[type="text"], input[type="datetime-local"], select { width: 300px; padding: 10px; margin: 5px; }
+        input[type="url"], input[type="text"], input[type="number"], input[type="password"], input[type="datetime-local"], select { width: 300px; padding: 10px; margin: 5px; }
         button { padding: 10px 20px; background: #007bff; color: white; border: none; cursor: pointer; }
         button:hover { background: #0056b3; }
         .result { margin: 20px 0; padding: 20px; background: #f8f9fa; border-radius: 5px; }
@@ -190,6 +729,10 @@ func (h *URLHandler) HomePage(w http.ResponseWriter, r *http.Request) {
             <div class="form-group">
                 <input type="text" id="customCode" placeholder="Custom short code (optional)">
             </div>
+            <div class="form-group">
+                <label for="activatesAt">Activates at (optional):</label>
+                <input type="datetime-local" id="activatesAt">
+            </div>
             <div class="form-group">
                 <label for="expirationOption">Expiration (optional):</label>
                 <select id="expirationOption">
@@ -205,6 +748,25 @@ func (h *URLHandler) HomePage(w http.ResponseWriter, r *http.Request) {
                 <label for="customExpiration">Custom expiration date:</label>
                 <input type="datetime-local" id="customExpiration">
             </div>
+            <div class="form-group">
+                <input type="number" id="maxClicks" min="1" placeholder="Max clicks (optional, 1 = one-time link)">
+            </div>
+            <div class="form-group">
+                <input type="url" id="fallbackUrl" placeholder="Fallback URL once expired or used up (optional)">
+            </div>
+            <div class="form-group">
+                <input type="password" id="linkPassword" placeholder="Password (optional)" autocomplete="new-password">
+            </div>
+            <div class="form-group">
+                <label for="redirectType">Redirect type:</label>
+                <select id="redirectType">
+                    <option value="">Default</option>
+                    <option value="302">302 Found (tracks every click)</option>
+                    <option value="307">307 Temporary Redirect (tracks every click)</option>
+                    <option value="301">301 Moved Permanently (cached by browsers)</option>
+                    <option value="308">308 Permanent Redirect (cached by browsers)</option>
+                </select>
+            </div>
             <div>
                 <button type="submit">Shorten URL</button>
             </div>
@@ -235,6 +797,11 @@ func (h *URLHandler) HomePage(w http.ResponseWriter, r *http.Request) {
             const customCode = document.getElementById('customCode').value;
             const expirationOption = document.getElementById('expirationOption').value;
             const customExpiration = document.getElementById('customExpiration').value;
+            const redirectType = document.getElementById('redirectType').value;
+            const linkPassword = document.getElementById('linkPassword').value;
+            const maxClicks = document.getElementById('maxClicks').value;
+            const activatesAt = document.getElementById('activatesAt').value;
+            const fallbackUrl = document.getElementById('fallbackUrl').value;
             const resultDiv = document.getElementById('result');
             
             // Calculate expiration date
@@ -266,7 +833,12 @@ func (h *URLHandler) HomePage(w http.ResponseWriter, r *http.Request) {
                 const requestBody = {
                     original_url: originalUrl,
                     custom_code: customCode || undefined,
-                    expires_at: expiresAt
+                    activates_at: activatesAt ? new Date(activatesAt).toISOString() : undefined,
+                    expires_at: expiresAt,
+                    redirect_type: redirectType ? parseInt(redirectType, 10) : undefined,
+                    password: linkPassword || undefined,
+                    max_clicks: maxClicks ? parseInt(maxClicks, 10) : undefined,
+                    fallback_url: fallbackUrl || undefined
                 };
 
                 const response = await fetch('/api/v1/shorten', {
@@ -281,8 +853,11 @@ func (h *URLHandler) HomePage(w http.ResponseWriter, r *http.Request) {
                 
                 if (response.ok) {
                     let expirationInfo = '';
+                    if (data.activates_at) {
+                        expirationInfo += ` + "`" + `<p><strong>Activates:</strong> ${new Date(data.activates_at).toLocaleString()}</p>` + "`" + `;
+                    }
                     if (data.expires_at) {
-                        expirationInfo = ` + "`" + `<p><strong>Expires:</strong> ${new Date(data.expires_at).toLocaleString()}</p>` + "`" + `;
+                        expirationInfo += ` + "`" + `<p><strong>Expires:</strong> ${new Date(data.expires_at).toLocaleString()}</p>` + "`" + `;
                     }
                     
                     resultDiv.innerHTML = ` + "`" + `
@@ -316,9 +891,13 @@ func (h *URLHandler) HomePage(w http.ResponseWriter, r *http.Request) {
 
 // Dashboard handles GET /dashboard
 func (h *URLHandler) Dashboard(w http.ResponseWriter, r *http.Request) {
-	clientIP := h.getClientIP(r)
+	ownerID := middleware.OwnerIDFromContext(r.Context())
+	if ownerID == "" {
+		redirectToLogin(w, r)
+		return
+	}
 
-	urls, err := h.urlService.GetUserURLs(clientIP)
+	urls, err := h.urlService.GetUserURLs(ownerID)
 	if err != nil {
 		http.Error(w, "Failed to load dashboard", http.StatusInternalServerError)
 		return
@@ -337,11 +916,12 @@ func (h *URLHandler) Dashboard(w http.ResponseWriter, r *http.Request) {
         th, td { padding: 12px; text-align: left; border-bottom: 1px solid #ddd; word-wrap: break-word; }
         th { background-color: #f2f2f2; }
         th:nth-child(1) { width: 10%; } /* Short Code */
-        th:nth-child(2) { width: 35%; } /* Original URL */
-        th:nth-child(3) { width: 8%; } /* Clicks */
-        th:nth-child(4) { width: 15%; } /* Created */
-        th:nth-child(5) { width: 15%; } /* Expires */
-        th:nth-child(6) { width: 17%; } /* Actions */
+        th:nth-child(2) { width: 27%; } /* Original URL */
+        th:nth-child(3) { width: 7%; } /* Clicks */
+        th:nth-child(4) { width: 13%; } /* Created */
+        th:nth-child(5) { width: 13%; } /* Activates */
+        th:nth-child(6) { width: 13%; } /* Expires */
+        th:nth-child(7) { width: 17%; } /* Actions */
         .short-url { color: #007bff; text-decoration: none; }
         .short-url:hover { text-decoration: underline; }
         .url-cell { overflow: hidden; text-overflow: ellipsis; max-width: 0; }
@@ -351,14 +931,20 @@ func (h *URLHandler) Dashboard(w http.ResponseWriter, r *http.Request) {
         .qr-btn:hover { background: #138496; }
         .expired { color: #dc3545; font-weight: bold; }
         .expires-soon { color: #ffc107; }
+        .scheduled { color: #6f42c1; font-weight: bold; }
         .actions-cell { white-space: nowrap; }
+        .logout-btn { padding: 3px 10px; margin-left: 10px; font-size: 12px; }
     </style>
 </head>
 <body>
     <h1>Dashboard</h1>
     <p><a href="/">← Back to Home</a></p>
+    <form method="POST" action="/logout">
+        Signed in as <strong>{{.Username}}</strong>
+        <button type="submit" class="logout-btn">Log out</button>
+    </form>
     
-    {{if .}}
+    {{if .URLs}}
     <table>
         <thead>
             <tr>
@@ -366,17 +952,29 @@ func (h *URLHandler) Dashboard(w http.ResponseWriter, r *http.Request) {
                 <th>Original URL</th>
                 <th>Clicks</th>
                 <th>Created</th>
+                <th>Activates</th>
                 <th>Expires</th>
                 <th>Actions</th>
             </tr>
         </thead>
         <tbody>
-            {{range .}}
+            {{range .URLs}}
             <tr>
                 <td><a href="/{{.ShortCode}}" class="short-url" target="_blank">{{.ShortCode}}</a></td>
                 <td class="url-cell" title="{{.OriginalURL}}">{{.OriginalURL}}</td>
                 <td>{{.ClickCount}}</td>
                 <td>{{.CreatedAt.Format "Jan 2, 2006 15:04"}}</td>
+                <td>
+                    {{if .ActivatesAt}}
+                        {{if .ActivatesAt.After $.Now}}
+                            <span class="scheduled" title="Not active yet">{{.ActivatesAt.Format "Jan 2, 2006 15:04"}}</span>
+                        {{else}}
+                            {{.ActivatesAt.Format "Jan 2, 2006 15:04"}}
+                        {{end}}
+                    {{else}}
+                        Immediately
+                    {{end}}
+                </td>
                 <td>
                     {{if .ExpiresAt}}
                         {{.ExpiresAt.Format "Jan 2, 2006 15:04"}}
@@ -394,12 +992,19 @@ func (h *URLHandler) Dashboard(w http.ResponseWriter, r *http.Request) {
     </table>
     {{else}}
     <p>No URLs created yet. <a href="/">Create your first short URL</a></p>
+    <p>Links created anonymously from the home page are not listed here.</p>
     {{end}}
 </body>
 </html>`
 
+	data := struct {
+		Username string
+		URLs     []models.URL
+		Now      time.Time
+	}{ownerID, urls, time.Now()}
+
 	t, _ := template.New("dashboard").Parse(tmpl)
-	t.Execute(w, urls)
+	t.Execute(w, data)
 }
 
 func (h *URLHandler) AnalyticsPage(w http.ResponseWriter, r *http.Request) {
@@ -411,17 +1016,32 @@ func (h *URLHandler) AnalyticsPage(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// First check if the URL exists
-	_, err := h.urlService.GetOriginalURL(shortCode)
+	ownerID := middleware.OwnerIDFromContext(r.Context())
+	if ownerID == "" {
+		redirectToLogin(w, r)
+		return
+	}
+
+	query, err := analyticsQuery(r)
 	if err != nil {
-		http.Error(w, "Short code not found", http.StatusNotFound)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
 	// Get analytics (even if no clicks yet)
-	analytics, err := h.analyticsService.GetAnalytics(shortCode)
+	analytics, err := h.analyticsService.QueryAnalytics(shortCode, ownerID, query)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Error fetching analytics: %v", err), http.StatusInternalServerError)
+		switch {
+		case errors.Is(err, services.ErrURLNotFound):
+			http.Error(w, "Short code not found", http.StatusNotFound)
+		case errors.Is(err, services.ErrForbidden):
+			http.Error(w, "You do not have access to this link's analytics", http.StatusForbidden)
+		case errors.Is(err, services.ErrInvalidTimeRange), errors.Is(err, services.ErrInvalidGranularity),
+			errors.Is(err, services.ErrTooManyBuckets), errors.Is(err, services.ErrInvalidLimit):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		default:
+			http.Error(w, fmt.Sprintf("Error fetching analytics: %v", err), http.StatusInternalServerError)
+		}
 		return
 	}
 
@@ -456,7 +1076,7 @@ func (h *URLHandler) AnalyticsPage(w http.ResponseWriter, r *http.Request) {
         .short-url { color: #007bff; word-break: break-all; }
         .chart { margin: 20px 0; }
         .day-item { display: flex; align-items: center; margin: 8px 0; }
-        .day-date { width: 120px; font-size: 14px; color: #666; }
+        .day-date { width: 140px; font-size: 14px; color: #666; }
         .day-bar { flex: 1; background: #e9ecef; height: 24px; border-radius: 4px; overflow: hidden; margin: 0 10px; }
         .day-fill { background: #28a745; height: 100%; display: flex; align-items: center; padding-left: 8px; color: white; font-size: 12px; }
     </style>
@@ -470,7 +1090,9 @@ func (h *URLHandler) AnalyticsPage(w http.ResponseWriter, r *http.Request) {
             <p><strong>Short URL:</strong> <span class="short-url">{{.URL.ShortCode}}</span></p>
             <p><strong>Original URL:</strong> <span class="short-url">{{.URL.OriginalURL}}</span></p>
             <p><strong>Created:</strong> {{.URL.CreatedAt.Format "Jan 2, 2006 15:04"}}</p>
+            {{if .URL.ActivatesAt}}<p><strong>Activates:</strong> {{.URL.ActivatesAt.Format "Jan 2, 2006 15:04"}}</p>{{end}}
             {{if .URL.ExpiresAt}}<p><strong>Expires:</strong> {{.URL.ExpiresAt.Format "Jan 2, 2006 15:04"}}</p>{{end}}
+            {{if .URL.FallbackURL}}<p><strong>Fallback URL:</strong> <span class="short-url">{{.URL.FallbackURL}}</span></p>{{end}}
         </div>
     </div>
 
@@ -487,6 +1109,25 @@ func (h *URLHandler) AnalyticsPage(w http.ResponseWriter, r *http.Request) {
             <h3>Countries</h3>
             <p class="value">{{len .ClicksByCountry}}</p>
         </div>
+        <div class="stat-card">
+            <h3>Bot Clicks</h3>
+            <p class="value">{{.BotClicks}}</p>
+            {{if .IncludesBots}}<a href="?">Leave out bots</a>{{else}}<a href="?include_bots=true">Include bots</a>{{end}}
+        </div>
+        {{if .URL.ClickIDParam}}
+        <div class="stat-card">
+            <h3>Conversions</h3>
+            <p class="value">{{.Conversions}}</p>
+        </div>
+        <div class="stat-card">
+            <h3>Conversion Rate</h3>
+            <p class="value">{{percent .ConversionRate}}</p>
+        </div>
+        <div class="stat-card">
+            <h3>Revenue</h3>
+            <p class="value">{{range $currency, $sum := .Revenue}}{{money $sum}} {{$currency}}<br>{{else}}-{{end}}</p>
+        </div>
+        {{end}}
     </div>
 
     <div class="section">
@@ -509,13 +1150,191 @@ func (h *URLHandler) AnalyticsPage(w http.ResponseWriter, r *http.Request) {
         {{end}}
     </div>
 
+    {{if .ClicksByBrowser}}
+    <div class="section">
+        <h2>Browsers, Systems and Devices</h2>
+        <table>
+            <thead>
+                <tr>
+                    <th>Browser</th>
+                    <th>Clicks</th>
+                </tr>
+            </thead>
+            <tbody>
+                {{range $browser, $count := .ClicksByBrowser}}
+                <tr>
+                    <td>{{$browser}}</td>
+                    <td>{{$count}}</td>
+                </tr>
+                {{end}}
+            </tbody>
+        </table>
+        <table>
+            <thead>
+                <tr>
+                    <th>Operating System</th>
+                    <th>Clicks</th>
+                </tr>
+            </thead>
+            <tbody>
+                {{range $os, $count := .ClicksByOS}}
+                <tr>
+                    <td>{{$os}}</td>
+                    <td>{{$count}}</td>
+                </tr>
+                {{end}}
+            </tbody>
+        </table>
+        <table>
+            <thead>
+                <tr>
+                    <th>Device</th>
+                    <th>Clicks</th>
+                </tr>
+            </thead>
+            <tbody>
+                {{range $device, $count := .ClicksByDevice}}
+                <tr>
+                    <td>{{$device}}</td>
+                    <td>{{$count}}</td>
+                </tr>
+                {{end}}
+            </tbody>
+        </table>
+    </div>
+    {{end}}
+
+    {{if .ClicksByReferrerCategory}}
+    <div class="section">
+        <h2>Traffic Sources</h2>
+        <table>
+            <thead>
+                <tr>
+                    <th>Source</th>
+                    <th>Clicks</th>
+                </tr>
+            </thead>
+            <tbody>
+                {{range $category, $count := .ClicksByReferrerCategory}}
+                <tr>
+                    <td>{{$category}}</td>
+                    <td>{{$count}}</td>
+                </tr>
+                {{end}}
+            </tbody>
+        </table>
+        {{if .ClicksByReferrer}}
+        <h3>Top Referrers</h3>
+        <table>
+            <thead>
+                <tr>
+                    <th>Referrer</th>
+                    <th>Clicks</th>
+                </tr>
+            </thead>
+            <tbody>
+                {{range $host, $count := .ClicksByReferrer}}
+                <tr>
+                    <td>{{$host}}</td>
+                    <td>{{$count}}</td>
+                </tr>
+                {{end}}
+            </tbody>
+        </table>
+        {{end}}
+    </div>
+    {{end}}
+
+    {{if .ClicksByCampaign}}
+    <div class="section">
+        <h2>Clicks by Campaign</h2>
+        <table>
+            <thead>
+                <tr>
+                    <th>Campaign</th>
+                    <th>Clicks</th>
+                </tr>
+            </thead>
+            <tbody>
+                {{range $campaign, $count := .ClicksByCampaign}}
+                <tr>
+                    <td>{{$campaign}}</td>
+                    <td>{{$count}}</td>
+                </tr>
+                {{end}}
+            </tbody>
+        </table>
+    </div>
+    {{end}}
+
+    {{if .URL.TargetingRules}}
+    <div class="section">
+        <h2>Clicks by Destination</h2>
+        <table>
+            <thead>
+                <tr>
+                    <th>Rule</th>
+                    <th>Matches</th>
+                    <th>Destination</th>
+                    <th>Clicks</th>
+                </tr>
+            </thead>
+            <tbody>
+                {{range .URL.TargetingRules}}
+                <tr>
+                    <td>{{.Name}}</td>
+                    <td>{{or .OS "any OS"}}, {{or .Device "any device"}}{{if .Countries}}, from {{join .Countries ", "}}{{end}}{{if .Continents}}, in {{join .Continents ", "}}{{end}}</td>
+                    <td class="short-url">{{.URL}}</td>
+                    <td>{{index $.ClicksByRule .Name}}</td>
+                </tr>
+                {{end}}
+                <tr>
+                    <td>default</td>
+                    <td>everyone else</td>
+                    <td class="short-url">{{if .URL.Variants}}A/B split{{else}}{{.URL.OriginalURL}}{{end}}</td>
+                    <td>{{index .ClicksByRule "default"}}</td>
+                </tr>
+            </tbody>
+        </table>
+    </div>
+    {{end}}
+
+    {{if .Variants}}
+    <div class="section">
+        <h2>A/B Variants</h2>
+        <table>
+            <thead>
+                <tr>
+                    <th>Variant</th>
+                    <th>Destination</th>
+                    <th>Weight</th>
+                    <th>Clicks</th>
+                    <th>Unique Visitors</th>
+                </tr>
+            </thead>
+            <tbody>
+                {{range .Variants}}
+                <tr>
+                    <td>{{.Name}}</td>
+                    <td class="short-url">{{or .URL "removed"}}</td>
+                    <td>{{.Weight}}</td>
+                    <td>{{.Clicks}}</td>
+                    <td>{{.UniqueVisitors}}</td>
+                </tr>
+                {{end}}
+            </tbody>
+        </table>
+    </div>
+    {{end}}
+
     <div class="section">
-        <h2>Clicks Over Time (Last 30 Days)</h2>
-        {{if .ClicksByDay}}
+        <h2>Clicks Over Time</h2>
+        <p>{{.From.Format "Jan 2, 2006 15:04"}} to {{.To.Format "Jan 2, 2006 15:04"}} {{.Timezone}}, by {{.Granularity}}</p>
+        {{if .TotalClicks}}
         <div class="chart">
-            {{range .ClicksByDay}}
+            {{range .ClicksOverTime}}
             <div class="day-item">
-                <div class="day-date">{{.Date}}</div>
+                <div class="day-date">{{.Label}}</div>
                 <div class="day-bar">
                     <div class="day-fill" style="width: {{div (mul .Clicks 100) $.TotalClicks}}%">
                         {{.Clicks}}
@@ -529,6 +1348,38 @@ func (h *URLHandler) AnalyticsPage(w http.ResponseWriter, r *http.Request) {
         {{end}}
     </div>
 
+    {{if .URL.ClickIDParam}}
+    <div class="section">
+        <h2>Conversions Over Time</h2>
+        {{if .Conversions}}
+        <table>
+            <thead>
+                <tr>
+                    <th>Period</th>
+                    <th>Conversions</th>
+                    <th>Conversion Rate</th>
+                    <th>Revenue</th>
+                </tr>
+            </thead>
+            <tbody>
+                {{range .ConversionsOverTime}}
+                {{if .Conversions}}
+                <tr>
+                    <td>{{.Label}}</td>
+                    <td>{{.Conversions}}</td>
+                    <td>{{percent .ConversionRate}}</td>
+                    <td>{{range $currency, $sum := .Revenue}}{{money $sum}} {{$currency}} {{else}}-{{end}}</td>
+                </tr>
+                {{end}}
+                {{end}}
+            </tbody>
+        </table>
+        {{else}}
+        <p class="no-data">No conversions reported yet</p>
+        {{end}}
+    </div>
+    {{end}}
+
     <div class="section">
         <h2>Recent Clicks</h2>
         {{if .RecentClicks}}
@@ -538,7 +1389,7 @@ func (h *URLHandler) AnalyticsPage(w http.ResponseWriter, r *http.Request) {
                     <th>Time</th>
                     <th>IP Address</th>
                     <th>Location</th>
-                    <th>User Agent</th>
+                    <th>Browser</th>
                 </tr>
             </thead>
             <tbody>
@@ -547,7 +1398,7 @@ func (h *URLHandler) AnalyticsPage(w http.ResponseWriter, r *http.Request) {
                     <td>{{.ClickedAt.Format "Jan 2, 15:04:05"}}</td>
                     <td>{{.IPAddress}}</td>
                     <td>{{if .Country}}{{.City}}, {{.Country}}{{else}}Unknown{{end}}</td>
-                    <td style="max-width: 300px; overflow: hidden; text-overflow: ellipsis; white-space: nowrap;">{{.UserAgent}}</td>
+                    <td style="max-width: 300px; overflow: hidden; text-overflow: ellipsis; white-space: nowrap;" title="{{.UserAgent}}">{{if .Browser}}{{.Browser}}{{with .BrowserVersion}} {{.}}{{end}} on {{.OS}}, {{.Device}}{{if .Bot}} ({{with .BotKind}}{{.}}{{else}}bot{{end}}){{end}}{{else}}{{.UserAgent}}{{end}}</td>
                 </tr>
                 {{end}}
             </tbody>
@@ -570,6 +1421,13 @@ func (h *URLHandler) AnalyticsPage(w http.ResponseWriter, r *http.Request) {
 		"mul": func(a, b int) int {
 			return a * b
 		},
+		"join": strings.Join,
+		"percent": func(rate float64) string {
+			return strconv.FormatFloat(rate*100, 'f', 1, 64) + "%"
+		},
+		"money": func(sum float64) string {
+			return strconv.FormatFloat(sum, 'f', 2, 64)
+		},
 	})
 	t, _ = t.Parse(tmpl)
 	t.Execute(w, analytics)
@@ -605,6 +1463,16 @@ func (h *URLHandler) getClientIP(r *http.Request) string {
 	return ip
 }
 
+// redirectToLogin sends browsers to the login form, coming back to the
+// current page afterwards.
+func redirectToLogin(w http.ResponseWriter, r *http.Request) {
+	http.Redirect(w, r, "/login?next="+neturl.QueryEscape(r.URL.RequestURI()), http.StatusSeeOther)
+}
+
+func isValidURL(u string) bool {
+	return strings.HasPrefix(u, "http://") || strings.HasPrefix(u, "https://")
+}
+
 func (h *URLHandler) getScheme(r *http.Request) string {
 	if r.TLS != nil {
 		return "https"
@@ -613,17 +1481,57 @@ func (h *URLHandler) getScheme(r *http.Request) string {
 }
 
 func (h *URLHandler) respondWithJSON(w http.ResponseWriter, code int, payload interface{}) {
+	respondWithJSON(w, code, payload)
+}
+
+func (h *URLHandler) respondWithError(w http.ResponseWriter, code int, error, message string) {
+	respondWithError(w, code, error, message)
+}
+
+func (h *URLHandler) respondWithServiceError(w http.ResponseWriter, title string, err error) {
+	respondWithServiceError(w, title, err)
+}
+
+// The helpers below are shared by all handlers in this package.
+
+func respondWithJSON(w http.ResponseWriter, code int, payload interface{}) {
 	response, _ := json.Marshal(payload)
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(code)
 	w.Write(response)
 }
 
-func (h *URLHandler) respondWithError(w http.ResponseWriter, code int, error, message string) {
+func respondWithError(w http.ResponseWriter, code int, error, message string) {
 	errorResponse := models.ErrorResponse{
 		Error:   error,
 		Message: message,
 		Code:    code,
 	}
-	h.respondWithJSON(w, code, errorResponse)
+	respondWithJSON(w, code, errorResponse)
+}
+
+// respondWithServiceError maps service sentinel errors to HTTP status codes.
+func respondWithServiceError(w http.ResponseWriter, title string, err error) {
+	code := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, services.ErrURLNotFound), errors.Is(err, services.ErrTeamNotFound),
+		errors.Is(err, services.ErrUserNotFound), errors.Is(err, services.ErrUnknownClick):
+		code = http.StatusNotFound
+	case errors.Is(err, services.ErrForbidden):
+		code = http.StatusForbidden
+	case errors.Is(err, services.ErrUnauthorized):
+		code = http.StatusUnauthorized
+	case errors.Is(err, services.ErrInvalidRole), errors.Is(err, services.ErrInvalidRedirectType),
+		errors.Is(err, services.ErrInvalidLinkPassword), errors.Is(err, services.ErrInvalidMaxClicks),
+		errors.Is(err, services.ErrInvalidActiveWindow), errors.Is(err, services.ErrInvalidFallbackURL),
+		errors.Is(err, services.ErrInvalidTargeting), errors.Is(err, services.ErrInvalidVariants),
+		errors.Is(err, services.ErrInvalidClickIDParam), errors.Is(err, services.ErrInvalidConversion),
+		errors.Is(err, services.ErrInvalidUTM), errors.Is(err, services.ErrInvalidTimeRange),
+		errors.Is(err, services.ErrInvalidGranularity), errors.Is(err, services.ErrTooManyBuckets),
+		errors.Is(err, services.ErrInvalidLimit):
+		code = http.StatusBadRequest
+	case errors.Is(err, services.ErrConversionExists):
+		code = http.StatusConflict
+	}
+	respondWithError(w, code, title, err.Error())
 }
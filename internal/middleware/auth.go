@@ -0,0 +1,100 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"url-shortener/internal/models"
+)
+
+type contextKey string
+
+const ownerIDKey contextKey = "ownerID"
+
+// SessionCookieName is the cookie holding the dashboard session token.
+const SessionCookieName = "session"
+
+// APIKeyAuthenticator resolves a plaintext API key to its stored record.
+type APIKeyAuthenticator interface {
+	Authenticate(key string) (*models.APIKey, error)
+}
+
+// SessionAuthenticator resolves a dashboard session token to its user.
+type SessionAuthenticator interface {
+	SessionUser(token string) (*models.User, error)
+}
+
+// SessionMiddleware resolves the session cookie set at login to an owner ID.
+// Missing or expired sessions leave the request anonymous, so pages can send
+// the visitor to the login form.
+func SessionMiddleware(sessions SessionAuthenticator) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			cookie, err := r.Cookie(SessionCookieName)
+			if err != nil || cookie.Value == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			user, err := sessions.SessionUser(cookie.Value)
+			if err != nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), ownerIDKey, user.Username)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// AuthMiddleware resolves "Authorization: Bearer <key>" to an owner ID and
+// stores it in the request context. Requests without the header pass through
+// anonymously; handlers that need an owner check OwnerIDFromContext.
+func AuthMiddleware(auth APIKeyAuthenticator) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			header := r.Header.Get("Authorization")
+			if header == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			scheme, key, found := strings.Cut(header, " ")
+			if !found || !strings.EqualFold(scheme, "Bearer") || strings.TrimSpace(key) == "" {
+				respondUnauthorized(w, "Authorization header must be \"Bearer <key>\"")
+				return
+			}
+
+			apiKey, err := auth.Authenticate(strings.TrimSpace(key))
+			if err != nil {
+				respondUnauthorized(w, err.Error())
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), ownerIDKey, apiKey.OwnerID)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// OwnerIDFromContext returns the owner set by AuthMiddleware, or "" for
+// anonymous requests.
+func OwnerIDFromContext(ctx context.Context) string {
+	ownerID, _ := ctx.Value(ownerIDKey).(string)
+	return ownerID
+}
+
+func respondUnauthorized(w http.ResponseWriter, message string) {
+	response, _ := json.Marshal(models.ErrorResponse{
+		Error:   "Unauthorized",
+		Message: message,
+		Code:    http.StatusUnauthorized,
+	})
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("WWW-Authenticate", "Bearer")
+	w.WriteHeader(http.StatusUnauthorized)
+	w.Write(response)
+}
@@ -6,11 +6,14 @@ import (
 	"strings"
 	"sync"
 	"time"
+
+	"url-shortener/internal/config"
 )
 
 type RateLimiter struct {
 	visitors map[string]*visitor
 	mu       sync.Mutex
+	config   config.RateLimit
 }
 
 type visitor struct {
@@ -18,26 +21,26 @@ type visitor struct {
 	requests int
 }
 
-var rateLimiter = &RateLimiter{
-	visitors: make(map[string]*visitor),
-}
+// RateLimitMiddleware allows cfg.Requests per cfg.Window from each client
+// IP. Each call has its own counters.
+func RateLimitMiddleware(cfg config.RateLimit) func(http.Handler) http.Handler {
+	rateLimiter := &RateLimiter{
+		visitors: make(map[string]*visitor),
+		config:   cfg,
+	}
 
-const (
-	maxRequests = 10              // Maximum requests per window
-	timeWindow  = 1 * time.Minute // Time window for rate limiting
-)
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ip := getClientIP(r)
 
-func RateLimitMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		ip := getClientIP(r)
+			if !rateLimiter.allowRequest(ip) {
+				http.Error(w, "Rate limit exceeded. Try again later.", http.StatusTooManyRequests)
+				return
+			}
 
-		if !rateLimiter.allowRequest(ip) {
-			http.Error(w, "Rate limit exceeded. Try again later.", http.StatusTooManyRequests)
-			return
-		}
-
-		next.ServeHTTP(w, r)
-	})
+			next.ServeHTTP(w, r)
+		})
+	}
 }
 
 // allowRequest checks if a request from the given IP is allowed
@@ -58,13 +61,13 @@ func (rl *RateLimiter) allowRequest(ip string) bool {
 		return true
 	}
 
-	if now.Sub(v.lastSeen) > timeWindow {
+	if now.Sub(v.lastSeen) > rl.config.Window {
 		v.requests = 1
 		v.lastSeen = now
 		return true
 	}
 
-	if v.requests >= maxRequests {
+	if v.requests >= rl.config.Requests {
 		return false
 	}
 
@@ -76,7 +79,7 @@ func (rl *RateLimiter) allowRequest(ip string) bool {
 // cleanup removes old entries to prevent memory leaks
 func (rl *RateLimiter) cleanup(now time.Time) {
 	for ip, v := range rl.visitors {
-		if now.Sub(v.lastSeen) > timeWindow*2 {
+		if now.Sub(v.lastSeen) > rl.config.Window*2 {
 			delete(rl.visitors, ip)
 		}
 	}
@@ -85,7 +88,7 @@ func (rl *RateLimiter) cleanup(now time.Time) {
 func CORSMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
 		w.Header().Set("Access-Control-Allow-Headers", "Origin, Content-Type, Accept, Authorization, X-Requested-With")
 
 		if r.Method == "OPTIONS" {
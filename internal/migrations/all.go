@@ -0,0 +1,462 @@
+package migrations
+
+import "database/sql"
+
+// All is the ordered list of schema migrations. Append new migrations with
+// the next version number; never edit one that has been released.
+var All = []Migration{
+	{
+		Version: 1,
+		Name:    "create_urls_and_clicks",
+		Up: func(tx *sql.Tx) error {
+			return exec(tx, `
+			CREATE TABLE IF NOT EXISTS urls (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				short_code VARCHAR(20) UNIQUE NOT NULL,
+				original_url TEXT NOT NULL,
+				created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+				expires_at DATETIME,
+				click_count INTEGER DEFAULT 0,
+				user_ip VARCHAR(45),
+				is_custom BOOLEAN DEFAULT FALSE
+			);`, `
+			CREATE TABLE IF NOT EXISTS clicks (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				url_short_code VARCHAR(20) NOT NULL,
+				ip_address VARCHAR(45),
+				user_agent TEXT,
+				referer TEXT,
+				country VARCHAR(100),
+				city VARCHAR(100),
+				clicked_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+				FOREIGN KEY (url_short_code) REFERENCES urls(short_code)
+			);`,
+				"CREATE INDEX IF NOT EXISTS idx_urls_short_code ON urls(short_code);",
+				"CREATE INDEX IF NOT EXISTS idx_clicks_short_code ON clicks(url_short_code);",
+				"CREATE INDEX IF NOT EXISTS idx_clicks_clicked_at ON clicks(clicked_at);",
+			)
+		},
+		Down: func(tx *sql.Tx) error {
+			return exec(tx, "DROP TABLE IF EXISTS clicks;", "DROP TABLE IF EXISTS urls;")
+		},
+	},
+	{
+		Version: 2,
+		Name:    "add_urls_deleted_at",
+		Up: func(tx *sql.Tx) error {
+			return addColumnIfMissing(tx, "urls", "deleted_at", "DATETIME")
+		},
+		Down: func(tx *sql.Tx) error {
+			return exec(tx, "ALTER TABLE urls DROP COLUMN deleted_at;")
+		},
+	},
+	{
+		Version: 3,
+		Name:    "create_api_keys",
+		Up: func(tx *sql.Tx) error {
+			if err := addColumnIfMissing(tx, "urls", "owner_id", "VARCHAR(64)"); err != nil {
+				return err
+			}
+			return exec(tx, `
+			CREATE TABLE IF NOT EXISTS api_keys (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				owner_id VARCHAR(64) NOT NULL,
+				name VARCHAR(100),
+				prefix VARCHAR(16) NOT NULL,
+				key_hash VARCHAR(64) UNIQUE NOT NULL,
+				created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+				last_used_at DATETIME,
+				revoked_at DATETIME
+			);`,
+				"CREATE INDEX IF NOT EXISTS idx_urls_owner_id ON urls(owner_id);",
+			)
+		},
+		Down: func(tx *sql.Tx) error {
+			return exec(tx,
+				"DROP INDEX IF EXISTS idx_urls_owner_id;",
+				"ALTER TABLE urls DROP COLUMN owner_id;",
+				"DROP TABLE IF EXISTS api_keys;",
+			)
+		},
+	},
+	{
+		Version: 4,
+		Name:    "create_users_and_teams",
+		Up: func(tx *sql.Tx) error {
+			if err := addColumnIfMissing(tx, "urls", "team_id", "INTEGER"); err != nil {
+				return err
+			}
+			return exec(tx, `
+			CREATE TABLE IF NOT EXISTS users (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				username VARCHAR(64) UNIQUE NOT NULL,
+				password_hash VARCHAR(100) NOT NULL,
+				created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+			);`, `
+			CREATE TABLE IF NOT EXISTS teams (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				name VARCHAR(100) UNIQUE NOT NULL,
+				created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+			);`, `
+			CREATE TABLE IF NOT EXISTS team_members (
+				team_id INTEGER NOT NULL,
+				user_id INTEGER NOT NULL,
+				role VARCHAR(16) NOT NULL,
+				created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+				PRIMARY KEY (team_id, user_id),
+				FOREIGN KEY (team_id) REFERENCES teams(id),
+				FOREIGN KEY (user_id) REFERENCES users(id)
+			);`, `
+			CREATE TABLE IF NOT EXISTS sessions (
+				token_hash VARCHAR(64) PRIMARY KEY,
+				user_id INTEGER NOT NULL,
+				created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+				expires_at DATETIME NOT NULL,
+				FOREIGN KEY (user_id) REFERENCES users(id)
+			);`,
+				"CREATE INDEX IF NOT EXISTS idx_urls_team_id ON urls(team_id);",
+				"CREATE INDEX IF NOT EXISTS idx_team_members_user_id ON team_members(user_id);",
+			)
+		},
+		Down: func(tx *sql.Tx) error {
+			return exec(tx,
+				"DROP INDEX IF EXISTS idx_urls_team_id;",
+				"ALTER TABLE urls DROP COLUMN team_id;",
+				"DROP TABLE IF EXISTS sessions;",
+				"DROP TABLE IF EXISTS team_members;",
+				"DROP TABLE IF EXISTS teams;",
+				"DROP TABLE IF EXISTS users;",
+			)
+		},
+	},
+	{
+		Version: 5,
+		Name:    "add_clicks_region_asn",
+		Up: func(tx *sql.Tx) error {
+			if err := addColumnIfMissing(tx, "clicks", "region", "VARCHAR(100)"); err != nil {
+				return err
+			}
+			if err := addColumnIfMissing(tx, "clicks", "asn", "INTEGER"); err != nil {
+				return err
+			}
+			return addColumnIfMissing(tx, "clicks", "as_org", "VARCHAR(255)")
+		},
+		Down: func(tx *sql.Tx) error {
+			return exec(tx,
+				"ALTER TABLE clicks DROP COLUMN region;",
+				"ALTER TABLE clicks DROP COLUMN asn;",
+				"ALTER TABLE clicks DROP COLUMN as_org;",
+			)
+		},
+	},
+	{
+		Version: 6,
+		Name:    "add_urls_redirect_type",
+		Up: func(tx *sql.Tx) error {
+			return addColumnIfMissing(tx, "urls", "redirect_type", "INTEGER NOT NULL DEFAULT 302")
+		},
+		Down: func(tx *sql.Tx) error {
+			return exec(tx, "ALTER TABLE urls DROP COLUMN redirect_type;")
+		},
+	},
+	{
+		Version: 7,
+		Name:    "add_link_passwords",
+		Up: func(tx *sql.Tx) error {
+			if err := addColumnIfMissing(tx, "urls", "password_hash", "VARCHAR(100)"); err != nil {
+				return err
+			}
+			return exec(tx, `
+			CREATE TABLE IF NOT EXISTS unlock_attempts (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				url_short_code VARCHAR(20) NOT NULL,
+				ip_address VARCHAR(45),
+				success BOOLEAN NOT NULL,
+				attempted_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+				FOREIGN KEY (url_short_code) REFERENCES urls(short_code)
+			);`,
+				"CREATE INDEX IF NOT EXISTS idx_unlock_attempts_short_code ON unlock_attempts(url_short_code);",
+			)
+		},
+		Down: func(tx *sql.Tx) error {
+			return exec(tx,
+				"DROP TABLE IF EXISTS unlock_attempts;",
+				"ALTER TABLE urls DROP COLUMN password_hash;",
+			)
+		},
+	},
+	{
+		Version: 8,
+		Name:    "add_urls_max_clicks",
+		Up: func(tx *sql.Tx) error {
+			return addColumnIfMissing(tx, "urls", "max_clicks", "INTEGER")
+		},
+		Down: func(tx *sql.Tx) error {
+			return exec(tx, "ALTER TABLE urls DROP COLUMN max_clicks;")
+		},
+	},
+	{
+		Version: 9,
+		Name:    "add_urls_activates_at",
+		Up: func(tx *sql.Tx) error {
+			return addColumnIfMissing(tx, "urls", "activates_at", "DATETIME")
+		},
+		Down: func(tx *sql.Tx) error {
+			return exec(tx, "ALTER TABLE urls DROP COLUMN activates_at;")
+		},
+	},
+	{
+		Version: 10,
+		Name:    "add_urls_fallback_url",
+		Up: func(tx *sql.Tx) error {
+			return addColumnIfMissing(tx, "urls", "fallback_url", "TEXT")
+		},
+		Down: func(tx *sql.Tx) error {
+			return exec(tx, "ALTER TABLE urls DROP COLUMN fallback_url;")
+		},
+	},
+	{
+		Version: 11,
+		Name:    "add_targeting_rules",
+		Up: func(tx *sql.Tx) error {
+			if err := addColumnIfMissing(tx, "urls", "targeting_rules", "TEXT"); err != nil {
+				return err
+			}
+			return addColumnIfMissing(tx, "clicks", "target_rule", "VARCHAR(50)")
+		},
+		Down: func(tx *sql.Tx) error {
+			return exec(tx,
+				"ALTER TABLE clicks DROP COLUMN target_rule;",
+				"ALTER TABLE urls DROP COLUMN targeting_rules;",
+			)
+		},
+	},
+	{
+		Version: 12,
+		Name:    "add_variants",
+		Up: func(tx *sql.Tx) error {
+			if err := addColumnIfMissing(tx, "urls", "variants", "TEXT"); err != nil {
+				return err
+			}
+			return addColumnIfMissing(tx, "clicks", "variant", "VARCHAR(50)")
+		},
+		Down: func(tx *sql.Tx) error {
+			return exec(tx,
+				"ALTER TABLE clicks DROP COLUMN variant;",
+				"ALTER TABLE urls DROP COLUMN variants;",
+			)
+		},
+	},
+	{
+		Version: 13,
+		Name:    "create_conversions",
+		Up: func(tx *sql.Tx) error {
+			if err := addColumnIfMissing(tx, "urls", "click_id_param", "VARCHAR(32)"); err != nil {
+				return err
+			}
+			if err := addColumnIfMissing(tx, "clicks", "click_id", "VARCHAR(64)"); err != nil {
+				return err
+			}
+			return exec(tx, `
+			CREATE TABLE IF NOT EXISTS conversions (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				click_id VARCHAR(64) UNIQUE NOT NULL,
+				url_short_code VARCHAR(20) NOT NULL,
+				value NUMERIC(18, 4) NOT NULL DEFAULT 0,
+				currency VARCHAR(3),
+				converted_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+				FOREIGN KEY (url_short_code) REFERENCES urls(short_code)
+			);`,
+				"CREATE INDEX IF NOT EXISTS idx_conversions_short_code ON conversions(url_short_code);",
+			)
+		},
+		Down: func(tx *sql.Tx) error {
+			return exec(tx,
+				"DROP TABLE IF EXISTS conversions;",
+				"ALTER TABLE clicks DROP COLUMN click_id;",
+				"ALTER TABLE urls DROP COLUMN click_id_param;",
+			)
+		},
+	},
+	{
+		Version: 14,
+		Name:    "add_utm_campaigns",
+		Up: func(tx *sql.Tx) error {
+			if err := addColumnIfMissing(tx, "urls", "forward_query", "BOOLEAN NOT NULL DEFAULT FALSE"); err != nil {
+				return err
+			}
+			return addColumnIfMissing(tx, "clicks", "utm_campaign", "VARCHAR(255)")
+		},
+		Down: func(tx *sql.Tx) error {
+			return exec(tx,
+				"ALTER TABLE clicks DROP COLUMN utm_campaign;",
+				"ALTER TABLE urls DROP COLUMN forward_query;",
+			)
+		},
+	},
+	{
+		Version: 15,
+		Name:    "add_click_user_agent_fields",
+		Up: func(tx *sql.Tx) error {
+			columns := [][2]string{
+				{"browser", "VARCHAR(20)"},
+				{"browser_version", "VARCHAR(20)"},
+				{"os", "VARCHAR(20)"},
+				{"device", "VARCHAR(20)"},
+				{"is_bot", "BOOLEAN NOT NULL DEFAULT FALSE"},
+			}
+			for _, column := range columns {
+				if err := addColumnIfMissing(tx, "clicks", column[0], column[1]); err != nil {
+					return err
+				}
+			}
+			return parseUserAgents(tx, `UPDATE clicks SET browser = ?, browser_version = ?, os = ?, device = ?, is_bot = ? WHERE user_agent = ?`)
+		},
+		Down: func(tx *sql.Tx) error {
+			return exec(tx,
+				"ALTER TABLE clicks DROP COLUMN is_bot;",
+				"ALTER TABLE clicks DROP COLUMN device;",
+				"ALTER TABLE clicks DROP COLUMN os;",
+				"ALTER TABLE clicks DROP COLUMN browser_version;",
+				"ALTER TABLE clicks DROP COLUMN browser;",
+			)
+		},
+	},
+	{
+		Version: 16,
+		Name:    "add_click_referrers",
+		Up: func(tx *sql.Tx) error {
+			if err := addColumnIfMissing(tx, "clicks", "referrer_host", "VARCHAR(255)"); err != nil {
+				return err
+			}
+			if err := addColumnIfMissing(tx, "clicks", "referrer_category", "VARCHAR(20)"); err != nil {
+				return err
+			}
+			return classifyReferrers(tx, `UPDATE clicks SET referrer_host = ?, referrer_category = ? WHERE url_short_code = ? AND COALESCE(referer, '') = ?`)
+		},
+		Down: func(tx *sql.Tx) error {
+			return exec(tx,
+				"ALTER TABLE clicks DROP COLUMN referrer_category;",
+				"ALTER TABLE clicks DROP COLUMN referrer_host;",
+			)
+		},
+	},
+	{
+		Version: 17,
+		Name:    "add_click_rollups",
+		Up: func(tx *sql.Tx) error {
+			bucket := "CAST(strftime('%s', substr(clicked_at, 1, 19)) AS INTEGER)"
+			return exec(tx, `
+			CREATE TABLE IF NOT EXISTS click_rollups_hourly (
+				url_short_code VARCHAR(20) NOT NULL,
+				bucket INTEGER NOT NULL,
+				is_bot BOOLEAN NOT NULL,
+				country VARCHAR(100) NOT NULL,
+				clicks INTEGER NOT NULL,
+				PRIMARY KEY (url_short_code, bucket, is_bot, country)
+			);`, `
+			CREATE TABLE IF NOT EXISTS click_rollups_daily (
+				url_short_code VARCHAR(20) NOT NULL,
+				bucket INTEGER NOT NULL,
+				is_bot BOOLEAN NOT NULL,
+				country VARCHAR(100) NOT NULL,
+				clicks INTEGER NOT NULL,
+				PRIMARY KEY (url_short_code, bucket, is_bot, country)
+			);`, `
+			CREATE TABLE IF NOT EXISTS click_visitors_daily (
+				url_short_code VARCHAR(20) NOT NULL,
+				bucket INTEGER NOT NULL,
+				is_bot BOOLEAN NOT NULL,
+				ip_address VARCHAR(45) NOT NULL,
+				PRIMARY KEY (url_short_code, bucket, is_bot, ip_address)
+			);`, `
+			INSERT INTO click_rollups_hourly (url_short_code, bucket, is_bot, country, clicks)
+			SELECT url_short_code, `+bucket+` / 3600 * 3600, is_bot, COALESCE(country, 'Unknown'), COUNT(*)
+			FROM clicks WHERE `+bucket+` IS NOT NULL
+			GROUP BY 1, 2, 3, 4;`, `
+			INSERT INTO click_rollups_daily (url_short_code, bucket, is_bot, country, clicks)
+			SELECT url_short_code, `+bucket+` / 86400 * 86400, is_bot, COALESCE(country, 'Unknown'), COUNT(*)
+			FROM clicks WHERE `+bucket+` IS NOT NULL
+			GROUP BY 1, 2, 3, 4;`, `
+			INSERT INTO click_visitors_daily (url_short_code, bucket, is_bot, ip_address)
+			SELECT DISTINCT url_short_code, `+bucket+` / 86400 * 86400, is_bot, ip_address
+			FROM clicks WHERE `+bucket+` IS NOT NULL AND ip_address IS NOT NULL;`,
+			)
+		},
+		Down: func(tx *sql.Tx) error {
+			return exec(tx,
+				"DROP TABLE IF EXISTS click_visitors_daily;",
+				"DROP TABLE IF EXISTS click_rollups_daily;",
+				"DROP TABLE IF EXISTS click_rollups_hourly;",
+			)
+		},
+	},
+	{
+		Version: 18,
+		Name:    "add_clicks_click_id_index",
+		Up: func(tx *sql.Tx) error {
+			return exec(tx, "CREATE INDEX IF NOT EXISTS idx_clicks_click_id ON clicks(click_id);")
+		},
+		Down: func(tx *sql.Tx) error {
+			return exec(tx, "DROP INDEX IF EXISTS idx_clicks_click_id;")
+		},
+	},
+	{
+		Version: 19,
+		Name:    "add_click_dimension_rollups",
+		Up: func(tx *sql.Tx) error {
+			err := exec(tx, `
+			CREATE TABLE IF NOT EXISTS click_dimensions_hourly (
+				url_short_code VARCHAR(20) NOT NULL,
+				bucket INTEGER NOT NULL,
+				is_bot BOOLEAN NOT NULL,
+				dimension VARCHAR(20) NOT NULL,
+				value VARCHAR(255) NOT NULL,
+				clicks INTEGER NOT NULL,
+				PRIMARY KEY (url_short_code, bucket, is_bot, dimension, value)
+			);`, `
+			CREATE TABLE IF NOT EXISTS click_dimensions_daily (
+				url_short_code VARCHAR(20) NOT NULL,
+				bucket INTEGER NOT NULL,
+				is_bot BOOLEAN NOT NULL,
+				dimension VARCHAR(20) NOT NULL,
+				value VARCHAR(255) NOT NULL,
+				clicks INTEGER NOT NULL,
+				PRIMARY KEY (url_short_code, bucket, is_bot, dimension, value)
+			);`, `
+			CREATE TABLE IF NOT EXISTS click_variant_visitors_daily (
+				url_short_code VARCHAR(20) NOT NULL,
+				bucket INTEGER NOT NULL,
+				is_bot BOOLEAN NOT NULL,
+				variant VARCHAR(50) NOT NULL,
+				ip_address VARCHAR(45) NOT NULL,
+				PRIMARY KEY (url_short_code, bucket, is_bot, variant, ip_address)
+			);`,
+			)
+			if err != nil {
+				return err
+			}
+			return rollUpDimensions(tx, "CAST(strftime('%s', substr(clicked_at, 1, 19)) AS INTEGER)")
+		},
+		Down: func(tx *sql.Tx) error {
+			return exec(tx,
+				"DROP TABLE IF EXISTS click_variant_visitors_daily;",
+				"DROP TABLE IF EXISTS click_dimensions_daily;",
+				"DROP TABLE IF EXISTS click_dimensions_hourly;",
+			)
+		},
+	},
+	{
+		Version: 20,
+		Name:    "add_clicks_bot_kind",
+		Up: func(tx *sql.Tx) error {
+			if err := addColumnIfMissing(tx, "clicks", "bot_kind", "VARCHAR(20)"); err != nil {
+				return err
+			}
+			return classifyBots(tx, `UPDATE clicks SET bot_kind = ? WHERE is_bot = TRUE AND user_agent = ?`)
+		},
+		Down: func(tx *sql.Tx) error {
+			return exec(tx, "ALTER TABLE clicks DROP COLUMN bot_kind;")
+		},
+	},
+}
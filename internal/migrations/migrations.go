@@ -0,0 +1,395 @@
+// Package migrations applies numbered, reversible schema changes to the
+// database and records them in the schema_migrations table. SQLite and
+// PostgreSQL each have their own list with matching version numbers.
+package migrations
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"url-shortener/internal/referrer"
+	"url-shortener/internal/useragent"
+)
+
+// Migration is one numbered schema change. Up and Down run inside a
+// transaction together with the schema_migrations bookkeeping.
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(tx *sql.Tx) error
+	Down    func(tx *sql.Tx) error
+}
+
+// Status reports whether a migration has been applied.
+type Status struct {
+	Version   int
+	Name      string
+	AppliedAt *time.Time
+}
+
+type Migrator struct {
+	db         *sql.DB
+	migrations []Migration
+	postgres   bool
+}
+
+// New returns a Migrator for the built-in SQLite migrations.
+func New(db *sql.DB) *Migrator {
+	return NewWithMigrations(db, All)
+}
+
+// ForDriver returns a Migrator for the built-in migrations of driver, which
+// is "sqlite" or "postgres".
+func ForDriver(db *sql.DB, driver string) *Migrator {
+	if driver == "postgres" {
+		m := NewWithMigrations(db, Postgres)
+		m.postgres = true
+		return m
+	}
+	return New(db)
+}
+
+// NewWithMigrations returns a Migrator for a custom migration list.
+func NewWithMigrations(db *sql.DB, migrations []Migration) *Migrator {
+	sorted := make([]Migration, len(migrations))
+	copy(sorted, migrations)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Version < sorted[j].Version })
+
+	return &Migrator{db: db, migrations: sorted}
+}
+
+// Up applies every pending migration in version order and returns the ones
+// it applied. It stops at the first failure, leaving earlier ones applied.
+func (m *Migrator) Up() ([]Migration, error) {
+	applied, err := m.appliedVersions()
+	if err != nil {
+		return nil, err
+	}
+
+	var done []Migration
+	for _, migration := range m.migrations {
+		if _, ok := applied[migration.Version]; ok {
+			continue
+		}
+
+		err := m.inTx(func(tx *sql.Tx) error {
+			if err := migration.Up(tx); err != nil {
+				return err
+			}
+			_, err := tx.Exec(m.rebind(`INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)`),
+				migration.Version, migration.Name, time.Now())
+			return err
+		})
+		if err != nil {
+			return done, fmt.Errorf("migration %d (%s) failed: %v", migration.Version, migration.Name, err)
+		}
+
+		done = append(done, migration)
+	}
+
+	return done, nil
+}
+
+// Down rolls back the most recently applied migrations, newest first, and
+// returns the ones it rolled back.
+func (m *Migrator) Down(steps int) ([]Migration, error) {
+	applied, err := m.appliedVersions()
+	if err != nil {
+		return nil, err
+	}
+
+	var done []Migration
+	for i := len(m.migrations) - 1; i >= 0 && len(done) < steps; i-- {
+		migration := m.migrations[i]
+		if _, ok := applied[migration.Version]; !ok {
+			continue
+		}
+
+		err := m.inTx(func(tx *sql.Tx) error {
+			if err := migration.Down(tx); err != nil {
+				return err
+			}
+			_, err := tx.Exec(m.rebind(`DELETE FROM schema_migrations WHERE version = ?`), migration.Version)
+			return err
+		})
+		if err != nil {
+			return done, fmt.Errorf("rollback of migration %d (%s) failed: %v", migration.Version, migration.Name, err)
+		}
+
+		done = append(done, migration)
+	}
+
+	return done, nil
+}
+
+// Status lists every known migration with the time it was applied, if any.
+func (m *Migrator) Status() ([]Status, error) {
+	applied, err := m.appliedVersions()
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]Status, 0, len(m.migrations))
+	for _, migration := range m.migrations {
+		status := Status{Version: migration.Version, Name: migration.Name}
+		if at, ok := applied[migration.Version]; ok {
+			appliedAt := at
+			status.AppliedAt = &appliedAt
+		}
+		statuses = append(statuses, status)
+	}
+
+	return statuses, nil
+}
+
+func (m *Migrator) appliedVersions() (map[int]time.Time, error) {
+	createTable := `
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name VARCHAR(100) NOT NULL,
+		applied_at TIMESTAMP NOT NULL
+	);`
+
+	if _, err := m.db.Exec(createTable); err != nil {
+		return nil, fmt.Errorf("failed to create schema_migrations table: %v", err)
+	}
+
+	rows, err := m.db.Query(`SELECT version, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var appliedAt time.Time
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = appliedAt
+	}
+
+	return applied, rows.Err()
+}
+
+// rebind numbers the ? placeholders of the bookkeeping queries for
+// PostgreSQL.
+func (m *Migrator) rebind(query string) string {
+	if !m.postgres {
+		return query
+	}
+	for n := 1; strings.Contains(query, "?"); n++ {
+		query = strings.Replace(query, "?", fmt.Sprintf("$%d", n), 1)
+	}
+	return query
+}
+
+func (m *Migrator) inTx(fn func(tx *sql.Tx) error) error {
+	tx, err := m.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// exec runs statements in order, stopping at the first error.
+func exec(tx *sql.Tx, statements ...string) error {
+	for _, statement := range statements {
+		if _, err := tx.Exec(statement); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseUserAgents fills in the parsed User-Agent columns of the clicks
+// recorded before they existed. update sets browser, browser_version, os,
+// device and is_bot of the clicks with the user_agent given last.
+func parseUserAgents(tx *sql.Tx, update string) error {
+	rows, err := tx.Query(`SELECT DISTINCT user_agent FROM clicks WHERE user_agent IS NOT NULL`)
+	if err != nil {
+		return err
+	}
+	var agents []string
+	for rows.Next() {
+		var ua string
+		if err := rows.Scan(&ua); err != nil {
+			rows.Close()
+			return err
+		}
+		agents = append(agents, ua)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, ua := range agents {
+		agent := useragent.Parse(ua)
+		version := sql.NullString{String: agent.BrowserVersion, Valid: agent.BrowserVersion != ""}
+		if _, err := tx.Exec(update, agent.Browser, version, agent.OS, agent.Device, agent.Bot, ua); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// classifyBots fills in the bot kind of the bot clicks recorded before it
+// existed, where their User-Agent tells it. update sets bot_kind of the bot
+// clicks with the user_agent given last.
+func classifyBots(tx *sql.Tx, update string) error {
+	rows, err := tx.Query(`SELECT DISTINCT user_agent FROM clicks WHERE is_bot = TRUE AND user_agent IS NOT NULL`)
+	if err != nil {
+		return err
+	}
+	var agents []string
+	for rows.Next() {
+		var ua string
+		if err := rows.Scan(&ua); err != nil {
+			rows.Close()
+			return err
+		}
+		agents = append(agents, ua)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, ua := range agents {
+		kind := useragent.Parse(ua).BotKind
+		if kind == "" {
+			// A HEAD request or prefetch, which the click does not record.
+			continue
+		}
+		if _, err := tx.Exec(update, kind, ua); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// classifyReferrers fills in the referrer columns of the clicks recorded
+// before they existed. Referrers from the link's destination count as
+// internal. update sets referrer_host and referrer_category of the clicks
+// with the short code and referer given last.
+func classifyReferrers(tx *sql.Tx, update string) error {
+	rows, err := tx.Query(`
+		SELECT DISTINCT clicks.url_short_code, COALESCE(clicks.referer, ''), urls.original_url
+		FROM clicks
+		JOIN urls ON urls.short_code = clicks.url_short_code`)
+	if err != nil {
+		return err
+	}
+	type pair struct{ shortCode, referer, destination string }
+	var pairs []pair
+	for rows.Next() {
+		var p pair
+		if err := rows.Scan(&p.shortCode, &p.referer, &p.destination); err != nil {
+			rows.Close()
+			return err
+		}
+		pairs = append(pairs, p)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, p := range pairs {
+		source := referrer.Parse(p.referer, p.destination)
+		host := sql.NullString{String: source.Host, Valid: source.Host != ""}
+		if _, err := tx.Exec(update, host, source.Category, p.shortCode, p.referer); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// clickDimensions lists the dimensions of the click_dimensions_hourly and
+// click_dimensions_daily rollups, with the value a click is counted under
+// and the condition for it to count at all.
+var clickDimensions = []struct{ name, value, where string }{
+	{"rule", "COALESCE(target_rule, '')", "TRUE"},
+	{"variant", "variant", "variant IS NOT NULL"},
+	{"campaign", "utm_campaign", "utm_campaign IS NOT NULL"},
+	{"browser", "browser", "browser IS NOT NULL"},
+	{"os", "COALESCE(os, '')", "browser IS NOT NULL"},
+	{"device", "COALESCE(device, '')", "browser IS NOT NULL"},
+	{"referrer_host", "referrer_host", "referrer_category IS NOT NULL AND referrer_host IS NOT NULL"},
+	{"referrer_category", "referrer_category", "referrer_category IS NOT NULL"},
+}
+
+// rollUpDimensions adds the clicks recorded so far to the dimension
+// rollups and the variant visitors. bucket converts clicked_at to Unix
+// seconds.
+func rollUpDimensions(tx *sql.Tx, bucket string) error {
+	var statements []string
+	for _, rollup := range []struct {
+		table string
+		unit  int
+	}{{"click_dimensions_hourly", 3600}, {"click_dimensions_daily", 86400}} {
+		for _, d := range clickDimensions {
+			statements = append(statements, fmt.Sprintf(`
+			INSERT INTO %s (url_short_code, bucket, is_bot, dimension, value, clicks)
+			SELECT url_short_code, %s / %d * %d, is_bot, '%s', %s, COUNT(*)
+			FROM clicks WHERE %s IS NOT NULL AND %s
+			GROUP BY 1, 2, 3, 5;`, rollup.table, bucket, rollup.unit, rollup.unit, d.name, d.value, bucket, d.where))
+		}
+	}
+	statements = append(statements, `
+			INSERT INTO click_variant_visitors_daily (url_short_code, bucket, is_bot, variant, ip_address)
+			SELECT DISTINCT url_short_code, `+bucket+` / 86400 * 86400, is_bot, variant, ip_address
+			FROM clicks WHERE `+bucket+` IS NOT NULL AND variant IS NOT NULL AND ip_address IS NOT NULL;`)
+	return exec(tx, statements...)
+}
+
+// addColumnIfMissing adds column to table unless PRAGMA table_info already
+// lists it. Databases created before migrations existed may already have
+// columns that later migrations add.
+func addColumnIfMissing(tx *sql.Tx, table, column, definition string) error {
+	exists, err := columnExists(tx, table, column)
+	if err != nil || exists {
+		return err
+	}
+
+	_, err = tx.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	return err
+}
+
+func columnExists(tx *sql.Tx, table, column string) (bool, error) {
+	rows, err := tx.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			cid       int
+			name      string
+			colType   string
+			notNull   int
+			dfltValue sql.NullString
+			pk        int
+		)
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &dfltValue, &pk); err != nil {
+			return false, err
+		}
+		if name == column {
+			return true, nil
+		}
+	}
+
+	return false, rows.Err()
+}
@@ -0,0 +1,442 @@
+package migrations
+
+import "database/sql"
+
+// Postgres mirrors All for PostgreSQL. Keep versions and names in step with
+// the SQLite list.
+var Postgres = []Migration{
+	{
+		Version: 1,
+		Name:    "create_urls_and_clicks",
+		Up: func(tx *sql.Tx) error {
+			return exec(tx, `
+			CREATE TABLE IF NOT EXISTS urls (
+				id SERIAL PRIMARY KEY,
+				short_code VARCHAR(20) UNIQUE NOT NULL,
+				original_url TEXT NOT NULL,
+				created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+				expires_at TIMESTAMPTZ,
+				click_count INTEGER DEFAULT 0,
+				user_ip VARCHAR(45),
+				is_custom BOOLEAN DEFAULT FALSE
+			);`, `
+			CREATE TABLE IF NOT EXISTS clicks (
+				id SERIAL PRIMARY KEY,
+				url_short_code VARCHAR(20) NOT NULL REFERENCES urls(short_code),
+				ip_address VARCHAR(45),
+				user_agent TEXT,
+				referer TEXT,
+				country VARCHAR(100),
+				city VARCHAR(100),
+				clicked_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+			);`,
+				"CREATE INDEX IF NOT EXISTS idx_clicks_short_code ON clicks(url_short_code);",
+				"CREATE INDEX IF NOT EXISTS idx_clicks_clicked_at ON clicks(clicked_at);",
+			)
+		},
+		Down: func(tx *sql.Tx) error {
+			return exec(tx, "DROP TABLE IF EXISTS clicks;", "DROP TABLE IF EXISTS urls;")
+		},
+	},
+	{
+		Version: 2,
+		Name:    "add_urls_deleted_at",
+		Up: func(tx *sql.Tx) error {
+			return exec(tx, "ALTER TABLE urls ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMPTZ;")
+		},
+		Down: func(tx *sql.Tx) error {
+			return exec(tx, "ALTER TABLE urls DROP COLUMN IF EXISTS deleted_at;")
+		},
+	},
+	{
+		Version: 3,
+		Name:    "create_api_keys",
+		Up: func(tx *sql.Tx) error {
+			return exec(tx,
+				"ALTER TABLE urls ADD COLUMN IF NOT EXISTS owner_id VARCHAR(64);", `
+			CREATE TABLE IF NOT EXISTS api_keys (
+				id SERIAL PRIMARY KEY,
+				owner_id VARCHAR(64) NOT NULL,
+				name VARCHAR(100),
+				prefix VARCHAR(16) NOT NULL,
+				key_hash VARCHAR(64) UNIQUE NOT NULL,
+				created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+				last_used_at TIMESTAMPTZ,
+				revoked_at TIMESTAMPTZ
+			);`,
+				"CREATE INDEX IF NOT EXISTS idx_urls_owner_id ON urls(owner_id);",
+			)
+		},
+		Down: func(tx *sql.Tx) error {
+			return exec(tx,
+				"DROP INDEX IF EXISTS idx_urls_owner_id;",
+				"ALTER TABLE urls DROP COLUMN IF EXISTS owner_id;",
+				"DROP TABLE IF EXISTS api_keys;",
+			)
+		},
+	},
+	{
+		Version: 4,
+		Name:    "create_users_and_teams",
+		Up: func(tx *sql.Tx) error {
+			return exec(tx,
+				"ALTER TABLE urls ADD COLUMN IF NOT EXISTS team_id INTEGER;", `
+			CREATE TABLE IF NOT EXISTS users (
+				id SERIAL PRIMARY KEY,
+				username VARCHAR(64) UNIQUE NOT NULL,
+				password_hash VARCHAR(100) NOT NULL,
+				created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+			);`, `
+			CREATE TABLE IF NOT EXISTS teams (
+				id SERIAL PRIMARY KEY,
+				name VARCHAR(100) UNIQUE NOT NULL,
+				created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+			);`, `
+			CREATE TABLE IF NOT EXISTS team_members (
+				team_id INTEGER NOT NULL REFERENCES teams(id),
+				user_id INTEGER NOT NULL REFERENCES users(id),
+				role VARCHAR(16) NOT NULL,
+				created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+				PRIMARY KEY (team_id, user_id)
+			);`, `
+			CREATE TABLE IF NOT EXISTS sessions (
+				token_hash VARCHAR(64) PRIMARY KEY,
+				user_id INTEGER NOT NULL REFERENCES users(id),
+				created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+				expires_at TIMESTAMPTZ NOT NULL
+			);`,
+				"CREATE INDEX IF NOT EXISTS idx_urls_team_id ON urls(team_id);",
+				"CREATE INDEX IF NOT EXISTS idx_team_members_user_id ON team_members(user_id);",
+			)
+		},
+		Down: func(tx *sql.Tx) error {
+			return exec(tx,
+				"DROP INDEX IF EXISTS idx_urls_team_id;",
+				"ALTER TABLE urls DROP COLUMN IF EXISTS team_id;",
+				"DROP TABLE IF EXISTS sessions;",
+				"DROP TABLE IF EXISTS team_members;",
+				"DROP TABLE IF EXISTS teams;",
+				"DROP TABLE IF EXISTS users;",
+			)
+		},
+	},
+	{
+		Version: 5,
+		Name:    "add_clicks_region_asn",
+		Up: func(tx *sql.Tx) error {
+			return exec(tx,
+				"ALTER TABLE clicks ADD COLUMN IF NOT EXISTS region VARCHAR(100);",
+				"ALTER TABLE clicks ADD COLUMN IF NOT EXISTS asn INTEGER;",
+				"ALTER TABLE clicks ADD COLUMN IF NOT EXISTS as_org VARCHAR(255);",
+			)
+		},
+		Down: func(tx *sql.Tx) error {
+			return exec(tx,
+				"ALTER TABLE clicks DROP COLUMN IF EXISTS region;",
+				"ALTER TABLE clicks DROP COLUMN IF EXISTS asn;",
+				"ALTER TABLE clicks DROP COLUMN IF EXISTS as_org;",
+			)
+		},
+	},
+	{
+		Version: 6,
+		Name:    "add_urls_redirect_type",
+		Up: func(tx *sql.Tx) error {
+			return exec(tx, "ALTER TABLE urls ADD COLUMN IF NOT EXISTS redirect_type INTEGER NOT NULL DEFAULT 302;")
+		},
+		Down: func(tx *sql.Tx) error {
+			return exec(tx, "ALTER TABLE urls DROP COLUMN IF EXISTS redirect_type;")
+		},
+	},
+	{
+		Version: 7,
+		Name:    "add_link_passwords",
+		Up: func(tx *sql.Tx) error {
+			return exec(tx,
+				"ALTER TABLE urls ADD COLUMN IF NOT EXISTS password_hash VARCHAR(100);", `
+			CREATE TABLE IF NOT EXISTS unlock_attempts (
+				id SERIAL PRIMARY KEY,
+				url_short_code VARCHAR(20) NOT NULL REFERENCES urls(short_code),
+				ip_address VARCHAR(45),
+				success BOOLEAN NOT NULL,
+				attempted_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+			);`,
+				"CREATE INDEX IF NOT EXISTS idx_unlock_attempts_short_code ON unlock_attempts(url_short_code);",
+			)
+		},
+		Down: func(tx *sql.Tx) error {
+			return exec(tx,
+				"DROP TABLE IF EXISTS unlock_attempts;",
+				"ALTER TABLE urls DROP COLUMN IF EXISTS password_hash;",
+			)
+		},
+	},
+	{
+		Version: 8,
+		Name:    "add_urls_max_clicks",
+		Up: func(tx *sql.Tx) error {
+			return exec(tx, "ALTER TABLE urls ADD COLUMN IF NOT EXISTS max_clicks INTEGER;")
+		},
+		Down: func(tx *sql.Tx) error {
+			return exec(tx, "ALTER TABLE urls DROP COLUMN IF EXISTS max_clicks;")
+		},
+	},
+	{
+		Version: 9,
+		Name:    "add_urls_activates_at",
+		Up: func(tx *sql.Tx) error {
+			return exec(tx, "ALTER TABLE urls ADD COLUMN IF NOT EXISTS activates_at TIMESTAMPTZ;")
+		},
+		Down: func(tx *sql.Tx) error {
+			return exec(tx, "ALTER TABLE urls DROP COLUMN IF EXISTS activates_at;")
+		},
+	},
+	{
+		Version: 10,
+		Name:    "add_urls_fallback_url",
+		Up: func(tx *sql.Tx) error {
+			return exec(tx, "ALTER TABLE urls ADD COLUMN IF NOT EXISTS fallback_url TEXT;")
+		},
+		Down: func(tx *sql.Tx) error {
+			return exec(tx, "ALTER TABLE urls DROP COLUMN IF EXISTS fallback_url;")
+		},
+	},
+	{
+		Version: 11,
+		Name:    "add_targeting_rules",
+		Up: func(tx *sql.Tx) error {
+			return exec(tx,
+				"ALTER TABLE urls ADD COLUMN IF NOT EXISTS targeting_rules TEXT;",
+				"ALTER TABLE clicks ADD COLUMN IF NOT EXISTS target_rule VARCHAR(50);",
+			)
+		},
+		Down: func(tx *sql.Tx) error {
+			return exec(tx,
+				"ALTER TABLE clicks DROP COLUMN IF EXISTS target_rule;",
+				"ALTER TABLE urls DROP COLUMN IF EXISTS targeting_rules;",
+			)
+		},
+	},
+	{
+		Version: 12,
+		Name:    "add_variants",
+		Up: func(tx *sql.Tx) error {
+			return exec(tx,
+				"ALTER TABLE urls ADD COLUMN IF NOT EXISTS variants TEXT;",
+				"ALTER TABLE clicks ADD COLUMN IF NOT EXISTS variant VARCHAR(50);",
+			)
+		},
+		Down: func(tx *sql.Tx) error {
+			return exec(tx,
+				"ALTER TABLE clicks DROP COLUMN IF EXISTS variant;",
+				"ALTER TABLE urls DROP COLUMN IF EXISTS variants;",
+			)
+		},
+	},
+	{
+		Version: 13,
+		Name:    "create_conversions",
+		Up: func(tx *sql.Tx) error {
+			return exec(tx,
+				"ALTER TABLE urls ADD COLUMN IF NOT EXISTS click_id_param VARCHAR(32);",
+				"ALTER TABLE clicks ADD COLUMN IF NOT EXISTS click_id VARCHAR(64);", `
+			CREATE TABLE IF NOT EXISTS conversions (
+				id SERIAL PRIMARY KEY,
+				click_id VARCHAR(64) UNIQUE NOT NULL,
+				url_short_code VARCHAR(20) NOT NULL REFERENCES urls(short_code),
+				value NUMERIC(18, 4) NOT NULL DEFAULT 0,
+				currency VARCHAR(3),
+				converted_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+			);`,
+				"CREATE INDEX IF NOT EXISTS idx_conversions_short_code ON conversions(url_short_code);",
+			)
+		},
+		Down: func(tx *sql.Tx) error {
+			return exec(tx,
+				"DROP TABLE IF EXISTS conversions;",
+				"ALTER TABLE clicks DROP COLUMN IF EXISTS click_id;",
+				"ALTER TABLE urls DROP COLUMN IF EXISTS click_id_param;",
+			)
+		},
+	},
+	{
+		Version: 14,
+		Name:    "add_utm_campaigns",
+		Up: func(tx *sql.Tx) error {
+			return exec(tx,
+				"ALTER TABLE urls ADD COLUMN IF NOT EXISTS forward_query BOOLEAN NOT NULL DEFAULT FALSE;",
+				"ALTER TABLE clicks ADD COLUMN IF NOT EXISTS utm_campaign VARCHAR(255);",
+			)
+		},
+		Down: func(tx *sql.Tx) error {
+			return exec(tx,
+				"ALTER TABLE clicks DROP COLUMN IF EXISTS utm_campaign;",
+				"ALTER TABLE urls DROP COLUMN IF EXISTS forward_query;",
+			)
+		},
+	},
+	{
+		Version: 15,
+		Name:    "add_click_user_agent_fields",
+		Up: func(tx *sql.Tx) error {
+			err := exec(tx,
+				"ALTER TABLE clicks ADD COLUMN IF NOT EXISTS browser VARCHAR(20);",
+				"ALTER TABLE clicks ADD COLUMN IF NOT EXISTS browser_version VARCHAR(20);",
+				"ALTER TABLE clicks ADD COLUMN IF NOT EXISTS os VARCHAR(20);",
+				"ALTER TABLE clicks ADD COLUMN IF NOT EXISTS device VARCHAR(20);",
+				"ALTER TABLE clicks ADD COLUMN IF NOT EXISTS is_bot BOOLEAN NOT NULL DEFAULT FALSE;",
+			)
+			if err != nil {
+				return err
+			}
+			return parseUserAgents(tx, `UPDATE clicks SET browser = $1, browser_version = $2, os = $3, device = $4, is_bot = $5 WHERE user_agent = $6`)
+		},
+		Down: func(tx *sql.Tx) error {
+			return exec(tx,
+				"ALTER TABLE clicks DROP COLUMN IF EXISTS is_bot;",
+				"ALTER TABLE clicks DROP COLUMN IF EXISTS device;",
+				"ALTER TABLE clicks DROP COLUMN IF EXISTS os;",
+				"ALTER TABLE clicks DROP COLUMN IF EXISTS browser_version;",
+				"ALTER TABLE clicks DROP COLUMN IF EXISTS browser;",
+			)
+		},
+	},
+	{
+		Version: 16,
+		Name:    "add_click_referrers",
+		Up: func(tx *sql.Tx) error {
+			err := exec(tx,
+				"ALTER TABLE clicks ADD COLUMN IF NOT EXISTS referrer_host VARCHAR(255);",
+				"ALTER TABLE clicks ADD COLUMN IF NOT EXISTS referrer_category VARCHAR(20);",
+			)
+			if err != nil {
+				return err
+			}
+			return classifyReferrers(tx, `UPDATE clicks SET referrer_host = $1, referrer_category = $2 WHERE url_short_code = $3 AND COALESCE(referer, '') = $4`)
+		},
+		Down: func(tx *sql.Tx) error {
+			return exec(tx,
+				"ALTER TABLE clicks DROP COLUMN IF EXISTS referrer_category;",
+				"ALTER TABLE clicks DROP COLUMN IF EXISTS referrer_host;",
+			)
+		},
+	},
+	{
+		Version: 17,
+		Name:    "add_click_rollups",
+		Up: func(tx *sql.Tx) error {
+			bucket := "FLOOR(EXTRACT(EPOCH FROM clicked_at))::BIGINT"
+			return exec(tx, `
+			CREATE TABLE IF NOT EXISTS click_rollups_hourly (
+				url_short_code VARCHAR(20) NOT NULL,
+				bucket BIGINT NOT NULL,
+				is_bot BOOLEAN NOT NULL,
+				country VARCHAR(100) NOT NULL,
+				clicks INTEGER NOT NULL,
+				PRIMARY KEY (url_short_code, bucket, is_bot, country)
+			);`, `
+			CREATE TABLE IF NOT EXISTS click_rollups_daily (
+				url_short_code VARCHAR(20) NOT NULL,
+				bucket BIGINT NOT NULL,
+				is_bot BOOLEAN NOT NULL,
+				country VARCHAR(100) NOT NULL,
+				clicks INTEGER NOT NULL,
+				PRIMARY KEY (url_short_code, bucket, is_bot, country)
+			);`, `
+			CREATE TABLE IF NOT EXISTS click_visitors_daily (
+				url_short_code VARCHAR(20) NOT NULL,
+				bucket BIGINT NOT NULL,
+				is_bot BOOLEAN NOT NULL,
+				ip_address VARCHAR(45) NOT NULL,
+				PRIMARY KEY (url_short_code, bucket, is_bot, ip_address)
+			);`, `
+			INSERT INTO click_rollups_hourly (url_short_code, bucket, is_bot, country, clicks)
+			SELECT url_short_code, `+bucket+` / 3600 * 3600, is_bot, COALESCE(country, 'Unknown'), COUNT(*)
+			FROM clicks WHERE clicked_at IS NOT NULL
+			GROUP BY 1, 2, 3, 4;`, `
+			INSERT INTO click_rollups_daily (url_short_code, bucket, is_bot, country, clicks)
+			SELECT url_short_code, `+bucket+` / 86400 * 86400, is_bot, COALESCE(country, 'Unknown'), COUNT(*)
+			FROM clicks WHERE clicked_at IS NOT NULL
+			GROUP BY 1, 2, 3, 4;`, `
+			INSERT INTO click_visitors_daily (url_short_code, bucket, is_bot, ip_address)
+			SELECT DISTINCT url_short_code, `+bucket+` / 86400 * 86400, is_bot, ip_address
+			FROM clicks WHERE clicked_at IS NOT NULL AND ip_address IS NOT NULL;`,
+			)
+		},
+		Down: func(tx *sql.Tx) error {
+			return exec(tx,
+				"DROP TABLE IF EXISTS click_visitors_daily;",
+				"DROP TABLE IF EXISTS click_rollups_daily;",
+				"DROP TABLE IF EXISTS click_rollups_hourly;",
+			)
+		},
+	},
+	{
+		Version: 18,
+		Name:    "add_clicks_click_id_index",
+		Up: func(tx *sql.Tx) error {
+			return exec(tx, "CREATE INDEX IF NOT EXISTS idx_clicks_click_id ON clicks(click_id);")
+		},
+		Down: func(tx *sql.Tx) error {
+			return exec(tx, "DROP INDEX IF EXISTS idx_clicks_click_id;")
+		},
+	},
+	{
+		Version: 19,
+		Name:    "add_click_dimension_rollups",
+		Up: func(tx *sql.Tx) error {
+			err := exec(tx, `
+			CREATE TABLE IF NOT EXISTS click_dimensions_hourly (
+				url_short_code VARCHAR(20) NOT NULL,
+				bucket BIGINT NOT NULL,
+				is_bot BOOLEAN NOT NULL,
+				dimension VARCHAR(20) NOT NULL,
+				value VARCHAR(255) NOT NULL,
+				clicks INTEGER NOT NULL,
+				PRIMARY KEY (url_short_code, bucket, is_bot, dimension, value)
+			);`, `
+			CREATE TABLE IF NOT EXISTS click_dimensions_daily (
+				url_short_code VARCHAR(20) NOT NULL,
+				bucket BIGINT NOT NULL,
+				is_bot BOOLEAN NOT NULL,
+				dimension VARCHAR(20) NOT NULL,
+				value VARCHAR(255) NOT NULL,
+				clicks INTEGER NOT NULL,
+				PRIMARY KEY (url_short_code, bucket, is_bot, dimension, value)
+			);`, `
+			CREATE TABLE IF NOT EXISTS click_variant_visitors_daily (
+				url_short_code VARCHAR(20) NOT NULL,
+				bucket BIGINT NOT NULL,
+				is_bot BOOLEAN NOT NULL,
+				variant VARCHAR(50) NOT NULL,
+				ip_address VARCHAR(45) NOT NULL,
+				PRIMARY KEY (url_short_code, bucket, is_bot, variant, ip_address)
+			);`,
+			)
+			if err != nil {
+				return err
+			}
+			return rollUpDimensions(tx, "FLOOR(EXTRACT(EPOCH FROM clicked_at))::BIGINT")
+		},
+		Down: func(tx *sql.Tx) error {
+			return exec(tx,
+				"DROP TABLE IF EXISTS click_variant_visitors_daily;",
+				"DROP TABLE IF EXISTS click_dimensions_daily;",
+				"DROP TABLE IF EXISTS click_dimensions_hourly;",
+			)
+		},
+	},
+	{
+		Version: 20,
+		Name:    "add_clicks_bot_kind",
+		Up: func(tx *sql.Tx) error {
+			if err := exec(tx, "ALTER TABLE clicks ADD COLUMN IF NOT EXISTS bot_kind VARCHAR(20);"); err != nil {
+				return err
+			}
+			return classifyBots(tx, `UPDATE clicks SET bot_kind = $1 WHERE is_bot = TRUE AND user_agent = $2`)
+		},
+		Down: func(tx *sql.Tx) error {
+			return exec(tx, "ALTER TABLE clicks DROP COLUMN IF EXISTS bot_kind;")
+		},
+	},
+}
@@ -10,10 +10,75 @@ type URL struct {
 	ShortCode   string     `json:"short_code" db:"short_code"`
 	OriginalURL string     `json:"original_url" db:"original_url"`
 	CreatedAt   time.Time  `json:"created_at" db:"created_at"`
+	ActivatesAt *time.Time `json:"activates_at,omitempty" db:"activates_at"`
 	ExpiresAt   *time.Time `json:"expires_at,omitempty" db:"expires_at"`
 	ClickCount  int        `json:"click_count" db:"click_count"`
 	UserIP      string     `json:"user_ip" db:"user_ip"`
+	OwnerID     string     `json:"owner_id,omitempty" db:"owner_id"`
+	TeamID      *int       `json:"team_id,omitempty" db:"team_id"`
 	IsCustom    bool       `json:"is_custom" db:"is_custom"`
+	DeletedAt   *time.Time `json:"deleted_at,omitempty" db:"deleted_at"`
+	// RedirectType is the HTTP status of the redirect: 301, 302, 307 or 308.
+	RedirectType int `json:"redirect_type" db:"redirect_type"`
+	// PasswordHash is the bcrypt hash of the link password, if it has one.
+	PasswordHash      string `json:"-" db:"password_hash"`
+	PasswordProtected bool   `json:"password_protected"`
+	// MaxClicks, when set, is the number of clicks after which the link
+	// stops redirecting. 1 makes a one-time link.
+	MaxClicks *int `json:"max_clicks,omitempty" db:"max_clicks"`
+	// FallbackURL is where visitors go once the link has expired, been
+	// deleted or reached MaxClicks.
+	FallbackURL string `json:"fallback_url,omitempty" db:"fallback_url"`
+	// TargetingRules send matching visitors elsewhere; visitors matching
+	// none go to OriginalURL.
+	TargetingRules []TargetingRule `json:"targeting_rules,omitempty" db:"targeting_rules"`
+	// Variants, when set, split the visitors matching no targeting rule
+	// between several destinations instead of sending them to OriginalURL.
+	Variants []Variant `json:"variants,omitempty" db:"variants"`
+	// ClickIDParam, when set, is the query parameter that carries a click
+	// ID to the destination, for reporting conversions of that click.
+	ClickIDParam string `json:"click_id_param,omitempty" db:"click_id_param"`
+	// ForwardQuery passes the query string of the short URL on to the
+	// destination, replacing parameters of the same name.
+	ForwardQuery bool `json:"forward_query" db:"forward_query"`
+}
+
+// UTM holds the campaign parameters merged into a link's destinations as
+// utm_source, utm_medium, utm_campaign, utm_term and utm_content. Empty
+// fields are left out.
+type UTM struct {
+	Source   string `json:"source,omitempty"`
+	Medium   string `json:"medium,omitempty"`
+	Campaign string `json:"campaign,omitempty"`
+	Term     string `json:"term,omitempty"`
+	Content  string `json:"content,omitempty"`
+}
+
+// DefaultTargetRule is the analytics name for clicks that matched no
+// targeting rule and went to the link's original URL.
+const DefaultTargetRule = "default"
+
+// TargetingRule sends visitors matching all of its conditions to URL: their
+// operating system, device type, country (ISO 3166-1 alpha-2 code, any of
+// Countries) and continent (code such as EU, any of Continents). An empty
+// condition matches anything. A link's rules are tried in order and the
+// first match wins; Name identifies the rule in click analytics.
+type TargetingRule struct {
+	Name       string   `json:"name"`
+	OS         string   `json:"os,omitempty"`
+	Device     string   `json:"device,omitempty"`
+	Countries  []string `json:"countries,omitempty"`
+	Continents []string `json:"continents,omitempty"`
+	URL        string   `json:"url"`
+}
+
+// Variant is one destination of an A/B split. Visitors are assigned a
+// variant with a probability of its Weight over the total weight, and keep
+// it on later visits; a Weight of 0 pauses the variant.
+type Variant struct {
+	Name   string `json:"name"`
+	URL    string `json:"url"`
+	Weight int    `json:"weight"`
 }
 
 type Click struct {
@@ -23,24 +88,211 @@ type Click struct {
 	UserAgent    string    `json:"user_agent" db:"user_agent"`
 	Referer      string    `json:"referer" db:"referer"`
 	Country      string    `json:"country" db:"country"`
+	Region       string    `json:"region" db:"region"`
 	City         string    `json:"city" db:"city"`
+	ASN          uint      `json:"asn,omitempty" db:"asn"`
+	ASOrg        string    `json:"as_org,omitempty" db:"as_org"`
 	ClickedAt    time.Time `json:"clicked_at" db:"clicked_at"`
+	// TargetRule is the name of the targeting rule that chose the
+	// destination, empty when the visitor went to the original URL.
+	TargetRule string `json:"target_rule,omitempty" db:"target_rule"`
+	// Variant is the name of the A/B variant the visitor was sent to.
+	Variant string `json:"variant,omitempty" db:"variant"`
+	// ClickID is the ID passed to the destination for conversion tracking.
+	ClickID string `json:"click_id,omitempty" db:"click_id"`
+	// Campaign is the utm_campaign of the destination the visitor was sent to.
+	Campaign string `json:"campaign,omitempty" db:"utm_campaign"`
+	// Browser, BrowserVersion, OS, Device and Bot are parsed from UserAgent
+	// when the click is recorded. BotKind says why a click is a bot's: a
+	// useragent.Bot kind, or BotKindHead or BotKindPrefetch for requests
+	// that only look at the link.
+	Browser        string `json:"browser,omitempty" db:"browser"`
+	BrowserVersion string `json:"browser_version,omitempty" db:"browser_version"`
+	OS             string `json:"os,omitempty" db:"os"`
+	Device         string `json:"device,omitempty" db:"device"`
+	Bot            bool   `json:"is_bot" db:"is_bot"`
+	BotKind        string `json:"bot_kind,omitempty" db:"bot_kind"`
+	// ReferrerHost is the normalised host of Referer, and ReferrerCategory
+	// the kind of traffic source it is, such as "search" or "direct".
+	ReferrerHost     string `json:"referrer_host,omitempty" db:"referrer_host"`
+	ReferrerCategory string `json:"referrer_category,omitempty" db:"referrer_category"`
+	// Counted is set when the link's click_count was already raised for
+	// this click, as happens for links with MaxClicks.
+	Counted bool `json:"-"`
+}
+
+// Kinds of bot clicks told apart by the request rather than its User-Agent.
+const (
+	BotKindHead     = "head"
+	BotKindPrefetch = "prefetch"
+)
+
+// Conversion is a goal reached after a click, such as a purchase, reported
+// with the click's ID. Each click converts at most once.
+type Conversion struct {
+	ID           int       `json:"id" db:"id"`
+	ClickID      string    `json:"click_id" db:"click_id"`
+	URLShortCode string    `json:"url_short_code" db:"url_short_code"`
+	Value        float64   `json:"value" db:"value"`
+	Currency     string    `json:"currency,omitempty" db:"currency"`
+	ConvertedAt  time.Time `json:"converted_at" db:"converted_at"`
+}
+
+// UnlockAttempt is a password submission for a protected link. Attempts are
+// kept apart from clicks; a successful one is followed by a normal click.
+type UnlockAttempt struct {
+	ID           int       `json:"id" db:"id"`
+	URLShortCode string    `json:"url_short_code" db:"url_short_code"`
+	IPAddress    string    `json:"ip_address" db:"ip_address"`
+	Success      bool      `json:"success" db:"success"`
+	AttemptedAt  time.Time `json:"attempted_at" db:"attempted_at"`
+}
+
+// APIKey represents a hashed API key. The plaintext key is only shown once,
+// when the key is created.
+type APIKey struct {
+	ID         int        `json:"id" db:"id"`
+	OwnerID    string     `json:"owner_id" db:"owner_id"`
+	Name       string     `json:"name" db:"name"`
+	Prefix     string     `json:"prefix" db:"prefix"`
+	KeyHash    string     `json:"-" db:"key_hash"`
+	CreatedAt  time.Time  `json:"created_at" db:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty" db:"last_used_at"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty" db:"revoked_at"`
+}
+
+// Role is a team member's permission level. Each role includes the
+// permissions of the ones before it.
+type Role string
+
+const (
+	RoleViewer Role = "viewer" // read analytics
+	RoleEditor Role = "editor" // create and update links
+	RoleAdmin  Role = "admin"  // delete links and manage members
+)
+
+// User is an account that can log in to the dashboard. Its Username is the
+// owner ID used for links and API keys.
+type User struct {
+	ID           int       `json:"id" db:"id"`
+	Username     string    `json:"username" db:"username"`
+	PasswordHash string    `json:"-" db:"password_hash"`
+	CreatedAt    time.Time `json:"created_at" db:"created_at"`
+}
+
+// Team groups users; links created for a team are shared by its members.
+type Team struct {
+	ID        int       `json:"id" db:"id"`
+	Name      string    `json:"name" db:"name"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+	Role      Role      `json:"role,omitempty"`
+}
+
+// TeamMember represents a user's role in a team
+type TeamMember struct {
+	TeamID   int    `json:"team_id" db:"team_id"`
+	Username string `json:"username" db:"username"`
+	Role     Role   `json:"role" db:"role"`
+}
+
+// CreateTeamRequest represents the request to create a team
+type CreateTeamRequest struct {
+	Name string `json:"name"`
+}
+
+// SetMemberRequest represents the request to add a member or change a role
+type SetMemberRequest struct {
+	Role Role `json:"role"`
 }
 
 // ShortenURLRequest represents the request to shorten a URL
 type ShortenURLRequest struct {
 	OriginalURL string     `json:"original_url" validate:"required,url"`
 	CustomCode  string     `json:"custom_code,omitempty" validate:"alphanum,min=3,max=20"`
+	ActivatesAt *time.Time `json:"activates_at,omitempty"`
 	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	TeamID      *int       `json:"team_id,omitempty"`
+	// RedirectType defaults to a temporary redirect, which browsers do not
+	// cache, so every click reaches the server.
+	RedirectType int `json:"redirect_type,omitempty"`
+	// Password, when set, must be entered before the link redirects.
+	Password string `json:"password,omitempty"`
+	// MaxClicks, when set, limits how often the link redirects.
+	MaxClicks *int `json:"max_clicks,omitempty"`
+	// FallbackURL, when set, replaces the error page of an unusable link.
+	FallbackURL string `json:"fallback_url,omitempty"`
+	// TargetingRules send matching visitors elsewhere, in order.
+	TargetingRules []TargetingRule `json:"targeting_rules,omitempty"`
+	// Variants split the remaining visitors between destinations.
+	Variants []Variant `json:"variants,omitempty"`
+	// ClickIDParam, when set, appends a click ID to the destination under
+	// this query parameter.
+	ClickIDParam string `json:"click_id_param,omitempty"`
+	// UTM parameters are merged into OriginalURL and the URLs of the
+	// targeting rules and variants, replacing ones already there.
+	UTM *UTM `json:"utm,omitempty"`
+	// ForwardQuery passes the short URL's query string to the destination.
+	ForwardQuery bool `json:"forward_query,omitempty"`
+}
+
+// UpdateURLRequest represents a partial update of an existing short link.
+// Fields left nil are not changed; an empty FallbackURL removes it, and an
+// empty list of TargetingRules or Variants removes them all.
+type UpdateURLRequest struct {
+	OriginalURL      *string    `json:"original_url,omitempty"`
+	ActivatesAt      *time.Time `json:"activates_at,omitempty"`
+	ClearActivatesAt bool       `json:"clear_activates_at,omitempty"`
+	ExpiresAt        *time.Time `json:"expires_at,omitempty"`
+	ClearExpiresAt   bool       `json:"clear_expires_at,omitempty"`
+	IsCustom         *bool      `json:"is_custom,omitempty"`
+	RedirectType     *int       `json:"redirect_type,omitempty"`
+	MaxClicks        *int       `json:"max_clicks,omitempty"`
+	ClearMaxClicks   bool       `json:"clear_max_clicks,omitempty"`
+	FallbackURL      *string    `json:"fallback_url,omitempty"`
+	// TargetingRules replaces the whole list of rules.
+	TargetingRules *[]TargetingRule `json:"targeting_rules,omitempty"`
+	// Variants replaces the whole list of variants, for example to change
+	// their weights.
+	Variants *[]Variant `json:"variants,omitempty"`
+	// Winner names the variant that won the test: its URL becomes the
+	// original URL and the variants are removed.
+	Winner string `json:"winner,omitempty"`
+	// ClickIDParam changes the click ID parameter; empty stops appending
+	// click IDs.
+	ClickIDParam *string `json:"click_id_param,omitempty"`
+	// UTM parameters are merged into the link's destinations after the
+	// other changes of the request.
+	UTM          *UTM  `json:"utm,omitempty"`
+	ForwardQuery *bool `json:"forward_query,omitempty"`
 }
 
 // ShortenURLResponse represents the response after shortening a URL
 type ShortenURLResponse struct {
-	ShortCode   string     `json:"short_code"`
-	ShortURL    string     `json:"short_url"`
-	OriginalURL string     `json:"original_url"`
-	CreatedAt   time.Time  `json:"created_at"`
-	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	ShortCode         string     `json:"short_code"`
+	ShortURL          string     `json:"short_url"`
+	OriginalURL       string     `json:"original_url"`
+	CreatedAt         time.Time  `json:"created_at"`
+	ActivatesAt       *time.Time `json:"activates_at,omitempty"`
+	ExpiresAt         *time.Time `json:"expires_at,omitempty"`
+	TeamID            *int       `json:"team_id,omitempty"`
+	RedirectType      int        `json:"redirect_type"`
+	PasswordProtected bool       `json:"password_protected"`
+	MaxClicks         *int       `json:"max_clicks,omitempty"`
+	FallbackURL       string     `json:"fallback_url,omitempty"`
+	// TargetingRules are the stored rules, with default names filled in.
+	TargetingRules []TargetingRule `json:"targeting_rules,omitempty"`
+	// Variants are the stored variants, with default names filled in.
+	Variants     []Variant `json:"variants,omitempty"`
+	ClickIDParam string    `json:"click_id_param,omitempty"`
+	ForwardQuery bool      `json:"forward_query"`
+}
+
+// ConversionRequest represents the request to record a conversion. Value
+// is optional and needs a Currency, an ISO 4217 code such as EUR.
+type ConversionRequest struct {
+	ClickID  string  `json:"click_id"`
+	Value    float64 `json:"value,omitempty"`
+	Currency string  `json:"currency,omitempty"`
 }
 
 // Analytics represents analytics data for a URL
@@ -49,8 +301,95 @@ type Analytics struct {
 	TotalClicks     int            `json:"total_clicks"`
 	UniqueVisitors  int            `json:"unique_visitors"`
 	ClicksByCountry map[string]int `json:"clicks_by_country"`
-	ClicksByDay     []DailyClicks  `json:"clicks_by_day"`
 	RecentClicks    []Click        `json:"recent_clicks"`
+	// The figures cover the clicks from From up to To. ClicksOverTime
+	// splits them into buckets of Granularity, which start on the minute,
+	// hour, day, Monday or first of the month in Timezone.
+	From           time.Time     `json:"from"`
+	To             time.Time     `json:"to"`
+	Granularity    string        `json:"granularity"`
+	Timezone       string        `json:"timezone"`
+	ClicksOverTime []ClickBucket `json:"clicks_over_time"`
+	// ClicksByDay and ConversionsByDay keep the daily series of earlier
+	// versions of the API: the last 30 days by day in UTC, whatever the
+	// query's time range and granularity.
+	ClicksByDay      []DailyClicks      `json:"clicks_by_day"`
+	ConversionsByDay []DailyConversions `json:"conversions_by_day"`
+	// BotClicks counts the clicks of crawlers, link previewers and other
+	// automated clients. They are part of the other figures only when
+	// IncludesBots is set.
+	BotClicks    int  `json:"bot_clicks"`
+	IncludesBots bool `json:"include_bots"`
+	// ClicksByRule counts clicks by the targeting rule that matched, with
+	// DefaultTargetRule for the original URL.
+	ClicksByRule map[string]int `json:"clicks_by_rule"`
+	// Variants reports the A/B variants of the link, followed by earlier
+	// variants that still have clicks.
+	Variants []VariantStats `json:"variants,omitempty"`
+	// ClicksByCampaign counts clicks by the utm_campaign of their
+	// destination; clicks without one are left out.
+	ClicksByCampaign map[string]int `json:"clicks_by_campaign"`
+	// Clicks by the browser family, operating system and device type
+	// parsed from their User-Agent.
+	ClicksByBrowser map[string]int `json:"clicks_by_browser"`
+	ClicksByOS      map[string]int `json:"clicks_by_os"`
+	ClicksByDevice  map[string]int `json:"clicks_by_device"`
+	// ClicksByReferrer counts clicks by the top referring hosts, and
+	// ClicksByReferrerCategory by traffic source, including direct clicks.
+	ClicksByReferrer         map[string]int `json:"clicks_by_referrer"`
+	ClicksByReferrerCategory map[string]int `json:"clicks_by_referrer_category"`
+	// Conversions reported within the time range. ConversionRate is their
+	// share of TotalClicks, and Revenue sums their values by currency.
+	Conversions         int                `json:"conversions"`
+	ConversionRate      float64            `json:"conversion_rate"`
+	Revenue             map[string]float64 `json:"revenue"`
+	ConversionsOverTime []ConversionBucket `json:"conversions_over_time"`
+	// Password submissions for protected links, counted apart from clicks.
+	UnlockAttempts       int `json:"unlock_attempts"`
+	FailedUnlockAttempts int `json:"failed_unlock_attempts"`
+}
+
+// Granularities of the analytics time series.
+const (
+	GranularityMinute = "minute"
+	GranularityHour   = "hour"
+	GranularityDay    = "day"
+	GranularityWeek   = "week"
+	GranularityMonth  = "month"
+)
+
+// AnalyticsQuery holds the options of an analytics request. Zero values
+// select the defaults: the last 30 days by day in UTC and 10 recent clicks.
+type AnalyticsQuery struct {
+	// IncludeBots counts the clicks of bots along with the rest.
+	IncludeBots bool
+	// From and To limit the figures to the clicks in between, To excluded.
+	From time.Time
+	To   time.Time
+	// Granularity is one of the Granularity constants.
+	Granularity string
+	// Location is the time zone that buckets start in.
+	Location *time.Location
+	// Limit is the number of recent clicks to return.
+	Limit int
+}
+
+// VariantStats counts the clicks and unique visitors sent to an A/B variant.
+// URL and Weight are empty for variants no longer on the link.
+type VariantStats struct {
+	Name           string `json:"name"`
+	URL            string `json:"url,omitempty"`
+	Weight         int    `json:"weight"`
+	Clicks         int    `json:"clicks"`
+	UniqueVisitors int    `json:"unique_visitors"`
+}
+
+// ClickBucket counts the clicks of one bucket of a time series. Label is
+// Start formatted for the series' granularity, such as 2006-01-02 15:00.
+type ClickBucket struct {
+	Start  time.Time `json:"start"`
+	Label  string    `json:"label"`
+	Clicks int       `json:"clicks"`
 }
 
 // DailyClicks represents clicks grouped by day
@@ -59,6 +398,33 @@ type DailyClicks struct {
 	Clicks int    `json:"clicks"`
 }
 
+// CampaignStats summarizes the clicks of a campaign across links.
+type CampaignStats struct {
+	Campaign       string `json:"campaign"`
+	Links          int    `json:"links"`
+	Clicks         int    `json:"clicks"`
+	UniqueVisitors int    `json:"unique_visitors"`
+}
+
+// ConversionBucket counts the conversions reported in one bucket of a time
+// series. ConversionRate compares them to the clicks of that bucket.
+type ConversionBucket struct {
+	Start          time.Time          `json:"start"`
+	Label          string             `json:"label"`
+	Conversions    int                `json:"conversions"`
+	ConversionRate float64            `json:"conversion_rate"`
+	Revenue        map[string]float64 `json:"revenue"`
+}
+
+// DailyConversions represents conversions grouped by the day they were
+// reported. ConversionRate compares them to the clicks of that day.
+type DailyConversions struct {
+	Date           string             `json:"date"`
+	Conversions    int                `json:"conversions"`
+	ConversionRate float64            `json:"conversion_rate"`
+	Revenue        map[string]float64 `json:"revenue"`
+}
+
 // ErrorResponse represents an error response
 type ErrorResponse struct {
 	Error   string `json:"error"`
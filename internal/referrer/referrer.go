@@ -0,0 +1,114 @@
+// Package referrer reduces a Referer header to the referring host and sorts
+// it into a traffic source category for click analytics. Like useragent it
+// matches a short list of well-known hosts rather than a full database.
+package referrer
+
+import (
+	"net/url"
+	"strings"
+)
+
+// Categories reported by Parse.
+const (
+	Direct   = "direct"
+	Search   = "search"
+	Social   = "social"
+	Email    = "email"
+	Internal = "internal"
+	Other    = "other"
+)
+
+// Source is the result of Parse. Host is empty for direct traffic.
+type Source struct {
+	Host     string
+	Category string
+}
+
+// Email is checked before search, as mail.google.com and mail.yahoo.com
+// would otherwise count as search engines. Android apps send
+// android-app://<package> referrers, whose host is the package name.
+var (
+	emailHosts = []string{
+		"mail.google.com", "inbox.google.com", "com.google.android.gm",
+		"outlook.live.com", "outlook.office.com", "outlook.office365.com",
+		"mail.yahoo.com", "mail.proton.me", "mail.aol.com", "mail.zoho.com",
+		"app.fastmail.com", "mail.yandex.ru", "com.microsoft.office.outlook",
+	}
+	searchHosts = []string{
+		"bing.com", "duckduckgo.com", "search.yahoo.com", "baidu.com",
+		"yandex.ru", "yandex.com", "ecosia.org", "search.brave.com",
+		"startpage.com", "qwant.com", "com.google.android.googlequicksearchbox",
+	}
+	socialHosts = []string{
+		"facebook.com", "fb.com", "instagram.com", "t.co", "twitter.com",
+		"x.com", "linkedin.com", "lnkd.in", "reddit.com", "pinterest.com",
+		"youtube.com", "tiktok.com", "threads.net", "news.ycombinator.com",
+		"mastodon.social", "bsky.app", "whatsapp.com", "telegram.org",
+		"t.me", "discord.com", "slack.com", "vk.com",
+		"com.twitter.android", "com.linkedin.android", "com.reddit.frontpage",
+	}
+)
+
+// Parse classifies referer. Referrers on one of internalHosts, such as the
+// shortener's own host or the link's destination, are Internal; hosts are
+// compared after Host normalises them.
+func Parse(referer string, internalHosts ...string) Source {
+	host := Host(referer)
+	if host == "" {
+		return Source{Category: Direct}
+	}
+
+	for _, internal := range internalHosts {
+		if h := Host(internal); h != "" && h == host {
+			return Source{Host: host, Category: Internal}
+		}
+	}
+
+	switch {
+	case matches(host, emailHosts):
+		return Source{Host: host, Category: Email}
+	case isGoogle(host), matches(host, searchHosts):
+		return Source{Host: host, Category: Search}
+	case matches(host, socialHosts):
+		return Source{Host: host, Category: Social}
+	}
+	return Source{Host: host, Category: Other}
+}
+
+// Host returns the lower-cased host of a URL without its port or a leading
+// "www." or "m.", or "" when there is none. A bare host is accepted too.
+func Host(rawURL string) string {
+	rawURL = strings.TrimSpace(rawURL)
+	if rawURL == "" {
+		return ""
+	}
+	if !strings.Contains(rawURL, "://") {
+		rawURL = "//" + rawURL
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	for _, prefix := range []string{"www.", "m."} {
+		host = strings.TrimPrefix(host, prefix)
+	}
+	return host
+}
+
+// matches reports whether host is one of hosts or a subdomain of one.
+func matches(host string, hosts []string) bool {
+	for _, h := range hosts {
+		if host == h || strings.HasSuffix(host, "."+h) {
+			return true
+		}
+	}
+	return false
+}
+
+// isGoogle reports whether host is a Google search domain, such as
+// google.com or google.co.uk.
+func isGoogle(host string) bool {
+	return strings.HasPrefix(host, "google.")
+}
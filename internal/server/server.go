@@ -0,0 +1,81 @@
+// Package server runs the HTTP server with timeouts, optional TLS and a
+// graceful shutdown that lets in-flight requests finish.
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"time"
+
+	"url-shortener/internal/config"
+)
+
+// New builds an http.Server for handler from cfg. Zero timeouts are
+// disabled.
+func New(handler http.Handler, cfg config.Server) *http.Server {
+	return &http.Server{
+		Addr:              cfg.Addr,
+		Handler:           handler,
+		ReadTimeout:       cfg.ReadTimeout,
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+		MaxHeaderBytes:    cfg.MaxHeaderBytes,
+	}
+}
+
+// Run listens on cfg.Addr and serves handler until ctx is cancelled, then
+// stops accepting connections and waits up to cfg.ShutdownTimeout for
+// in-flight requests. TLS is used when cfg names a certificate and key. It
+// returns nil after a clean shutdown.
+func Run(ctx context.Context, handler http.Handler, cfg config.Server) error {
+	listener, err := net.Listen("tcp", cfg.Addr)
+	if err != nil {
+		return err
+	}
+
+	return Serve(ctx, listener, handler, cfg)
+}
+
+// Serve is Run on an existing listener, which it closes.
+func Serve(ctx context.Context, listener net.Listener, handler http.Handler, cfg config.Server) error {
+	srv := New(handler, cfg)
+
+	errs := make(chan error, 1)
+	go func() {
+		if cfg.TLSCertFile != "" {
+			log.Printf("Server starting on %s (TLS)", listener.Addr())
+			errs <- srv.ServeTLS(listener, cfg.TLSCertFile, cfg.TLSKeyFile)
+		} else {
+			log.Printf("Server starting on %s", listener.Addr())
+			errs <- srv.Serve(listener)
+		}
+	}()
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+
+	timeout := cfg.ShutdownTimeout
+	if timeout <= 0 {
+		timeout = 15 * time.Second
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	log.Println("Shutting down, waiting for in-flight requests")
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("shutdown: %v", err)
+	}
+
+	if err := <-errs; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
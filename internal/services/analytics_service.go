@@ -1,261 +1,376 @@
 package services
 
 import (
-	"database/sql"
-	"encoding/json"
-	"fmt"
-	"net/http"
+	"errors"
+	"sync"
 	"time"
 
-	"url-shortener/internal/database"
+	"url-shortener/internal/geoip"
 	"url-shortener/internal/models"
+	"url-shortener/internal/referrer"
+	"url-shortener/internal/store"
+	"url-shortener/internal/useragent"
 )
 
 type AnalyticsService struct {
-	db *database.DB
-}
+	clicks store.ClickStore
+	urls   store.URLStore
+	teams  TeamLookup
+	geo    geoip.Provider
 
+	pendingMu sync.Mutex
+	pending   map[string]chan struct{} // closed once the queued click with that ID is written
+}
 
-func NewAnalyticsService(db *database.DB) *AnalyticsService {
-	return &AnalyticsService{db: db}
+// NewAnalyticsService creates an AnalyticsService reading clicks and links
+// from the given stores. teams may be nil when team links are not used, and
+// a nil geo resolves every location to Unknown.
+func NewAnalyticsService(clicks store.ClickStore, urls store.URLStore, teams TeamLookup, geo geoip.Provider) *AnalyticsService {
+	if geo == nil {
+		geo = geoip.None{}
+	}
+	return &AnalyticsService{clicks: clicks, urls: urls, teams: teams, geo: geo, pending: make(map[string]chan struct{})}
 }
 
 func (s *AnalyticsService) RecordClick(click models.Click) error {
-	query := `
-		INSERT INTO clicks (url_short_code, ip_address, user_agent, referer, country, city, clicked_at)
-		VALUES (?, ?, ?, ?, ?, ?, ?)
-	`
+	return s.clicks.RecordClick(click)
+}
+
+// RecordClicks stores a batch of clicks and counts them towards their links.
+func (s *AnalyticsService) RecordClicks(clicks []models.Click) error {
+	return s.clicks.RecordClicks(clicks)
+}
+
+// RecordUnlockAttempt stores a password submission for a protected link.
+// Attempts are not clicks and do not change the click count.
+func (s *AnalyticsService) RecordUnlockAttempt(attempt models.UnlockAttempt) error {
+	return s.clicks.RecordUnlockAttempt(attempt)
+}
+
+// EnrichClick fills in the derived fields of a click, such as its location,
+// browser and referrer. Fields resolved earlier, as for geo-targeted
+// redirects, are kept.
+func (s *AnalyticsService) EnrichClick(click *models.Click) {
+	if click.Browser == "" {
+		s.ParseAgent(click)
+	}
+	if click.ReferrerCategory == "" {
+		s.ClassifyReferrer(click)
+	}
+	if click.Country == "" {
+		s.LocateClick(click)
+	}
+}
 
-	_, err := s.db.Exec(query, click.URLShortCode, click.IPAddress, click.UserAgent,
-		click.Referer, click.Country, click.City, click.ClickedAt)
+// LocateClick resolves the location of the click's IP address, stores it on
+// the click and returns it.
+func (s *AnalyticsService) LocateClick(click *models.Click) geoip.Location {
+	loc := s.GetLocationFromIP(click.IPAddress)
+	click.Country, click.Region, click.City = loc.Country, loc.Region, loc.City
+	click.ASN, click.ASOrg = loc.ASN, loc.ASOrg
+	return loc
+}
+
+// ParseAgent parses the click's User-Agent, stores the browser, operating
+// system, device type and bot flag and kind on the click and returns them.
+func (s *AnalyticsService) ParseAgent(click *models.Click) useragent.Agent {
+	agent := useragent.Parse(click.UserAgent)
+	click.Browser, click.BrowserVersion = agent.Browser, agent.BrowserVersion
+	click.OS, click.Device, click.Bot, click.BotKind = agent.OS, agent.Device, agent.Bot, agent.BotKind
+	return agent
+}
 
-	return err
+// ClassifyReferrer stores the host and category of the click's Referer on
+// the click. Referrers from internalHosts, given as hosts or URLs, are
+// internal.
+func (s *AnalyticsService) ClassifyReferrer(click *models.Click, internalHosts ...string) {
+	source := referrer.Parse(click.Referer, internalHosts...)
+	click.ReferrerHost, click.ReferrerCategory = source.Host, source.Category
 }
 
-func (s *AnalyticsService) GetAnalytics(shortCode string) (*models.Analytics, error) {
-	url, err := s.getURLByShortCode(shortCode)
+// GetAnalytics returns the analytics of a link that ownerID can view, either
+// as its personal owner or as a member of its team, for the last 30 days by
+// day in UTC. Bot clicks are left out.
+func (s *AnalyticsService) GetAnalytics(shortCode string, ownerID string) (*models.Analytics, error) {
+	return s.QueryAnalytics(shortCode, ownerID, models.AnalyticsQuery{})
+}
+
+// QueryAnalytics is GetAnalytics with the options of query. Every figure
+// but the unlock attempts is limited to the query's time range.
+func (s *AnalyticsService) QueryAnalytics(shortCode string, ownerID string, query models.AnalyticsQuery) (*models.Analytics, error) {
+	if ownerID == "" {
+		return nil, ErrUnauthorized
+	}
+
+	now := time.Now()
+	query, err := normalizeQuery(query, now)
 	if err != nil {
 		return nil, err
 	}
-
-	totalClicks, err := s.getTotalClicks(shortCode)
+	starts, err := bucketStarts(query.From, query.To, query.Granularity, query.Location)
 	if err != nil {
 		return nil, err
 	}
 
-	uniqueVisitors, err := s.getUniqueVisitors(shortCode)
+	// Soft-deleted links are included so their click history stays
+	// reachable after a delete.
+	url, err := s.urls.GetURL(shortCode, true)
 	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			return nil, ErrURLNotFound
+		}
 		return nil, err
 	}
 
-	clicksByCountry, err := s.getClicksByCountry(shortCode)
-	if err != nil {
+	if err := s.checkViewAccess(shortCode, ownerID); err != nil {
 		return nil, err
 	}
 
-	clicksByDay, err := s.getClicksByDay(shortCode, 30)
+	within := store.TimeRange{From: query.From, To: query.To}
+	filter := store.ClickFilter{IncludeBots: query.IncludeBots, TimeRange: within}
+
+	// Bot clicks are counted either way, so the response shows how many
+	// were left out.
+	humanClicks, err := s.clicks.CountClicks(shortCode, store.ClickFilter{TimeRange: within})
 	if err != nil {
 		return nil, err
 	}
-
-	recentClicks, err := s.getRecentClicks(shortCode, 10)
+	allClicks, err := s.clicks.CountClicks(shortCode, store.ClickFilter{IncludeBots: true, TimeRange: within})
 	if err != nil {
 		return nil, err
 	}
+	totalClicks := humanClicks
+	if query.IncludeBots {
+		totalClicks = allClicks
+	}
 
-	analytics := &models.Analytics{
-		URL:             url,
-		TotalClicks:     totalClicks,
-		UniqueVisitors:  uniqueVisitors,
-		ClicksByCountry: clicksByCountry,
-		ClicksByDay:     clicksByDay,
-		RecentClicks:    recentClicks,
+	uniqueVisitors, err := s.clicks.CountUniqueVisitors(shortCode, filter)
+	if err != nil {
+		return nil, err
 	}
 
-	return analytics, nil
-}
+	clicksByCountry, err := s.clicks.ClicksByCountry(shortCode, filter, 10)
+	if err != nil {
+		return nil, err
+	}
 
-func (s *AnalyticsService) getURLByShortCode(shortCode string) (*models.URL, error) {
-	query := `
-		SELECT id, short_code, original_url, created_at, expires_at, click_count, user_ip, is_custom
-		FROM urls 
-		WHERE short_code = ?
-	`
+	slot := slotFor(query.From, query.To, query.Granularity, query.Location)
+	clicksBySlot, err := s.clicks.ClicksBySlot(shortCode, filter, slot)
+	if err != nil {
+		return nil, err
+	}
+	clicksOverTime := clickSeries(starts, query.Granularity, clicksBySlot)
 
-	url := &models.URL{}
-	err := s.db.QueryRow(query, shortCode).Scan(
-		&url.ID, &url.ShortCode, &url.OriginalURL, &url.CreatedAt,
-		&url.ExpiresAt, &url.ClickCount, &url.UserIP, &url.IsCustom,
-	)
+	recentClicks, err := s.clicks.RecentClicks(shortCode, filter, query.Limit)
+	if err != nil {
+		return nil, err
+	}
+	for i := range recentClicks {
+		recentClicks[i].ClickedAt = recentClicks[i].ClickedAt.In(query.Location)
+	}
 
+	clicksByRule, err := s.clicks.ClicksByRule(shortCode, filter)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return nil, fmt.Errorf("short code not found")
-		}
 		return nil, err
 	}
+	if n, ok := clicksByRule[""]; ok {
+		delete(clicksByRule, "")
+		clicksByRule[models.DefaultTargetRule] = n
+	}
 
-	return url, nil
-}
+	clicksByVariant, err := s.clicks.ClicksByVariant(shortCode, filter)
+	if err != nil {
+		return nil, err
+	}
 
-func (s *AnalyticsService) getTotalClicks(shortCode string) (int, error) {
-	query := `SELECT COUNT(*) FROM clicks WHERE url_short_code = ?`
-	var count int
-	err := s.db.QueryRow(query, shortCode).Scan(&count)
-	return count, err
-}
+	clicksByCampaign, err := s.clicks.ClicksByCampaign(shortCode, filter)
+	if err != nil {
+		return nil, err
+	}
 
-func (s *AnalyticsService) getUniqueVisitors(shortCode string) (int, error) {
-	query := `SELECT COUNT(DISTINCT ip_address) FROM clicks WHERE url_short_code = ?`
-	var count int
-	err := s.db.QueryRow(query, shortCode).Scan(&count)
-	return count, err
-}
+	clicksByBrowser, clicksByOS, clicksByDevice, err := s.clicks.ClicksByAgent(shortCode, filter)
+	if err != nil {
+		return nil, err
+	}
 
-func (s *AnalyticsService) getClicksByCountry(shortCode string) (map[string]int, error) {
-	query := `
-		SELECT COALESCE(country, 'Unknown') as country, COUNT(*) as count 
-		FROM clicks 
-		WHERE url_short_code = ? AND COALESCE(country, 'Unknown') != ''
-		GROUP BY country 
-		ORDER BY count DESC
-		LIMIT 10
-	`
-
-	rows, err := s.db.Query(query, shortCode)
+	clicksByReferrer, clicksByReferrerCategory, err := s.clicks.ClicksByReferrer(shortCode, filter, 10)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
 
-	result := make(map[string]int)
-	for rows.Next() {
-		var country string
-		var count int
-		if err := rows.Scan(&country, &count); err != nil {
-			return nil, err
-		}
-		result[country] = count
+	conversions, revenue, err := s.clicks.CountConversions(shortCode, within)
+	if err != nil {
+		return nil, err
 	}
 
-	return result, nil
-}
+	conversionsBySlot, err := s.clicks.ConversionsBySlot(shortCode, within, slot)
+	if err != nil {
+		return nil, err
+	}
 
-func (s *AnalyticsService) getClicksByDay(shortCode string, days int) ([]models.DailyClicks, error) {
-	query := `
-		SELECT COALESCE(DATE(clicked_at), '') as date, COUNT(*) as count
-		FROM clicks 
-		WHERE url_short_code = ? AND clicked_at >= datetime('now', '-' || ? || ' days')
-		GROUP BY DATE(clicked_at)
-		ORDER BY date DESC
-	`
+	conversionsOverTime := conversionSeries(starts, query.Granularity, conversionsBySlot, clicksOverTime)
+	clicksByDay, conversionsByDay, err := s.dailySeries(shortCode, query, now, clicksOverTime, conversionsOverTime)
+	if err != nil {
+		return nil, err
+	}
 
-	rows, err := s.db.Query(query, shortCode, days)
+	unlockAttempts, failedUnlocks, err := s.clicks.CountUnlockAttempts(shortCode)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
 
-	var result []models.DailyClicks
-	for rows.Next() {
-		var daily models.DailyClicks
-		if err := rows.Scan(&daily.Date, &daily.Clicks); err != nil {
-			return nil, err
-		}
-		result = append(result, daily)
+	analytics := &models.Analytics{
+		URL:                      url,
+		TotalClicks:              totalClicks,
+		BotClicks:                allClicks - humanClicks,
+		IncludesBots:             query.IncludeBots,
+		UniqueVisitors:           uniqueVisitors,
+		ClicksByCountry:          clicksByCountry,
+		RecentClicks:             recentClicks,
+		From:                     query.From.In(query.Location),
+		To:                       query.To.In(query.Location),
+		Granularity:              query.Granularity,
+		Timezone:                 query.Location.String(),
+		ClicksOverTime:           clicksOverTime,
+		ClicksByDay:              clicksByDay,
+		ClicksByRule:             clicksByRule,
+		Variants:                 variantStats(url.Variants, clicksByVariant),
+		ClicksByCampaign:         clicksByCampaign,
+		ClicksByBrowser:          clicksByBrowser,
+		ClicksByOS:               clicksByOS,
+		ClicksByDevice:           clicksByDevice,
+		ClicksByReferrer:         clicksByReferrer,
+		ClicksByReferrerCategory: clicksByReferrerCategory,
+		Conversions:              conversions,
+		ConversionRate:           conversionRate(conversions, totalClicks),
+		Revenue:                  revenue,
+		ConversionsOverTime:      conversionsOverTime,
+		ConversionsByDay:         conversionsByDay,
+		UnlockAttempts:           unlockAttempts,
+		FailedUnlockAttempts:     failedUnlocks,
 	}
 
-	return result, nil
+	return analytics, nil
 }
 
-
-func (s *AnalyticsService) getRecentClicks(shortCode string, limit int) ([]models.Click, error) {
-	query := `
-		SELECT id, url_short_code, ip_address, 
-		       COALESCE(user_agent, '') as user_agent, 
-		       COALESCE(referer, '') as referer, 
-		       COALESCE(country, 'Unknown') as country, 
-		       COALESCE(city, 'Unknown') as city, 
-		       clicked_at
-		FROM clicks 
-		WHERE url_short_code = ?
-		ORDER BY clicked_at DESC
-		LIMIT ?
-	`
-
-	rows, err := s.db.Query(query, shortCode, limit)
+// dailySeries returns the clicks and conversions of the default query, the
+// last 30 days by day in UTC, for the clicks_by_day and conversions_by_day
+// of earlier versions of the API. The series of query are reused when it
+// is the default one.
+func (s *AnalyticsService) dailySeries(shortCode string, query models.AnalyticsQuery, now time.Time, clicks []models.ClickBucket, conversions []models.ConversionBucket) ([]models.DailyClicks, []models.DailyConversions, error) {
+	daily, err := normalizeQuery(models.AnalyticsQuery{IncludeBots: query.IncludeBots}, now)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
-	defer rows.Close()
-
-	var clicks []models.Click
-	for rows.Next() {
-		var click models.Click
-		err := rows.Scan(&click.ID, &click.URLShortCode, &click.IPAddress,
-			&click.UserAgent, &click.Referer, &click.Country, &click.City, &click.ClickedAt)
+	if query.Granularity != daily.Granularity || query.Location != daily.Location || !query.From.Equal(daily.From) || !query.To.Equal(daily.To) {
+		starts, err := bucketStarts(daily.From, daily.To, daily.Granularity, daily.Location)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
-		clicks = append(clicks, click)
+		slot := slotFor(daily.From, daily.To, daily.Granularity, daily.Location)
+		within := store.TimeRange{From: daily.From, To: daily.To}
+		clicksBySlot, err := s.clicks.ClicksBySlot(shortCode, store.ClickFilter{IncludeBots: daily.IncludeBots, TimeRange: within}, slot)
+		if err != nil {
+			return nil, nil, err
+		}
+		conversionsBySlot, err := s.clicks.ConversionsBySlot(shortCode, within, slot)
+		if err != nil {
+			return nil, nil, err
+		}
+		clicks = clickSeries(starts, daily.Granularity, clicksBySlot)
+		conversions = conversionSeries(starts, daily.Granularity, conversionsBySlot, clicks)
 	}
 
-	return clicks, nil
-}
-
-type ipAPIResponse struct {
-	Country string `json:"country"`
-	City    string `json:"city"`
-	Status  string `json:"status"`
+	clicksByDay := make([]models.DailyClicks, len(clicks))
+	for i, bucket := range clicks {
+		clicksByDay[i] = models.DailyClicks{Date: bucket.Label, Clicks: bucket.Clicks}
+	}
+	conversionsByDay := make([]models.DailyConversions, len(conversions))
+	for i, bucket := range conversions {
+		conversionsByDay[i] = models.DailyConversions{
+			Date:           bucket.Label,
+			Conversions:    bucket.Conversions,
+			ConversionRate: bucket.ConversionRate,
+			Revenue:        bucket.Revenue,
+		}
+	}
+	return clicksByDay, conversionsByDay, nil
 }
 
-func (s *AnalyticsService) GetLocationFromIP(ip string) (country, city string) {
-	// Handle localhost
-	if ip == "127.0.0.1" || ip == "::1" || ip == "" || ip == "localhost" {
-		return "Local", "Local"
+// variantStats lists the current variants with their counts from clicked,
+// followed by the earlier variants found only in clicked.
+func variantStats(variants []models.Variant, clicked []models.VariantStats) []models.VariantStats {
+	counts := make(map[string]models.VariantStats)
+	for _, c := range clicked {
+		counts[c.Name] = c
 	}
 
-	// Skip private IP ranges
-	if isPrivateIP(ip) {
-		return "Local Network", "Local Network"
+	var result []models.VariantStats
+	for _, v := range variants {
+		c := counts[v.Name]
+		result = append(result, models.VariantStats{
+			Name:           v.Name,
+			URL:            v.URL,
+			Weight:         v.Weight,
+			Clicks:         c.Clicks,
+			UniqueVisitors: c.UniqueVisitors,
+		})
+		delete(counts, v.Name)
 	}
+	for _, c := range clicked {
+		if _, ok := counts[c.Name]; ok {
+			result = append(result, c)
+		}
+	}
+	return result
+}
 
-	// Call free IP geolocation API
-	client := &http.Client{
-		Timeout: 2 * time.Second,
+// CampaignStats groups the clicks of every link ownerID can view by the
+// utm_campaign of their destination, leaving out bots.
+func (s *AnalyticsService) CampaignStats(ownerID string) ([]models.CampaignStats, error) {
+	if ownerID == "" {
+		return nil, ErrUnauthorized
 	}
-	
-	url := fmt.Sprintf("http://ip-api.com/json/%s", ip)
-	resp, err := client.Get(url)
+
+	access, err := accessFor(s.teams, ownerID, models.RoleViewer)
 	if err != nil {
-		fmt.Printf("Error fetching location for IP %s: %v\n", ip, err)
-		return "Unknown", "Unknown"
+		return nil, err
 	}
-	defer resp.Body.Close()
 
-	var data ipAPIResponse
-	if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
-		fmt.Printf("Error decoding response for IP %s: %v\n", ip, err)
-		return "Unknown", "Unknown"
+	return s.clicks.CampaignStats(access, store.ClickFilter{})
+}
+
+func (s *AnalyticsService) checkViewAccess(shortCode string, ownerID string) error {
+	access, err := accessFor(s.teams, ownerID, models.RoleViewer)
+	if err != nil {
+		return err
 	}
 
-	if data.Status != "success" {
-		fmt.Printf("API returned non-success status for IP %s: %s\n", ip, data.Status)
-		return "Unknown", "Unknown"
+	ok, err := s.urls.CanAccess(shortCode, access)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrForbidden
 	}
 
-	country = data.Country
-	city = data.City
-	
-	if country == "" {
-		country = "Unknown"
+	return nil
+}
+
+// GetLocationFromIP resolves ip with the configured GeoIP provider.
+// Loopback and private addresses are labelled without a lookup.
+func (s *AnalyticsService) GetLocationFromIP(ip string) geoip.Location {
+	// Handle localhost
+	if ip == "127.0.0.1" || ip == "::1" || ip == "" || ip == "localhost" {
+		return geoip.Location{Country: "Local", Region: "Local", City: "Local"}
 	}
-	if city == "" {
-		city = "Unknown"
+
+	// Skip private IP ranges
+	if isPrivateIP(ip) {
+		return geoip.Location{Country: "Local Network", Region: "Local Network", City: "Local Network"}
 	}
 
-	fmt.Printf("Location for IP %s: %s, %s\n", ip, city, country)
-	return country, city
+	return s.geo.Lookup(ip)
 }
 
 func isPrivateIP(ip string) bool {
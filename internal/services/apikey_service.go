@@ -0,0 +1,155 @@
+package services
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"url-shortener/internal/database"
+	"url-shortener/internal/models"
+)
+
+const (
+	apiKeyPrefix       = "usk_"
+	apiKeySecretLength = 32
+	apiKeyDisplayChars = 12
+)
+
+var (
+	ErrInvalidAPIKey = errors.New("invalid API key")
+	ErrAPIKeyRevoked = errors.New("API key has been revoked")
+	ErrAPIKeyMissing = errors.New("API key not found")
+)
+
+type APIKeyService struct {
+	db *database.DB
+}
+
+func NewAPIKeyService(db *database.DB) *APIKeyService {
+	return &APIKeyService{db: db}
+}
+
+// CreateKey mints a new key for ownerID. Only the SHA-256 hash is stored, so
+// the returned plaintext key cannot be recovered later.
+func (s *APIKeyService) CreateKey(ownerID, name string) (string, *models.APIKey, error) {
+	if ownerID == "" {
+		return "", nil, fmt.Errorf("owner ID is required")
+	}
+
+	plaintext := apiKeyPrefix + generateRandomCode(apiKeySecretLength)
+
+	key := &models.APIKey{
+		OwnerID:   ownerID,
+		Name:      name,
+		Prefix:    plaintext[:apiKeyDisplayChars],
+		KeyHash:   hashToken(plaintext),
+		CreatedAt: time.Now(),
+	}
+
+	query := `
+		INSERT INTO api_keys (owner_id, name, prefix, key_hash, created_at)
+		VALUES (?, ?, ?, ?, ?)
+	`
+
+	id, err := s.db.InsertID(query, key.OwnerID, key.Name, key.Prefix, key.KeyHash, key.CreatedAt)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to save API key: %v", err)
+	}
+	key.ID = int(id)
+
+	return plaintext, key, nil
+}
+
+// Authenticate resolves a plaintext key to its record and stamps last_used_at.
+func (s *APIKeyService) Authenticate(plaintext string) (*models.APIKey, error) {
+	query := `
+		SELECT id, owner_id, COALESCE(name, ''), prefix, key_hash, created_at, last_used_at, revoked_at
+		FROM api_keys
+		WHERE key_hash = ?
+	`
+
+	key := &models.APIKey{}
+	err := s.db.QueryRow(query, hashToken(plaintext)).Scan(
+		&key.ID, &key.OwnerID, &key.Name, &key.Prefix, &key.KeyHash,
+		&key.CreatedAt, &key.LastUsedAt, &key.RevokedAt,
+	)
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrInvalidAPIKey
+		}
+		return nil, err
+	}
+
+	if key.RevokedAt != nil {
+		return nil, ErrAPIKeyRevoked
+	}
+
+	now := time.Now()
+	if _, err := s.db.Exec(`UPDATE api_keys SET last_used_at = ? WHERE id = ?`, now, key.ID); err != nil {
+		return nil, fmt.Errorf("failed to update API key: %v", err)
+	}
+	key.LastUsedAt = &now
+
+	return key, nil
+}
+
+// ListKeys returns every key of ownerID, including revoked ones.
+func (s *APIKeyService) ListKeys(ownerID string) ([]models.APIKey, error) {
+	query := `
+		SELECT id, owner_id, COALESCE(name, ''), prefix, key_hash, created_at, last_used_at, revoked_at
+		FROM api_keys
+		WHERE owner_id = ?
+		ORDER BY created_at DESC
+	`
+
+	rows, err := s.db.Query(query, ownerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []models.APIKey
+	for rows.Next() {
+		var key models.APIKey
+		err := rows.Scan(
+			&key.ID, &key.OwnerID, &key.Name, &key.Prefix, &key.KeyHash,
+			&key.CreatedAt, &key.LastUsedAt, &key.RevokedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	return keys, rows.Err()
+}
+
+// RevokeKey marks a key of ownerID as revoked. Revoked keys stay in the table
+// so their history is kept.
+func (s *APIKeyService) RevokeKey(ownerID string, id int) error {
+	query := `UPDATE api_keys SET revoked_at = ? WHERE id = ? AND owner_id = ? AND revoked_at IS NULL`
+
+	result, err := s.db.Exec(query, time.Now(), id, ownerID)
+	if err != nil {
+		return fmt.Errorf("failed to revoke API key: %v", err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrAPIKeyMissing
+	}
+
+	return nil
+}
+
+func hashToken(plaintext string) string {
+	sum := sha256.Sum256([]byte(plaintext))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,174 @@
+package services
+
+import (
+	"context"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"url-shortener/internal/config"
+	"url-shortener/internal/models"
+)
+
+// ClickQueueStats are the counters of a ClickQueue.
+type ClickQueueStats struct {
+	Enqueued uint64 `json:"enqueued"`
+	Dropped  uint64 `json:"dropped"`
+	Written  uint64 `json:"written"`
+	Failed   uint64 `json:"failed"`
+	Pending  int    `json:"pending"`
+}
+
+// ClickQueue records clicks off the request path. Enqueue never blocks:
+// when the buffer is full the click is dropped and counted. Workers enrich
+// clicks and write them in batches until Close drains the queue.
+type ClickQueue struct {
+	analytics *AnalyticsService
+	config    config.ClickQueue
+	clicks    chan models.Click
+	wg        sync.WaitGroup
+
+	mu     sync.RWMutex
+	closed bool
+
+	enqueued atomic.Uint64
+	dropped  atomic.Uint64
+	written  atomic.Uint64
+	failed   atomic.Uint64
+}
+
+// NewClickQueue creates a ClickQueue writing through analytics and starts
+// its workers. Zero settings fall back to config.Default.
+func NewClickQueue(analytics *AnalyticsService, cfg config.ClickQueue) *ClickQueue {
+	defaults := config.Default().ClickQueue
+	if cfg.Size <= 0 {
+		cfg.Size = defaults.Size
+	}
+	if cfg.Workers <= 0 {
+		cfg.Workers = defaults.Workers
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = defaults.BatchSize
+	}
+	if cfg.FlushInterval <= 0 {
+		cfg.FlushInterval = defaults.FlushInterval
+	}
+
+	q := &ClickQueue{
+		analytics: analytics,
+		config:    cfg,
+		clicks:    make(chan models.Click, cfg.Size),
+	}
+
+	for i := 0; i < cfg.Workers; i++ {
+		q.wg.Add(1)
+		go q.work()
+	}
+
+	return q
+}
+
+// Enqueue queues a click and reports whether it was accepted.
+func (q *ClickQueue) Enqueue(click models.Click) bool {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	if q.closed {
+		q.dropped.Add(1)
+		return false
+	}
+
+	// Conversions of the click wait for it to be written.
+	if click.ClickID != "" {
+		q.analytics.holdClickID(click.ClickID)
+	}
+	select {
+	case q.clicks <- click:
+		q.enqueued.Add(1)
+		return true
+	default:
+		q.analytics.releaseClickIDs([]models.Click{click})
+		if q.dropped.Add(1) == 1 {
+			log.Printf("Click queue full (%d); dropping clicks", q.config.Size)
+		}
+		return false
+	}
+}
+
+// Stats returns the current counters.
+func (q *ClickQueue) Stats() ClickQueueStats {
+	return ClickQueueStats{
+		Enqueued: q.enqueued.Load(),
+		Dropped:  q.dropped.Load(),
+		Written:  q.written.Load(),
+		Failed:   q.failed.Load(),
+		Pending:  len(q.clicks),
+	}
+}
+
+// Close stops accepting clicks and waits until the queued ones are written
+// or ctx is done.
+func (q *ClickQueue) Close(ctx context.Context) error {
+	q.mu.Lock()
+	if !q.closed {
+		q.closed = true
+		close(q.clicks)
+	}
+	q.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		q.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (q *ClickQueue) work() {
+	defer q.wg.Done()
+
+	ticker := time.NewTicker(q.config.FlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]models.Click, 0, q.config.BatchSize)
+	for {
+		select {
+		case click, ok := <-q.clicks:
+			if !ok {
+				q.flush(batch)
+				return
+			}
+			q.analytics.EnrichClick(&click)
+			batch = append(batch, click)
+			if len(batch) >= q.config.BatchSize {
+				q.flush(batch)
+				batch = batch[:0]
+			}
+		case <-ticker.C:
+			q.flush(batch)
+			batch = batch[:0]
+		}
+	}
+}
+
+func (q *ClickQueue) flush(batch []models.Click) {
+	if len(batch) == 0 {
+		return
+	}
+	defer q.analytics.releaseClickIDs(batch)
+
+	if err := q.analytics.RecordClicks(batch); err != nil {
+		q.failed.Add(uint64(len(batch)))
+		log.Printf("Failed to record %d clicks: %v", len(batch), err)
+		return
+	}
+
+	q.written.Add(uint64(len(batch)))
+}
@@ -0,0 +1,171 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	neturl "net/url"
+	"strings"
+	"time"
+
+	"url-shortener/internal/models"
+	"url-shortener/internal/store"
+)
+
+const (
+	clickIDRandomLength = 16
+	maxClickIDParamLen  = 32
+	currencyCodeLength  = 3
+	// pendingClickWait is how long a conversion waits for its click to
+	// leave the click queue.
+	pendingClickWait = 5 * time.Second
+)
+
+var (
+	ErrUnknownClick        = errors.New("unknown click ID")
+	ErrConversionExists    = errors.New("click already converted")
+	ErrInvalidConversion   = errors.New("invalid conversion")
+	ErrInvalidClickIDParam = fmt.Errorf("click ID parameter must be at most %d letters, digits, '-' or '_'", maxClickIDParamLen)
+)
+
+// NewClickID returns a click ID for a redirect of shortCode. It starts with
+// the short code, so a conversion can be attributed to its link while the
+// click itself is still queued.
+func NewClickID(shortCode string) string {
+	return shortCode + "-" + generateRandomCode(clickIDRandomLength)
+}
+
+// parseClickID returns the short code of a click ID made by NewClickID.
+func parseClickID(clickID string) (string, bool) {
+	i := strings.LastIndex(clickID, "-")
+	if i <= 0 || len(clickID)-i-1 != clickIDRandomLength {
+		return "", false
+	}
+	for _, c := range clickID[i+1:] {
+		if !strings.ContainsRune(charset, c) {
+			return "", false
+		}
+	}
+	return clickID[:i], true
+}
+
+// WithClickID adds param=clickID to the query of destination, replacing a
+// parameter of that name.
+func WithClickID(destination, param, clickID string) string {
+	return mergeQuery(destination, neturl.QueryEscape(param)+"="+neturl.QueryEscape(clickID))
+}
+
+func validClickIDParam(param string) bool {
+	return param != "" && len(param) <= maxClickIDParamLen && validToken(param)
+}
+
+// RecordConversion attributes a conversion to the recorded click with
+// req.ClickID. A click still in the click queue is waited for.
+func (s *AnalyticsService) RecordConversion(req models.ConversionRequest) (*models.Conversion, error) {
+	shortCode, ok := parseClickID(req.ClickID)
+	if !ok {
+		return nil, ErrUnknownClick
+	}
+	url, err := s.urls.GetURL(shortCode, true)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			return nil, ErrUnknownClick
+		}
+		return nil, err
+	}
+	if url.ClickIDParam == "" {
+		return nil, ErrUnknownClick
+	}
+
+	currency := strings.ToUpper(strings.TrimSpace(req.Currency))
+	switch {
+	case req.Value < 0 || math.IsNaN(req.Value) || math.IsInf(req.Value, 0):
+		return nil, fmt.Errorf("%w: value must be a positive number", ErrInvalidConversion)
+	case currency != "" && !validCurrencyCode(currency):
+		return nil, fmt.Errorf("%w: currency must be a three-letter ISO 4217 code", ErrInvalidConversion)
+	case req.Value > 0 && currency == "":
+		return nil, fmt.Errorf("%w: a value needs a currency", ErrInvalidConversion)
+	}
+
+	recorded, err := s.clickRecorded(shortCode, req.ClickID)
+	if err != nil {
+		return nil, err
+	}
+	if !recorded {
+		return nil, ErrUnknownClick
+	}
+
+	conversion := models.Conversion{
+		ClickID:      req.ClickID,
+		URLShortCode: shortCode,
+		Value:        req.Value,
+		Currency:     currency,
+		ConvertedAt:  time.Now(),
+	}
+	if err := s.clicks.RecordConversion(conversion); err != nil {
+		if errors.Is(err, store.ErrDuplicateConversion) {
+			return nil, ErrConversionExists
+		}
+		return nil, fmt.Errorf("failed to record conversion: %v", err)
+	}
+
+	return &conversion, nil
+}
+
+// clickRecorded reports whether the click with clickID was stored, first
+// waiting up to pendingClickWait for it if it is queued. Pending clicks are
+// looked up before the store, so a click written in between is not missed.
+func (s *AnalyticsService) clickRecorded(shortCode, clickID string) (bool, error) {
+	s.pendingMu.Lock()
+	written, queued := s.pending[clickID]
+	s.pendingMu.Unlock()
+	if queued {
+		select {
+		case <-written:
+		case <-time.After(pendingClickWait):
+			return false, nil
+		}
+	}
+
+	return s.clicks.ClickExists(shortCode, clickID)
+}
+
+// holdClickID marks the click with clickID as queued until releaseClickIDs.
+func (s *AnalyticsService) holdClickID(clickID string) {
+	s.pendingMu.Lock()
+	defer s.pendingMu.Unlock()
+	s.pending[clickID] = make(chan struct{})
+}
+
+// releaseClickIDs wakes the conversions waiting for clicks, once they are
+// written or have failed to be.
+func (s *AnalyticsService) releaseClickIDs(clicks []models.Click) {
+	s.pendingMu.Lock()
+	defer s.pendingMu.Unlock()
+	for _, click := range clicks {
+		if written, ok := s.pending[click.ClickID]; ok {
+			close(written)
+			delete(s.pending, click.ClickID)
+		}
+	}
+}
+
+func validCurrencyCode(code string) bool {
+	if len(code) != currencyCodeLength {
+		return false
+	}
+	for _, c := range code {
+		if c < 'A' || c > 'Z' {
+			return false
+		}
+	}
+	return true
+}
+
+// conversionRate returns conversions over clicks, or 0 without clicks.
+func conversionRate(conversions, clicks int) float64 {
+	if clicks == 0 {
+		return 0
+	}
+	return float64(conversions) / float64(clicks)
+}
@@ -0,0 +1,132 @@
+package services
+
+import (
+	"fmt"
+	neturl "net/url"
+	"strings"
+
+	"url-shortener/internal/models"
+)
+
+const maxUTMValueLen = 255
+
+var ErrInvalidUTM = fmt.Errorf("UTM values must be at most %d bytes", maxUTMValueLen)
+
+// mergeQuery adds the encoded query extra to destination. Parameters of
+// destination that extra also sets are replaced; the others keep their
+// order and encoding.
+func mergeQuery(destination, extra string) string {
+	if extra == "" {
+		return destination
+	}
+	u, err := neturl.Parse(destination)
+	if err != nil {
+		return destination
+	}
+
+	replaced := make(map[string]bool)
+	var added []string
+	for _, pair := range strings.Split(extra, "&") {
+		if pair != "" {
+			replaced[queryKey(pair)] = true
+			added = append(added, pair)
+		}
+	}
+	var pairs []string
+	for _, pair := range strings.Split(u.RawQuery, "&") {
+		if pair != "" && !replaced[queryKey(pair)] {
+			pairs = append(pairs, pair)
+		}
+	}
+
+	u.RawQuery = strings.Join(append(pairs, added...), "&")
+	return u.String()
+}
+
+func queryKey(pair string) string {
+	key, _, _ := strings.Cut(pair, "=")
+	if unescaped, err := neturl.QueryUnescape(key); err == nil {
+		return unescaped
+	}
+	return key
+}
+
+// ForwardQuery passes the query string of a short URL request on to
+// destination, replacing parameters of the same name.
+func ForwardQuery(destination, rawQuery string) string {
+	return mergeQuery(destination, rawQuery)
+}
+
+// Campaign returns the utm_campaign of destination, if any.
+func Campaign(destination string) string {
+	u, err := neturl.Parse(destination)
+	if err != nil {
+		return ""
+	}
+	return u.Query().Get("utm_campaign")
+}
+
+// utmQuery encodes the non-empty fields of utm in a fixed order.
+func utmQuery(utm models.UTM) string {
+	var pairs []string
+	for _, p := range [][2]string{
+		{"utm_source", utm.Source},
+		{"utm_medium", utm.Medium},
+		{"utm_campaign", utm.Campaign},
+		{"utm_term", utm.Term},
+		{"utm_content", utm.Content},
+	} {
+		if p[1] != "" {
+			pairs = append(pairs, p[0]+"="+neturl.QueryEscape(p[1]))
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+// normalizeUTM trims the fields of utm and checks their length.
+func normalizeUTM(utm models.UTM) (models.UTM, error) {
+	for _, field := range []*string{&utm.Source, &utm.Medium, &utm.Campaign, &utm.Term, &utm.Content} {
+		*field = strings.TrimSpace(*field)
+		if len(*field) > maxUTMValueLen {
+			return utm, ErrInvalidUTM
+		}
+	}
+	return utm, nil
+}
+
+// withUTM merges utm into the destinations of a link: its original URL and
+// the URLs of its targeting rules and variants. The lists are copied.
+func withUTM(utm models.UTM, originalURL string, rules []models.TargetingRule, variants []models.Variant) (string, []models.TargetingRule, []models.Variant) {
+	query := utmQuery(utm)
+
+	var mergedRules []models.TargetingRule
+	for _, rule := range rules {
+		rule.URL = mergeQuery(rule.URL, query)
+		mergedRules = append(mergedRules, rule)
+	}
+	var mergedVariants []models.Variant
+	for _, v := range variants {
+		v.URL = mergeQuery(v.URL, query)
+		mergedVariants = append(mergedVariants, v)
+	}
+
+	return mergeQuery(originalURL, query), mergedRules, mergedVariants
+}
+
+// applyUTM merges req.UTM into the destinations current has after req, so
+// they are written together.
+func applyUTM(current *models.URL, req *models.UpdateURLRequest) {
+	originalURL, rules, variants := current.OriginalURL, current.TargetingRules, current.Variants
+	if req.OriginalURL != nil {
+		originalURL = *req.OriginalURL
+	}
+	if req.TargetingRules != nil {
+		rules = *req.TargetingRules
+	}
+	if req.Variants != nil {
+		variants = *req.Variants
+	}
+
+	originalURL, rules, variants = withUTM(*req.UTM, originalURL, rules, variants)
+	req.OriginalURL, req.TargetingRules, req.Variants = &originalURL, &rules, &variants
+}
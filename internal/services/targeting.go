@@ -0,0 +1,160 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+
+	"url-shortener/internal/geoip"
+	"url-shortener/internal/models"
+	"url-shortener/internal/useragent"
+)
+
+const (
+	maxTargetingRules    = 20
+	maxTargetRuleNameLen = 50
+)
+
+// continents are the continent codes used by GeoIP databases.
+var continents = map[string]bool{
+	"AF": true, "AN": true, "AS": true, "EU": true, "NA": true, "OC": true, "SA": true,
+}
+
+// Visitor is what targeting rules are matched against.
+type Visitor struct {
+	Agent    useragent.Agent
+	Location geoip.Location
+	// Variant is the A/B variant assigned on an earlier visit, if any.
+	Variant string
+}
+
+// Target is where Destination sends a visitor.
+type Target struct {
+	URL string
+	// Rule is the name of the matched targeting rule, if any.
+	Rule string
+	// Variant is the name of the A/B variant chosen when no rule matched.
+	Variant string
+}
+
+// TargetsLocation reports whether any rule of url depends on the visitor's
+// location, so callers only resolve it when needed.
+func (s *URLService) TargetsLocation(url *models.URL) bool {
+	for _, rule := range url.TargetingRules {
+		if len(rule.Countries) > 0 || len(rule.Continents) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Destination picks where visitor goes: the URL of the first targeting rule
+// that matches, else a variant of the A/B split, else the original URL.
+func (s *URLService) Destination(url *models.URL, visitor Visitor) Target {
+	for _, rule := range url.TargetingRules {
+		if ruleMatches(rule, visitor) {
+			return Target{URL: rule.URL, Rule: rule.Name}
+		}
+	}
+	if v, ok := pickVariant(url.Variants, visitor.Variant); ok {
+		return Target{URL: v.URL, Variant: v.Name}
+	}
+	return Target{URL: url.OriginalURL}
+}
+
+func ruleMatches(rule models.TargetingRule, visitor Visitor) bool {
+	return (rule.OS == "" || rule.OS == visitor.Agent.OS) &&
+		(rule.Device == "" || rule.Device == visitor.Agent.Device) &&
+		(len(rule.Countries) == 0 || contains(rule.Countries, visitor.Location.CountryCode)) &&
+		(len(rule.Continents) == 0 || contains(rule.Continents, visitor.Location.ContinentCode))
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeTargetingRules checks rules and returns them with lower-case
+// OS and device, upper-case country and continent codes, and unnamed rules
+// named after their conditions, such as "ios tablet" or "DE,AT".
+func normalizeTargetingRules(rules []models.TargetingRule) ([]models.TargetingRule, error) {
+	if len(rules) > maxTargetingRules {
+		return nil, fmt.Errorf("%w: at most %d rules are allowed", ErrInvalidTargeting, maxTargetingRules)
+	}
+
+	var normalized []models.TargetingRule
+	names := make(map[string]bool)
+	for i, rule := range rules {
+		rule.OS = strings.ToLower(strings.TrimSpace(rule.OS))
+		rule.Device = strings.ToLower(strings.TrimSpace(rule.Device))
+		rule.Countries = upperCodes(rule.Countries)
+		rule.Continents = upperCodes(rule.Continents)
+		rule.Name = strings.TrimSpace(rule.Name)
+
+		if rule.OS == "" && rule.Device == "" && len(rule.Countries) == 0 && len(rule.Continents) == 0 {
+			return nil, fmt.Errorf("%w: rule %d needs an os, device, countries or continents", ErrInvalidTargeting, i+1)
+		}
+		if rule.OS != "" && !useragent.KnownOS(rule.OS) {
+			return nil, fmt.Errorf("%w: rule %d has unknown os %q", ErrInvalidTargeting, i+1, rule.OS)
+		}
+		if rule.Device != "" && !useragent.KnownDevice(rule.Device) {
+			return nil, fmt.Errorf("%w: rule %d has unknown device %q", ErrInvalidTargeting, i+1, rule.Device)
+		}
+		for _, country := range rule.Countries {
+			if !validCountryCode(country) {
+				return nil, fmt.Errorf("%w: rule %d has country %q, want a two-letter ISO code", ErrInvalidTargeting, i+1, country)
+			}
+		}
+		for _, continent := range rule.Continents {
+			if !continents[continent] {
+				return nil, fmt.Errorf("%w: rule %d has unknown continent %q", ErrInvalidTargeting, i+1, continent)
+			}
+		}
+		if !validHTTPURL(rule.URL) {
+			return nil, fmt.Errorf("%w: rule %d needs a url starting with http:// or https://", ErrInvalidTargeting, i+1)
+		}
+
+		if rule.Name == "" {
+			rule.Name = strings.Join(strings.Fields(strings.Join([]string{
+				rule.OS, rule.Device, strings.Join(rule.Countries, ","), strings.Join(rule.Continents, ","),
+			}, " ")), " ")
+		}
+		switch {
+		case len(rule.Name) > maxTargetRuleNameLen:
+			return nil, fmt.Errorf("%w: rule %d needs a name of at most %d bytes", ErrInvalidTargeting, i+1, maxTargetRuleNameLen)
+		case rule.Name == models.DefaultTargetRule:
+			return nil, fmt.Errorf("%w: %q is reserved for the original URL", ErrInvalidTargeting, models.DefaultTargetRule)
+		case names[rule.Name]:
+			return nil, fmt.Errorf("%w: more than one rule is named %q", ErrInvalidTargeting, rule.Name)
+		}
+		names[rule.Name] = true
+
+		normalized = append(normalized, rule)
+	}
+
+	return normalized, nil
+}
+
+// upperCodes trims and upper-cases codes, returning nil for none.
+func upperCodes(codes []string) []string {
+	var result []string
+	for _, code := range codes {
+		result = append(result, strings.ToUpper(strings.TrimSpace(code)))
+	}
+	return result
+}
+
+func validCountryCode(code string) bool {
+	if len(code) != 2 {
+		return false
+	}
+	for _, c := range code {
+		if c < 'A' || c > 'Z' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,280 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"url-shortener/internal/database"
+	"url-shortener/internal/models"
+	"url-shortener/internal/store"
+)
+
+var (
+	ErrTeamNotFound = errors.New("team not found")
+	ErrInvalidRole  = errors.New("role must be viewer, editor or admin")
+)
+
+// TeamLookup resolves team roles for the link services. TeamService
+// implements it; a nil TeamLookup means only personal links are accessible.
+type TeamLookup interface {
+	RoleFor(teamID int, username string) (models.Role, error)
+	TeamIDsWithRole(username string, need models.Role) ([]int, error)
+}
+
+type TeamService struct {
+	db *database.DB
+}
+
+func NewTeamService(db *database.DB) *TeamService {
+	return &TeamService{db: db}
+}
+
+// CreateTeam creates a team with creator as its first admin.
+func (s *TeamService) CreateTeam(name, creator string) (*models.Team, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, fmt.Errorf("team name is required")
+	}
+
+	user, err := NewUserService(s.db).GetUserByUsername(creator)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	team := &models.Team{Name: name, CreatedAt: time.Now(), Role: models.RoleAdmin}
+
+	id, err := tx.InsertID(`INSERT INTO teams (name, created_at) VALUES (?, ?)`, team.Name, team.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save team: %v", err)
+	}
+	team.ID = int(id)
+
+	query := `INSERT INTO team_members (team_id, user_id, role, created_at) VALUES (?, ?, ?, ?)`
+	if _, err := tx.Exec(query, team.ID, user.ID, models.RoleAdmin, team.CreatedAt); err != nil {
+		return nil, fmt.Errorf("failed to add team admin: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return team, nil
+}
+
+// ListTeams returns the teams username belongs to, with their role in each.
+func (s *TeamService) ListTeams(username string) ([]models.Team, error) {
+	query := `
+		SELECT t.id, t.name, t.created_at, m.role
+		FROM teams t
+		JOIN team_members m ON m.team_id = t.id
+		JOIN users u ON u.id = m.user_id
+		WHERE u.username = ?
+		ORDER BY t.name
+	`
+
+	rows, err := s.db.Query(query, username)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var teams []models.Team
+	for rows.Next() {
+		var team models.Team
+		if err := rows.Scan(&team.ID, &team.Name, &team.CreatedAt, &team.Role); err != nil {
+			return nil, err
+		}
+		teams = append(teams, team)
+	}
+
+	return teams, rows.Err()
+}
+
+// SetMember adds username to a team or changes their role. actor must be an
+// admin of the team; an empty actor skips the check for command-line use.
+func (s *TeamService) SetMember(teamID int, username string, role models.Role, actor string) error {
+	if !validRole(role) {
+		return ErrInvalidRole
+	}
+	if err := s.requireAdmin(teamID, actor); err != nil {
+		return err
+	}
+
+	user, err := NewUserService(s.db).GetUserByUsername(username)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO team_members (team_id, user_id, role, created_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT (team_id, user_id) DO UPDATE SET role = excluded.role
+	`
+	if _, err := s.db.Exec(query, teamID, user.ID, role, time.Now()); err != nil {
+		return fmt.Errorf("failed to save team member: %v", err)
+	}
+
+	return nil
+}
+
+// RemoveMember removes username from a team. actor must be an admin of the
+// team.
+func (s *TeamService) RemoveMember(teamID int, username string, actor string) error {
+	if err := s.requireAdmin(teamID, actor); err != nil {
+		return err
+	}
+
+	query := `
+		DELETE FROM team_members
+		WHERE team_id = ? AND user_id = (SELECT id FROM users WHERE username = ?)
+	`
+	result, err := s.db.Exec(query, teamID, username)
+	if err != nil {
+		return fmt.Errorf("failed to remove team member: %v", err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrUserNotFound
+	}
+
+	return nil
+}
+
+// RoleFor returns username's role in a team, or "" if they are not a member.
+func (s *TeamService) RoleFor(teamID int, username string) (models.Role, error) {
+	var exists int
+	if err := s.db.QueryRow(`SELECT COUNT(*) FROM teams WHERE id = ?`, teamID).Scan(&exists); err != nil {
+		return "", err
+	}
+	if exists == 0 {
+		return "", ErrTeamNotFound
+	}
+
+	query := `
+		SELECT m.role
+		FROM team_members m
+		JOIN users u ON u.id = m.user_id
+		WHERE m.team_id = ? AND u.username = ?
+	`
+
+	var role models.Role
+	err := s.db.QueryRow(query, teamID, username).Scan(&role)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+
+	return role, err
+}
+
+func (s *TeamService) requireAdmin(teamID int, actor string) error {
+	if actor == "" {
+		var exists int
+		if err := s.db.QueryRow(`SELECT COUNT(*) FROM teams WHERE id = ?`, teamID).Scan(&exists); err != nil {
+			return err
+		}
+		if exists == 0 {
+			return ErrTeamNotFound
+		}
+		return nil
+	}
+
+	role, err := s.RoleFor(teamID, actor)
+	if err != nil {
+		return err
+	}
+	if role != models.RoleAdmin {
+		return ErrForbidden
+	}
+
+	return nil
+}
+
+func validRole(role models.Role) bool {
+	return role == models.RoleViewer || role == models.RoleEditor || role == models.RoleAdmin
+}
+
+// roleAllows reports whether role includes the permissions of need.
+func roleAllows(role, need models.Role) bool {
+	for _, r := range rolesAtLeast(need) {
+		if role == r {
+			return true
+		}
+	}
+	return false
+}
+
+// rolesAtLeast lists the roles that include the permissions of need.
+func rolesAtLeast(need models.Role) []models.Role {
+	switch need {
+	case models.RoleViewer:
+		return []models.Role{models.RoleViewer, models.RoleEditor, models.RoleAdmin}
+	case models.RoleEditor:
+		return []models.Role{models.RoleEditor, models.RoleAdmin}
+	default:
+		return []models.Role{models.RoleAdmin}
+	}
+}
+
+// TeamIDsWithRole lists the teams where username holds at least role need.
+func (s *TeamService) TeamIDsWithRole(username string, need models.Role) ([]int, error) {
+	roles := rolesAtLeast(need)
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(roles)), ", ")
+
+	query := `
+		SELECT m.team_id
+		FROM team_members m
+		JOIN users u ON u.id = m.user_id
+		WHERE u.username = ? AND m.role IN (` + placeholders + `)
+	`
+
+	args := []interface{}{username}
+	for _, role := range roles {
+		args = append(args, role)
+	}
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, rows.Err()
+}
+
+// accessFor describes the links ownerID may act on with at least role need:
+// their personal links plus links of teams where they hold a sufficient role.
+func accessFor(teams TeamLookup, ownerID string, need models.Role) (store.Access, error) {
+	access := store.Access{OwnerID: ownerID}
+	if teams == nil {
+		return access, nil
+	}
+
+	ids, err := teams.TeamIDsWithRole(ownerID, need)
+	if err != nil {
+		return access, err
+	}
+	access.TeamIDs = ids
+
+	return access, nil
+}
@@ -0,0 +1,201 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"url-shortener/internal/models"
+)
+
+const (
+	defaultAnalyticsDays = 30
+	defaultRecentClicks  = 10
+	maxRecentClicks      = 1000
+	maxBuckets           = 5000
+)
+
+var (
+	ErrInvalidTimeRange   = errors.New("from must be before to")
+	ErrInvalidGranularity = errors.New("granularity must be minute, hour, day, week or month")
+	ErrTooManyBuckets     = fmt.Errorf("time range must span at most %d buckets", maxBuckets)
+	ErrInvalidLimit       = fmt.Errorf("limit must be between 1 and %d", maxRecentClicks)
+)
+
+// bucketLayouts formats the bucket labels of each granularity.
+var bucketLayouts = map[string]string{
+	models.GranularityMinute: "2006-01-02 15:04",
+	models.GranularityHour:   "2006-01-02 15:00",
+	models.GranularityDay:    "2006-01-02",
+	models.GranularityWeek:   "2006-01-02",
+	models.GranularityMonth:  "2006-01",
+}
+
+// normalizeQuery fills in the defaults of query, counting back from now,
+// and checks it.
+func normalizeQuery(query models.AnalyticsQuery, now time.Time) (models.AnalyticsQuery, error) {
+	if query.Location == nil {
+		query.Location = time.UTC
+	}
+	if query.Granularity == "" {
+		query.Granularity = models.GranularityDay
+	}
+	if query.To.IsZero() {
+		query.To = now
+	}
+	if query.From.IsZero() {
+		query.From = query.To.AddDate(0, 0, -defaultAnalyticsDays)
+	}
+	if query.Limit == 0 {
+		query.Limit = defaultRecentClicks
+	}
+
+	if _, ok := bucketLayouts[query.Granularity]; !ok {
+		return query, ErrInvalidGranularity
+	}
+	if !query.From.Before(query.To) {
+		return query, ErrInvalidTimeRange
+	}
+	if query.Limit < 0 || query.Limit > maxRecentClicks {
+		return query, ErrInvalidLimit
+	}
+	return query, nil
+}
+
+// bucketStarts returns the starts of the buckets of granularity in loc that
+// cover from up to to. The first bucket may start before from.
+func bucketStarts(from, to time.Time, granularity string, loc *time.Location) ([]time.Time, error) {
+	var starts []time.Time
+	for start := bucketStart(from, granularity, loc); start.Before(to); start = nextBucket(start, granularity, loc) {
+		if len(starts) == maxBuckets {
+			return nil, ErrTooManyBuckets
+		}
+		starts = append(starts, start)
+	}
+	return starts, nil
+}
+
+// bucketStart returns the start of the bucket of granularity that t falls
+// in, in loc. Weeks start on Monday.
+func bucketStart(t time.Time, granularity string, loc *time.Location) time.Time {
+	t = t.In(loc)
+	year, month, day := t.Date()
+	switch granularity {
+	case models.GranularityMinute:
+		return truncateLocal(t, time.Minute)
+	case models.GranularityHour:
+		return truncateLocal(t, time.Hour)
+	case models.GranularityDay:
+		return time.Date(year, month, day, 0, 0, 0, 0, loc)
+	case models.GranularityWeek:
+		return time.Date(year, month, day-(int(t.Weekday())+6)%7, 0, 0, 0, 0, loc)
+	default:
+		return time.Date(year, month, 1, 0, 0, 0, 0, loc)
+	}
+}
+
+// nextBucket returns the start of the bucket after the one at start.
+func nextBucket(start time.Time, granularity string, loc *time.Location) time.Time {
+	switch granularity {
+	case models.GranularityMinute:
+		start = start.Add(time.Minute)
+	case models.GranularityHour:
+		start = start.Add(time.Hour)
+	case models.GranularityDay:
+		start = start.AddDate(0, 0, 1)
+	case models.GranularityWeek:
+		start = start.AddDate(0, 0, 7)
+	default:
+		start = start.AddDate(0, 1, 0)
+	}
+	// Realigning keeps buckets on the local clock across offset changes.
+	return bucketStart(start, granularity, loc)
+}
+
+// truncateLocal rounds t down to a multiple of unit on its local clock.
+// Unlike time.Date it tells the two 01:30s of a DST change apart.
+func truncateLocal(t time.Time, unit time.Duration) time.Time {
+	_, offset := t.Zone()
+	shift := time.Duration(offset) * time.Second
+	return t.Add(shift).Truncate(unit).Add(-shift)
+}
+
+// slotFor returns the length of the UTC slots the store counts clicks in
+// for buckets of granularity in loc between from and to: the longest that
+// never straddle a bucket boundary.
+func slotFor(from, to time.Time, granularity string, loc *time.Location) time.Duration {
+	if granularity == models.GranularityMinute {
+		return time.Minute
+	}
+
+	// The offset from UTC only changes between zones, such as summer and
+	// winter time, so one check per zone in the range is enough.
+	utc, wholeHours := true, true
+	for t := from.In(loc); t.Before(to); {
+		_, offset := t.Zone()
+		utc = utc && offset == 0
+		wholeHours = wholeHours && offset%3600 == 0
+		_, end := t.ZoneBounds()
+		if end.IsZero() {
+			break
+		}
+		t = end
+	}
+
+	switch {
+	case utc && granularity != models.GranularityHour:
+		return 24 * time.Hour
+	case wholeHours:
+		return time.Hour
+	}
+	// Every time zone in use is a whole number of quarter hours off UTC.
+	return 15 * time.Minute
+}
+
+// clickSeries adds up slots, keyed by the Unix time they start at, into
+// the buckets at starts, keeping empty buckets so charts have no gaps.
+func clickSeries(starts []time.Time, granularity string, slots map[int64]int) []models.ClickBucket {
+	series := make([]models.ClickBucket, len(starts))
+	for i, start := range starts {
+		series[i] = models.ClickBucket{Start: start, Label: start.Format(bucketLayouts[granularity])}
+	}
+	for slot, count := range slots {
+		series[bucketIndex(starts, slot)].Clicks += count
+	}
+	return series
+}
+
+// conversionSeries is clickSeries for conversions, with the conversion rate
+// of each bucket taken from clicks.
+func conversionSeries(starts []time.Time, granularity string, slots map[int64]models.ConversionBucket, clicks []models.ClickBucket) []models.ConversionBucket {
+	series := make([]models.ConversionBucket, len(starts))
+	for i, start := range starts {
+		series[i] = models.ConversionBucket{
+			Start:   start,
+			Label:   start.Format(bucketLayouts[granularity]),
+			Revenue: make(map[string]float64),
+		}
+	}
+	for slot, conversions := range slots {
+		bucket := &series[bucketIndex(starts, slot)]
+		bucket.Conversions += conversions.Conversions
+		for currency, sum := range conversions.Revenue {
+			bucket.Revenue[currency] += sum
+		}
+	}
+	for i := range series {
+		series[i].ConversionRate = conversionRate(series[i].Conversions, clicks[i].Clicks)
+	}
+	return series
+}
+
+// bucketIndex returns the index of the bucket that the slot starting at
+// the Unix time slot falls in.
+func bucketIndex(starts []time.Time, slot int64) int {
+	i := sort.Search(len(starts), func(i int) bool { return starts[i].Unix() > slot }) - 1
+	if i < 0 {
+		return 0
+	}
+	return i
+}
@@ -0,0 +1,201 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"url-shortener/internal/config"
+	"url-shortener/internal/models"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	ErrWrongPassword   = errors.New("wrong password")
+	ErrTooManyAttempts = errors.New("too many wrong passwords, try again later")
+)
+
+// UnlockService checks passwords of protected links, throttles guessing and
+// signs the cookie that lets a visitor through without asking again.
+type UnlockService struct {
+	secret []byte
+	config config.Unlock
+
+	proxies []*net.IPNet
+
+	mu        sync.Mutex
+	failures  map[string][]time.Time // by visitor IP and short code
+	lastSweep time.Time
+}
+
+// NewUnlockService creates an UnlockService. Zero settings fall back to
+// config.Default().Unlock, and an empty secret to a random one.
+func NewUnlockService(cfg config.Unlock) *UnlockService {
+	defaults := config.Default().Unlock
+	if cfg.CookieTTL <= 0 {
+		cfg.CookieTTL = defaults.CookieTTL
+	}
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = defaults.MaxAttempts
+	}
+	if cfg.Window <= 0 {
+		cfg.Window = defaults.Window
+	}
+
+	secret := []byte(cfg.Secret)
+	if len(secret) == 0 {
+		secret = make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			log.Fatalf("failed to generate unlock secret: %v", err)
+		}
+	}
+
+	proxies, err := cfg.ProxyNets()
+	if err != nil {
+		log.Fatalf("invalid unlock trusted proxies: %v", err)
+	}
+
+	return &UnlockService{secret: secret, config: cfg, proxies: proxies, failures: make(map[string][]time.Time)}
+}
+
+// CookieName returns the name of the cookie that unlocks shortCode.
+func (s *UnlockService) CookieName(shortCode string) string {
+	return "unlock_" + shortCode
+}
+
+// CookieTTL is how long an unlock cookie stays valid.
+func (s *UnlockService) CookieTTL() time.Duration {
+	return s.config.CookieTTL
+}
+
+// CheckPassword compares password with the hash of url. After MaxAttempts
+// wrong passwords from ip within Window it returns ErrTooManyAttempts
+// without checking. Each check takes up an attempt before bcrypt runs, so
+// parallel guesses cannot all slip under the limit; a right password
+// gives it back along with the earlier failures.
+func (s *UnlockService) CheckPassword(url *models.URL, password, ip string) error {
+	key := ip + "|" + url.ShortCode
+	now := time.Now()
+
+	s.mu.Lock()
+	s.sweep(now)
+	recent := s.recentFailures(key, now)
+	if len(recent) >= s.config.MaxAttempts {
+		s.mu.Unlock()
+		return ErrTooManyAttempts
+	}
+	s.failures[key] = append(recent, now)
+	s.mu.Unlock()
+
+	if err := bcrypt.CompareHashAndPassword([]byte(url.PasswordHash), []byte(password)); err != nil {
+		return ErrWrongPassword
+	}
+
+	s.mu.Lock()
+	delete(s.failures, key)
+	s.mu.Unlock()
+	return nil
+}
+
+// VisitorIP returns the address that attempts from a request are counted
+// against: the host of remoteAddr, or, when that is a trusted proxy, the
+// nearest address in forwardedFor that is not. Headers from anyone else
+// are ignored, as visitors could otherwise pick a new address per guess.
+func (s *UnlockService) VisitorIP(remoteAddr, forwardedFor string) string {
+	ip := remoteAddr
+	if host, _, err := net.SplitHostPort(remoteAddr); err == nil {
+		ip = host
+	}
+	if !s.trusted(ip) {
+		return ip
+	}
+
+	hops := strings.Split(forwardedFor, ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if net.ParseIP(hop) == nil {
+			break
+		}
+		ip = hop
+		if !s.trusted(hop) {
+			break
+		}
+	}
+	return ip
+}
+
+func (s *UnlockService) trusted(ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, network := range s.proxies {
+		if network.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// sweep drops the failures of every visitor once per Window, so addresses
+// that never come back do not pile up. Callers hold s.mu.
+func (s *UnlockService) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < s.config.Window {
+		return
+	}
+	for key := range s.failures {
+		s.recentFailures(key, now)
+	}
+	s.lastSweep = now
+}
+
+// recentFailures drops failures older than Window. Callers hold s.mu.
+func (s *UnlockService) recentFailures(key string, now time.Time) []time.Time {
+	var recent []time.Time
+	for _, at := range s.failures[key] {
+		if now.Sub(at) < s.config.Window {
+			recent = append(recent, at)
+		}
+	}
+	if len(recent) == 0 {
+		delete(s.failures, key)
+	} else {
+		s.failures[key] = recent
+	}
+	return recent
+}
+
+// Token returns a cookie value that unlocks url until expires. Changing the
+// password invalidates it.
+func (s *UnlockService) Token(url *models.URL, expires time.Time) string {
+	exp := strconv.FormatInt(expires.Unix(), 10)
+	return exp + "." + s.sign(url, exp)
+}
+
+// Verify reports whether token is an unexpired Token for url.
+func (s *UnlockService) Verify(url *models.URL, token string) bool {
+	exp, sig, ok := strings.Cut(token, ".")
+	if !ok {
+		return false
+	}
+	unix, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil || time.Now().Unix() >= unix {
+		return false
+	}
+	return hmac.Equal([]byte(sig), []byte(s.sign(url, exp)))
+}
+
+func (s *UnlockService) sign(url *models.URL, exp string) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(url.ShortCode + "\n" + exp + "\n" + url.PasswordHash))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
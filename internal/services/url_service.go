@@ -2,34 +2,80 @@ package services
 
 import (
 	"crypto/rand"
-	"database/sql"
+	"errors"
 	"fmt"
 	"math/big"
+	"net/http"
 	"strings"
 	"time"
 
-	"url-shortener/internal/database"
+	"url-shortener/internal/config"
 	"url-shortener/internal/models"
+	"url-shortener/internal/store"
+
+	"golang.org/x/crypto/bcrypt"
 )
 
 const (
-	shortCodeLength = 6
-	charset         = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
-	maxRetries      = 5
+	charset = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+	minLinkPasswordLength = 4
+	// bcrypt ignores everything after 72 bytes
+	maxLinkPasswordLength = 72
+)
+
+var (
+	ErrURLNotFound  = errors.New("short code not found")
+	ErrURLExpired   = errors.New("URL has expired")
+	ErrURLNotActive = errors.New("link is not active yet")
+	ErrForbidden    = errors.New("you do not own this short code")
+	ErrUnauthorized = errors.New("authentication required")
+
+	ErrClickLimitReached   = errors.New("link has reached its click limit")
+	ErrInvalidRedirectType = errors.New("redirect type must be 301, 302, 307 or 308")
+	ErrInvalidMaxClicks    = errors.New("max clicks must be at least 1")
+	ErrInvalidActiveWindow = errors.New("activates_at must be before expires_at")
+	ErrInvalidFallbackURL  = errors.New("fallback URL must start with http:// or https://")
+	ErrInvalidTargeting    = errors.New("invalid targeting rules")
+	ErrInvalidVariants     = errors.New("invalid variants")
+	ErrInvalidLinkPassword = fmt.Errorf("link password must be between %d and %d bytes", minLinkPasswordLength, maxLinkPasswordLength)
 )
 
 type URLService struct {
-	db *database.DB
+	urls   store.URLStore
+	teams  TeamLookup
+	config config.Links
 }
 
-func NewURLService(db *database.DB) *URLService {
-	return &URLService{db: db}
+// NewURLService creates a URLService on top of urls. teams resolves team
+// roles and may be nil when team links are not used.
+func NewURLService(urls store.URLStore, teams TeamLookup, cfg config.Links) *URLService {
+	return &URLService{urls: urls, teams: teams, config: cfg}
 }
 
-func (s *URLService) ShortenURL(req models.ShortenURLRequest, userIP string) (*models.URL, error) {
+// ShortenURL creates a short link. ownerID is empty for anonymous requests;
+// such links are not listed by GetUserURLs and cannot be changed later.
+// Links for a team require ownerID to be at least an editor of it.
+func (s *URLService) ShortenURL(req models.ShortenURLRequest, ownerID, userIP string) (*models.URL, error) {
 	var shortCode string
 	var err error
 
+	if req.TeamID != nil {
+		if ownerID == "" {
+			return nil, ErrUnauthorized
+		}
+		if s.teams == nil {
+			return nil, ErrTeamNotFound
+		}
+		role, err := s.teams.RoleFor(*req.TeamID, ownerID)
+		if err != nil {
+			return nil, err
+		}
+		if !roleAllows(role, models.RoleEditor) {
+			return nil, ErrForbidden
+		}
+	}
+
 	// If custom code is provided, validate and use it
 	if req.CustomCode != "" {
 		if err := s.validateCustomCode(req.CustomCode); err != nil {
@@ -43,99 +89,364 @@ func (s *URLService) ShortenURL(req models.ShortenURLRequest, userIP string) (*m
 		}
 	}
 
+	redirectType := req.RedirectType
+	if redirectType == 0 {
+		redirectType = s.defaultRedirectType()
+	}
+	if !validRedirectType(redirectType) {
+		return nil, ErrInvalidRedirectType
+	}
+
+	if req.MaxClicks != nil && *req.MaxClicks < 1 {
+		return nil, ErrInvalidMaxClicks
+	}
+	if !validActiveWindow(req.ActivatesAt, req.ExpiresAt) {
+		return nil, ErrInvalidActiveWindow
+	}
+	if req.FallbackURL != "" && !validHTTPURL(req.FallbackURL) {
+		return nil, ErrInvalidFallbackURL
+	}
+	rules, err := normalizeTargetingRules(req.TargetingRules)
+	if err != nil {
+		return nil, err
+	}
+	variants, err := normalizeVariants(req.Variants)
+	if err != nil {
+		return nil, err
+	}
+	if req.ClickIDParam != "" && !validClickIDParam(req.ClickIDParam) {
+		return nil, ErrInvalidClickIDParam
+	}
+	originalURL := req.OriginalURL
+	if req.UTM != nil {
+		utm, err := normalizeUTM(*req.UTM)
+		if err != nil {
+			return nil, err
+		}
+		originalURL, rules, variants = withUTM(utm, originalURL, rules, variants)
+	}
+
+	var passwordHash string
+	if req.Password != "" {
+		if len(req.Password) < minLinkPasswordLength || len(req.Password) > maxLinkPasswordLength {
+			return nil, ErrInvalidLinkPassword
+		}
+		hash, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+		if err != nil {
+			return nil, fmt.Errorf("failed to hash password: %v", err)
+		}
+		passwordHash = string(hash)
+	}
+
 	// Create URL entry
 	url := &models.URL{
-		ShortCode:   shortCode,
-		OriginalURL: req.OriginalURL,
-		CreatedAt:   time.Now(),
-		ExpiresAt:   req.ExpiresAt,
-		UserIP:      userIP,
-		IsCustom:    req.CustomCode != "",
+		ShortCode:         shortCode,
+		OriginalURL:       originalURL,
+		CreatedAt:         time.Now(),
+		ActivatesAt:       req.ActivatesAt,
+		ExpiresAt:         req.ExpiresAt,
+		UserIP:            userIP,
+		OwnerID:           ownerID,
+		TeamID:            req.TeamID,
+		IsCustom:          req.CustomCode != "",
+		RedirectType:      redirectType,
+		PasswordHash:      passwordHash,
+		PasswordProtected: passwordHash != "",
+		MaxClicks:         req.MaxClicks,
+		FallbackURL:       req.FallbackURL,
+		TargetingRules:    rules,
+		Variants:          variants,
+		ClickIDParam:      req.ClickIDParam,
+		ForwardQuery:      req.ForwardQuery,
 	}
 
-	query := `
-		INSERT INTO urls (short_code, original_url, created_at, expires_at, user_ip, is_custom)
-		VALUES (?, ?, ?, ?, ?, ?)
-	`
+	if err := s.urls.CreateURL(url); err != nil {
+		if errors.Is(err, store.ErrDuplicateShortCode) {
+			return nil, fmt.Errorf("custom code already exists")
+		}
+		return nil, fmt.Errorf("failed to save URL: %v", err)
+	}
 
-	result, err := s.db.Exec(query, url.ShortCode, url.OriginalURL, url.CreatedAt,
-		url.ExpiresAt, url.UserIP, url.IsCustom)
+	return url, nil
+}
+
+func (s *URLService) GetOriginalURL(shortCode string) (*models.URL, error) {
+	url, err := s.getURL(shortCode)
 	if err != nil {
-		return nil, fmt.Errorf("failed to save URL: %v", err)
+		return nil, err
+	}
+
+	now := time.Now()
+	if url.ActivatesAt != nil && now.Before(*url.ActivatesAt) {
+		return nil, fmt.Errorf("%w: it activates at %s", ErrURLNotActive, url.ActivatesAt.UTC().Format(time.RFC3339))
 	}
 
-	id, _ := result.LastInsertId()
-	url.ID = int(id)
+	if url.ExpiresAt != nil && now.After(*url.ExpiresAt) {
+		return nil, ErrURLExpired
+	}
+
+	if url.MaxClicks != nil && url.ClickCount >= *url.MaxClicks {
+		return nil, ErrClickLimitReached
+	}
 
 	return url, nil
 }
 
-func (s *URLService) GetOriginalURL(shortCode string) (*models.URL, error) {
-	query := `
-		SELECT id, short_code, original_url, created_at, expires_at, click_count, user_ip, is_custom
-		FROM urls 
-		WHERE short_code = ?
-	`
-
-	url := &models.URL{}
-	err := s.db.QueryRow(query, shortCode).Scan(
-		&url.ID, &url.ShortCode, &url.OriginalURL, &url.CreatedAt,
-		&url.ExpiresAt, &url.ClickCount, &url.UserIP, &url.IsCustom,
-	)
+// ClaimClick counts a redirect of a link with MaxClicks before it happens,
+// so concurrent redirects cannot exceed the limit. It reports whether the
+// click was counted; links without a limit are counted later with their
+// recorded clicks.
+func (s *URLService) ClaimClick(url *models.URL) (bool, error) {
+	if url.MaxClicks == nil {
+		return false, nil
+	}
+
+	ok, err := s.urls.ClaimClick(url.ShortCode)
+	if err != nil {
+		return false, err
+	}
+	if !ok {
+		return false, ErrClickLimitReached
+	}
+
+	return true, nil
+}
 
+// FallbackURL returns where visitors of a link that exists but can no
+// longer be used should go: the link's own fallback, or the configured one.
+// Deleted links keep theirs; unknown short codes have none.
+func (s *URLService) FallbackURL(shortCode string) string {
+	url, err := s.urls.GetURL(shortCode, true)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return nil, fmt.Errorf("short code not found")
+		return ""
+	}
+	if url.FallbackURL != "" {
+		return url.FallbackURL
+	}
+	return s.config.FallbackURL
+}
+
+// UpdateURL applies a partial update to a short link that ownerID owns or
+// edits through a team. Expired links can still be updated so their expiry
+// can be extended. Declaring a Winner ends the link's A/B split.
+func (s *URLService) UpdateURL(shortCode string, req models.UpdateURLRequest, ownerID string) (*models.URL, error) {
+	if ownerID == "" {
+		return nil, ErrUnauthorized
+	}
+
+	if req.RedirectType != nil && !validRedirectType(*req.RedirectType) {
+		return nil, ErrInvalidRedirectType
+	}
+	if req.MaxClicks != nil && *req.MaxClicks < 1 {
+		return nil, ErrInvalidMaxClicks
+	}
+	if req.FallbackURL != nil && *req.FallbackURL != "" && !validHTTPURL(*req.FallbackURL) {
+		return nil, ErrInvalidFallbackURL
+	}
+	if req.TargetingRules != nil {
+		rules, err := normalizeTargetingRules(*req.TargetingRules)
+		if err != nil {
+			return nil, err
+		}
+		req.TargetingRules = &rules
+	}
+	if req.Variants != nil {
+		variants, err := normalizeVariants(*req.Variants)
+		if err != nil {
+			return nil, err
 		}
+		req.Variants = &variants
+	}
+	if req.ClickIDParam != nil && *req.ClickIDParam != "" && !validClickIDParam(*req.ClickIDParam) {
+		return nil, ErrInvalidClickIDParam
+	}
+	if req.Winner != "" && (req.OriginalURL != nil || req.Variants != nil) {
+		return nil, fmt.Errorf("%w: winner cannot be combined with original_url or variants", ErrInvalidVariants)
+	}
+	if req.UTM != nil {
+		utm, err := normalizeUTM(*req.UTM)
+		if err != nil {
+			return nil, err
+		}
+		req.UTM = &utm
+	}
+
+	access, err := accessFor(s.teams, ownerID, models.RoleEditor)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.ActivatesAt != nil || req.ExpiresAt != nil {
+		if err := s.checkUpdatedWindow(shortCode, req); err != nil {
+			return nil, err
+		}
+	}
+
+	// The store checks access in the same statement as the write. A winner
+	// and UTM parameters are resolved against the link in the same
+	// transaction, so only links within access are read and the
+	// destinations cannot change in between.
+	var updated bool
+	if req.Winner != "" || req.UTM != nil {
+		var prepareErr error
+		updated, err = s.urls.UpdateURLWith(shortCode, access, func(current *models.URL) (models.UpdateURLRequest, error) {
+			update := req
+			if update.Winner != "" {
+				if prepareErr = applyWinner(current, &update); prepareErr != nil {
+					return update, prepareErr
+				}
+			}
+			if update.UTM != nil {
+				applyUTM(current, &update)
+			}
+			return update, nil
+		})
+		if prepareErr != nil {
+			return nil, prepareErr
+		}
+	} else {
+		updated, err = s.urls.UpdateURL(shortCode, req, access)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to update URL: %v", err)
+	}
+
+	if err := s.checkOwnedWrite(updated, shortCode); err != nil {
 		return nil, err
 	}
 
-	if url.ExpiresAt != nil && time.Now().After(*url.ExpiresAt) {
-		return nil, fmt.Errorf("URL has expired")
+	return s.getURL(shortCode)
+}
+
+// DeleteURL soft-deletes a short link that ownerID owns or administers
+// through a team. The row and its clicks are kept for analytics history, and
+// the short code stays reserved.
+func (s *URLService) DeleteURL(shortCode string, ownerID string) error {
+	if ownerID == "" {
+		return ErrUnauthorized
+	}
+
+	access, err := accessFor(s.teams, ownerID, models.RoleAdmin)
+	if err != nil {
+		return err
+	}
+
+	deleted, err := s.urls.DeleteURL(shortCode, time.Now(), access)
+	if err != nil {
+		return fmt.Errorf("failed to delete URL: %v", err)
+	}
+
+	return s.checkOwnedWrite(deleted, shortCode)
+}
+
+// checkUpdatedWindow checks the activation window a link would have after
+// req. A missing link is left for the access-checked update to report.
+func (s *URLService) checkUpdatedWindow(shortCode string, req models.UpdateURLRequest) error {
+	current, err := s.getURL(shortCode)
+	if err != nil {
+		if errors.Is(err, ErrURLNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	activatesAt, expiresAt := current.ActivatesAt, current.ExpiresAt
+	if req.ClearActivatesAt {
+		activatesAt = nil
+	} else if req.ActivatesAt != nil {
+		activatesAt = req.ActivatesAt
+	}
+	if req.ClearExpiresAt {
+		expiresAt = nil
+	} else if req.ExpiresAt != nil {
+		expiresAt = req.ExpiresAt
+	}
+
+	if !validActiveWindow(activatesAt, expiresAt) {
+		return ErrInvalidActiveWindow
+	}
+	return nil
+}
+
+// validActiveWindow reports whether a link active from activatesAt until
+// expiresAt is ever active. Either end may be open.
+func validActiveWindow(activatesAt, expiresAt *time.Time) bool {
+	return activatesAt == nil || expiresAt == nil || activatesAt.Before(*expiresAt)
+}
+
+// validHTTPURL reports whether u is an http or https URL visitors can be
+// redirected to.
+func validHTTPURL(u string) bool {
+	return strings.HasPrefix(u, "http://") || strings.HasPrefix(u, "https://")
+}
+
+// checkOwnedWrite turns an access-scoped write that matched no rows into
+// ErrURLNotFound or ErrForbidden, depending on whether the link exists.
+func (s *URLService) checkOwnedWrite(written bool, shortCode string) error {
+	if written {
+		return nil
+	}
+
+	if _, err := s.getURL(shortCode); err != nil {
+		return err
+	}
+
+	return ErrForbidden
+}
+
+// getURL loads a non-deleted short link regardless of expiry.
+func (s *URLService) getURL(shortCode string) (*models.URL, error) {
+	url, err := s.urls.GetURL(shortCode, false)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			return nil, ErrURLNotFound
+		}
+		return nil, err
 	}
 
 	return url, nil
 }
 
 func (s *URLService) IncrementClickCount(shortCode string) error {
-	query := `UPDATE urls SET click_count = click_count + 1 WHERE short_code = ?`
-	_, err := s.db.Exec(query, shortCode)
-	return err
+	return s.urls.IncrementClickCount(shortCode)
 }
 
-func (s *URLService) GetUserURLs(userIP string) ([]models.URL, error) {
-	query := `
-		SELECT id, short_code, original_url, created_at, expires_at, click_count, user_ip, is_custom
-		FROM urls 
-		WHERE user_ip = ?
-		ORDER BY created_at DESC
-	`
+// GetUserURLs lists the non-deleted links ownerID can view: their personal
+// links and those of their teams, newest first.
+func (s *URLService) GetUserURLs(ownerID string) ([]models.URL, error) {
+	if ownerID == "" {
+		return nil, ErrUnauthorized
+	}
 
-	rows, err := s.db.Query(query, userIP)
+	access, err := accessFor(s.teams, ownerID, models.RoleViewer)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
 
-	var urls []models.URL
-	for rows.Next() {
-		var url models.URL
-		err := rows.Scan(
-			&url.ID, &url.ShortCode, &url.OriginalURL, &url.CreatedAt,
-			&url.ExpiresAt, &url.ClickCount, &url.UserIP, &url.IsCustom,
-		)
-		if err != nil {
-			return nil, err
-		}
-		urls = append(urls, url)
+	return s.urls.ListURLs(access)
+}
+
+// defaultRedirectType is the configured redirect status, or 302 Found.
+func (s *URLService) defaultRedirectType() int {
+	if s.config.RedirectType != 0 {
+		return s.config.RedirectType
 	}
+	return http.StatusFound
+}
 
-	return urls, nil
+// validRedirectType reports whether code is a redirect status a link may use.
+func validRedirectType(code int) bool {
+	switch code {
+	case http.StatusMovedPermanently, http.StatusFound,
+		http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return true
+	}
+	return false
 }
 
 func (s *URLService) generateUniqueShortCode() (string, error) {
-	for i := 0; i < maxRetries; i++ {
-		code := generateRandomCode(shortCodeLength)
+	for i := 0; i < s.config.MaxRetries; i++ {
+		code := generateRandomCode(s.config.ShortCodeLength)
 
 		// Check if code already exists
 		exists, err := s.shortCodeExists(code)
@@ -148,17 +459,11 @@ func (s *URLService) generateUniqueShortCode() (string, error) {
 		}
 	}
 
-	return "", fmt.Errorf("failed to generate unique short code after %d retries", maxRetries)
+	return "", fmt.Errorf("failed to generate unique short code after %d retries", s.config.MaxRetries)
 }
 
 func (s *URLService) shortCodeExists(shortCode string) (bool, error) {
-	query := `SELECT COUNT(*) FROM urls WHERE short_code = ?`
-	var count int
-	err := s.db.QueryRow(query, shortCode).Scan(&count)
-	if err != nil {
-		return false, err
-	}
-	return count > 0, nil
+	return s.urls.ShortCodeExists(shortCode)
 }
 
 func (s *URLService) validateCustomCode(code string) error {
@@ -0,0 +1,139 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"url-shortener/internal/database"
+	"url-shortener/internal/models"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	sessionTokenLength = 32
+	sessionTTL         = 7 * 24 * time.Hour
+	minPasswordLength  = 8
+)
+
+var (
+	ErrInvalidCredentials = errors.New("invalid username or password")
+	ErrInvalidSession     = errors.New("session is invalid or has expired")
+	ErrUserNotFound       = errors.New("user not found")
+)
+
+type UserService struct {
+	db *database.DB
+}
+
+func NewUserService(db *database.DB) *UserService {
+	return &UserService{db: db}
+}
+
+// CreateUser registers a user with a bcrypt-hashed password.
+func (s *UserService) CreateUser(username, password string) (*models.User, error) {
+	username = strings.TrimSpace(username)
+	if username == "" {
+		return nil, fmt.Errorf("username is required")
+	}
+	if len(password) < minPasswordLength {
+		return nil, fmt.Errorf("password must be at least %d characters", minPasswordLength)
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash password: %v", err)
+	}
+
+	user := &models.User{
+		Username:     username,
+		PasswordHash: string(hash),
+		CreatedAt:    time.Now(),
+	}
+
+	query := `INSERT INTO users (username, password_hash, created_at) VALUES (?, ?, ?)`
+	id, err := s.db.InsertID(query, user.Username, user.PasswordHash, user.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save user: %v", err)
+	}
+	user.ID = int(id)
+
+	return user, nil
+}
+
+// Login checks the password and starts a session. The returned token goes in
+// the session cookie; only its hash is stored.
+func (s *UserService) Login(username, password string) (string, *models.User, error) {
+	user, err := s.GetUserByUsername(username)
+	if err != nil {
+		if errors.Is(err, ErrUserNotFound) {
+			return "", nil, ErrInvalidCredentials
+		}
+		return "", nil, err
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)); err != nil {
+		return "", nil, ErrInvalidCredentials
+	}
+
+	token := generateRandomCode(sessionTokenLength)
+	now := time.Now()
+
+	query := `INSERT INTO sessions (token_hash, user_id, created_at, expires_at) VALUES (?, ?, ?, ?)`
+	if _, err := s.db.Exec(query, hashToken(token), user.ID, now, now.Add(sessionTTL)); err != nil {
+		return "", nil, fmt.Errorf("failed to create session: %v", err)
+	}
+
+	return token, user, nil
+}
+
+// SessionUser resolves a session token to its user.
+func (s *UserService) SessionUser(token string) (*models.User, error) {
+	query := `
+		SELECT u.id, u.username, u.password_hash, u.created_at
+		FROM sessions s
+		JOIN users u ON u.id = s.user_id
+		WHERE s.token_hash = ? AND s.expires_at > ?
+	`
+
+	user := &models.User{}
+	err := s.db.QueryRow(query, hashToken(token), time.Now()).Scan(
+		&user.ID, &user.Username, &user.PasswordHash, &user.CreatedAt,
+	)
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrInvalidSession
+		}
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// Logout ends the session identified by token.
+func (s *UserService) Logout(token string) error {
+	_, err := s.db.Exec(`DELETE FROM sessions WHERE token_hash = ?`, hashToken(token))
+	return err
+}
+
+func (s *UserService) GetUserByUsername(username string) (*models.User, error) {
+	query := `SELECT id, username, password_hash, created_at FROM users WHERE username = ?`
+
+	user := &models.User{}
+	err := s.db.QueryRow(query, username).Scan(
+		&user.ID, &user.Username, &user.PasswordHash, &user.CreatedAt,
+	)
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrUserNotFound
+		}
+		return nil, err
+	}
+
+	return user, nil
+}
@@ -0,0 +1,118 @@
+package services
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+
+	"url-shortener/internal/models"
+)
+
+const (
+	maxVariants       = 10
+	maxVariantNameLen = 50
+
+	// VariantCookieTTL is how long a visitor keeps their A/B variant.
+	VariantCookieTTL = 90 * 24 * time.Hour
+)
+
+// VariantCookieName returns the name of the cookie that remembers the
+// variant a visitor of shortCode was assigned.
+func (s *URLService) VariantCookieName(shortCode string) string {
+	return "variant_" + shortCode
+}
+
+// pickVariant returns the variant named current if it is still active, so
+// returning visitors keep theirs, or else draws one by weight. It returns
+// false when no variant is active.
+func pickVariant(variants []models.Variant, current string) (models.Variant, bool) {
+	total := 0
+	for _, v := range variants {
+		if v.Weight > 0 && v.Name == current {
+			return v, true
+		}
+		total += v.Weight
+	}
+	if total <= 0 {
+		return models.Variant{}, false
+	}
+
+	n := rand.Intn(total)
+	for _, v := range variants {
+		if n < v.Weight {
+			return v, true
+		}
+		n -= v.Weight
+	}
+	return models.Variant{}, false
+}
+
+// normalizeVariants checks variants and names unnamed ones "a", "b" and so
+// on by position. When no weights are given the split is even.
+func normalizeVariants(variants []models.Variant) ([]models.Variant, error) {
+	switch {
+	case len(variants) == 0:
+		return nil, nil
+	case len(variants) == 1:
+		return nil, fmt.Errorf("%w: at least two variants are needed", ErrInvalidVariants)
+	case len(variants) > maxVariants:
+		return nil, fmt.Errorf("%w: at most %d variants are allowed", ErrInvalidVariants, maxVariants)
+	}
+
+	normalized := make([]models.Variant, 0, len(variants))
+	names := make(map[string]bool)
+	total := 0
+	for i, v := range variants {
+		v.Name = strings.TrimSpace(v.Name)
+		if v.Name == "" {
+			v.Name = string(rune('a' + i))
+		}
+		switch {
+		case len(v.Name) > maxVariantNameLen || !validToken(v.Name):
+			return nil, fmt.Errorf("%w: variant %d needs a name of at most %d letters, digits, '-' or '_'", ErrInvalidVariants, i+1, maxVariantNameLen)
+		case names[v.Name]:
+			return nil, fmt.Errorf("%w: more than one variant is named %q", ErrInvalidVariants, v.Name)
+		case !validHTTPURL(v.URL):
+			return nil, fmt.Errorf("%w: variant %q needs a url starting with http:// or https://", ErrInvalidVariants, v.Name)
+		case v.Weight < 0:
+			return nil, fmt.Errorf("%w: variant %q has a negative weight", ErrInvalidVariants, v.Name)
+		}
+		names[v.Name] = true
+		total += v.Weight
+		normalized = append(normalized, v)
+	}
+
+	if total == 0 {
+		for i := range normalized {
+			normalized[i].Weight = 1
+		}
+	}
+
+	return normalized, nil
+}
+
+// validToken reports whether s only uses letters, digits, '-' and '_', as
+// variant names must to be stored in a cookie.
+func validToken(s string) bool {
+	for _, c := range s {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// applyWinner turns req.Winner into an update that makes the winning
+// variant's URL the original URL of current and removes the variants.
+func applyWinner(current *models.URL, req *models.UpdateURLRequest) error {
+	for _, v := range current.Variants {
+		if v.Name == req.Winner {
+			none := []models.Variant{}
+			req.OriginalURL = &v.URL
+			req.Variants = &none
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: the link has no variant named %q", ErrInvalidVariants, req.Winner)
+}
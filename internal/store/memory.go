@@ -0,0 +1,633 @@
+package store
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"url-shortener/internal/models"
+)
+
+// MemoryStore implements URLStore and ClickStore in process memory with the
+// same semantics as SQLStore. Data is lost when the process exits, which
+// suits tests and throwaway demo instances.
+type MemoryStore struct {
+	mu          sync.RWMutex
+	urls        map[string]*models.URL
+	clicks      []models.Click
+	unlocks     []models.UnlockAttempt
+	conversions []models.Conversion
+	nextURLID   int
+	nextClickID int
+}
+
+var _ Store = (*MemoryStore)(nil)
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{urls: make(map[string]*models.URL)}
+}
+
+func (s *MemoryStore) CreateURL(url *models.URL) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.urls[url.ShortCode]; exists {
+		return ErrDuplicateShortCode
+	}
+
+	s.nextURLID++
+	url.ID = s.nextURLID
+	s.urls[url.ShortCode] = copyURL(url)
+
+	return nil
+}
+
+func (s *MemoryStore) GetURL(shortCode string, includeDeleted bool) (*models.URL, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	url, ok := s.urls[shortCode]
+	if !ok || (url.DeletedAt != nil && !includeDeleted) {
+		return nil, ErrNotFound
+	}
+
+	return copyURL(url), nil
+}
+
+func (s *MemoryStore) ShortCodeExists(shortCode string) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	_, ok := s.urls[shortCode]
+	return ok, nil
+}
+
+func (s *MemoryStore) UpdateURL(shortCode string, req models.UpdateURLRequest, access Access) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	url, ok := s.urls[shortCode]
+	if !ok || url.DeletedAt != nil || !access.allows(url) {
+		return false, nil
+	}
+
+	applyUpdate(url, req)
+	return true, nil
+}
+
+func (s *MemoryStore) UpdateURLWith(shortCode string, access Access, prepare func(current *models.URL) (models.UpdateURLRequest, error)) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	url, ok := s.urls[shortCode]
+	if !ok || url.DeletedAt != nil || !access.allows(url) {
+		return false, nil
+	}
+
+	req, err := prepare(copyURL(url))
+	if err != nil {
+		return false, err
+	}
+	applyUpdate(url, req)
+	return true, nil
+}
+
+// applyUpdate writes the fields req sets to url. Callers hold s.mu.
+func applyUpdate(url *models.URL, req models.UpdateURLRequest) {
+	if req.OriginalURL != nil {
+		url.OriginalURL = *req.OriginalURL
+	}
+	if req.ClearActivatesAt {
+		url.ActivatesAt = nil
+	} else if req.ActivatesAt != nil {
+		activatesAt := *req.ActivatesAt
+		url.ActivatesAt = &activatesAt
+	}
+	if req.ClearExpiresAt {
+		url.ExpiresAt = nil
+	} else if req.ExpiresAt != nil {
+		expiresAt := *req.ExpiresAt
+		url.ExpiresAt = &expiresAt
+	}
+	if req.IsCustom != nil {
+		url.IsCustom = *req.IsCustom
+	}
+	if req.RedirectType != nil {
+		url.RedirectType = *req.RedirectType
+	}
+	if req.ClearMaxClicks {
+		url.MaxClicks = nil
+	} else if req.MaxClicks != nil {
+		maxClicks := *req.MaxClicks
+		url.MaxClicks = &maxClicks
+	}
+	if req.FallbackURL != nil {
+		url.FallbackURL = *req.FallbackURL
+	}
+	if req.TargetingRules != nil {
+		url.TargetingRules = copyRules(*req.TargetingRules)
+	}
+	if req.Variants != nil {
+		url.Variants = append([]models.Variant(nil), *req.Variants...)
+	}
+	if req.ClickIDParam != nil {
+		url.ClickIDParam = *req.ClickIDParam
+	}
+	if req.ForwardQuery != nil {
+		url.ForwardQuery = *req.ForwardQuery
+	}
+}
+
+func (s *MemoryStore) DeleteURL(shortCode string, deletedAt time.Time, access Access) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	url, ok := s.urls[shortCode]
+	if !ok || url.DeletedAt != nil || !access.allows(url) {
+		return false, nil
+	}
+
+	url.DeletedAt = &deletedAt
+	return true, nil
+}
+
+func (s *MemoryStore) CanAccess(shortCode string, access Access) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	url, ok := s.urls[shortCode]
+	return ok && access.allows(url), nil
+}
+
+func (s *MemoryStore) ListURLs(access Access) ([]models.URL, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var urls []models.URL
+	for _, url := range s.urls {
+		if url.DeletedAt == nil && access.allows(url) {
+			urls = append(urls, *copyURL(url))
+		}
+	}
+
+	sort.Slice(urls, func(i, j int) bool {
+		return urls[i].CreatedAt.After(urls[j].CreatedAt)
+	})
+
+	return urls, nil
+}
+
+func (s *MemoryStore) IncrementClickCount(shortCode string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if url, ok := s.urls[shortCode]; ok {
+		url.ClickCount++
+	}
+	return nil
+}
+
+func (s *MemoryStore) ClaimClick(shortCode string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	url, ok := s.urls[shortCode]
+	if !ok || (url.MaxClicks != nil && url.ClickCount >= *url.MaxClicks) {
+		return false, nil
+	}
+
+	url.ClickCount++
+	return true, nil
+}
+
+func (s *MemoryStore) RecordClick(click models.Click) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.nextClickID++
+	click.ID = s.nextClickID
+	s.clicks = append(s.clicks, click)
+
+	return nil
+}
+
+func (s *MemoryStore) RecordClicks(clicks []models.Click) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, click := range clicks {
+		s.nextClickID++
+		click.ID = s.nextClickID
+		s.clicks = append(s.clicks, click)
+
+		if url, ok := s.urls[click.URLShortCode]; ok && !click.Counted && !click.Bot {
+			url.ClickCount++
+		}
+	}
+
+	return nil
+}
+
+func (s *MemoryStore) CountClicks(shortCode string, filter ClickFilter) (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return len(s.clicksFor(shortCode, filter)), nil
+}
+
+func (s *MemoryStore) CountUniqueVisitors(shortCode string, filter ClickFilter) (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	seen := make(map[string]bool)
+	for _, click := range s.clicksFor(shortCode, filter) {
+		seen[click.IPAddress] = true
+	}
+
+	return len(seen), nil
+}
+
+func (s *MemoryStore) ClicksByCountry(shortCode string, filter ClickFilter, limit int) (map[string]int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	counts := make(map[string]int)
+	for _, click := range s.clicksFor(shortCode, filter) {
+		if click.Country != "" {
+			counts[click.Country]++
+		}
+	}
+
+	return topCounts(counts, limit), nil
+}
+
+func (s *MemoryStore) ClicksBySlot(shortCode string, filter ClickFilter, slot time.Duration) (map[int64]int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	counts := make(map[int64]int)
+	for _, click := range s.clicksFor(shortCode, filter) {
+		counts[slotStart(click.ClickedAt, slot)]++
+	}
+
+	return counts, nil
+}
+
+func (s *MemoryStore) RecentClicks(shortCode string, filter ClickFilter, limit int) ([]models.Click, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	clicks := s.clicksFor(shortCode, filter)
+	sort.SliceStable(clicks, func(i, j int) bool {
+		return clicks[i].ClickedAt.After(clicks[j].ClickedAt)
+	})
+	if len(clicks) > limit {
+		clicks = clicks[:limit]
+	}
+
+	for i := range clicks {
+		if clicks[i].Country == "" {
+			clicks[i].Country = "Unknown"
+		}
+		if clicks[i].Region == "" {
+			clicks[i].Region = "Unknown"
+		}
+		if clicks[i].City == "" {
+			clicks[i].City = "Unknown"
+		}
+	}
+
+	return clicks, nil
+}
+
+func (s *MemoryStore) ClicksByRule(shortCode string, filter ClickFilter) (map[string]int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	counts := make(map[string]int)
+	for _, click := range s.clicksFor(shortCode, filter) {
+		counts[click.TargetRule]++
+	}
+
+	return counts, nil
+}
+
+func (s *MemoryStore) ClicksByVariant(shortCode string, filter ClickFilter) ([]models.VariantStats, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	stats := make(map[string]*models.VariantStats)
+	visitors := make(map[string]map[string]bool)
+	var names []string
+	for _, click := range s.clicksFor(shortCode, filter) {
+		if click.Variant == "" {
+			continue
+		}
+		if stats[click.Variant] == nil {
+			stats[click.Variant] = &models.VariantStats{Name: click.Variant}
+			visitors[click.Variant] = make(map[string]bool)
+			names = append(names, click.Variant)
+		}
+		stats[click.Variant].Clicks++
+		visitors[click.Variant][click.IPAddress] = true
+	}
+	sort.Strings(names)
+
+	var result []models.VariantStats
+	for _, name := range names {
+		stats[name].UniqueVisitors = len(visitors[name])
+		result = append(result, *stats[name])
+	}
+
+	return result, nil
+}
+
+func (s *MemoryStore) ClicksByCampaign(shortCode string, filter ClickFilter) (map[string]int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	counts := make(map[string]int)
+	for _, click := range s.clicksFor(shortCode, filter) {
+		if click.Campaign != "" {
+			counts[click.Campaign]++
+		}
+	}
+
+	return counts, nil
+}
+
+func (s *MemoryStore) ClicksByAgent(shortCode string, filter ClickFilter) (map[string]int, map[string]int, map[string]int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	browsers, oses, devices := make(map[string]int), make(map[string]int), make(map[string]int)
+	for _, click := range s.clicksFor(shortCode, filter) {
+		if click.Browser == "" {
+			continue
+		}
+		browsers[click.Browser]++
+		oses[click.OS]++
+		devices[click.Device]++
+	}
+
+	return browsers, oses, devices, nil
+}
+
+func (s *MemoryStore) ClicksByReferrer(shortCode string, filter ClickFilter, limit int) (map[string]int, map[string]int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	hosts, categories := make(map[string]int), make(map[string]int)
+	for _, click := range s.clicksFor(shortCode, filter) {
+		if click.ReferrerCategory == "" {
+			continue
+		}
+		if click.ReferrerHost != "" {
+			hosts[click.ReferrerHost]++
+		}
+		categories[click.ReferrerCategory]++
+	}
+
+	return topCounts(hosts, limit), categories, nil
+}
+
+func (s *MemoryStore) CampaignStats(access Access, filter ClickFilter) ([]models.CampaignStats, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	stats := make(map[string]*models.CampaignStats)
+	links := make(map[string]map[string]bool)
+	visitors := make(map[string]map[string]bool)
+	for _, click := range s.clicks {
+		url, ok := s.urls[click.URLShortCode]
+		if click.Campaign == "" || !ok || !access.allows(url) || !filter.allows(click) {
+			continue
+		}
+		if stats[click.Campaign] == nil {
+			stats[click.Campaign] = &models.CampaignStats{Campaign: click.Campaign}
+			links[click.Campaign] = make(map[string]bool)
+			visitors[click.Campaign] = make(map[string]bool)
+		}
+		stats[click.Campaign].Clicks++
+		links[click.Campaign][click.URLShortCode] = true
+		visitors[click.Campaign][click.IPAddress] = true
+	}
+
+	var result []models.CampaignStats
+	for campaign, c := range stats {
+		c.Links = len(links[campaign])
+		c.UniqueVisitors = len(visitors[campaign])
+		result = append(result, *c)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Clicks != result[j].Clicks {
+			return result[i].Clicks > result[j].Clicks
+		}
+		return result[i].Campaign < result[j].Campaign
+	})
+
+	return result, nil
+}
+
+func (s *MemoryStore) ClickExists(shortCode, clickID string) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, click := range s.clicks {
+		if click.URLShortCode == shortCode && click.ClickID == clickID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (s *MemoryStore) RecordConversion(conversion models.Conversion) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, c := range s.conversions {
+		if c.ClickID == conversion.ClickID {
+			return ErrDuplicateConversion
+		}
+	}
+
+	conversion.ID = len(s.conversions) + 1
+	s.conversions = append(s.conversions, conversion)
+
+	return nil
+}
+
+func (s *MemoryStore) CountConversions(shortCode string, within TimeRange) (int, map[string]float64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	total := 0
+	revenue := make(map[string]float64)
+	for _, c := range s.conversions {
+		if c.URLShortCode != shortCode || !within.contains(c.ConvertedAt) {
+			continue
+		}
+		total++
+		if c.Currency != "" {
+			revenue[c.Currency] += c.Value
+		}
+	}
+
+	return total, revenue, nil
+}
+
+func (s *MemoryStore) ConversionsBySlot(shortCode string, within TimeRange, slot time.Duration) (map[int64]models.ConversionBucket, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	result := make(map[int64]models.ConversionBucket)
+	for _, c := range s.conversions {
+		if c.URLShortCode != shortCode || !within.contains(c.ConvertedAt) {
+			continue
+		}
+		start := slotStart(c.ConvertedAt, slot)
+		bucket, ok := result[start]
+		if !ok {
+			bucket.Revenue = make(map[string]float64)
+		}
+		bucket.Conversions++
+		if c.Currency != "" {
+			bucket.Revenue[c.Currency] += c.Value
+		}
+		result[start] = bucket
+	}
+
+	return result, nil
+}
+
+func (s *MemoryStore) RecordUnlockAttempt(attempt models.UnlockAttempt) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	attempt.ID = len(s.unlocks) + 1
+	s.unlocks = append(s.unlocks, attempt)
+
+	return nil
+}
+
+func (s *MemoryStore) CountUnlockAttempts(shortCode string) (int, int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var total, failed int
+	for _, attempt := range s.unlocks {
+		if attempt.URLShortCode != shortCode {
+			continue
+		}
+		total++
+		if !attempt.Success {
+			failed++
+		}
+	}
+
+	return total, failed, nil
+}
+
+// clicksFor returns a copy of the clicks of a link that filter lets
+// through. Callers hold s.mu.
+func (s *MemoryStore) clicksFor(shortCode string, filter ClickFilter) []models.Click {
+	var clicks []models.Click
+	for _, click := range s.clicks {
+		if click.URLShortCode == shortCode && filter.allows(click) {
+			clicks = append(clicks, click)
+		}
+	}
+	return clicks
+}
+
+// allows mirrors filterClause for a single click.
+func (f ClickFilter) allows(click models.Click) bool {
+	return (f.IncludeBots || !click.Bot) && f.contains(click.ClickedAt)
+}
+
+// contains mirrors rangeClause for a single time.
+func (r TimeRange) contains(t time.Time) bool {
+	return (r.From.IsZero() || !t.Before(r.From)) && (r.To.IsZero() || t.Before(r.To))
+}
+
+// slotStart returns the Unix time of the start of the slot t falls in.
+func slotStart(t time.Time, slot time.Duration) int64 {
+	seconds := int64(slot / time.Second)
+	return t.Unix() / seconds * seconds
+}
+
+// allows mirrors accessClause for a single link.
+func (a Access) allows(url *models.URL) bool {
+	if url.TeamID == nil {
+		return url.OwnerID != "" && url.OwnerID == a.OwnerID
+	}
+	for _, id := range a.TeamIDs {
+		if id == *url.TeamID {
+			return true
+		}
+	}
+	return false
+}
+
+// topCounts keeps the limit largest entries of counts.
+func topCounts(counts map[string]int, limit int) map[string]int {
+	keys := make([]string, 0, len(counts))
+	for key := range counts {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if counts[keys[i]] != counts[keys[j]] {
+			return counts[keys[i]] > counts[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+
+	result := make(map[string]int)
+	for i, key := range keys {
+		if i == limit {
+			break
+		}
+		result[key] = counts[key]
+	}
+	return result
+}
+
+// copyURL returns a deep copy so callers cannot mutate stored links. Like
+// scanURL it derives PasswordProtected from the hash.
+func copyURL(url *models.URL) *models.URL {
+	c := *url
+	c.PasswordProtected = c.PasswordHash != ""
+	if url.ActivatesAt != nil {
+		t := *url.ActivatesAt
+		c.ActivatesAt = &t
+	}
+	if url.ExpiresAt != nil {
+		t := *url.ExpiresAt
+		c.ExpiresAt = &t
+	}
+	if url.TeamID != nil {
+		id := *url.TeamID
+		c.TeamID = &id
+	}
+	if url.DeletedAt != nil {
+		t := *url.DeletedAt
+		c.DeletedAt = &t
+	}
+	if url.MaxClicks != nil {
+		n := *url.MaxClicks
+		c.MaxClicks = &n
+	}
+	c.TargetingRules = copyRules(url.TargetingRules)
+	c.Variants = append([]models.Variant(nil), url.Variants...)
+	return &c
+}
+
+func copyRules(rules []models.TargetingRule) []models.TargetingRule {
+	var c []models.TargetingRule
+	for _, rule := range rules {
+		rule.Countries = append([]string(nil), rule.Countries...)
+		rule.Continents = append([]string(nil), rule.Continents...)
+		c = append(c, rule)
+	}
+	return c
+}
@@ -0,0 +1,381 @@
+package store
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"url-shortener/internal/database"
+	"url-shortener/internal/models"
+)
+
+// Clicks are also counted per UTC hour and day in click_rollups_hourly and
+// click_rollups_daily, by link, bot flag and country, and the visitors of
+// each day are kept in click_visitors_daily. The rollups are updated in the
+// same transaction as the clicks, so the analytics queries read whole hours
+// and days from them and only scan clicks at the partial hours at the ends
+// of a range.
+//
+// The other breakdowns are rolled up the same way in click_dimensions_hourly
+// and click_dimensions_daily, one row per dimension and value, and the
+// visitors of each variant in click_variant_visitors_daily.
+
+// Dimensions of the dimension rollups.
+const (
+	dimensionRule             = "rule"
+	dimensionVariant          = "variant"
+	dimensionCampaign         = "campaign"
+	dimensionBrowser          = "browser"
+	dimensionOS               = "os"
+	dimensionDevice           = "device"
+	dimensionReferrerHost     = "referrer_host"
+	dimensionReferrerCategory = "referrer_category"
+)
+
+// dimensionColumns gives the value of each dimension in clicks and the
+// condition for a click to count in it. clickDimensions must agree.
+var dimensionColumns = map[string]struct{ value, where string }{
+	dimensionRule:             {"COALESCE(target_rule, '')", "TRUE"},
+	dimensionVariant:          {"variant", "variant IS NOT NULL"},
+	dimensionCampaign:         {"utm_campaign", "utm_campaign IS NOT NULL"},
+	dimensionBrowser:          {"browser", "browser IS NOT NULL"},
+	dimensionOS:               {"COALESCE(os, '')", "browser IS NOT NULL"},
+	dimensionDevice:           {"COALESCE(device, '')", "browser IS NOT NULL"},
+	dimensionReferrerHost:     {"referrer_host", "referrer_category IS NOT NULL AND referrer_host IS NOT NULL"},
+	dimensionReferrerCategory: {"referrer_category", "referrer_category IS NOT NULL"},
+}
+
+// clickDimensions returns the values that click counts under, by
+// dimension. Empty strings are stored as NULL, so the conditions of
+// dimensionColumns become checks for empty fields.
+func clickDimensions(click models.Click) map[string]string {
+	dimensions := map[string]string{dimensionRule: click.TargetRule}
+	if click.Variant != "" {
+		dimensions[dimensionVariant] = click.Variant
+	}
+	if click.Campaign != "" {
+		dimensions[dimensionCampaign] = click.Campaign
+	}
+	if click.Browser != "" {
+		dimensions[dimensionBrowser] = click.Browser
+		dimensions[dimensionOS] = click.OS
+		dimensions[dimensionDevice] = click.Device
+	}
+	if click.ReferrerCategory != "" {
+		dimensions[dimensionReferrerCategory] = click.ReferrerCategory
+		if click.ReferrerHost != "" {
+			dimensions[dimensionReferrerHost] = click.ReferrerHost
+		}
+	}
+	return dimensions
+}
+
+type rollupKey struct {
+	shortCode string
+	bucket    int64
+	bot       bool
+	country   string
+}
+
+type visitorKey struct {
+	shortCode string
+	bucket    int64
+	bot       bool
+	ip        string
+}
+
+type dimensionKey struct {
+	shortCode string
+	bucket    int64
+	bot       bool
+	dimension string
+	value     string
+}
+
+type variantVisitorKey struct {
+	shortCode string
+	bucket    int64
+	bot       bool
+	variant   string
+	ip        string
+}
+
+// recordRollups adds clicks to the rollups within tx, with one statement
+// per row touched rather than per click.
+func recordRollups(tx *database.Tx, clicks []models.Click) error {
+	hourly := make(map[rollupKey]int)
+	daily := make(map[rollupKey]int)
+	visitors := make(map[visitorKey]bool)
+	hourlyDimensions := make(map[dimensionKey]int)
+	dailyDimensions := make(map[dimensionKey]int)
+	variantVisitors := make(map[variantVisitorKey]bool)
+	for _, click := range clicks {
+		at := click.ClickedAt.UTC()
+		hour := at.Truncate(time.Hour).Unix()
+		day := at.Truncate(24 * time.Hour).Unix()
+		hourly[rollupKey{click.URLShortCode, hour, click.Bot, click.Country}]++
+		daily[rollupKey{click.URLShortCode, day, click.Bot, click.Country}]++
+		visitors[visitorKey{click.URLShortCode, day, click.Bot, click.IPAddress}] = true
+		for dimension, value := range clickDimensions(click) {
+			hourlyDimensions[dimensionKey{click.URLShortCode, hour, click.Bot, dimension, value}]++
+			dailyDimensions[dimensionKey{click.URLShortCode, day, click.Bot, dimension, value}]++
+		}
+		if click.Variant != "" {
+			variantVisitors[variantVisitorKey{click.URLShortCode, day, click.Bot, click.Variant, click.IPAddress}] = true
+		}
+	}
+
+	for _, rollup := range []struct {
+		table  string
+		counts map[rollupKey]int
+	}{{"click_rollups_hourly", hourly}, {"click_rollups_daily", daily}} {
+		query := `
+			INSERT INTO ` + rollup.table + ` (url_short_code, bucket, is_bot, country, clicks)
+			VALUES (?, ?, ?, ?, ?)
+			ON CONFLICT (url_short_code, bucket, is_bot, country) DO UPDATE SET clicks = ` + rollup.table + `.clicks + excluded.clicks
+		`
+		for key, n := range rollup.counts {
+			if _, err := tx.Exec(query, key.shortCode, key.bucket, key.bot, key.country, n); err != nil {
+				return err
+			}
+		}
+	}
+
+	for key := range visitors {
+		query := `
+			INSERT INTO click_visitors_daily (url_short_code, bucket, is_bot, ip_address)
+			VALUES (?, ?, ?, ?)
+			ON CONFLICT DO NOTHING
+		`
+		if _, err := tx.Exec(query, key.shortCode, key.bucket, key.bot, key.ip); err != nil {
+			return err
+		}
+	}
+
+	for _, rollup := range []struct {
+		table  string
+		counts map[dimensionKey]int
+	}{{"click_dimensions_hourly", hourlyDimensions}, {"click_dimensions_daily", dailyDimensions}} {
+		query := `
+			INSERT INTO ` + rollup.table + ` (url_short_code, bucket, is_bot, dimension, value, clicks)
+			VALUES (?, ?, ?, ?, ?, ?)
+			ON CONFLICT (url_short_code, bucket, is_bot, dimension, value) DO UPDATE SET clicks = ` + rollup.table + `.clicks + excluded.clicks
+		`
+		for key, n := range rollup.counts {
+			if _, err := tx.Exec(query, key.shortCode, key.bucket, key.bot, key.dimension, key.value, n); err != nil {
+				return err
+			}
+		}
+	}
+
+	for key := range variantVisitors {
+		query := `
+			INSERT INTO click_variant_visitors_daily (url_short_code, bucket, is_bot, variant, ip_address)
+			VALUES (?, ?, ?, ?, ?)
+			ON CONFLICT DO NOTHING
+		`
+		if _, err := tx.Exec(query, key.shortCode, key.bucket, key.bot, key.variant, key.ip); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rollupPlan splits a time range into the spans read from each rollup
+// table and from clicks.
+type rollupPlan struct {
+	daily  []TimeRange
+	hourly []TimeRange
+	raw    []TimeRange
+}
+
+// planRollups splits within into whole UTC days, if daily is set, then
+// whole UTC hours, if hourly is set, leaving the rest to raw clicks.
+func planRollups(within TimeRange, daily, hourly bool) rollupPlan {
+	var plan rollupPlan
+	rest := []TimeRange{within}
+	if daily {
+		plan.daily, rest = splitRange(within, 24*time.Hour)
+	}
+	if !hourly {
+		plan.raw = rest
+		return plan
+	}
+	for _, r := range rest {
+		inner, edges := splitRange(r, time.Hour)
+		plan.hourly = append(plan.hourly, inner...)
+		plan.raw = append(plan.raw, edges...)
+	}
+	return plan
+}
+
+// splitRange splits within into the span of whole units of UTC time it
+// covers, if any, and the partial units at either end. Open ends stay open.
+func splitRange(within TimeRange, unit time.Duration) (inner, edges []TimeRange) {
+	from, to := within.From, within.To
+	if !from.IsZero() {
+		from = from.UTC().Truncate(unit)
+		if from.Before(within.From) {
+			from = from.Add(unit)
+		}
+	}
+	if !to.IsZero() {
+		to = to.UTC().Truncate(unit)
+	}
+	if !from.IsZero() && !to.IsZero() && !from.Before(to) {
+		return nil, []TimeRange{within}
+	}
+
+	if !within.From.IsZero() && !from.Equal(within.From) {
+		edges = append(edges, TimeRange{From: within.From, To: from})
+	}
+	if !within.To.IsZero() && !to.Equal(within.To) {
+		edges = append(edges, TimeRange{From: to, To: within.To})
+	}
+	return []TimeRange{{From: from, To: to}}, edges
+}
+
+// clickRollups returns a subquery of the clicks of shortCode that filter
+// lets through, as rows of the Unix time, country and count of clicks,
+// read following plan.
+func (s *SQLStore) clickRollups(shortCode string, filter ClickFilter, plan rollupPlan) (string, []interface{}) {
+	var parts []string
+	var args []interface{}
+	for _, r := range plan.raw {
+		clause, rangeArgs := filterClause(ClickFilter{IncludeBots: filter.IncludeBots, TimeRange: r}, shortCode)
+		parts = append(parts, `SELECT `+fmt.Sprintf(s.dialect.unixTime, "clicked_at")+` AS at, COALESCE(country, 'Unknown') AS country, 1 AS clicks FROM clicks WHERE url_short_code = ?`+clause)
+		args = append(args, rangeArgs...)
+	}
+	for _, rollup := range []struct {
+		table string
+		spans []TimeRange
+	}{{"click_rollups_daily", plan.daily}, {"click_rollups_hourly", plan.hourly}} {
+		for _, r := range rollup.spans {
+			clause, rangeArgs := bucketClause(filter.IncludeBots, r, shortCode)
+			parts = append(parts, `SELECT bucket AS at, country, clicks FROM `+rollup.table+` WHERE url_short_code = ?`+clause)
+			args = append(args, rangeArgs...)
+		}
+	}
+	return `(` + strings.Join(parts, ` UNION ALL `) + `) AS rolled_up`, args
+}
+
+// visitorRollups is clickRollups for the IP addresses of the visitors,
+// read from click_visitors_daily for whole days.
+func (s *SQLStore) visitorRollups(shortCode string, filter ClickFilter) (string, []interface{}) {
+	plan := planRollups(filter.TimeRange, true, false)
+	var parts []string
+	var args []interface{}
+	for _, r := range plan.raw {
+		clause, rangeArgs := filterClause(ClickFilter{IncludeBots: filter.IncludeBots, TimeRange: r}, shortCode)
+		parts = append(parts, `SELECT ip_address FROM clicks WHERE url_short_code = ?`+clause)
+		args = append(args, rangeArgs...)
+	}
+	for _, r := range plan.daily {
+		clause, rangeArgs := bucketClause(filter.IncludeBots, r, shortCode)
+		parts = append(parts, `SELECT ip_address FROM click_visitors_daily WHERE url_short_code = ?`+clause)
+		args = append(args, rangeArgs...)
+	}
+	return `(` + strings.Join(parts, ` UNION ALL `) + `) AS visitors`, args
+}
+
+// dimensionRollups returns a subquery of the clicks of shortCode that
+// filter lets through, as rows of a dimension among dimensions, its value
+// and the count of clicks.
+func (s *SQLStore) dimensionRollups(shortCode string, filter ClickFilter, dimensions ...string) (string, []interface{}) {
+	plan := planRollups(filter.TimeRange, true, true)
+	var parts []string
+	var args []interface{}
+	for _, r := range plan.raw {
+		for _, dimension := range dimensions {
+			column := dimensionColumns[dimension]
+			clause, rangeArgs := filterClause(ClickFilter{IncludeBots: filter.IncludeBots, TimeRange: r}, shortCode)
+			parts = append(parts, `SELECT '`+dimension+`' AS dimension, `+column.value+` AS value, COUNT(*) AS clicks FROM clicks WHERE url_short_code = ? AND `+column.where+clause+` GROUP BY `+column.value)
+			args = append(args, rangeArgs...)
+		}
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(dimensions)), ", ")
+	for _, rollup := range []struct {
+		table string
+		spans []TimeRange
+	}{{"click_dimensions_daily", plan.daily}, {"click_dimensions_hourly", plan.hourly}} {
+		for _, r := range rollup.spans {
+			keys := []interface{}{shortCode}
+			for _, dimension := range dimensions {
+				keys = append(keys, dimension)
+			}
+			clause, rangeArgs := bucketClause(filter.IncludeBots, r, keys...)
+			parts = append(parts, `SELECT dimension, value, clicks FROM `+rollup.table+` WHERE url_short_code = ? AND dimension IN (`+placeholders+`)`+clause)
+			args = append(args, rangeArgs...)
+		}
+	}
+	return `(` + strings.Join(parts, ` UNION ALL `) + `) AS rolled_up`, args
+}
+
+// dimensionCounts counts the clicks of shortCode that filter lets through
+// by the values of each of dimensions.
+func (s *SQLStore) dimensionCounts(shortCode string, filter ClickFilter, dimensions ...string) (map[string]map[string]int, error) {
+	from, args := s.dimensionRollups(shortCode, filter, dimensions...)
+	query := `
+		SELECT dimension, value, SUM(clicks) as count
+		FROM ` + from + `
+		GROUP BY dimension, value
+	`
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make(map[string]map[string]int)
+	for _, dimension := range dimensions {
+		result[dimension] = make(map[string]int)
+	}
+	for rows.Next() {
+		var dimension, value string
+		var count int
+		if err := rows.Scan(&dimension, &value, &count); err != nil {
+			return nil, err
+		}
+		result[dimension][value] = count
+	}
+
+	return result, rows.Err()
+}
+
+// variantVisitorRollups is visitorRollups for the variants, as rows of the
+// variant and the IP address of a visitor.
+func (s *SQLStore) variantVisitorRollups(shortCode string, filter ClickFilter) (string, []interface{}) {
+	plan := planRollups(filter.TimeRange, true, false)
+	var parts []string
+	var args []interface{}
+	for _, r := range plan.raw {
+		clause, rangeArgs := filterClause(ClickFilter{IncludeBots: filter.IncludeBots, TimeRange: r}, shortCode)
+		parts = append(parts, `SELECT variant, ip_address FROM clicks WHERE url_short_code = ? AND variant IS NOT NULL`+clause)
+		args = append(args, rangeArgs...)
+	}
+	for _, r := range plan.daily {
+		clause, rangeArgs := bucketClause(filter.IncludeBots, r, shortCode)
+		parts = append(parts, `SELECT variant, ip_address FROM click_variant_visitors_daily WHERE url_short_code = ?`+clause)
+		args = append(args, rangeArgs...)
+	}
+	return `(` + strings.Join(parts, ` UNION ALL `) + `) AS visitors`, args
+}
+
+// bucketClause is filterClause for the rollup tables, whose buckets are
+// Unix times.
+func bucketClause(includeBots bool, within TimeRange, args ...interface{}) (string, []interface{}) {
+	clause := ""
+	if !within.From.IsZero() {
+		clause += ` AND bucket >= ?`
+		args = append(args, within.From.Unix())
+	}
+	if !within.To.IsZero() {
+		clause += ` AND bucket < ?`
+		args = append(args, within.To.Unix())
+	}
+	if !includeBots {
+		clause += ` AND is_bot = FALSE`
+	}
+	return clause, args
+}
@@ -0,0 +1,776 @@
+package store
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"url-shortener/internal/database"
+	"url-shortener/internal/models"
+)
+
+// SQLStore implements URLStore and ClickStore on top of database.DB. The
+// SQLite and PostgreSQL variants share queries and differ only in dialect.
+type SQLStore struct {
+	db      *database.DB
+	dialect dialect
+}
+
+var _ Store = (*SQLStore)(nil)
+
+// execer is what database.DB and database.Tx have in common.
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+type dialect struct {
+	// unixTime converts the timestamp column in its %s to Unix seconds.
+	unixTime string
+	// lockRow is appended to a SELECT to lock the rows it reads until the
+	// transaction ends. SQLite locks the whole database on write instead.
+	lockRow string
+	// isUniqueViolation recognises the driver's unique constraint error.
+	isUniqueViolation func(err error) bool
+}
+
+var sqliteDialect = dialect{
+	// The driver stores times as text like "2006-01-02 15:04:05.999 +0000
+	// UTC", whose offset SQLite cannot read; clicks and conversions are
+	// written in UTC, so the leading date and time is enough.
+	unixTime: `CAST(strftime('%%s', substr(%s, 1, 19)) AS INTEGER)`,
+	isUniqueViolation: func(err error) bool {
+		return strings.Contains(err.Error(), "UNIQUE constraint failed")
+	},
+}
+
+var postgresDialect = dialect{
+	unixTime: `FLOOR(EXTRACT(EPOCH FROM %s))::BIGINT`,
+	lockRow:  ` FOR UPDATE`,
+	isUniqueViolation: func(err error) bool {
+		return strings.Contains(err.Error(), "duplicate key value violates unique constraint")
+	},
+}
+
+// New returns the store matching db.Driver.
+func New(db *database.DB) *SQLStore {
+	if db.Driver == database.DriverPostgres {
+		return NewPostgresStore(db)
+	}
+	return NewSQLiteStore(db)
+}
+
+func NewSQLiteStore(db *database.DB) *SQLStore {
+	return &SQLStore{db: db, dialect: sqliteDialect}
+}
+
+func NewPostgresStore(db *database.DB) *SQLStore {
+	return &SQLStore{db: db, dialect: postgresDialect}
+}
+
+const urlColumns = `id, short_code, original_url, created_at, activates_at, expires_at, click_count, user_ip, COALESCE(owner_id, ''), team_id, is_custom, deleted_at, redirect_type, COALESCE(password_hash, ''), max_clicks, COALESCE(fallback_url, ''), COALESCE(targeting_rules, ''), COALESCE(variants, ''), COALESCE(click_id_param, ''), forward_query`
+
+func scanURL(row interface{ Scan(...interface{}) error }, url *models.URL) error {
+	var rules, variants string
+	err := row.Scan(
+		&url.ID, &url.ShortCode, &url.OriginalURL, &url.CreatedAt,
+		&url.ActivatesAt, &url.ExpiresAt, &url.ClickCount, &url.UserIP, &url.OwnerID, &url.TeamID,
+		&url.IsCustom, &url.DeletedAt, &url.RedirectType, &url.PasswordHash,
+		&url.MaxClicks, &url.FallbackURL, &rules, &variants, &url.ClickIDParam, &url.ForwardQuery,
+	)
+	if err != nil {
+		return err
+	}
+	url.PasswordProtected = url.PasswordHash != ""
+	if rules != "" {
+		if err := json.Unmarshal([]byte(rules), &url.TargetingRules); err != nil {
+			return fmt.Errorf("invalid targeting rules of %s: %v", url.ShortCode, err)
+		}
+	}
+	if variants != "" {
+		if err := json.Unmarshal([]byte(variants), &url.Variants); err != nil {
+			return fmt.Errorf("invalid variants of %s: %v", url.ShortCode, err)
+		}
+	}
+	return nil
+}
+
+func (s *SQLStore) CreateURL(url *models.URL) error {
+	query := `
+		INSERT INTO urls (short_code, original_url, created_at, activates_at, expires_at, user_ip, owner_id, team_id, is_custom, redirect_type, password_hash, max_clicks, fallback_url, targeting_rules, variants, click_id_param, forward_query)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`
+
+	rules, err := encodeList(url.TargetingRules, len(url.TargetingRules))
+	if err != nil {
+		return err
+	}
+	variants, err := encodeList(url.Variants, len(url.Variants))
+	if err != nil {
+		return err
+	}
+
+	id, err := s.db.InsertID(query, url.ShortCode, url.OriginalURL, url.CreatedAt,
+		url.ActivatesAt, url.ExpiresAt, url.UserIP, nullString(url.OwnerID), url.TeamID, url.IsCustom, url.RedirectType,
+		nullString(url.PasswordHash), url.MaxClicks, nullString(url.FallbackURL), rules, variants, nullString(url.ClickIDParam), url.ForwardQuery)
+	if err != nil {
+		if s.dialect.isUniqueViolation(err) {
+			return ErrDuplicateShortCode
+		}
+		return err
+	}
+
+	url.ID = int(id)
+	return nil
+}
+
+func (s *SQLStore) GetURL(shortCode string, includeDeleted bool) (*models.URL, error) {
+	query := `SELECT ` + urlColumns + ` FROM urls WHERE short_code = ?`
+	if !includeDeleted {
+		query += ` AND deleted_at IS NULL`
+	}
+
+	url := &models.URL{}
+	if err := scanURL(s.db.QueryRow(query, shortCode), url); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	return url, nil
+}
+
+func (s *SQLStore) ShortCodeExists(shortCode string) (bool, error) {
+	query := `SELECT COUNT(*) FROM urls WHERE short_code = ?`
+	var count int
+	if err := s.db.QueryRow(query, shortCode).Scan(&count); err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+func (s *SQLStore) UpdateURL(shortCode string, req models.UpdateURLRequest, access Access) (bool, error) {
+	return updateURL(s.db, shortCode, req, access)
+}
+
+func (s *SQLStore) UpdateURLWith(shortCode string, access Access, prepare func(current *models.URL) (models.UpdateURLRequest, error)) (bool, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	clause, accessArgs := accessClause(access)
+	query := `SELECT ` + urlColumns + ` FROM urls WHERE short_code = ? AND deleted_at IS NULL AND ` + clause + s.dialect.lockRow
+	current := &models.URL{}
+	if err := scanURL(tx.QueryRow(query, append([]interface{}{shortCode}, accessArgs...)...), current); err != nil {
+		if err == sql.ErrNoRows {
+			return false, nil
+		}
+		return false, err
+	}
+
+	req, err := prepare(current)
+	if err != nil {
+		return false, err
+	}
+	updated, err := updateURL(tx, shortCode, req, access)
+	if err != nil || !updated {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// updateURL runs UpdateURL on db or within a transaction.
+func updateURL(db execer, shortCode string, req models.UpdateURLRequest, access Access) (bool, error) {
+	// NULL parameters leave the column unchanged.
+	clause, accessArgs := accessClause(access)
+	query := `
+		UPDATE urls SET
+			original_url = COALESCE(?, original_url),
+			activates_at = CASE WHEN ? THEN NULL ELSE COALESCE(?, activates_at) END,
+			expires_at = CASE WHEN ? THEN NULL ELSE COALESCE(?, expires_at) END,
+			is_custom = COALESCE(?, is_custom),
+			redirect_type = COALESCE(?, redirect_type),
+			max_clicks = CASE WHEN ? THEN NULL ELSE COALESCE(?, max_clicks) END,
+			fallback_url = COALESCE(?, fallback_url),
+			targeting_rules = CASE WHEN ? THEN ? ELSE targeting_rules END,
+			variants = CASE WHEN ? THEN ? ELSE variants END,
+			click_id_param = COALESCE(?, click_id_param),
+			forward_query = COALESCE(?, forward_query)
+		WHERE short_code = ? AND deleted_at IS NULL AND ` + clause
+
+	var rules, variants sql.NullString
+	if req.TargetingRules != nil {
+		var err error
+		if rules, err = encodeList(*req.TargetingRules, len(*req.TargetingRules)); err != nil {
+			return false, err
+		}
+	}
+	if req.Variants != nil {
+		var err error
+		if variants, err = encodeList(*req.Variants, len(*req.Variants)); err != nil {
+			return false, err
+		}
+	}
+
+	args := append([]interface{}{req.OriginalURL, req.ClearActivatesAt, req.ActivatesAt, req.ClearExpiresAt, req.ExpiresAt,
+		req.IsCustom, req.RedirectType, req.ClearMaxClicks, req.MaxClicks, req.FallbackURL,
+		req.TargetingRules != nil, rules, req.Variants != nil, variants, req.ClickIDParam, req.ForwardQuery, shortCode}, accessArgs...)
+	result, err := db.Exec(query, args...)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	return affected > 0, err
+}
+
+func (s *SQLStore) DeleteURL(shortCode string, deletedAt time.Time, access Access) (bool, error) {
+	clause, accessArgs := accessClause(access)
+	query := `
+		UPDATE urls SET deleted_at = ?
+		WHERE short_code = ? AND deleted_at IS NULL AND ` + clause
+
+	args := append([]interface{}{deletedAt, shortCode}, accessArgs...)
+	return s.execAffected(query, args...)
+}
+
+func (s *SQLStore) CanAccess(shortCode string, access Access) (bool, error) {
+	clause, accessArgs := accessClause(access)
+	query := `SELECT COUNT(*) FROM urls WHERE short_code = ? AND ` + clause
+
+	var count int
+	args := append([]interface{}{shortCode}, accessArgs...)
+	if err := s.db.QueryRow(query, args...).Scan(&count); err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+func (s *SQLStore) ListURLs(access Access) ([]models.URL, error) {
+	clause, args := accessClause(access)
+	query := `
+		SELECT ` + urlColumns + `
+		FROM urls 
+		WHERE deleted_at IS NULL AND ` + clause + `
+		ORDER BY created_at DESC
+	`
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var urls []models.URL
+	for rows.Next() {
+		var url models.URL
+		if err := scanURL(rows, &url); err != nil {
+			return nil, err
+		}
+		urls = append(urls, url)
+	}
+
+	return urls, rows.Err()
+}
+
+func (s *SQLStore) IncrementClickCount(shortCode string) error {
+	query := `UPDATE urls SET click_count = click_count + 1 WHERE short_code = ?`
+	_, err := s.db.Exec(query, shortCode)
+	return err
+}
+
+func (s *SQLStore) ClaimClick(shortCode string) (bool, error) {
+	query := `
+		UPDATE urls SET click_count = click_count + 1
+		WHERE short_code = ? AND (max_clicks IS NULL OR click_count < max_clicks)
+	`
+	return s.execAffected(query, shortCode)
+}
+
+func (s *SQLStore) RecordClick(click models.Click) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := insertClicks(tx, []models.Click{click}); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *SQLStore) RecordClicks(clicks []models.Click) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := insertClicks(tx, clicks); err != nil {
+		return err
+	}
+
+	counts := make(map[string]int)
+	for _, click := range clicks {
+		if !click.Counted && !click.Bot {
+			counts[click.URLShortCode]++
+		}
+	}
+	for shortCode, n := range counts {
+		if _, err := tx.Exec(`UPDATE urls SET click_count = click_count + ? WHERE short_code = ?`, n, shortCode); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// insertClicks inserts clicks and adds them to the rollups within tx.
+func insertClicks(tx *database.Tx, clicks []models.Click) error {
+	query := `
+		INSERT INTO clicks (url_short_code, ip_address, user_agent, referer, country, region, city, asn, as_org, target_rule, variant, click_id, utm_campaign, browser, browser_version, os, device, is_bot, bot_kind, referrer_host, referrer_category, clicked_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`
+
+	for _, click := range clicks {
+		_, err := tx.Exec(query, click.URLShortCode, click.IPAddress, click.UserAgent,
+			click.Referer, click.Country, click.Region, click.City, click.ASN, click.ASOrg, nullString(click.TargetRule), nullString(click.Variant), nullString(click.ClickID), nullString(click.Campaign),
+			nullString(click.Browser), nullString(click.BrowserVersion), nullString(click.OS), nullString(click.Device), click.Bot, nullString(click.BotKind),
+			nullString(click.ReferrerHost), nullString(click.ReferrerCategory), click.ClickedAt.UTC())
+		if err != nil {
+			return err
+		}
+	}
+
+	return recordRollups(tx, clicks)
+}
+
+func (s *SQLStore) CountClicks(shortCode string, filter ClickFilter) (int, error) {
+	from, args := s.clickRollups(shortCode, filter, planRollups(filter.TimeRange, true, true))
+	query := `SELECT COALESCE(SUM(clicks), 0) FROM ` + from
+	var count int
+	err := s.db.QueryRow(query, args...).Scan(&count)
+	return count, err
+}
+
+func (s *SQLStore) CountUniqueVisitors(shortCode string, filter ClickFilter) (int, error) {
+	from, args := s.visitorRollups(shortCode, filter)
+	query := `SELECT COUNT(DISTINCT ip_address) FROM ` + from
+	var count int
+	err := s.db.QueryRow(query, args...).Scan(&count)
+	return count, err
+}
+
+func (s *SQLStore) ClicksByCountry(shortCode string, filter ClickFilter, limit int) (map[string]int, error) {
+	from, args := s.clickRollups(shortCode, filter, planRollups(filter.TimeRange, true, true))
+	query := `
+		SELECT country, SUM(clicks) as count
+		FROM ` + from + `
+		WHERE country != ''
+		GROUP BY country
+		ORDER BY count DESC
+		LIMIT ?
+	`
+
+	rows, err := s.db.Query(query, append(args, limit)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make(map[string]int)
+	for rows.Next() {
+		var country string
+		var count int
+		if err := rows.Scan(&country, &count); err != nil {
+			return nil, err
+		}
+		result[country] = count
+	}
+
+	return result, rows.Err()
+}
+
+func (s *SQLStore) ClicksBySlot(shortCode string, filter ClickFilter, slot time.Duration) (map[int64]int, error) {
+	// Rollups can only stand in for slots they fit in evenly.
+	daily := slot%(24*time.Hour) == 0
+	hourly := slot%time.Hour == 0
+	seconds := int64(slot / time.Second)
+	from, args := s.clickRollups(shortCode, filter, planRollups(filter.TimeRange, daily, hourly))
+	query := `
+		SELECT (at / ?) * ? as slot, SUM(clicks) as count
+		FROM ` + from + `
+		GROUP BY 1
+	`
+	args = append([]interface{}{seconds, seconds}, args...)
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make(map[int64]int)
+	for rows.Next() {
+		var start int64
+		var count int
+		if err := rows.Scan(&start, &count); err != nil {
+			return nil, err
+		}
+		result[start] = count
+	}
+
+	return result, rows.Err()
+}
+
+func (s *SQLStore) RecentClicks(shortCode string, filter ClickFilter, limit int) ([]models.Click, error) {
+	clause, args := filterClause(filter, shortCode)
+	query := `
+		SELECT id, url_short_code, ip_address, 
+		       COALESCE(user_agent, '') as user_agent, 
+		       COALESCE(referer, '') as referer, 
+		       COALESCE(country, 'Unknown') as country, 
+		       COALESCE(region, 'Unknown') as region, 
+		       COALESCE(city, 'Unknown') as city, 
+		       COALESCE(asn, 0) as asn, 
+		       COALESCE(as_org, '') as as_org, 
+		       COALESCE(target_rule, '') as target_rule, 
+		       COALESCE(variant, '') as variant, 
+		       COALESCE(click_id, '') as click_id, 
+		       COALESCE(utm_campaign, '') as utm_campaign, 
+		       COALESCE(browser, '') as browser, 
+		       COALESCE(browser_version, '') as browser_version, 
+		       COALESCE(os, '') as os, 
+		       COALESCE(device, '') as device, 
+		       is_bot, 
+		       COALESCE(bot_kind, '') as bot_kind, 
+		       COALESCE(referrer_host, '') as referrer_host, 
+		       COALESCE(referrer_category, '') as referrer_category, 
+		       clicked_at
+		FROM clicks 
+		WHERE url_short_code = ?` + clause + `
+		ORDER BY clicked_at DESC
+		LIMIT ?
+	`
+
+	rows, err := s.db.Query(query, append(args, limit)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var clicks []models.Click
+	for rows.Next() {
+		var click models.Click
+		err := rows.Scan(&click.ID, &click.URLShortCode, &click.IPAddress,
+			&click.UserAgent, &click.Referer, &click.Country, &click.Region, &click.City,
+			&click.ASN, &click.ASOrg, &click.TargetRule, &click.Variant, &click.ClickID, &click.Campaign,
+			&click.Browser, &click.BrowserVersion, &click.OS, &click.Device, &click.Bot, &click.BotKind,
+			&click.ReferrerHost, &click.ReferrerCategory, &click.ClickedAt)
+		if err != nil {
+			return nil, err
+		}
+		clicks = append(clicks, click)
+	}
+
+	return clicks, rows.Err()
+}
+
+func (s *SQLStore) ClicksByRule(shortCode string, filter ClickFilter) (map[string]int, error) {
+	counts, err := s.dimensionCounts(shortCode, filter, dimensionRule)
+	if err != nil {
+		return nil, err
+	}
+	return counts[dimensionRule], nil
+}
+
+func (s *SQLStore) ClicksByVariant(shortCode string, filter ClickFilter) ([]models.VariantStats, error) {
+	counts, err := s.dimensionCounts(shortCode, filter, dimensionVariant)
+	if err != nil {
+		return nil, err
+	}
+
+	from, args := s.variantVisitorRollups(shortCode, filter)
+	query := `
+		SELECT variant, COUNT(DISTINCT ip_address) as visitors
+		FROM ` + from + `
+		GROUP BY variant
+	`
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	visitors := make(map[string]int)
+	for rows.Next() {
+		var variant string
+		var count int
+		if err := rows.Scan(&variant, &count); err != nil {
+			return nil, err
+		}
+		visitors[variant] = count
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var result []models.VariantStats
+	for name, clicks := range counts[dimensionVariant] {
+		result = append(result, models.VariantStats{Name: name, Clicks: clicks, UniqueVisitors: visitors[name]})
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+
+	return result, nil
+}
+
+func (s *SQLStore) ClicksByCampaign(shortCode string, filter ClickFilter) (map[string]int, error) {
+	counts, err := s.dimensionCounts(shortCode, filter, dimensionCampaign)
+	if err != nil {
+		return nil, err
+	}
+	return counts[dimensionCampaign], nil
+}
+
+func (s *SQLStore) ClicksByAgent(shortCode string, filter ClickFilter) (map[string]int, map[string]int, map[string]int, error) {
+	counts, err := s.dimensionCounts(shortCode, filter, dimensionBrowser, dimensionOS, dimensionDevice)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return counts[dimensionBrowser], counts[dimensionOS], counts[dimensionDevice], nil
+}
+
+func (s *SQLStore) ClicksByReferrer(shortCode string, filter ClickFilter, limit int) (map[string]int, map[string]int, error) {
+	counts, err := s.dimensionCounts(shortCode, filter, dimensionReferrerHost, dimensionReferrerCategory)
+	if err != nil {
+		return nil, nil, err
+	}
+	return topCounts(counts[dimensionReferrerHost], limit), counts[dimensionReferrerCategory], nil
+}
+
+func (s *SQLStore) CampaignStats(access Access, filter ClickFilter) ([]models.CampaignStats, error) {
+	clause, args := accessClause(access)
+	filtered, args := filterClause(filter, args...)
+	query := `
+		SELECT clicks.utm_campaign, COUNT(DISTINCT clicks.url_short_code) as links,
+		       COUNT(*) as count, COUNT(DISTINCT clicks.ip_address) as visitors
+		FROM clicks
+		JOIN urls ON urls.short_code = clicks.url_short_code
+		WHERE clicks.utm_campaign IS NOT NULL AND ` + clause + filtered + `
+		GROUP BY clicks.utm_campaign
+		ORDER BY count DESC, clicks.utm_campaign
+	`
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []models.CampaignStats
+	for rows.Next() {
+		var stats models.CampaignStats
+		if err := rows.Scan(&stats.Campaign, &stats.Links, &stats.Clicks, &stats.UniqueVisitors); err != nil {
+			return nil, err
+		}
+		result = append(result, stats)
+	}
+
+	return result, rows.Err()
+}
+
+func (s *SQLStore) ClickExists(shortCode, clickID string) (bool, error) {
+	query := `SELECT COUNT(*) FROM clicks WHERE click_id = ? AND url_short_code = ?`
+	var count int
+	if err := s.db.QueryRow(query, clickID, shortCode).Scan(&count); err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+func (s *SQLStore) RecordConversion(conversion models.Conversion) error {
+	query := `
+		INSERT INTO conversions (click_id, url_short_code, value, currency, converted_at)
+		VALUES (?, ?, ?, ?, ?)
+	`
+
+	_, err := s.db.Exec(query, conversion.ClickID, conversion.URLShortCode, conversion.Value,
+		nullString(conversion.Currency), conversion.ConvertedAt.UTC())
+	if err != nil && s.dialect.isUniqueViolation(err) {
+		return ErrDuplicateConversion
+	}
+	return err
+}
+
+func (s *SQLStore) CountConversions(shortCode string, within TimeRange) (int, map[string]float64, error) {
+	clause, args := rangeClause("converted_at", within, shortCode)
+	query := `
+		SELECT COALESCE(currency, '') as currency, COUNT(*) as count, COALESCE(SUM(value), 0) as revenue
+		FROM conversions
+		WHERE url_short_code = ?` + clause + `
+		GROUP BY COALESCE(currency, '')
+	`
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer rows.Close()
+
+	total := 0
+	revenue := make(map[string]float64)
+	for rows.Next() {
+		var currency string
+		var count int
+		var sum float64
+		if err := rows.Scan(&currency, &count, &sum); err != nil {
+			return 0, nil, err
+		}
+		total += count
+		if currency != "" {
+			revenue[currency] = sum
+		}
+	}
+
+	return total, revenue, rows.Err()
+}
+
+func (s *SQLStore) ConversionsBySlot(shortCode string, within TimeRange, slot time.Duration) (map[int64]models.ConversionBucket, error) {
+	seconds := int64(slot / time.Second)
+	clause, args := rangeClause("converted_at", within, seconds, seconds, shortCode)
+	query := `
+		SELECT (` + fmt.Sprintf(s.dialect.unixTime, "converted_at") + ` / ?) * ? as slot, COALESCE(currency, '') as currency, COUNT(*) as count, COALESCE(SUM(value), 0) as revenue
+		FROM conversions
+		WHERE url_short_code = ?` + clause + `
+		GROUP BY 1, 2
+	`
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	// Rows come per slot and currency.
+	result := make(map[int64]models.ConversionBucket)
+	for rows.Next() {
+		var start int64
+		var currency string
+		var count int
+		var sum float64
+		if err := rows.Scan(&start, &currency, &count, &sum); err != nil {
+			return nil, err
+		}
+		bucket, ok := result[start]
+		if !ok {
+			bucket.Revenue = make(map[string]float64)
+		}
+		bucket.Conversions += count
+		if currency != "" {
+			bucket.Revenue[currency] = sum
+		}
+		result[start] = bucket
+	}
+
+	return result, rows.Err()
+}
+
+func (s *SQLStore) RecordUnlockAttempt(attempt models.UnlockAttempt) error {
+	query := `
+		INSERT INTO unlock_attempts (url_short_code, ip_address, success, attempted_at)
+		VALUES (?, ?, ?, ?)
+	`
+
+	_, err := s.db.Exec(query, attempt.URLShortCode, attempt.IPAddress, attempt.Success, attempt.AttemptedAt)
+	return err
+}
+
+func (s *SQLStore) CountUnlockAttempts(shortCode string) (int, int, error) {
+	query := `
+		SELECT COUNT(*), COALESCE(SUM(CASE WHEN success THEN 0 ELSE 1 END), 0)
+		FROM unlock_attempts
+		WHERE url_short_code = ?
+	`
+
+	var total, failed int
+	err := s.db.QueryRow(query, shortCode).Scan(&total, &failed)
+	return total, failed, err
+}
+
+func (s *SQLStore) execAffected(query string, args ...interface{}) (bool, error) {
+	result, err := s.db.Exec(query, args...)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected > 0, nil
+}
+
+// accessClause restricts a query on urls to the links within access.
+func accessClause(access Access) (string, []interface{}) {
+	clause := `(team_id IS NULL AND owner_id = ?)`
+	args := []interface{}{access.OwnerID}
+
+	if len(access.TeamIDs) > 0 {
+		placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(access.TeamIDs)), ", ")
+		clause = `(` + clause + ` OR team_id IN (` + placeholders + `))`
+		for _, id := range access.TeamIDs {
+			args = append(args, id)
+		}
+	}
+
+	return clause, args
+}
+
+// filterClause restricts a query on clicks to those filter counts, as an
+// AND condition to append to its WHERE clause. args are the arguments of
+// the query before the clause; those of the clause are appended to them.
+func filterClause(filter ClickFilter, args ...interface{}) (string, []interface{}) {
+	clause, args := rangeClause("clicks.clicked_at", filter.TimeRange, args...)
+	if !filter.IncludeBots {
+		clause += ` AND clicks.is_bot = FALSE`
+	}
+	return clause, args
+}
+
+// rangeClause restricts column to within like filterClause. Times are
+// compared in UTC, as they are stored.
+func rangeClause(column string, within TimeRange, args ...interface{}) (string, []interface{}) {
+	clause := ""
+	if !within.From.IsZero() {
+		clause += ` AND ` + column + ` >= ?`
+		args = append(args, within.From.UTC())
+	}
+	if !within.To.IsZero() {
+		clause += ` AND ` + column + ` < ?`
+		args = append(args, within.To.UTC())
+	}
+	return clause, args
+}
+
+// encodeList stores a list of n targeting rules or variants as JSON, and an
+// empty list as NULL.
+func encodeList(list interface{}, n int) (sql.NullString, error) {
+	if n == 0 {
+		return sql.NullString{}, nil
+	}
+	data, err := json.Marshal(list)
+	if err != nil {
+		return sql.NullString{}, err
+	}
+	return sql.NullString{String: string(data), Valid: true}, nil
+}
+
+// nullString stores empty strings as NULL.
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
@@ -0,0 +1,135 @@
+// Package store defines the persistence interfaces used by URLService and
+// AnalyticsService, with SQL implementations for SQLite and PostgreSQL and
+// an in-memory implementation.
+package store
+
+import (
+	"errors"
+	"time"
+
+	"url-shortener/internal/models"
+)
+
+var (
+	// ErrNotFound is returned when no link matches a short code.
+	ErrNotFound = errors.New("not found")
+	// ErrDuplicateShortCode is returned when a short code is already taken.
+	ErrDuplicateShortCode = errors.New("short code already exists")
+	// ErrDuplicateConversion is returned when a click already converted.
+	ErrDuplicateConversion = errors.New("click already converted")
+)
+
+// Access describes which links a caller may act on: personal links of
+// OwnerID plus every link of the teams in TeamIDs.
+type Access struct {
+	OwnerID string
+	TeamIDs []int
+}
+
+// TimeRange limits a query to the events from From up to, but not
+// including, To. A zero From or To leaves that end open.
+type TimeRange struct {
+	From time.Time
+	To   time.Time
+}
+
+// ClickFilter narrows the clicks that analytics queries count and list. The
+// zero value leaves out clicks flagged as bots and has no time limits.
+type ClickFilter struct {
+	IncludeBots bool
+	TimeRange
+}
+
+// URLStore persists short links.
+type URLStore interface {
+	// CreateURL inserts url and sets its ID.
+	CreateURL(url *models.URL) error
+	// GetURL returns a link regardless of expiry. Soft-deleted links are
+	// only returned when includeDeleted is set.
+	GetURL(shortCode string, includeDeleted bool) (*models.URL, error)
+	// ShortCodeExists reports whether any link, deleted or not, uses the code.
+	ShortCodeExists(shortCode string) (bool, error)
+	// UpdateURL applies a partial update to a non-deleted link within
+	// access and reports whether a row matched.
+	UpdateURL(shortCode string, req models.UpdateURLRequest, access Access) (bool, error)
+	// UpdateURLWith is UpdateURL with the update derived by prepare from
+	// the link as it is, read and written in one transaction so concurrent
+	// updates are not lost. prepare only sees links within access, and its
+	// error is returned unchanged.
+	UpdateURLWith(shortCode string, access Access, prepare func(current *models.URL) (models.UpdateURLRequest, error)) (bool, error)
+	// DeleteURL soft-deletes a link within access and reports whether a row
+	// matched.
+	DeleteURL(shortCode string, deletedAt time.Time, access Access) (bool, error)
+	// CanAccess reports whether a link, deleted or not, is within access.
+	CanAccess(shortCode string, access Access) (bool, error)
+	// ListURLs returns the non-deleted links within access, newest first.
+	ListURLs(access Access) ([]models.URL, error)
+	IncrementClickCount(shortCode string) error
+	// ClaimClick raises click_count unless the link has reached max_clicks,
+	// in one atomic step, and reports whether the click was allowed.
+	ClaimClick(shortCode string) (bool, error)
+}
+
+// ClickStore persists click events and answers the analytics queries.
+type ClickStore interface {
+	RecordClick(click models.Click) error
+	// RecordClicks inserts a batch of clicks and adds those not yet Counted
+	// to the links' click counts in a single transaction. Bot clicks are
+	// stored but not counted.
+	RecordClicks(clicks []models.Click) error
+	// The queries below only see the clicks that filter lets through.
+	CountClicks(shortCode string, filter ClickFilter) (int, error)
+	CountUniqueVisitors(shortCode string, filter ClickFilter) (int, error)
+	// ClicksByCountry returns the limit countries with the most clicks.
+	ClicksByCountry(shortCode string, filter ClickFilter, limit int) (map[string]int, error)
+	// ClicksBySlot counts clicks in consecutive slots of the given length
+	// since the Unix epoch, keyed by the Unix time of the slot's start.
+	// Slots without clicks are left out.
+	ClicksBySlot(shortCode string, filter ClickFilter, slot time.Duration) (map[int64]int, error)
+	// RecentClicks returns the latest limit clicks, newest first.
+	RecentClicks(shortCode string, filter ClickFilter, limit int) ([]models.Click, error)
+	// ClicksByRule counts clicks by their TargetRule, with "" for clicks
+	// that matched no rule.
+	ClicksByRule(shortCode string, filter ClickFilter) (map[string]int, error)
+	// ClicksByVariant counts the clicks and unique visitors of each A/B
+	// variant that has clicks, ordered by name. Only Name, Clicks and
+	// UniqueVisitors are set.
+	ClicksByVariant(shortCode string, filter ClickFilter) ([]models.VariantStats, error)
+	// ClicksByCampaign counts clicks by their Campaign, leaving out clicks
+	// without one.
+	ClicksByCampaign(shortCode string, filter ClickFilter) (map[string]int, error)
+	// ClicksByAgent counts clicks by their Browser, OS and Device, leaving
+	// out clicks recorded without them.
+	ClicksByAgent(shortCode string, filter ClickFilter) (browsers, oses, devices map[string]int, err error)
+	// ClicksByReferrer returns the limit referrer hosts with the most clicks
+	// and counts clicks by ReferrerCategory, leaving out clicks recorded
+	// without one.
+	ClicksByReferrer(shortCode string, filter ClickFilter, limit int) (hosts, categories map[string]int, err error)
+	// CampaignStats groups the clicks of all links within access, deleted
+	// or not, by Campaign, most clicked first.
+	CampaignStats(access Access, filter ClickFilter) ([]models.CampaignStats, error)
+	// ClickExists reports whether a click of shortCode was recorded with
+	// clickID.
+	ClickExists(shortCode, clickID string) (bool, error)
+	// RecordConversion stores a conversion, or returns
+	// ErrDuplicateConversion when its click already has one.
+	RecordConversion(conversion models.Conversion) error
+	// CountConversions returns the number of conversions of a link within
+	// a time range and the sum of their values by currency.
+	CountConversions(shortCode string, within TimeRange) (int, map[string]float64, error)
+	// ConversionsBySlot is ClicksBySlot for conversions. Only Conversions
+	// and Revenue are set.
+	ConversionsBySlot(shortCode string, within TimeRange, slot time.Duration) (map[int64]models.ConversionBucket, error)
+	// RecordUnlockAttempt stores a password submission for a protected link.
+	RecordUnlockAttempt(attempt models.UnlockAttempt) error
+	// CountUnlockAttempts returns the number of password submissions for a
+	// link and how many of them failed.
+	CountUnlockAttempts(shortCode string) (total, failed int, err error)
+}
+
+// Store combines URLStore and ClickStore, as implemented by SQLStore and
+// MemoryStore.
+type Store interface {
+	URLStore
+	ClickStore
+}
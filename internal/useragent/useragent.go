@@ -0,0 +1,202 @@
+// Package useragent classifies a visitor's User-Agent header by browser,
+// operating system and device type, and flags crawlers, for targeting rules
+// and click analytics. It uses substring checks on well-known tokens rather
+// than a full UA database.
+package useragent
+
+import "strings"
+
+// Browser families reported by Parse.
+const (
+	BrowserChrome  = "chrome"
+	BrowserFirefox = "firefox"
+	BrowserSafari  = "safari"
+	BrowserEdge    = "edge"
+	BrowserOpera   = "opera"
+	BrowserSamsung = "samsung"
+	BrowserIE      = "ie"
+	BrowserOther   = "other"
+)
+
+// Operating systems reported by Parse.
+const (
+	OSiOS      = "ios"
+	OSAndroid  = "android"
+	OSWindows  = "windows"
+	OSMacOS    = "macos"
+	OSLinux    = "linux"
+	OSChromeOS = "chromeos"
+	OSOther    = "other"
+)
+
+// Device types reported by Parse.
+const (
+	DeviceMobile  = "mobile"
+	DeviceTablet  = "tablet"
+	DeviceDesktop = "desktop"
+)
+
+// Kinds of automated clients reported by Parse.
+const (
+	BotCrawler  = "crawler"
+	BotUnfurler = "unfurler"
+	BotHeadless = "headless"
+	BotLibrary  = "library"
+)
+
+// Agent is the result of Parse. BrowserVersion is the browser's major
+// version, empty when unknown. BotKind is one of the Bot constants when
+// Bot is set.
+type Agent struct {
+	OS             string
+	Device         string
+	Browser        string
+	BrowserVersion string
+	Bot            bool
+	BotKind        string
+}
+
+// Parse classifies ua. Unrecognised agents are OSOther on a desktop unless
+// they call themselves mobile, with BrowserOther.
+func Parse(ua string) Agent {
+	s := strings.ToLower(ua)
+	agent := platform(s)
+	agent.Browser, agent.BrowserVersion = browser(s)
+	agent.BotKind = botKind(s)
+	agent.Bot = agent.BotKind != ""
+	return agent
+}
+
+// platform finds the operating system and device type in the lower-cased
+// agent s.
+func platform(s string) Agent {
+
+	// iOS and Android agents also mention Mac OS X and Linux, so they are
+	// checked first.
+	switch {
+	case strings.Contains(s, "ipad"):
+		return Agent{OS: OSiOS, Device: DeviceTablet}
+	case strings.Contains(s, "iphone"), strings.Contains(s, "ipod"):
+		return Agent{OS: OSiOS, Device: DeviceMobile}
+	case strings.Contains(s, "android"):
+		if strings.Contains(s, "mobile") {
+			return Agent{OS: OSAndroid, Device: DeviceMobile}
+		}
+		return Agent{OS: OSAndroid, Device: DeviceTablet}
+	case strings.Contains(s, "windows phone"):
+		return Agent{OS: OSWindows, Device: DeviceMobile}
+	case strings.Contains(s, "cros"):
+		return Agent{OS: OSChromeOS, Device: DeviceDesktop}
+	case strings.Contains(s, "macintosh"), strings.Contains(s, "mac os x"):
+		return Agent{OS: OSMacOS, Device: DeviceDesktop}
+	case strings.Contains(s, "windows"):
+		return Agent{OS: OSWindows, Device: DeviceDesktop}
+	case strings.Contains(s, "linux"):
+		return Agent{OS: OSLinux, Device: DeviceDesktop}
+	case strings.Contains(s, "mobile"):
+		return Agent{OS: OSOther, Device: DeviceMobile}
+	}
+	return Agent{OS: OSOther, Device: DeviceDesktop}
+}
+
+// browser finds the browser family and major version in the lower-cased
+// agent s. Most browsers also mention Chrome or Safari, so the more
+// specific tokens are checked first.
+func browser(s string) (string, string) {
+	switch {
+	case strings.Contains(s, "edg/"), strings.Contains(s, "edga/"), strings.Contains(s, "edgios/"), strings.Contains(s, "edge/"):
+		return BrowserEdge, version(s, "edg/", "edga/", "edgios/", "edge/")
+	case strings.Contains(s, "opr/"), strings.Contains(s, "opera"):
+		return BrowserOpera, version(s, "opr/", "version/", "opera/")
+	case strings.Contains(s, "samsungbrowser/"):
+		return BrowserSamsung, version(s, "samsungbrowser/")
+	case strings.Contains(s, "firefox/"), strings.Contains(s, "fxios/"):
+		return BrowserFirefox, version(s, "firefox/", "fxios/")
+	case strings.Contains(s, "chrome/"), strings.Contains(s, "crios/"), strings.Contains(s, "chromium/"):
+		return BrowserChrome, version(s, "chrome/", "crios/", "chromium/")
+	case strings.Contains(s, "safari/"), strings.Contains(s, "applewebkit/") && strings.Contains(s, "version/"):
+		return BrowserSafari, version(s, "version/")
+	case strings.Contains(s, "msie "), strings.Contains(s, "trident/"):
+		return BrowserIE, version(s, "msie ", "rv:")
+	}
+	return BrowserOther, ""
+}
+
+// version returns the major version that follows the first of tokens found
+// in s.
+func version(s string, tokens ...string) string {
+	for _, token := range tokens {
+		i := strings.Index(s, token)
+		if i < 0 {
+			continue
+		}
+		rest := s[i+len(token):]
+		end := 0
+		for end < len(rest) && rest[end] >= '0' && rest[end] <= '9' {
+			end++
+		}
+		if end > 0 {
+			return rest[:end]
+		}
+	}
+	return ""
+}
+
+// botTokens appear in the agents of link previewers, headless browsers,
+// HTTP libraries and crawlers, checked in that order since unfurlers often
+// call themselves bots too. Most crawlers end a word in "bot", which
+// botKind checks last.
+var botTokens = []struct {
+	kind   string
+	tokens []string
+}{
+	{BotUnfurler, []string{
+		"facebookexternalhit", "embedly", "whatsapp", "skypeuripreview",
+		"slackbot", "twitterbot", "linkedinbot", "discordbot", "telegrambot",
+	}},
+	{BotHeadless, []string{"headlesschrome", "lighthouse"}},
+	{BotLibrary, []string{
+		"curl/", "wget/", "python-requests", "python-urllib", "go-http-client",
+		"java/", "libwww", "httpclient", "scrapy",
+	}},
+	{BotCrawler, []string{"crawler", "spider", "slurp", "facebookcatalog"}},
+}
+
+// botKind returns the kind of automated client the lower-cased agent s
+// belongs to, or "" for a person.
+func botKind(s string) string {
+	for _, group := range botTokens {
+		for _, token := range group.tokens {
+			if strings.Contains(s, token) {
+				return group.kind
+			}
+		}
+	}
+	words := strings.FieldsFunc(s, func(r rune) bool { return r < 'a' || r > 'z' })
+	for _, word := range words {
+		// Cubot is a phone maker, not a crawler.
+		if strings.HasSuffix(word, "bot") && word != "cubot" {
+			return BotCrawler
+		}
+	}
+	return ""
+}
+
+// KnownOS reports whether os is one of the operating systems Parse reports.
+func KnownOS(os string) bool {
+	switch os {
+	case OSiOS, OSAndroid, OSWindows, OSMacOS, OSLinux, OSChromeOS, OSOther:
+		return true
+	}
+	return false
+}
+
+// KnownDevice reports whether device is one of the device types Parse
+// reports.
+func KnownDevice(device string) bool {
+	switch device {
+	case DeviceMobile, DeviceTablet, DeviceDesktop:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,143 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"url-shortener/internal/config"
+	"url-shortener/internal/handlers"
+	"url-shortener/internal/middleware"
+	"url-shortener/internal/models"
+	"url-shortener/internal/services"
+	"url-shortener/internal/store"
+	"url-shortener/internal/useragent"
+
+	"github.com/gorilla/mux"
+)
+
+func TestBotClicksLeftOut(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	urlSvc := services.NewURLService(store.New(db), nil, config.Default().Links)
+	analyticsSvc := services.NewAnalyticsService(store.New(db), store.New(db), nil, nil)
+	keySvc := services.NewAPIKeyService(db)
+	handler := handlers.NewURLHandler(urlSvc, analyticsSvc, nil, nil, config.Default().QR)
+
+	router := mux.NewRouter()
+	router.Use(middleware.AuthMiddleware(keySvc))
+	router.HandleFunc("/api/v1/analytics/{shortCode}", handler.GetAnalytics).Methods("GET")
+	router.HandleFunc("/{shortCode}", handler.RedirectURL).Methods("GET", "HEAD")
+
+	aliceKey, _, err := keySvc.CreateKey("alice", "test")
+	if err != nil {
+		t.Fatalf("create key failed: %v", err)
+	}
+	one := 1
+	for _, req := range []models.ShortenURLRequest{
+		{OriginalURL: "https://example.com/promo", CustomCode: "promo"},
+		{OriginalURL: "https://example.com/secret", CustomCode: "once", MaxClicks: &one},
+	} {
+		if _, err := urlSvc.ShortenURL(req, "alice", "127.0.0.1"); err != nil {
+			t.Fatalf("shorten %s failed: %v", req.CustomCode, err)
+		}
+	}
+
+	visit := func(method, path, ua string, headers map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("User-Agent", ua)
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	slackUA := "Slackbot-LinkExpanding 1.0 (+https://api.slack.com/robots)"
+	visits := []struct {
+		name    string
+		method  string
+		ua      string
+		headers map[string]string
+	}{
+		{"person", "GET", windowsUA, nil},
+		{"unfurler", "GET", slackUA, nil},
+		{"head request", "HEAD", macUA, nil},
+		{"prefetch", "GET", macUA, map[string]string{"Sec-Purpose": "prefetch;prerender"}},
+		{"safari preview", "GET", iPhoneUA, map[string]string{"X-Purpose": "preview"}},
+		{"another person", "GET", iPhoneUA, nil},
+	}
+	for _, v := range visits {
+		if rr := visit(v.method, "/promo", v.ua, v.headers); rr.Code != http.StatusFound {
+			t.Errorf("%s: got %d, want a redirect", v.name, rr.Code)
+		}
+	}
+
+	// Bots neither use up a one-time link nor learn where it leads, since
+	// anyone can look like one.
+	for _, v := range visits[1:5] {
+		if rr := visit(v.method, "/once", v.ua, v.headers); rr.Code != http.StatusNoContent || rr.Header().Get("Location") != "" {
+			t.Errorf("%s of a one-time link: got %d to %q, want 204 without a Location", v.name, rr.Code, rr.Header().Get("Location"))
+		}
+	}
+	if rr := visit("GET", "/once", windowsUA, nil); rr.Code != http.StatusFound {
+		t.Errorf("person after the bots: got %d, want 302", rr.Code)
+	}
+	if rr := visit("GET", "/once", windowsUA, nil); rr.Code != http.StatusGone {
+		t.Errorf("second person: got %d, want 410", rr.Code)
+	}
+	if rr := visit("HEAD", "/once", macUA, nil); rr.Code != http.StatusGone {
+		t.Errorf("HEAD of a used one-time link: got %d, want 410", rr.Code)
+	}
+
+	getAnalytics := func(query string) (*httptest.ResponseRecorder, models.Analytics) {
+		req := httptest.NewRequest("GET", "/api/v1/analytics/promo"+query, nil)
+		req.Header.Set("Authorization", "Bearer "+aliceKey)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		var analytics models.Analytics
+		if rr.Code == http.StatusOK {
+			if err := json.NewDecoder(rr.Body).Decode(&analytics); err != nil {
+				t.Fatalf("decode analytics failed: %v", err)
+			}
+		}
+		return rr, analytics
+	}
+
+	rr, analytics := getAnalytics("")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("analytics: got %d: %s", rr.Code, rr.Body.String())
+	}
+	if analytics.TotalClicks != 2 || analytics.BotClicks != 4 || analytics.IncludesBots || len(analytics.RecentClicks) != 2 {
+		t.Errorf("Expected 2 clicks with 4 bots left out, got %d clicks, %d bots, %d recent", analytics.TotalClicks, analytics.BotClicks, len(analytics.RecentClicks))
+	}
+	if analytics.URL.ClickCount != 2 {
+		t.Errorf("Expected click_count to leave out bots, got %d", analytics.URL.ClickCount)
+	}
+
+	rr, analytics = getAnalytics("?include_bots=true")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("analytics with bots: got %d: %s", rr.Code, rr.Body.String())
+	}
+	if analytics.TotalClicks != 6 || analytics.BotClicks != 4 || !analytics.IncludesBots || len(analytics.RecentClicks) != 6 {
+		t.Errorf("Expected all 6 clicks with include_bots, got %d clicks, %d bots, %d recent", analytics.TotalClicks, analytics.BotClicks, len(analytics.RecentClicks))
+	}
+	kinds := make(map[string]int)
+	for _, click := range analytics.RecentClicks {
+		if click.Bot {
+			kinds[click.BotKind]++
+		}
+	}
+	wantKinds := map[string]int{useragent.BotUnfurler: 1, models.BotKindHead: 1, models.BotKindPrefetch: 2}
+	if !reflect.DeepEqual(kinds, wantKinds) {
+		t.Errorf("Expected recent bot clicks of kinds %v, got %v", wantKinds, kinds)
+	}
+
+	if rr, _ := getAnalytics("?include_bots=maybe"); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an invalid include_bots, got %d", rr.Code)
+	}
+}
@@ -0,0 +1,164 @@
+package tests
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"url-shortener/internal/config"
+	"url-shortener/internal/handlers"
+	"url-shortener/internal/models"
+	"url-shortener/internal/services"
+	"url-shortener/internal/store"
+
+	"github.com/gorilla/mux"
+)
+
+// blockingStore holds every batch write until release is closed.
+type blockingStore struct {
+	*store.MemoryStore
+	entered chan struct{}
+	release chan struct{}
+}
+
+func (s *blockingStore) RecordClicks(clicks []models.Click) error {
+	s.entered <- struct{}{}
+	<-s.release
+	return s.MemoryStore.RecordClicks(clicks)
+}
+
+func TestClickQueueBatchesAndFlushesOnClose(t *testing.T) {
+	urlSvc, analyticsSvc := newMemoryServices(t)
+
+	req := models.ShortenURLRequest{OriginalURL: "https://example.com/queue", CustomCode: "queue"}
+	if _, err := urlSvc.ShortenURL(req, "alice", "127.0.0.1"); err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+
+	queue := services.NewClickQueue(analyticsSvc, config.ClickQueue{
+		Workers:       2,
+		BatchSize:     100,
+		FlushInterval: time.Hour,
+	})
+
+	for i := 0; i < 250; i++ {
+		click := models.Click{
+			URLShortCode: "queue",
+			IPAddress:    fmt.Sprintf("10.0.0.%d", i%10),
+			ClickedAt:    time.Now(),
+		}
+		if !queue.Enqueue(click) {
+			t.Fatalf("click %d was dropped", i)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := queue.Close(ctx); err != nil {
+		t.Fatalf("close failed: %v", err)
+	}
+
+	stats := queue.Stats()
+	if stats.Enqueued != 250 || stats.Written != 250 || stats.Dropped != 0 || stats.Pending != 0 {
+		t.Errorf("stats = %+v, want 250 enqueued and written", stats)
+	}
+
+	analytics, err := analyticsSvc.GetAnalytics("queue", "alice")
+	if err != nil {
+		t.Fatalf("get analytics failed: %v", err)
+	}
+	if analytics.TotalClicks != 250 || analytics.URL.ClickCount != 250 {
+		t.Errorf("total clicks = %d, click count = %d, want 250", analytics.TotalClicks, analytics.URL.ClickCount)
+	}
+	if analytics.RecentClicks[0].Country != "Local Network" {
+		t.Errorf("country = %q, want clicks enriched with Local Network", analytics.RecentClicks[0].Country)
+	}
+
+	if queue.Enqueue(models.Click{URLShortCode: "queue"}) {
+		t.Error("closed queue should not accept clicks")
+	}
+}
+
+func TestClickQueueDropsWhenFull(t *testing.T) {
+	t.Parallel()
+
+	blocking := &blockingStore{
+		MemoryStore: store.NewMemoryStore(),
+		entered:     make(chan struct{}, 2),
+		release:     make(chan struct{}),
+	}
+	analyticsSvc := services.NewAnalyticsService(blocking, blocking, nil, nil)
+	queue := services.NewClickQueue(analyticsSvc, config.ClickQueue{
+		Size:      1,
+		Workers:   1,
+		BatchSize: 1,
+	})
+
+	click := models.Click{URLShortCode: "full", ClickedAt: time.Now()}
+	queue.Enqueue(click)
+	<-blocking.entered // the worker is now stuck writing the first click
+
+	if !queue.Enqueue(click) {
+		t.Error("second click should fit in the buffer")
+	}
+	if queue.Enqueue(click) {
+		t.Error("third click should be dropped")
+	}
+
+	close(blocking.release)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := queue.Close(ctx); err != nil {
+		t.Fatalf("close failed: %v", err)
+	}
+
+	stats := queue.Stats()
+	if stats.Enqueued != 2 || stats.Written != 2 || stats.Dropped != 1 {
+		t.Errorf("stats = %+v, want 2 written and 1 dropped", stats)
+	}
+
+	rr := httptest.NewRecorder()
+	handlers.Metrics(queue)(rr, httptest.NewRequest("GET", "/metrics", nil))
+	if !strings.Contains(rr.Body.String(), "click_queue_dropped_total 1\n") {
+		t.Errorf("metrics missing drop count:\n%s", rr.Body.String())
+	}
+}
+
+func TestRedirectQueuesClick(t *testing.T) {
+	urlSvc, analyticsSvc := newMemoryServices(t)
+	queue := services.NewClickQueue(analyticsSvc, config.ClickQueue{FlushInterval: time.Hour})
+	handler := handlers.NewURLHandler(urlSvc, analyticsSvc, queue, nil, config.Default().QR)
+
+	req := models.ShortenURLRequest{OriginalURL: "https://example.com/target", CustomCode: "redir"}
+	if _, err := urlSvc.ShortenURL(req, "alice", "127.0.0.1"); err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+
+	httpReq := httptest.NewRequest("GET", "/redir", nil)
+	httpReq = mux.SetURLVars(httpReq, map[string]string{"shortCode": "redir"})
+	rr := httptest.NewRecorder()
+	handler.RedirectURL(rr, httpReq)
+
+	if rr.Code != http.StatusFound {
+		t.Fatalf("status = %d, want %d", rr.Code, http.StatusFound)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := queue.Close(ctx); err != nil {
+		t.Fatalf("close failed: %v", err)
+	}
+
+	url, err := urlSvc.GetOriginalURL("redir")
+	if err != nil {
+		t.Fatalf("get failed: %v", err)
+	}
+	if url.ClickCount != 1 {
+		t.Errorf("click count = %d, want 1", url.ClickCount)
+	}
+}
@@ -0,0 +1,179 @@
+package tests
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"url-shortener/internal/config"
+)
+
+func writeConfigFile(t *testing.T, name, contents string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(contents), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestConfigDefaults(t *testing.T) {
+	cfg, args, err := config.Load(nil)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if len(args) != 0 {
+		t.Errorf("Expected no remaining args, got %v", args)
+	}
+	if !reflect.DeepEqual(*cfg, config.Default()) {
+		t.Errorf("Expected defaults, got %+v", *cfg)
+	}
+}
+
+func TestConfigFiles(t *testing.T) {
+	files := map[string]string{
+		"app.yaml": `
+server:
+  addr: ":9090"
+  write_timeout: 45s
+database:
+  path: /tmp/links.db
+click_queue:
+  flush_interval: 250ms
+qr:
+  size: 512
+`,
+		"app.toml": `
+[server]
+addr = ":9090"
+write_timeout = "45s"
+
+[database]
+path = "/tmp/links.db"
+
+[click_queue]
+flush_interval = "250ms"
+
+[qr]
+size = 512
+`,
+	}
+
+	for name, contents := range files {
+		t.Run(name, func(t *testing.T) {
+			path := writeConfigFile(t, name, contents)
+			cfg, _, err := config.Load([]string{"-config", path})
+			if err != nil {
+				t.Fatalf("Load failed: %v", err)
+			}
+
+			if cfg.Server.Addr != ":9090" || cfg.Server.WriteTimeout != 45*time.Second {
+				t.Errorf("Unexpected server settings: %+v", cfg.Server)
+			}
+			if cfg.Database.Path != "/tmp/links.db" {
+				t.Errorf("Expected database path from file, got %q", cfg.Database.Path)
+			}
+			if cfg.ClickQueue.FlushInterval != 250*time.Millisecond {
+				t.Errorf("Expected flush interval 250ms, got %v", cfg.ClickQueue.FlushInterval)
+			}
+			if cfg.QR.Size != 512 {
+				t.Errorf("Expected QR size 512, got %d", cfg.QR.Size)
+			}
+			// Keys missing from the file keep their defaults
+			if cfg.Server.ReadTimeout != config.Default().Server.ReadTimeout {
+				t.Errorf("Expected default read timeout, got %v", cfg.Server.ReadTimeout)
+			}
+		})
+	}
+}
+
+func TestConfigPrecedence(t *testing.T) {
+	path := writeConfigFile(t, "app.yaml", `
+server:
+  addr: ":7000"
+rate_limit:
+  requests: 20
+links:
+  short_code_length: 8
+`)
+	t.Setenv("CONFIG_FILE", path)
+	t.Setenv("RATE_LIMIT_REQUESTS", "30")
+	t.Setenv("SHORT_CODE_LENGTH", "9")
+
+	cfg, args, err := config.Load([]string{"-short-code-length", "10", "migrate", "up"})
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	if cfg.Server.Addr != ":7000" {
+		t.Errorf("Expected the file to override the default, got %q", cfg.Server.Addr)
+	}
+	if cfg.RateLimit.Requests != 30 {
+		t.Errorf("Expected the environment to override the file, got %d", cfg.RateLimit.Requests)
+	}
+	if cfg.Links.ShortCodeLength != 10 {
+		t.Errorf("Expected the flag to override the environment, got %d", cfg.Links.ShortCodeLength)
+	}
+	if !reflect.DeepEqual(args, []string{"migrate", "up"}) {
+		t.Errorf("Expected the subcommand to be left over, got %v", args)
+	}
+}
+
+func TestConfigInvalidValues(t *testing.T) {
+	t.Setenv("CLICK_FLUSH_INTERVAL", "soon")
+	if _, _, err := config.Load(nil); err == nil || !strings.Contains(err.Error(), "CLICK_FLUSH_INTERVAL") {
+		t.Errorf("Expected an error naming CLICK_FLUSH_INTERVAL, got %v", err)
+	}
+
+	path := writeConfigFile(t, "app.ini", "addr = :9090\n")
+	if _, _, err := config.Load([]string{"-config", path}); err == nil {
+		t.Error("Expected an unsupported file extension to be rejected")
+	}
+
+	if _, _, err := config.Load([]string{"-qr-size", "big"}); err == nil {
+		t.Error("Expected a non-numeric flag to be rejected")
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	cfg := config.Default()
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Default config should be valid: %v", err)
+	}
+
+	cfg.Server.TLSCertFile = "cert.pem"
+	cfg.Database.Driver = "mysql"
+	cfg.GeoIP.Provider = "mmdb"
+	cfg.QR.Size = 10
+	cfg.Links.FallbackURL = "example.com/landing"
+	cfg.Unlock.TrustedProxies = "10.0.0.0/8, proxy.internal"
+
+	err := cfg.Validate()
+	if err == nil {
+		t.Fatal("Expected validation to fail")
+	}
+	for _, want := range []string{"tls_key_file", "database.driver", "geoip.db_path", "qr.size", "links.fallback_url", "unlock.trusted_proxies"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Expected the error to mention %s, got %v", want, err)
+		}
+	}
+}
+
+func TestGeoIPEffectiveProvider(t *testing.T) {
+	cases := []struct {
+		geo  config.GeoIP
+		want string
+	}{
+		{config.GeoIP{}, "none"},
+		{config.GeoIP{DBPath: "city.mmdb"}, "mmdb"},
+		{config.GeoIP{Provider: "ip-api", DBPath: "city.mmdb"}, "ip-api"},
+	}
+	for _, tc := range cases {
+		if got := tc.geo.EffectiveProvider(); got != tc.want {
+			t.Errorf("EffectiveProvider(%+v) = %q, want %q", tc.geo, got, tc.want)
+		}
+	}
+}
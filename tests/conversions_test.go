@@ -0,0 +1,195 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"image/gif"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	neturl "net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"url-shortener/internal/config"
+	"url-shortener/internal/handlers"
+	"url-shortener/internal/models"
+	"url-shortener/internal/services"
+
+	"github.com/gorilla/mux"
+)
+
+// redirectClickID follows /{shortCode} and returns the redirect location
+// and the click ID passed in param.
+func redirectClickID(t *testing.T, handler *handlers.URLHandler, shortCode, param string) (*neturl.URL, string) {
+	t.Helper()
+	req := mux.SetURLVars(httptest.NewRequest("GET", "/"+shortCode, nil), map[string]string{"shortCode": shortCode})
+	rr := httptest.NewRecorder()
+	handler.RedirectURL(rr, req)
+	if rr.Code != http.StatusFound {
+		t.Fatalf("Expected a redirect, got %d", rr.Code)
+	}
+	if cc := rr.Header().Get("Cache-Control"); !strings.Contains(cc, "no-store") {
+		t.Errorf("Expected redirects with click IDs not to be cached, got %q", cc)
+	}
+	location, err := neturl.Parse(rr.Header().Get("Location"))
+	if err != nil {
+		t.Fatalf("invalid location %q: %v", rr.Header().Get("Location"), err)
+	}
+	return location, location.Query().Get(param)
+}
+
+func postConversion(handler *handlers.URLHandler, req models.ConversionRequest) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(req)
+	rr := httptest.NewRecorder()
+	handler.RecordConversion(rr, httptest.NewRequest("POST", "/api/v1/conversions", bytes.NewReader(body)))
+	return rr
+}
+
+func TestConversionTracking(t *testing.T) {
+	urlSvc, analyticsSvc := newMemoryServices(t)
+	handler := handlers.NewURLHandler(urlSvc, analyticsSvc, nil, nil, config.Default().QR)
+
+	req := models.ShortenURLRequest{OriginalURL: "https://shop.example.com/p?ref=mail#top", CustomCode: "shop", ClickIDParam: "cid"}
+	if _, err := urlSvc.ShortenURL(req, "alice", "127.0.0.1"); err != nil {
+		t.Fatalf("shorten failed: %v", err)
+	}
+
+	location, first := redirectClickID(t, handler, "shop", "cid")
+	if !strings.HasPrefix(first, "shop-") || location.Query().Get("ref") != "mail" || location.Fragment != "top" {
+		t.Fatalf("Expected the click ID next to the existing query, got %s", location)
+	}
+	_, second := redirectClickID(t, handler, "shop", "cid")
+	if second == first {
+		t.Errorf("Expected a new click ID per redirect, got %q twice", first)
+	}
+
+	analytics, err := analyticsSvc.GetAnalytics("shop", "alice")
+	if err != nil {
+		t.Fatalf("analytics failed: %v", err)
+	}
+	recorded := map[string]bool{}
+	for _, click := range analytics.RecentClicks {
+		recorded[click.ClickID] = true
+	}
+	if !recorded[first] || !recorded[second] {
+		t.Errorf("Expected the clicks to keep their IDs, got %v", recorded)
+	}
+
+	rr := postConversion(handler, models.ConversionRequest{ClickID: first, Value: 19.99, Currency: "eur"})
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var conversion models.Conversion
+	if err := json.NewDecoder(rr.Body).Decode(&conversion); err != nil || conversion.URLShortCode != "shop" || conversion.Currency != "EUR" {
+		t.Errorf("Expected a EUR conversion of shop, got %+v, %v", conversion, err)
+	}
+	if rr := postConversion(handler, models.ConversionRequest{ClickID: first}); rr.Code != http.StatusConflict {
+		t.Errorf("Expected 409 for a click that already converted, got %d", rr.Code)
+	}
+
+	for i := 0; i < 2; i++ {
+		pixel := httptest.NewRecorder()
+		handler.ConversionPixel(pixel, httptest.NewRequest("GET", "/api/v1/conversions/pixel.gif?click_id="+second+"&value=5&currency=USD", nil))
+		if pixel.Code != http.StatusOK || pixel.Header().Get("Content-Type") != "image/gif" {
+			t.Fatalf("Expected the pixel, got %d %q: %s", pixel.Code, pixel.Header().Get("Content-Type"), pixel.Body.String())
+		}
+		if img, err := gif.Decode(pixel.Body); err != nil || img.Bounds().Dx() != 1 || img.Bounds().Dy() != 1 {
+			t.Errorf("Expected a 1x1 GIF, got %v", err)
+		}
+	}
+
+	analytics, err = analyticsSvc.GetAnalytics("shop", "alice")
+	if err != nil {
+		t.Fatalf("analytics failed: %v", err)
+	}
+	if analytics.Conversions != 2 || analytics.ConversionRate != 1 {
+		t.Errorf("Expected 2 conversions of 2 clicks, got %d at %v", analytics.Conversions, analytics.ConversionRate)
+	}
+	if math.Abs(analytics.Revenue["EUR"]-19.99) > 1e-9 || analytics.Revenue["USD"] != 5 {
+		t.Errorf("Expected 19.99 EUR and 5 USD, got %v", analytics.Revenue)
+	}
+	today := analytics.ConversionsOverTime[len(analytics.ConversionsOverTime)-1]
+	if len(analytics.ConversionsOverTime) != 31 || today.Conversions != 2 || today.ConversionRate != 1 {
+		t.Errorf("Expected 30 empty days and today with 2 conversions of 2 clicks, got %+v", analytics.ConversionsOverTime)
+	}
+	byDay := analytics.ConversionsByDay[len(analytics.ConversionsByDay)-1]
+	if len(analytics.ConversionsByDay) != 31 || byDay.Date != today.Label || byDay.Conversions != 2 || byDay.ConversionRate != 1 || byDay.Revenue["USD"] != 5 {
+		t.Errorf("Expected conversions_by_day to match the day series, got %+v", analytics.ConversionsByDay)
+	}
+	if clicks := analytics.ClicksByDay[len(analytics.ClicksByDay)-1]; len(analytics.ClicksByDay) != 31 || clicks.Clicks != 2 {
+		t.Errorf("Expected clicks_by_day to end with today's 2 clicks, got %+v", analytics.ClicksByDay)
+	}
+}
+
+func TestConversionsRequireClickIDs(t *testing.T) {
+	urlSvc, analyticsSvc := newMemoryServices(t)
+	handler := handlers.NewURLHandler(urlSvc, analyticsSvc, nil, nil, config.Default().QR)
+
+	if _, err := urlSvc.ShortenURL(models.ShortenURLRequest{OriginalURL: "https://example.com/plain", CustomCode: "plain"}, "alice", "127.0.0.1"); err != nil {
+		t.Fatalf("shorten failed: %v", err)
+	}
+	req := mux.SetURLVars(httptest.NewRequest("GET", "/plain", nil), map[string]string{"shortCode": "plain"})
+	rr := httptest.NewRecorder()
+	handler.RedirectURL(rr, req)
+	if rr.Header().Get("Location") != "https://example.com/plain" {
+		t.Errorf("Expected no click ID without click_id_param, got %q", rr.Header().Get("Location"))
+	}
+
+	if _, err := urlSvc.ShortenURL(models.ShortenURLRequest{OriginalURL: "https://example.com", ClickIDParam: "click id"}, "alice", "127.0.0.1"); !errors.Is(err, services.ErrInvalidClickIDParam) {
+		t.Errorf("Expected ErrInvalidClickIDParam, got %v", err)
+	}
+	param := "gclid"
+	if _, err := urlSvc.UpdateURL("plain", models.UpdateURLRequest{ClickIDParam: &param}, "alice"); err != nil {
+		t.Fatalf("enabling click IDs failed: %v", err)
+	}
+	_, clickID := redirectClickID(t, handler, "plain", "gclid")
+
+	cases := []struct {
+		name string
+		req  models.ConversionRequest
+		code int
+	}{
+		{"missing click ID", models.ConversionRequest{}, http.StatusNotFound},
+		{"made-up click ID", models.ConversionRequest{ClickID: "plain-123"}, http.StatusNotFound},
+		{"forged click ID", models.ConversionRequest{ClickID: "plain-" + strings.Repeat("a", 16)}, http.StatusNotFound},
+		{"unknown link", models.ConversionRequest{ClickID: "nosuchlink-" + strings.Repeat("a", 16)}, http.StatusNotFound},
+		{"negative value", models.ConversionRequest{ClickID: clickID, Value: -1, Currency: "EUR"}, http.StatusBadRequest},
+		{"value without currency", models.ConversionRequest{ClickID: clickID, Value: 10}, http.StatusBadRequest},
+		{"bad currency", models.ConversionRequest{ClickID: clickID, Value: 10, Currency: "euro"}, http.StatusBadRequest},
+	}
+	for _, tc := range cases {
+		if rr := postConversion(handler, tc.req); rr.Code != tc.code {
+			t.Errorf("%s: got %d, want %d", tc.name, rr.Code, tc.code)
+		}
+	}
+
+	pixel := httptest.NewRecorder()
+	handler.ConversionPixel(pixel, httptest.NewRequest("GET", "/api/v1/conversions/pixel.gif?click_id="+clickID+"&value=lots", nil))
+	if pixel.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for a non-numeric pixel value, got %d", pixel.Code)
+	}
+}
+
+func TestConversionWaitsForQueuedClick(t *testing.T) {
+	urlSvc, analyticsSvc := newMemoryServices(t)
+	queue := services.NewClickQueue(analyticsSvc, config.ClickQueue{Workers: 1, BatchSize: 100, FlushInterval: 100 * time.Millisecond})
+	defer queue.Close(context.Background())
+	handler := handlers.NewURLHandler(urlSvc, analyticsSvc, queue, nil, config.Default().QR)
+
+	req := models.ShortenURLRequest{OriginalURL: "https://shop.example.com/", CustomCode: "queued", ClickIDParam: "cid"}
+	if _, err := urlSvc.ShortenURL(req, "alice", "127.0.0.1"); err != nil {
+		t.Fatalf("shorten failed: %v", err)
+	}
+
+	_, clickID := redirectClickID(t, handler, "queued", "cid")
+	if rr := postConversion(handler, models.ConversionRequest{ClickID: clickID}); rr.Code != http.StatusCreated {
+		t.Errorf("Expected the conversion to wait for its queued click, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr := postConversion(handler, models.ConversionRequest{ClickID: "queued-" + strings.Repeat("b", 16)}); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for a click ID that was never issued, got %d", rr.Code)
+	}
+}
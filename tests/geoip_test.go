@@ -0,0 +1,194 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"url-shortener/internal/geoip"
+	"url-shortener/internal/services"
+	"url-shortener/internal/store"
+)
+
+// mmdbValue encodes a value in the MaxMind DB data format. Only the types
+// and sizes (under 285 bytes) the fixture needs are supported.
+func mmdbValue(v interface{}) []byte {
+	var buf bytes.Buffer
+	switch v := v.(type) {
+	case string:
+		if len(v) < 29 {
+			buf.WriteByte(2<<5 | byte(len(v)))
+		} else {
+			buf.Write([]byte{2<<5 | 29, byte(len(v) - 29)})
+		}
+		buf.WriteString(v)
+	case uint16:
+		buf.WriteByte(5<<5 | 2)
+		binary.Write(&buf, binary.BigEndian, v)
+	case uint32:
+		buf.WriteByte(6<<5 | 4)
+		binary.Write(&buf, binary.BigEndian, v)
+	case []interface{}:
+		buf.Write([]byte{byte(len(v)), 11 - 7})
+		for _, item := range v {
+			buf.Write(mmdbValue(item))
+		}
+	case [][2]interface{}:
+		buf.WriteByte(7<<5 | byte(len(v)))
+		for _, pair := range v {
+			buf.Write(mmdbValue(pair[0]))
+			buf.Write(mmdbValue(pair[1]))
+		}
+	default:
+		panic("unsupported mmdb value")
+	}
+	return buf.Bytes()
+}
+
+// writeTestMMDB writes an IPv4 database with one record for network/prefix,
+// using 24-bit records.
+func writeTestMMDB(t *testing.T, network net.IP, prefix int, record [][2]interface{}) string {
+	t.Helper()
+
+	nodeCount := uint32(prefix)
+	ip := network.To4()
+
+	var tree bytes.Buffer
+	for i := 0; i < prefix; i++ {
+		next := uint32(i + 1)
+		if i == prefix-1 {
+			next = nodeCount + 16 // first byte of the data section
+		}
+		records := [2]uint32{nodeCount, nodeCount}
+		records[ip[i/8]>>(7-uint(i%8))&1] = next
+		for _, r := range records {
+			tree.Write([]byte{byte(r >> 16), byte(r >> 8), byte(r)})
+		}
+	}
+
+	var file bytes.Buffer
+	file.Write(tree.Bytes())
+	file.Write(make([]byte, 16))
+	file.Write(mmdbValue(record))
+	file.WriteString("\xAB\xCD\xEFMaxMind.com")
+	file.Write(mmdbValue([][2]interface{}{
+		{"binary_format_major_version", uint16(2)},
+		{"binary_format_minor_version", uint16(0)},
+		{"database_type", "Test-City"},
+		{"ip_version", uint16(4)},
+		{"node_count", nodeCount},
+		{"record_size", uint16(24)},
+	}))
+
+	path := filepath.Join(t.TempDir(), "test.mmdb")
+	if err := os.WriteFile(path, file.Bytes(), 0o644); err != nil {
+		t.Fatalf("write mmdb failed: %v", err)
+	}
+	return path
+}
+
+func names(en string) [][2]interface{} {
+	return [][2]interface{}{{"names", [][2]interface{}{{"en", en}}}}
+}
+
+func TestMMDBProvider(t *testing.T) {
+	path := writeTestMMDB(t, net.ParseIP("81.2.69.0"), 24, [][2]interface{}{
+		{"city", names("London")},
+		{"continent", [][2]interface{}{{"code", "EU"}}},
+		{"country", append(names("United Kingdom"), [2]interface{}{"iso_code", "GB"})},
+		{"subdivisions", []interface{}{names("England")}},
+		{"autonomous_system_number", uint32(20712)},
+		{"autonomous_system_organization", "Andrews & Arnold Ltd"},
+	})
+
+	p, err := geoip.OpenMMDB(path, "")
+	if err != nil {
+		t.Fatalf("open mmdb failed: %v", err)
+	}
+	defer p.Close()
+
+	got := p.Lookup("81.2.69.142")
+	want := geoip.Location{
+		Country:       "United Kingdom",
+		CountryCode:   "GB",
+		ContinentCode: "EU",
+		Region:        "England",
+		City:          "London",
+		ASN:           20712,
+		ASOrg:         "Andrews & Arnold Ltd",
+	}
+	if got != want {
+		t.Errorf("lookup = %+v, want %+v", got, want)
+	}
+
+	for _, ip := range []string{"8.8.8.8", "not-an-ip", "2001:db8::1"} {
+		if got := p.Lookup(ip); got != geoip.UnknownLocation() {
+			t.Errorf("lookup %q = %+v, want unknown", ip, got)
+		}
+	}
+
+	if _, err := geoip.OpenMMDB(filepath.Join(t.TempDir(), "missing.mmdb"), ""); err == nil {
+		t.Error("expected error for a missing database")
+	}
+}
+
+func TestIPAPIProvider(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/json/8.8.8.8" || !strings.Contains(r.URL.Query().Get("fields"), "continentCode") {
+			t.Errorf("unexpected request %s", r.URL)
+		}
+		w.Write([]byte(`{"status":"success","country":"United States","countryCode":"US","continentCode":"NA","regionName":"California","city":"Mountain View","as":"AS15169 Google LLC"}`))
+	}))
+	defer server.Close()
+
+	got := geoip.NewIPAPIProvider(server.URL+"/json/", time.Second).Lookup("8.8.8.8")
+	want := geoip.Location{
+		Country:       "United States",
+		CountryCode:   "US",
+		ContinentCode: "NA",
+		Region:        "California",
+		City:          "Mountain View",
+		ASN:           15169,
+		ASOrg:         "Google LLC",
+	}
+	if got != want {
+		t.Errorf("lookup = %+v, want %+v", got, want)
+	}
+}
+
+func TestGetLocationFromIP(t *testing.T) {
+	s := store.NewMemoryStore()
+
+	analyticsSvc := services.NewAnalyticsService(s, s, nil, nil)
+	if got := analyticsSvc.GetLocationFromIP("203.0.113.9"); got != geoip.UnknownLocation() {
+		t.Errorf("without a provider: got %+v, want unknown", got)
+	}
+	if got := analyticsSvc.GetLocationFromIP("127.0.0.1"); got.Country != "Local" {
+		t.Errorf("loopback country = %q, want Local", got.Country)
+	}
+	if got := analyticsSvc.GetLocationFromIP("192.168.1.5"); got.Country != "Local Network" {
+		t.Errorf("private country = %q, want Local Network", got.Country)
+	}
+
+	path := writeTestMMDB(t, net.ParseIP("203.0.113.0"), 24, [][2]interface{}{
+		{"country", names("Norway")},
+	})
+	p, err := geoip.OpenMMDB(path, "")
+	if err != nil {
+		t.Fatalf("open mmdb failed: %v", err)
+	}
+	defer p.Close()
+
+	analyticsSvc = services.NewAnalyticsService(s, s, nil, p)
+	got := analyticsSvc.GetLocationFromIP("203.0.113.9")
+	if got.Country != "Norway" || got.City != geoip.Unknown || got.Region != geoip.Unknown {
+		t.Errorf("got %+v, want Norway with unknown region and city", got)
+	}
+}
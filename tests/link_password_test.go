@@ -0,0 +1,252 @@
+package tests
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"url-shortener/internal/config"
+	"url-shortener/internal/handlers"
+	"url-shortener/internal/models"
+	"url-shortener/internal/services"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func newUnlockRouter(t *testing.T, cfg config.Unlock) (*mux.Router, *services.URLService, *services.AnalyticsService) {
+	t.Helper()
+	urlSvc, analyticsSvc := newMemoryServices(t)
+	handler := handlers.NewURLHandler(urlSvc, analyticsSvc, nil, services.NewUnlockService(cfg), config.Default().QR)
+
+	router := mux.NewRouter()
+	router.HandleFunc("/{shortCode}", handler.RedirectURL).Methods("GET")
+	router.HandleFunc("/{shortCode}", handler.UnlockURL).Methods("POST")
+	return router, urlSvc, analyticsSvc
+}
+
+func postPassword(router http.Handler, shortCode, password string) *httptest.ResponseRecorder {
+	form := url.Values{"password": {password}}
+	req := httptest.NewRequest("POST", "/"+shortCode, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestShortenWithPassword(t *testing.T) {
+	svc, _ := newMemoryServices(t)
+
+	req := models.ShortenURLRequest{OriginalURL: "https://example.com/doc", CustomCode: "secret", Password: "hunter22"}
+	created, err := svc.ShortenURL(req, "alice", "127.0.0.1")
+	if err != nil {
+		t.Fatalf("shorten failed: %v", err)
+	}
+	if !created.PasswordProtected {
+		t.Error("link should be password protected")
+	}
+	if created.PasswordHash == "" || created.PasswordHash == req.Password {
+		t.Errorf("password should be stored hashed, got %q", created.PasswordHash)
+	}
+
+	body, _ := json.Marshal(created)
+	if strings.Contains(string(body), created.PasswordHash) {
+		t.Error("JSON must not expose the password hash")
+	}
+
+	short := models.ShortenURLRequest{OriginalURL: "https://example.com/doc", Password: "abc"}
+	if _, err := svc.ShortenURL(short, "alice", "127.0.0.1"); !errors.Is(err, services.ErrInvalidLinkPassword) {
+		t.Errorf("short password: got %v, want ErrInvalidLinkPassword", err)
+	}
+}
+
+func TestPasswordProtectedRedirect(t *testing.T) {
+	router, urlSvc, analyticsSvc := newUnlockRouter(t, config.Unlock{Secret: "0123456789abcdef"})
+
+	req := models.ShortenURLRequest{OriginalURL: "https://example.com/doc", CustomCode: "locked", Password: "hunter22"}
+	if _, err := urlSvc.ShortenURL(req, "alice", "127.0.0.1"); err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/locked", nil))
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `name="password"`) {
+		t.Fatalf("expected the unlock form, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr.Header().Get("Location") != "" {
+		t.Error("protected link must not redirect before unlocking")
+	}
+
+	if rr := postPassword(router, "locked", "wrong"); rr.Code != http.StatusUnauthorized {
+		t.Errorf("wrong password: status = %d, want %d", rr.Code, http.StatusUnauthorized)
+	}
+
+	rr = postPassword(router, "locked", "hunter22")
+	if rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != "/locked" {
+		t.Fatalf("right password: got %d to %q, want 303 to /locked", rr.Code, rr.Header().Get("Location"))
+	}
+	cookies := rr.Result().Cookies()
+	if len(cookies) != 1 || !cookies[0].HttpOnly || cookies[0].Path != "/locked" {
+		t.Fatalf("expected one HttpOnly unlock cookie for /locked, got %+v", cookies)
+	}
+
+	get := httptest.NewRequest("GET", "/locked", nil)
+	get.AddCookie(cookies[0])
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, get)
+	if rr.Code != http.StatusFound || rr.Header().Get("Location") != req.OriginalURL {
+		t.Fatalf("unlocked link: got %d to %q", rr.Code, rr.Header().Get("Location"))
+	}
+	if cc := rr.Header().Get("Cache-Control"); !strings.Contains(cc, "no-store") {
+		t.Errorf("Cache-Control = %q, protected redirects must not be cached", cc)
+	}
+
+	tampered := *cookies[0]
+	tampered.Value += "x"
+	get = httptest.NewRequest("GET", "/locked", nil)
+	get.AddCookie(&tampered)
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, get)
+	if rr.Code != http.StatusOK || rr.Header().Get("Location") != "" {
+		t.Errorf("tampered cookie should show the form, got %d", rr.Code)
+	}
+
+	analytics, err := analyticsSvc.GetAnalytics("locked", "alice")
+	if err != nil {
+		t.Fatalf("analytics failed: %v", err)
+	}
+	if analytics.TotalClicks != 1 {
+		t.Errorf("total clicks = %d, want 1", analytics.TotalClicks)
+	}
+	if analytics.UnlockAttempts != 2 || analytics.FailedUnlockAttempts != 1 {
+		t.Errorf("unlock attempts = %d (%d failed), want 2 (1 failed)",
+			analytics.UnlockAttempts, analytics.FailedUnlockAttempts)
+	}
+}
+
+func TestUnlockThrottling(t *testing.T) {
+	router, urlSvc, analyticsSvc := newUnlockRouter(t, config.Unlock{MaxAttempts: 2, Window: time.Hour})
+
+	req := models.ShortenURLRequest{OriginalURL: "https://example.com/doc", CustomCode: "guess", Password: "hunter22"}
+	if _, err := urlSvc.ShortenURL(req, "alice", "127.0.0.1"); err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if rr := postPassword(router, "guess", "wrong"); rr.Code != http.StatusUnauthorized {
+			t.Fatalf("attempt %d: status = %d, want %d", i+1, rr.Code, http.StatusUnauthorized)
+		}
+	}
+
+	if rr := postPassword(router, "guess", "hunter22"); rr.Code != http.StatusTooManyRequests {
+		t.Errorf("throttled attempt: status = %d, want %d", rr.Code, http.StatusTooManyRequests)
+	}
+
+	// Without a trusted proxy, X-Forwarded-For does not make a new visitor.
+	form := url.Values{"password": {"hunter22"}}
+	spoofed := httptest.NewRequest("POST", "/guess", strings.NewReader(form.Encode()))
+	spoofed.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	spoofed.Header.Set("X-Forwarded-For", "203.0.113.7")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, spoofed)
+	if rr.Code != http.StatusTooManyRequests {
+		t.Errorf("spoofed X-Forwarded-For: status = %d, want %d", rr.Code, http.StatusTooManyRequests)
+	}
+
+	analytics, err := analyticsSvc.GetAnalytics("guess", "alice")
+	if err != nil {
+		t.Fatalf("analytics failed: %v", err)
+	}
+	if analytics.UnlockAttempts != 2 {
+		t.Errorf("unlock attempts = %d, want 2; throttled submissions are not checked", analytics.UnlockAttempts)
+	}
+}
+
+func TestUnlockTokens(t *testing.T) {
+	t.Parallel()
+	unlocker := services.NewUnlockService(config.Unlock{Secret: "0123456789abcdef"})
+	link := &models.URL{ShortCode: "doc", PasswordHash: "hash-1"}
+
+	token := unlocker.Token(link, time.Now().Add(time.Hour))
+	if !unlocker.Verify(link, token) {
+		t.Error("fresh token should verify")
+	}
+
+	if unlocker.Verify(&models.URL{ShortCode: "other", PasswordHash: "hash-1"}, token) {
+		t.Error("token must not unlock another link")
+	}
+	if unlocker.Verify(&models.URL{ShortCode: "doc", PasswordHash: "hash-2"}, token) {
+		t.Error("token must not survive a password change")
+	}
+	if unlocker.Verify(link, unlocker.Token(link, time.Now().Add(-time.Second))) {
+		t.Error("expired token must not verify")
+	}
+
+	other := services.NewUnlockService(config.Unlock{Secret: "fedcba9876543210"})
+	if other.Verify(link, token) {
+		t.Error("token signed with another secret must not verify")
+	}
+}
+
+func TestUnlockParallelGuesses(t *testing.T) {
+	t.Parallel()
+	unlocker := services.NewUnlockService(config.Unlock{MaxAttempts: 3, Window: time.Hour})
+	hash, err := bcrypt.GenerateFromPassword([]byte("hunter22"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("hash failed: %v", err)
+	}
+	link := &models.URL{ShortCode: "doc", PasswordHash: string(hash)}
+
+	var wg sync.WaitGroup
+	results := make(chan error, 20)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results <- unlocker.CheckPassword(link, "wrong", "198.51.100.1")
+		}()
+	}
+	wg.Wait()
+	close(results)
+
+	checked := 0
+	for err := range results {
+		if errors.Is(err, services.ErrWrongPassword) {
+			checked++
+		} else if !errors.Is(err, services.ErrTooManyAttempts) {
+			t.Errorf("unexpected error %v", err)
+		}
+	}
+	if checked != 3 {
+		t.Errorf("checked %d parallel guesses, want 3", checked)
+	}
+}
+
+func TestUnlockVisitorIP(t *testing.T) {
+	t.Parallel()
+	unlocker := services.NewUnlockService(config.Unlock{TrustedProxies: "10.0.0.0/8, 192.0.2.1"})
+
+	cases := []struct {
+		remoteAddr, forwardedFor, want string
+	}{
+		{"198.51.100.1:4000", "", "198.51.100.1"},
+		{"198.51.100.1:4000", "203.0.113.7", "198.51.100.1"},
+		{"[2001:db8::1]:4000", "203.0.113.7", "2001:db8::1"},
+		{"192.0.2.1:4000", "203.0.113.7", "203.0.113.7"},
+		{"192.0.2.1:4000", "203.0.113.7, 10.1.2.3", "203.0.113.7"},
+		{"192.0.2.1:4000", "1.2.3.4, 203.0.113.7, 10.1.2.3", "203.0.113.7"},
+		{"192.0.2.1:4000", "not-an-ip", "192.0.2.1"},
+		{"192.0.2.1:4000", "", "192.0.2.1"},
+	}
+	for _, tc := range cases {
+		if got := unlocker.VisitorIP(tc.remoteAddr, tc.forwardedFor); got != tc.want {
+			t.Errorf("VisitorIP(%q, %q) = %q, want %q", tc.remoteAddr, tc.forwardedFor, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,439 @@
+package tests
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"url-shortener/internal/migrations"
+)
+
+func openTempSQLite(t *testing.T) *sql.DB {
+	t.Helper()
+
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "migrate.db"))
+	if err != nil {
+		t.Fatalf("open db failed: %v", err)
+	}
+	return db
+}
+
+func tableExists(t *testing.T, db *sql.DB, name string) bool {
+	t.Helper()
+
+	var count int
+	err := db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?`, name).Scan(&count)
+	if err != nil {
+		t.Fatalf("inspect schema failed: %v", err)
+	}
+	return count > 0
+}
+
+func TestMigrationsUpDownStatus(t *testing.T) {
+	db := openTempSQLite(t)
+	defer db.Close()
+
+	migrator := migrations.New(db)
+
+	applied, err := migrator.Up()
+	if err != nil {
+		t.Fatalf("up failed: %v", err)
+	}
+	if len(applied) != len(migrations.All) {
+		t.Errorf("applied %d migrations, want %d", len(applied), len(migrations.All))
+	}
+
+	again, err := migrator.Up()
+	if err != nil || len(again) != 0 {
+		t.Errorf("second up applied %d, err %v; want 0", len(again), err)
+	}
+
+	statuses, err := migrator.Status()
+	if err != nil {
+		t.Fatalf("status failed: %v", err)
+	}
+	for _, status := range statuses {
+		if status.AppliedAt == nil {
+			t.Errorf("migration %d should be applied", status.Version)
+		}
+	}
+
+	if _, err := migrator.Down(len(migrations.All)); err != nil {
+		t.Fatalf("down failed: %v", err)
+	}
+	if tableExists(t, db, "urls") || tableExists(t, db, "api_keys") {
+		t.Error("rolling back every migration should drop the tables")
+	}
+
+	if _, err := migrator.Up(); err != nil {
+		t.Fatalf("up after full rollback failed: %v", err)
+	}
+	if !tableExists(t, db, "team_members") {
+		t.Error("team_members table should exist after re-applying")
+	}
+}
+
+func TestMigrationsDownSteps(t *testing.T) {
+	db := openTempSQLite(t)
+	defer db.Close()
+
+	migrator := migrations.New(db)
+	if _, err := migrator.Up(); err != nil {
+		t.Fatalf("up failed: %v", err)
+	}
+
+	rolledBack, err := migrator.Down(1)
+	if err != nil {
+		t.Fatalf("down failed: %v", err)
+	}
+	latest := migrations.All[len(migrations.All)-1]
+	if len(rolledBack) != 1 || rolledBack[0].Version != latest.Version {
+		t.Fatalf("rolled back %+v, want only version %d", rolledBack, latest.Version)
+	}
+
+	statuses, _ := migrator.Status()
+	for _, status := range statuses {
+		pending := status.AppliedAt == nil
+		if pending != (status.Version == latest.Version) {
+			t.Errorf("migration %d pending = %v", status.Version, pending)
+		}
+	}
+}
+
+func TestMigrationParsesExistingUserAgents(t *testing.T) {
+	db := openTempSQLite(t)
+	defer db.Close()
+
+	migrator := migrations.New(db)
+	if _, err := migrator.Up(); err != nil {
+		t.Fatalf("up failed: %v", err)
+	}
+	if _, err := migrator.Down(rollbackCount(t, "add_click_user_agent_fields")); err != nil {
+		t.Fatalf("down failed: %v", err)
+	}
+
+	if _, err := db.Exec(`INSERT INTO urls (short_code, original_url) VALUES ('old', 'https://example.com')`); err != nil {
+		t.Fatalf("insert url failed: %v", err)
+	}
+	for _, ua := range []string{androidUA, "Twitterbot/1.0"} {
+		if _, err := db.Exec(`INSERT INTO clicks (url_short_code, ip_address, user_agent) VALUES ('old', '1.1.1.1', ?)`, ua); err != nil {
+			t.Fatalf("insert click failed: %v", err)
+		}
+	}
+	if _, err := migrator.Up(); err != nil {
+		t.Fatalf("up failed: %v", err)
+	}
+
+	rows, err := db.Query(`SELECT browser, COALESCE(browser_version, ''), os, device, is_bot FROM clicks ORDER BY id`)
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	defer rows.Close()
+	var got []string
+	for rows.Next() {
+		var browser, version, os, device string
+		var bot bool
+		if err := rows.Scan(&browser, &version, &os, &device, &bot); err != nil {
+			t.Fatalf("scan failed: %v", err)
+		}
+		got = append(got, fmt.Sprintf("%s %s %s %s %v", browser, version, os, device, bot))
+	}
+	want := []string{"chrome 120 android mobile false", "other  other desktop true"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected the existing clicks to be parsed as %q, got %q", want, got)
+	}
+}
+
+func TestMigrationClassifiesExistingReferrers(t *testing.T) {
+	db := openTempSQLite(t)
+	defer db.Close()
+
+	migrator := migrations.New(db)
+	if _, err := migrator.Up(); err != nil {
+		t.Fatalf("up failed: %v", err)
+	}
+	if _, err := migrator.Down(rollbackCount(t, "add_click_referrers")); err != nil {
+		t.Fatalf("down failed: %v", err)
+	}
+
+	if _, err := db.Exec(`INSERT INTO urls (short_code, original_url) VALUES ('old', 'https://shop.example.com/p')`); err != nil {
+		t.Fatalf("insert url failed: %v", err)
+	}
+	for _, referer := range []string{"", "https://www.google.com/search?q=x", "https://shop.example.com/cart", "https://blog.example.org/post"} {
+		if _, err := db.Exec(`INSERT INTO clicks (url_short_code, ip_address, referer) VALUES ('old', '1.1.1.1', ?)`, referer); err != nil {
+			t.Fatalf("insert click failed: %v", err)
+		}
+	}
+	if _, err := migrator.Up(); err != nil {
+		t.Fatalf("up failed: %v", err)
+	}
+
+	rows, err := db.Query(`SELECT COALESCE(referrer_host, ''), referrer_category FROM clicks ORDER BY id`)
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	defer rows.Close()
+	var got []string
+	for rows.Next() {
+		var host, category string
+		if err := rows.Scan(&host, &category); err != nil {
+			t.Fatalf("scan failed: %v", err)
+		}
+		got = append(got, strings.TrimSpace(host+" "+category))
+	}
+	want := []string{"direct", "google.com search", "shop.example.com internal", "blog.example.org other"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected the existing clicks to be classified as %q, got %q", want, got)
+	}
+}
+
+func TestMigrationClassifiesExistingBots(t *testing.T) {
+	db := openTempSQLite(t)
+	defer db.Close()
+
+	migrator := migrations.New(db)
+	if _, err := migrator.Up(); err != nil {
+		t.Fatalf("up failed: %v", err)
+	}
+	if _, err := migrator.Down(rollbackCount(t, "add_clicks_bot_kind")); err != nil {
+		t.Fatalf("down failed: %v", err)
+	}
+
+	if _, err := db.Exec(`INSERT INTO urls (short_code, original_url) VALUES ('old', 'https://example.com')`); err != nil {
+		t.Fatalf("insert url failed: %v", err)
+	}
+	// The last click is a HEAD request or prefetch from a browser, which
+	// its User-Agent cannot tell.
+	for _, click := range []struct {
+		ua  string
+		bot bool
+	}{{androidUA, false}, {"Twitterbot/1.0", true}, {"curl/8.4.0", true}, {macUA, true}} {
+		if _, err := db.Exec(`INSERT INTO clicks (url_short_code, ip_address, user_agent, is_bot) VALUES ('old', '1.1.1.1', ?, ?)`, click.ua, click.bot); err != nil {
+			t.Fatalf("insert click failed: %v", err)
+		}
+	}
+	if _, err := migrator.Up(); err != nil {
+		t.Fatalf("up failed: %v", err)
+	}
+
+	rows, err := db.Query(`SELECT COALESCE(bot_kind, '') FROM clicks ORDER BY id`)
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	defer rows.Close()
+	var got []string
+	for rows.Next() {
+		var kind string
+		if err := rows.Scan(&kind); err != nil {
+			t.Fatalf("scan failed: %v", err)
+		}
+		got = append(got, kind)
+	}
+	want := []string{"", "unfurler", "library", ""}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected the existing bots to be classified as %q, got %q", want, got)
+	}
+}
+
+func TestMigrationRollsUpExistingClicks(t *testing.T) {
+	db := openTempSQLite(t)
+	defer db.Close()
+
+	migrator := migrations.New(db)
+	if _, err := migrator.Up(); err != nil {
+		t.Fatalf("up failed: %v", err)
+	}
+	if _, err := migrator.Down(rollbackCount(t, "add_click_rollups")); err != nil {
+		t.Fatalf("down failed: %v", err)
+	}
+	if tableExists(t, db, "click_rollups_hourly") {
+		t.Fatal("Expected the rollups to be dropped")
+	}
+
+	if _, err := db.Exec(`INSERT INTO urls (short_code, original_url) VALUES ('old', 'https://example.com')`); err != nil {
+		t.Fatalf("insert url failed: %v", err)
+	}
+	for _, click := range []struct {
+		ip, country, at string
+		bot             bool
+	}{
+		{"1.1.1.1", "NO", "2025-01-01 10:15:00", false},
+		{"2.2.2.2", "NO", "2025-01-01 10:45:00", false},
+		{"1.1.1.1", "", "2025-01-01 11:05:00", false},
+		{"3.3.3.3", "US", "2025-01-02 00:00:00.5 +0000 UTC", true},
+	} {
+		country := sql.NullString{String: click.country, Valid: click.country != ""}
+		if _, err := db.Exec(`INSERT INTO clicks (url_short_code, ip_address, country, clicked_at, is_bot) VALUES ('old', ?, ?, ?, ?)`, click.ip, country, click.at, click.bot); err != nil {
+			t.Fatalf("insert click failed: %v", err)
+		}
+	}
+	if _, err := migrator.Up(); err != nil {
+		t.Fatalf("up failed: %v", err)
+	}
+
+	rollups := func(table string) []string {
+		rows, err := db.Query(`SELECT bucket, is_bot, country, clicks FROM ` + table + ` ORDER BY bucket, country`)
+		if err != nil {
+			t.Fatalf("query %s failed: %v", table, err)
+		}
+		defer rows.Close()
+		var got []string
+		for rows.Next() {
+			var bucket int64
+			var bot bool
+			var country string
+			var clicks int
+			if err := rows.Scan(&bucket, &bot, &country, &clicks); err != nil {
+				t.Fatalf("scan failed: %v", err)
+			}
+			got = append(got, fmt.Sprintf("%d %v %s %d", bucket, bot, country, clicks))
+		}
+		return got
+	}
+	wantHourly := []string{"1735725600 false NO 2", "1735729200 false Unknown 1", "1735776000 true US 1"}
+	if got := rollups("click_rollups_hourly"); !reflect.DeepEqual(got, wantHourly) {
+		t.Errorf("Expected hourly rollups %q, got %q", wantHourly, got)
+	}
+	wantDaily := []string{"1735689600 false NO 2", "1735689600 false Unknown 1", "1735776000 true US 1"}
+	if got := rollups("click_rollups_daily"); !reflect.DeepEqual(got, wantDaily) {
+		t.Errorf("Expected daily rollups %q, got %q", wantDaily, got)
+	}
+
+	var visitors int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM click_visitors_daily WHERE bucket = 1735689600`).Scan(&visitors); err != nil || visitors != 2 {
+		t.Errorf("Expected 2 visitors on the first day, got %d, %v", visitors, err)
+	}
+}
+
+func TestMigrationRollsUpClickDimensions(t *testing.T) {
+	db := openTempSQLite(t)
+	defer db.Close()
+
+	migrator := migrations.New(db)
+	if _, err := migrator.Up(); err != nil {
+		t.Fatalf("up failed: %v", err)
+	}
+	if _, err := migrator.Down(rollbackCount(t, "add_click_dimension_rollups")); err != nil {
+		t.Fatalf("down failed: %v", err)
+	}
+	if tableExists(t, db, "click_dimensions_daily") {
+		t.Fatal("Expected the dimension rollups to be dropped")
+	}
+
+	if _, err := db.Exec(`INSERT INTO urls (short_code, original_url) VALUES ('old', 'https://example.com')`); err != nil {
+		t.Fatalf("insert url failed: %v", err)
+	}
+	for _, click := range []struct {
+		ip, rule, variant, browser, category, at string
+	}{
+		{"1.1.1.1", "ios", "a", "safari", "search", "2025-01-01 10:15:00"},
+		{"2.2.2.2", "", "a", "chrome", "direct", "2025-01-01 10:45:00"},
+		{"1.1.1.1", "ios", "b", "", "", "2025-01-01 11:05:00"},
+	} {
+		null := func(s string) sql.NullString { return sql.NullString{String: s, Valid: s != ""} }
+		if _, err := db.Exec(`INSERT INTO clicks (url_short_code, ip_address, target_rule, variant, browser, os, device, referrer_category, clicked_at, is_bot) VALUES ('old', ?, ?, ?, ?, ?, ?, ?, ?, FALSE)`,
+			click.ip, null(click.rule), null(click.variant), null(click.browser), null(click.browser), null(click.browser), null(click.category), click.at); err != nil {
+			t.Fatalf("insert click failed: %v", err)
+		}
+	}
+	if _, err := migrator.Up(); err != nil {
+		t.Fatalf("up failed: %v", err)
+	}
+
+	dimensions := func(table string) []string {
+		rows, err := db.Query(`SELECT bucket, dimension, value, clicks FROM ` + table + ` ORDER BY bucket, dimension, value`)
+		if err != nil {
+			t.Fatalf("query %s failed: %v", table, err)
+		}
+		defer rows.Close()
+		var got []string
+		for rows.Next() {
+			var bucket int64
+			var dimension, value string
+			var clicks int
+			if err := rows.Scan(&bucket, &dimension, &value, &clicks); err != nil {
+				t.Fatalf("scan failed: %v", err)
+			}
+			got = append(got, fmt.Sprintf("%d %s=%s %d", bucket, dimension, value, clicks))
+		}
+		return got
+	}
+	wantDaily := []string{
+		"1735689600 browser=chrome 1", "1735689600 browser=safari 1",
+		"1735689600 device=chrome 1", "1735689600 device=safari 1",
+		"1735689600 os=chrome 1", "1735689600 os=safari 1",
+		"1735689600 referrer_category=direct 1", "1735689600 referrer_category=search 1",
+		"1735689600 rule= 1", "1735689600 rule=ios 2",
+		"1735689600 variant=a 2", "1735689600 variant=b 1",
+	}
+	if got := dimensions("click_dimensions_daily"); !reflect.DeepEqual(got, wantDaily) {
+		t.Errorf("Expected daily dimension rollups %q, got %q", wantDaily, got)
+	}
+	if got := dimensions("click_dimensions_hourly"); len(got) != 13 || got[len(got)-1] != "1735729200 variant=b 1" {
+		t.Errorf("Expected the last click in its own hour, got %q", got)
+	}
+
+	var visitors int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM click_variant_visitors_daily WHERE variant = 'a'`).Scan(&visitors); err != nil || visitors != 2 {
+		t.Errorf("Expected 2 visitors of variant a, got %d, %v", visitors, err)
+	}
+}
+
+// rollbackCount returns how many migrations Down must roll back to undo
+// the named migration.
+func rollbackCount(t *testing.T, name string) int {
+	t.Helper()
+	for i, m := range migrations.All {
+		if m.Name == name {
+			return len(migrations.All) - i
+		}
+	}
+	t.Fatalf("no migration named %s", name)
+	return 0
+}
+
+func TestMigrationFailureRollsBack(t *testing.T) {
+	db := openTempSQLite(t)
+	defer db.Close()
+
+	failing := []migrations.Migration{
+		{
+			Version: 1,
+			Name:    "create_a",
+			Up: func(tx *sql.Tx) error {
+				_, err := tx.Exec(`CREATE TABLE a (id INTEGER)`)
+				return err
+			},
+			Down: func(tx *sql.Tx) error { return nil },
+		},
+		{
+			Version: 2,
+			Name:    "half_done",
+			Up: func(tx *sql.Tx) error {
+				if _, err := tx.Exec(`CREATE TABLE b (id INTEGER)`); err != nil {
+					return err
+				}
+				return errors.New("boom")
+			},
+			Down: func(tx *sql.Tx) error { return nil },
+		},
+	}
+
+	applied, err := migrations.NewWithMigrations(db, failing).Up()
+	if err == nil {
+		t.Fatal("expected the second migration to fail")
+	}
+	if len(applied) != 1 {
+		t.Errorf("applied %d migrations, want 1", len(applied))
+	}
+	if !tableExists(t, db, "a") {
+		t.Error("first migration should stay applied")
+	}
+	if tableExists(t, db, "b") {
+		t.Error("failed migration should be rolled back")
+	}
+}
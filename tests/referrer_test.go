@@ -0,0 +1,93 @@
+package tests
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"url-shortener/internal/config"
+	"url-shortener/internal/handlers"
+	"url-shortener/internal/models"
+	"url-shortener/internal/referrer"
+
+	"github.com/gorilla/mux"
+)
+
+func TestParseReferrer(t *testing.T) {
+	cases := []struct {
+		referer string
+		want    referrer.Source
+	}{
+		{"", referrer.Source{Category: referrer.Direct}},
+		{"not a url\x7f", referrer.Source{Category: referrer.Direct}},
+		{"https://www.google.com/search?q=shortener", referrer.Source{Host: "google.com", Category: referrer.Search}},
+		{"https://www.google.co.uk/", referrer.Source{Host: "google.co.uk", Category: referrer.Search}},
+		{"https://duckduckgo.com/", referrer.Source{Host: "duckduckgo.com", Category: referrer.Search}},
+		{"android-app://com.google.android.googlequicksearchbox/", referrer.Source{Host: "com.google.android.googlequicksearchbox", Category: referrer.Search}},
+		{"https://mail.google.com/mail/u/0/", referrer.Source{Host: "mail.google.com", Category: referrer.Email}},
+		{"https://outlook.live.com/", referrer.Source{Host: "outlook.live.com", Category: referrer.Email}},
+		{"https://t.co/abc123", referrer.Source{Host: "t.co", Category: referrer.Social}},
+		{"https://m.facebook.com/", referrer.Source{Host: "facebook.com", Category: referrer.Social}},
+		{"https://old.reddit.com/r/golang", referrer.Source{Host: "old.reddit.com", Category: referrer.Social}},
+		{"https://WWW.Example.org:8443/post", referrer.Source{Host: "example.org", Category: referrer.Other}},
+		{"https://notfacebook.com/", referrer.Source{Host: "notfacebook.com", Category: referrer.Other}},
+	}
+	for _, tc := range cases {
+		if got := referrer.Parse(tc.referer); got != tc.want {
+			t.Errorf("Parse(%q) = %+v, want %+v", tc.referer, got, tc.want)
+		}
+	}
+
+	if got := referrer.Parse("https://sho.rt/abc", "sho.rt:8080"); got.Category != referrer.Internal {
+		t.Errorf("Expected the shortener's own host to be internal, got %+v", got)
+	}
+	if got := referrer.Parse("https://www.shop.example.com/cart", "https://shop.example.com/p?x=1"); got.Category != referrer.Internal {
+		t.Errorf("Expected the destination's host to be internal, got %+v", got)
+	}
+}
+
+func TestClicksByReferrer(t *testing.T) {
+	urlSvc, analyticsSvc := newMemoryServices(t)
+	handler := handlers.NewURLHandler(urlSvc, analyticsSvc, nil, nil, config.Default().QR)
+
+	if _, err := urlSvc.ShortenURL(models.ShortenURLRequest{OriginalURL: "https://shop.example.org/p", CustomCode: "sources"}, "alice", "127.0.0.1"); err != nil {
+		t.Fatalf("shorten failed: %v", err)
+	}
+	// httptest requests are made to example.com, the shortener's host here.
+	for _, referer := range []string{
+		"",
+		"https://www.google.com/search?q=shop",
+		"https://www.google.de/",
+		"https://mail.google.com/mail/u/0/",
+		"https://t.co/xyz",
+		"https://shop.example.org/cart",
+		"https://example.com/dashboard",
+		"https://blog.example.net/review",
+	} {
+		req := mux.SetURLVars(httptest.NewRequest("GET", "/sources", nil), map[string]string{"shortCode": "sources"})
+		req.Header.Set("Referer", referer)
+		handler.RedirectURL(httptest.NewRecorder(), req)
+	}
+
+	analytics, err := analyticsSvc.GetAnalytics("sources", "alice")
+	if err != nil {
+		t.Fatalf("analytics failed: %v", err)
+	}
+	wantCategories := map[string]int{"direct": 1, "search": 2, "email": 1, "social": 1, "internal": 2, "other": 1}
+	if !reflect.DeepEqual(analytics.ClicksByReferrerCategory, wantCategories) {
+		t.Errorf("ClicksByReferrerCategory = %v, want %v", analytics.ClicksByReferrerCategory, wantCategories)
+	}
+	wantHosts := map[string]int{
+		"google.com": 1, "google.de": 1, "mail.google.com": 1, "t.co": 1,
+		"shop.example.org": 1, "example.com": 1, "blog.example.net": 1,
+	}
+	if !reflect.DeepEqual(analytics.ClicksByReferrer, wantHosts) {
+		t.Errorf("ClicksByReferrer = %v, want %v", analytics.ClicksByReferrer, wantHosts)
+	}
+
+	for _, click := range analytics.RecentClicks {
+		if click.Referer == "https://t.co/xyz" && (click.ReferrerHost != "t.co" || click.ReferrerCategory != referrer.Social) {
+			t.Errorf("Expected the click to keep its referrer, got %+v", click)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package tests
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"url-shortener/internal/models"
+	"url-shortener/internal/store"
+)
+
+// TestClickRollups checks that the SQL stores, which read whole hours and
+// days from the rollups, agree with the memory store, which counts every
+// click, on ranges that start and end in the middle of hours, for every
+// figure that is rolled up.
+func TestClickRollups(t *testing.T) {
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	countries := []string{"NO", "US", "DE", ""}
+	ips := []string{"1.1.1.1", "2.2.2.2", "3.3.3.3", "4.4.4.4", "5.5.5.5"}
+	rules := []string{"ios", "", "dach"}
+	variants := []string{"a", "b", ""}
+	campaigns := []string{"spring", "", "summer", ""}
+	agents := [][3]string{{"chrome", "windows", "desktop"}, {"safari", "ios", "mobile"}, {"", "", ""}}
+	referrers := [][2]string{{"google.com", "search"}, {"", "direct"}, {"t.co", "social"}, {"", ""}}
+	var clicks []models.Click
+	for i := 0; i < 300; i++ {
+		agent, source := agents[i%len(agents)], referrers[i*5%len(referrers)]
+		clicks = append(clicks, models.Click{
+			URLShortCode:     "rollup",
+			IPAddress:        ips[i*7%len(ips)],
+			Country:          countries[i*3%len(countries)],
+			TargetRule:       rules[i%len(rules)],
+			Variant:          variants[i*11%len(variants)],
+			Campaign:         campaigns[i%len(campaigns)],
+			Browser:          agent[0],
+			OS:               agent[1],
+			Device:           agent[2],
+			ReferrerHost:     source[0],
+			ReferrerCategory: source[1],
+			Bot:              i%5 == 0,
+			// Spread over three days, off the minute and second.
+			ClickedAt: start.Add(time.Duration(i*863)*time.Second + time.Duration(i)*time.Millisecond),
+		})
+	}
+
+	ranges := map[string]store.TimeRange{
+		"everything":          {},
+		"whole days":          {From: start.AddDate(0, 0, 1), To: start.AddDate(0, 0, 2)},
+		"partial hours":       {From: start.Add(10*time.Hour + 17*time.Minute + 30*time.Second), To: start.Add(53*time.Hour + 42*time.Minute)},
+		"within an hour":      {From: start.Add(10*time.Hour + 5*time.Minute), To: start.Add(10*time.Hour + 50*time.Minute)},
+		"across an hour":      {From: start.Add(10*time.Hour + 30*time.Minute), To: start.Add(11*time.Hour + 30*time.Minute)},
+		"from a partial hour": {From: start.Add(20*time.Hour + 10*time.Minute)},
+		"to a partial hour":   {To: start.Add(30*time.Hour + 10*time.Minute)},
+	}
+
+	type result struct {
+		Clicks, Visitors                  int
+		Countries                         map[string]int
+		Slots                             map[time.Duration]map[int64]int
+		Rules, Campaigns                  map[string]int
+		Variants                          []models.VariantStats
+		Browsers, OSes, Devices           map[string]int
+		ReferrerHosts, ReferrerCategories map[string]int
+	}
+	query := func(t *testing.T, s store.Store, filter store.ClickFilter) result {
+		var r result
+		var err error
+		if r.Clicks, err = s.CountClicks("rollup", filter); err != nil {
+			t.Fatalf("CountClicks failed: %v", err)
+		}
+		if r.Visitors, err = s.CountUniqueVisitors("rollup", filter); err != nil {
+			t.Fatalf("CountUniqueVisitors failed: %v", err)
+		}
+		if r.Countries, err = s.ClicksByCountry("rollup", filter, 10); err != nil {
+			t.Fatalf("ClicksByCountry failed: %v", err)
+		}
+		if r.Rules, err = s.ClicksByRule("rollup", filter); err != nil {
+			t.Fatalf("ClicksByRule failed: %v", err)
+		}
+		if r.Variants, err = s.ClicksByVariant("rollup", filter); err != nil {
+			t.Fatalf("ClicksByVariant failed: %v", err)
+		}
+		if r.Campaigns, err = s.ClicksByCampaign("rollup", filter); err != nil {
+			t.Fatalf("ClicksByCampaign failed: %v", err)
+		}
+		if r.Browsers, r.OSes, r.Devices, err = s.ClicksByAgent("rollup", filter); err != nil {
+			t.Fatalf("ClicksByAgent failed: %v", err)
+		}
+		if r.ReferrerHosts, r.ReferrerCategories, err = s.ClicksByReferrer("rollup", filter, 10); err != nil {
+			t.Fatalf("ClicksByReferrer failed: %v", err)
+		}
+		r.Slots = make(map[time.Duration]map[int64]int)
+		for _, slot := range []time.Duration{time.Minute, 15 * time.Minute, time.Hour, 24 * time.Hour} {
+			if r.Slots[slot], err = s.ClicksBySlot("rollup", filter, slot); err != nil {
+				t.Fatalf("ClicksBySlot failed: %v", err)
+			}
+		}
+		return r
+	}
+
+	reference := store.NewMemoryStore()
+	if err := reference.RecordClicks(clicks); err != nil {
+		t.Fatalf("RecordClicks failed: %v", err)
+	}
+
+	for name, open := range storeBackends(t) {
+		if name == "memory" {
+			continue
+		}
+		t.Run(name, func(t *testing.T) {
+			s := open(t)
+			// Record some clicks one by one and the rest in batches, so that
+			// rollup rows are both created and added to.
+			for _, click := range clicks[:50] {
+				if err := s.RecordClick(click); err != nil {
+					t.Fatalf("RecordClick failed: %v", err)
+				}
+			}
+			for i := 50; i < len(clicks); i += 40 {
+				end := i + 40
+				if end > len(clicks) {
+					end = len(clicks)
+				}
+				if err := s.RecordClicks(clicks[i:end]); err != nil {
+					t.Fatalf("RecordClicks failed: %v", err)
+				}
+			}
+
+			for rangeName, within := range ranges {
+				for _, includeBots := range []bool{false, true} {
+					filter := store.ClickFilter{IncludeBots: includeBots, TimeRange: within}
+					want := query(t, reference, filter)
+					if got := query(t, s, filter); !reflect.DeepEqual(got, want) {
+						t.Errorf("%s, bots %v: got %+v, want %+v", rangeName, includeBots, got, want)
+					}
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,300 @@
+package tests
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"url-shortener/internal/config"
+	"url-shortener/internal/geoip"
+	"url-shortener/internal/handlers"
+	"url-shortener/internal/models"
+	"url-shortener/internal/services"
+	"url-shortener/internal/store"
+	"url-shortener/internal/useragent"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	iPhoneUA  = "Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.0 Mobile/15E148 Safari/604.1"
+	iPadUA    = "Mozilla/5.0 (iPad; CPU OS 17_0 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.0 Mobile/15E148 Safari/604.1"
+	androidUA = "Mozilla/5.0 (Linux; Android 14; Pixel 8) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Mobile Safari/537.36"
+	windowsUA = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36"
+	macUA     = "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.0 Safari/605.1.15"
+)
+
+func TestParseUserAgent(t *testing.T) {
+	cases := []struct {
+		ua   string
+		want useragent.Agent
+	}{
+		{iPhoneUA, useragent.Agent{OS: useragent.OSiOS, Device: useragent.DeviceMobile, Browser: useragent.BrowserSafari, BrowserVersion: "17"}},
+		{iPadUA, useragent.Agent{OS: useragent.OSiOS, Device: useragent.DeviceTablet, Browser: useragent.BrowserSafari, BrowserVersion: "17"}},
+		{androidUA, useragent.Agent{OS: useragent.OSAndroid, Device: useragent.DeviceMobile, Browser: useragent.BrowserChrome, BrowserVersion: "120"}},
+		{"Mozilla/5.0 (Linux; Android 13; SM-X200) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36", useragent.Agent{OS: useragent.OSAndroid, Device: useragent.DeviceTablet, Browser: useragent.BrowserChrome, BrowserVersion: "120"}},
+		{windowsUA, useragent.Agent{OS: useragent.OSWindows, Device: useragent.DeviceDesktop, Browser: useragent.BrowserChrome, BrowserVersion: "120"}},
+		{macUA, useragent.Agent{OS: useragent.OSMacOS, Device: useragent.DeviceDesktop, Browser: useragent.BrowserSafari, BrowserVersion: "17"}},
+		{"Mozilla/5.0 (X11; Linux x86_64; rv:121.0) Gecko/20100101 Firefox/121.0", useragent.Agent{OS: useragent.OSLinux, Device: useragent.DeviceDesktop, Browser: useragent.BrowserFirefox, BrowserVersion: "121"}},
+		{"Mozilla/5.0 (X11; CrOS x86_64 14541.0.0) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36", useragent.Agent{OS: useragent.OSChromeOS, Device: useragent.DeviceDesktop, Browser: useragent.BrowserChrome, BrowserVersion: "120"}},
+		{"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36 Edg/120.0.2210.91", useragent.Agent{OS: useragent.OSWindows, Device: useragent.DeviceDesktop, Browser: useragent.BrowserEdge, BrowserVersion: "120"}},
+		{"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36 OPR/106.0.0.0", useragent.Agent{OS: useragent.OSWindows, Device: useragent.DeviceDesktop, Browser: useragent.BrowserOpera, BrowserVersion: "106"}},
+		{"Mozilla/5.0 (Linux; Android 14; SM-S918B) AppleWebKit/537.36 (KHTML, like Gecko) SamsungBrowser/23.0 Chrome/115.0.0.0 Mobile Safari/537.36", useragent.Agent{OS: useragent.OSAndroid, Device: useragent.DeviceMobile, Browser: useragent.BrowserSamsung, BrowserVersion: "23"}},
+		{"Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) CriOS/120.0.6099.119 Mobile/15E148 Safari/604.1", useragent.Agent{OS: useragent.OSiOS, Device: useragent.DeviceMobile, Browser: useragent.BrowserChrome, BrowserVersion: "120"}},
+		{"Mozilla/5.0 (Windows NT 10.0; Trident/7.0; rv:11.0) like Gecko", useragent.Agent{OS: useragent.OSWindows, Device: useragent.DeviceDesktop, Browser: useragent.BrowserIE, BrowserVersion: "11"}},
+		{"Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)", useragent.Agent{OS: useragent.OSOther, Device: useragent.DeviceDesktop, Browser: useragent.BrowserOther, Bot: true, BotKind: useragent.BotCrawler}},
+		{"Slackbot-LinkExpanding 1.0 (+https://api.slack.com/robots)", useragent.Agent{OS: useragent.OSOther, Device: useragent.DeviceDesktop, Browser: useragent.BrowserOther, Bot: true, BotKind: useragent.BotUnfurler}},
+		{"facebookexternalhit/1.1 (+http://www.facebook.com/externalhit_uatext.php)", useragent.Agent{OS: useragent.OSOther, Device: useragent.DeviceDesktop, Browser: useragent.BrowserOther, Bot: true, BotKind: useragent.BotUnfurler}},
+		{"Mozilla/5.0 (Linux; Android 9; CUBOT X19) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Mobile Safari/537.36", useragent.Agent{OS: useragent.OSAndroid, Device: useragent.DeviceMobile, Browser: useragent.BrowserChrome, BrowserVersion: "120"}},
+		{"Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) HeadlessChrome/120.0.0.0 Safari/537.36", useragent.Agent{OS: useragent.OSLinux, Device: useragent.DeviceDesktop, Browser: useragent.BrowserChrome, BrowserVersion: "120", Bot: true, BotKind: useragent.BotHeadless}},
+		{"curl/8.4.0", useragent.Agent{OS: useragent.OSOther, Device: useragent.DeviceDesktop, Browser: useragent.BrowserOther, Bot: true, BotKind: useragent.BotLibrary}},
+		{"", useragent.Agent{OS: useragent.OSOther, Device: useragent.DeviceDesktop, Browser: useragent.BrowserOther}},
+	}
+	for _, tc := range cases {
+		if got := useragent.Parse(tc.ua); got != tc.want {
+			t.Errorf("Parse(%q) = %+v, want %+v", tc.ua, got, tc.want)
+		}
+	}
+}
+
+func TestClicksByAgent(t *testing.T) {
+	urlSvc, analyticsSvc := newMemoryServices(t)
+	handler := handlers.NewURLHandler(urlSvc, analyticsSvc, nil, nil, config.Default().QR)
+
+	if _, err := urlSvc.ShortenURL(models.ShortenURLRequest{OriginalURL: "https://example.com", CustomCode: "agents"}, "alice", "127.0.0.1"); err != nil {
+		t.Fatalf("shorten failed: %v", err)
+	}
+	for _, ua := range []string{iPhoneUA, androidUA, windowsUA, "Slackbot-LinkExpanding 1.0 (+https://api.slack.com/robots)"} {
+		req := mux.SetURLVars(httptest.NewRequest("GET", "/agents", nil), map[string]string{"shortCode": "agents"})
+		req.Header.Set("User-Agent", ua)
+		handler.RedirectURL(httptest.NewRecorder(), req)
+	}
+
+	analytics, err := analyticsSvc.QueryAnalytics("agents", "alice", models.AnalyticsQuery{IncludeBots: true})
+	if err != nil {
+		t.Fatalf("analytics failed: %v", err)
+	}
+	if want := map[string]int{"safari": 1, "chrome": 2, "other": 1}; !reflect.DeepEqual(analytics.ClicksByBrowser, want) {
+		t.Errorf("ClicksByBrowser = %v, want %v", analytics.ClicksByBrowser, want)
+	}
+	if want := map[string]int{"ios": 1, "android": 1, "windows": 1, "other": 1}; !reflect.DeepEqual(analytics.ClicksByOS, want) {
+		t.Errorf("ClicksByOS = %v, want %v", analytics.ClicksByOS, want)
+	}
+	if want := map[string]int{"mobile": 2, "desktop": 2}; !reflect.DeepEqual(analytics.ClicksByDevice, want) {
+		t.Errorf("ClicksByDevice = %v, want %v", analytics.ClicksByDevice, want)
+	}
+
+	bots := 0
+	for _, click := range analytics.RecentClicks {
+		if click.Bot {
+			bots++
+		}
+		if click.Browser == "chrome" && click.BrowserVersion != "120" {
+			t.Errorf("Expected Chrome 120, got %+v", click)
+		}
+	}
+	if bots != 1 {
+		t.Errorf("Expected the Slack unfurler to be flagged as the only bot, got %d", bots)
+	}
+}
+
+func TestTargetingRules(t *testing.T) {
+	urlSvc, analyticsSvc := newMemoryServices(t)
+	handler := handlers.NewURLHandler(urlSvc, analyticsSvc, nil, nil, config.Default().QR)
+
+	req := models.ShortenURLRequest{
+		OriginalURL: "https://example.com/app",
+		CustomCode:  "getapp",
+		TargetingRules: []models.TargetingRule{
+			{Name: "app store", OS: "iOS", URL: "https://apps.apple.com/app/id1"},
+			{OS: "android", URL: "https://play.google.com/store/apps/details?id=app"},
+			{Device: "tablet", URL: "https://example.com/tablet"},
+		},
+	}
+	created, err := urlSvc.ShortenURL(req, "alice", "127.0.0.1")
+	if err != nil {
+		t.Fatalf("shorten failed: %v", err)
+	}
+	if got := created.TargetingRules[1]; got.Name != "android" || got.OS != "android" {
+		t.Errorf("Expected the unnamed rule to be named after its condition, got %+v", got)
+	}
+	if got := created.TargetingRules[0]; got.OS != useragent.OSiOS {
+		t.Errorf("Expected the OS to be lower-cased, got %+v", got)
+	}
+
+	cases := []struct {
+		ua       string
+		location string
+	}{
+		{iPhoneUA, "https://apps.apple.com/app/id1"},
+		{iPadUA, "https://apps.apple.com/app/id1"}, // the first matching rule wins
+		{androidUA, "https://play.google.com/store/apps/details?id=app"},
+		{windowsUA, "https://example.com/app"},
+		{macUA, "https://example.com/app"},
+	}
+	for _, tc := range cases {
+		httpReq := mux.SetURLVars(httptest.NewRequest("GET", "/getapp", nil), map[string]string{"shortCode": "getapp"})
+		httpReq.Header.Set("User-Agent", tc.ua)
+		rr := httptest.NewRecorder()
+		handler.RedirectURL(rr, httpReq)
+
+		if rr.Code != http.StatusFound || rr.Header().Get("Location") != tc.location {
+			t.Errorf("%s: got %d to %q, want %q", tc.ua, rr.Code, rr.Header().Get("Location"), tc.location)
+		}
+		if vary := rr.Header().Get("Vary"); vary != "User-Agent" {
+			t.Errorf("Vary = %q, want User-Agent", vary)
+		}
+	}
+
+	analytics, err := analyticsSvc.GetAnalytics("getapp", "alice")
+	if err != nil {
+		t.Fatalf("analytics failed: %v", err)
+	}
+	want := map[string]int{"app store": 2, "android": 1, models.DefaultTargetRule: 2}
+	if len(analytics.ClicksByRule) != len(want) {
+		t.Errorf("ClicksByRule = %v, want %v", analytics.ClicksByRule, want)
+	}
+	for rule, n := range want {
+		if analytics.ClicksByRule[rule] != n {
+			t.Errorf("ClicksByRule[%q] = %d, want %d", rule, analytics.ClicksByRule[rule], n)
+		}
+	}
+	matched := 0
+	for _, click := range analytics.RecentClicks {
+		if click.TargetRule == "app store" {
+			matched++
+		}
+	}
+	if matched != 2 {
+		t.Errorf("Expected 2 recent clicks with the app store rule, got %d", matched)
+	}
+
+	none := []models.TargetingRule{}
+	if _, err := urlSvc.UpdateURL("getapp", models.UpdateURLRequest{TargetingRules: &none}, "alice"); err != nil {
+		t.Fatalf("clearing rules failed: %v", err)
+	}
+	url, err := urlSvc.GetOriginalURL("getapp")
+	if err != nil {
+		t.Fatalf("GetOriginalURL failed: %v", err)
+	}
+	if target := urlSvc.Destination(url, services.Visitor{Agent: useragent.Parse(iPhoneUA)}); target.URL != "https://example.com/app" || target.Rule != "" || len(url.TargetingRules) != 0 {
+		t.Errorf("Expected cleared rules to send everyone to the original URL, got %+v, %+v", target, url.TargetingRules)
+	}
+}
+
+func TestInvalidTargetingRules(t *testing.T) {
+	urlSvc, _ := newMemoryServices(t)
+
+	cases := map[string][]models.TargetingRule{
+		"no condition":   {{URL: "https://example.com"}},
+		"unknown os":     {{OS: "symbian", URL: "https://example.com"}},
+		"unknown device": {{Device: "watch", URL: "https://example.com"}},
+		"bad url":        {{OS: "ios", URL: "itms-apps://app"}},
+		"duplicate name": {{OS: "ios", URL: "https://a.example.com"}, {Name: "ios", Device: "tablet", URL: "https://b.example.com"}},
+		"reserved name":  {{Name: models.DefaultTargetRule, OS: "ios", URL: "https://example.com"}},
+	}
+	for name, rules := range cases {
+		req := models.ShortenURLRequest{OriginalURL: "https://example.com", TargetingRules: rules}
+		if _, err := urlSvc.ShortenURL(req, "alice", "127.0.0.1"); !errors.Is(err, services.ErrInvalidTargeting) {
+			t.Errorf("%s: got %v, want ErrInvalidTargeting", name, err)
+		}
+	}
+
+	if _, err := urlSvc.ShortenURL(models.ShortenURLRequest{OriginalURL: "https://example.com", CustomCode: "rules"}, "alice", "127.0.0.1"); err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	bad := cases["unknown os"]
+	if _, err := urlSvc.UpdateURL("rules", models.UpdateURLRequest{TargetingRules: &bad}, "alice"); !errors.Is(err, services.ErrInvalidTargeting) {
+		t.Errorf("update: got %v, want ErrInvalidTargeting", err)
+	}
+}
+
+func TestGeoTargetingRules(t *testing.T) {
+	path := writeTestMMDB(t, net.ParseIP("81.2.69.0"), 24, [][2]interface{}{
+		{"continent", [][2]interface{}{{"code", "EU"}}},
+		{"country", append(names("United Kingdom"), [2]interface{}{"iso_code", "GB"})},
+	})
+	geo, err := geoip.OpenMMDB(path, "")
+	if err != nil {
+		t.Fatalf("open mmdb failed: %v", err)
+	}
+	defer geo.Close()
+
+	s := store.NewMemoryStore()
+	urlSvc := services.NewURLService(s, nil, config.Default().Links)
+	analyticsSvc := services.NewAnalyticsService(s, s, nil, geo)
+	handler := handlers.NewURLHandler(urlSvc, analyticsSvc, nil, nil, config.Default().QR)
+
+	req := models.ShortenURLRequest{
+		OriginalURL:  "https://example.com/en",
+		CustomCode:   "shop",
+		RedirectType: http.StatusMovedPermanently,
+		TargetingRules: []models.TargetingRule{
+			{Countries: []string{"de", "AT"}, URL: "https://example.com/de"},
+			{Name: "europe", Continents: []string{"eu"}, URL: "https://example.com/eu"},
+		},
+	}
+	created, err := urlSvc.ShortenURL(req, "alice", "127.0.0.1")
+	if err != nil {
+		t.Fatalf("shorten failed: %v", err)
+	}
+	if got := created.TargetingRules[0]; got.Name != "DE,AT" || got.Countries[0] != "DE" {
+		t.Errorf("Expected upper-cased codes and a derived name, got %+v", got)
+	}
+	if !urlSvc.TargetsLocation(created) {
+		t.Error("Expected rules with countries to target the location")
+	}
+
+	visits := []struct {
+		ip       string
+		location string
+	}{
+		{"81.2.69.142", "https://example.com/eu"},
+		{"81.2.69.7", "https://example.com/eu"},
+		{"8.8.8.8", "https://example.com/en"},
+	}
+	for _, v := range visits {
+		httpReq := mux.SetURLVars(httptest.NewRequest("GET", "/shop", nil), map[string]string{"shortCode": "shop"})
+		httpReq.Header.Set("X-Forwarded-For", v.ip)
+		rr := httptest.NewRecorder()
+		handler.RedirectURL(rr, httpReq)
+
+		if rr.Header().Get("Location") != v.location {
+			t.Errorf("%s: redirected to %q, want %q", v.ip, rr.Header().Get("Location"), v.location)
+		}
+		if cc := rr.Header().Get("Cache-Control"); !strings.Contains(cc, "no-store") {
+			t.Errorf("%s: Cache-Control = %q, geo-targeted redirects must not be cached", v.ip, cc)
+		}
+	}
+
+	analytics, err := analyticsSvc.GetAnalytics("shop", "alice")
+	if err != nil {
+		t.Fatalf("analytics failed: %v", err)
+	}
+	if analytics.ClicksByRule["europe"] != 2 || analytics.ClicksByRule[models.DefaultTargetRule] != 1 {
+		t.Errorf("ClicksByRule = %v, want 2 for europe and 1 for default", analytics.ClicksByRule)
+	}
+	if analytics.ClicksByCountry["United Kingdom"] != 2 {
+		t.Errorf("Expected the location resolved for targeting to be kept, got %v", analytics.ClicksByCountry)
+	}
+
+	de := services.Visitor{Location: geoip.Location{CountryCode: "DE", ContinentCode: "EU"}}
+	if target := urlSvc.Destination(created, de); target.URL != "https://example.com/de" || target.Rule != "DE,AT" {
+		t.Errorf("German visitor: got %+v", target)
+	}
+
+	bad := map[string][]models.TargetingRule{
+		"country name": {{Countries: []string{"Germany"}, URL: "https://example.com"}},
+		"continent":    {{Continents: []string{"Europe"}, URL: "https://example.com"}},
+	}
+	for name, rules := range bad {
+		req := models.ShortenURLRequest{OriginalURL: "https://example.com", TargetingRules: rules}
+		if _, err := urlSvc.ShortenURL(req, "alice", "127.0.0.1"); !errors.Is(err, services.ErrInvalidTargeting) {
+			t.Errorf("%s: got %v, want ErrInvalidTargeting", name, err)
+		}
+	}
+}
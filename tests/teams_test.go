@@ -0,0 +1,210 @@
+package tests
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"url-shortener/internal/config"
+	"url-shortener/internal/database"
+	"url-shortener/internal/handlers"
+	"url-shortener/internal/middleware"
+	"url-shortener/internal/models"
+	"url-shortener/internal/services"
+	"url-shortener/internal/store"
+
+	"github.com/gorilla/mux"
+)
+
+// setupTeam creates users admin, editor, viewer and outsider, and a team in
+// which the first three hold the matching role.
+func setupTeam(t *testing.T, db *database.DB) int {
+	t.Helper()
+
+	users := services.NewUserService(db)
+	for _, name := range []string{"admin", "editor", "viewer", "outsider"} {
+		if _, err := users.CreateUser(name, "password123"); err != nil {
+			t.Fatalf("create user %s failed: %v", name, err)
+		}
+	}
+
+	teams := services.NewTeamService(db)
+	team, err := teams.CreateTeam("growth", "admin")
+	if err != nil {
+		t.Fatalf("create team failed: %v", err)
+	}
+	if err := teams.SetMember(team.ID, "editor", models.RoleEditor, "admin"); err != nil {
+		t.Fatalf("add editor failed: %v", err)
+	}
+	if err := teams.SetMember(team.ID, "viewer", models.RoleViewer, "admin"); err != nil {
+		t.Fatalf("add viewer failed: %v", err)
+	}
+
+	return team.ID
+}
+
+func TestTeamRolePermissions(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	teamID := setupTeam(t, db)
+	urlSvc := services.NewURLService(store.New(db), services.NewTeamService(db), config.Default().Links)
+	analyticsSvc := services.NewAnalyticsService(store.New(db), store.New(db), services.NewTeamService(db), nil)
+
+	req := models.ShortenURLRequest{
+		OriginalURL: "https://example.com/team",
+		CustomCode:  "teamlink",
+		TeamID:      &teamID,
+	}
+
+	if _, err := urlSvc.ShortenURL(req, "viewer", "127.0.0.1"); !errors.Is(err, services.ErrForbidden) {
+		t.Errorf("shorten by viewer: got %v, want ErrForbidden", err)
+	}
+	if _, err := urlSvc.ShortenURL(req, "outsider", "127.0.0.1"); !errors.Is(err, services.ErrForbidden) {
+		t.Errorf("shorten by outsider: got %v, want ErrForbidden", err)
+	}
+	if _, err := urlSvc.ShortenURL(req, "editor", "127.0.0.1"); err != nil {
+		t.Fatalf("shorten by editor failed: %v", err)
+	}
+
+	for _, user := range []string{"admin", "editor", "viewer"} {
+		if _, err := analyticsSvc.GetAnalytics("teamlink", user); err != nil {
+			t.Errorf("analytics for %s: %v", user, err)
+		}
+		urls, err := urlSvc.GetUserURLs(user)
+		if err != nil || len(urls) != 1 {
+			t.Errorf("list for %s: got %d URLs, err %v; want 1", user, len(urls), err)
+		}
+	}
+	if _, err := analyticsSvc.GetAnalytics("teamlink", "outsider"); !errors.Is(err, services.ErrForbidden) {
+		t.Errorf("analytics for outsider: got %v, want ErrForbidden", err)
+	}
+
+	newURL := "https://example.com/team/v2"
+	update := models.UpdateURLRequest{OriginalURL: &newURL}
+	if _, err := urlSvc.UpdateURL("teamlink", update, "viewer"); !errors.Is(err, services.ErrForbidden) {
+		t.Errorf("update by viewer: got %v, want ErrForbidden", err)
+	}
+	if _, err := urlSvc.UpdateURL("teamlink", update, "editor"); err != nil {
+		t.Errorf("update by editor failed: %v", err)
+	}
+
+	if err := urlSvc.DeleteURL("teamlink", "editor"); !errors.Is(err, services.ErrForbidden) {
+		t.Errorf("delete by editor: got %v, want ErrForbidden", err)
+	}
+	if err := urlSvc.DeleteURL("teamlink", "admin"); err != nil {
+		t.Errorf("delete by admin failed: %v", err)
+	}
+}
+
+func TestTeamMembershipManagement(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	teamID := setupTeam(t, db)
+	teams := services.NewTeamService(db)
+
+	if err := teams.SetMember(teamID, "outsider", models.RoleViewer, "editor"); !errors.Is(err, services.ErrForbidden) {
+		t.Errorf("add member by editor: got %v, want ErrForbidden", err)
+	}
+	if err := teams.SetMember(teamID, "outsider", "owner", "admin"); !errors.Is(err, services.ErrInvalidRole) {
+		t.Errorf("invalid role: got %v, want ErrInvalidRole", err)
+	}
+	if err := teams.SetMember(teamID, "viewer", models.RoleEditor, "admin"); err != nil {
+		t.Fatalf("promote viewer failed: %v", err)
+	}
+
+	role, err := teams.RoleFor(teamID, "viewer")
+	if err != nil || role != models.RoleEditor {
+		t.Errorf("role = %q, err %v; want editor", role, err)
+	}
+
+	if err := teams.RemoveMember(teamID, "viewer", "admin"); err != nil {
+		t.Fatalf("remove member failed: %v", err)
+	}
+	list, err := teams.ListTeams("viewer")
+	if err != nil || len(list) != 0 {
+		t.Errorf("teams after removal: %d, err %v; want 0", len(list), err)
+	}
+
+	if _, err := teams.RoleFor(9999, "admin"); !errors.Is(err, services.ErrTeamNotFound) {
+		t.Errorf("missing team: got %v, want ErrTeamNotFound", err)
+	}
+}
+
+func TestSessionLogin(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	userSvc := services.NewUserService(db)
+	if _, err := userSvc.CreateUser("alice", "password123"); err != nil {
+		t.Fatalf("create user failed: %v", err)
+	}
+
+	urlSvc := services.NewURLService(store.New(db), services.NewTeamService(db), config.Default().Links)
+	urlHandler := handlers.NewURLHandler(urlSvc, services.NewAnalyticsService(store.New(db), store.New(db), services.NewTeamService(db), nil), nil, nil, config.Default().QR)
+	authHandler := handlers.NewAuthHandler(userSvc)
+	sessions := middleware.SessionMiddleware(userSvc)
+
+	if _, err := urlSvc.ShortenURL(models.ShortenURLRequest{OriginalURL: "https://example.com/mine"}, "alice", "127.0.0.1"); err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+
+	router := mux.NewRouter()
+	router.HandleFunc("/login", authHandler.Login).Methods("POST")
+	router.HandleFunc("/logout", authHandler.Logout).Methods("POST")
+	router.Handle("/dashboard", sessions(http.HandlerFunc(urlHandler.Dashboard))).Methods("GET")
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/dashboard", nil))
+	if rr.Code != http.StatusSeeOther || !strings.HasPrefix(rr.Header().Get("Location"), "/login") {
+		t.Fatalf("anonymous dashboard: status %d location %q, want redirect to login", rr.Code, rr.Header().Get("Location"))
+	}
+
+	login := func(password, next string) *httptest.ResponseRecorder {
+		form := url.Values{"username": {"alice"}, "password": {password}, "next": {next}}
+		req := httptest.NewRequest("POST", "/login", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	if rr := login("wrong-password", "/dashboard"); rr.Code != http.StatusUnauthorized {
+		t.Errorf("bad password: status = %d, want 401", rr.Code)
+	}
+
+	rr = login("password123", "//evil.example.com")
+	if rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != "/dashboard" {
+		t.Errorf("login: status %d location %q, want redirect to /dashboard", rr.Code, rr.Header().Get("Location"))
+	}
+
+	var session *http.Cookie
+	for _, c := range rr.Result().Cookies() {
+		if c.Name == middleware.SessionCookieName {
+			session = c
+		}
+	}
+	if session == nil || !session.HttpOnly {
+		t.Fatal("login should set an HttpOnly session cookie")
+	}
+
+	req := httptest.NewRequest("GET", "/dashboard", nil)
+	req.AddCookie(session)
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "https://example.com/mine") {
+		t.Errorf("dashboard with session: status %d, want 200 listing alice's link", rr.Code)
+	}
+
+	req = httptest.NewRequest("POST", "/logout", nil)
+	req.AddCookie(session)
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	if _, err := userSvc.SessionUser(session.Value); !errors.Is(err, services.ErrInvalidSession) {
+		t.Errorf("session after logout: got %v, want ErrInvalidSession", err)
+	}
+}
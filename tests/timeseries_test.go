@@ -0,0 +1,182 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"url-shortener/internal/config"
+	"url-shortener/internal/handlers"
+	"url-shortener/internal/middleware"
+	"url-shortener/internal/models"
+	"url-shortener/internal/services"
+	"url-shortener/internal/store"
+
+	"github.com/gorilla/mux"
+)
+
+func TestAnalyticsTimeSeries(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	urlSvc := services.NewURLService(store.New(db), nil, config.Default().Links)
+	analyticsSvc := services.NewAnalyticsService(store.New(db), store.New(db), nil, nil)
+	keySvc := services.NewAPIKeyService(db)
+	handler := handlers.NewURLHandler(urlSvc, analyticsSvc, nil, nil, config.Default().QR)
+
+	router := mux.NewRouter()
+	router.Use(middleware.AuthMiddleware(keySvc))
+	router.HandleFunc("/api/v1/analytics/{shortCode}", handler.GetAnalytics).Methods("GET")
+
+	aliceKey, _, err := keySvc.CreateKey("alice", "test")
+	if err != nil {
+		t.Fatalf("create key failed: %v", err)
+	}
+	if _, err := urlSvc.ShortenURL(models.ShortenURLRequest{OriginalURL: "https://example.com", CustomCode: "series"}, "alice", "127.0.0.1"); err != nil {
+		t.Fatalf("shorten failed: %v", err)
+	}
+	// Oslo moves from UTC+1 to UTC+2 at 01:00 UTC on March 30, 2025, and
+	// Kolkata is UTC+5:30.
+	for _, at := range []string{
+		"2025-01-01T00:20:00Z",
+		"2025-01-01T00:40:00Z",
+		"2025-03-29T22:30:00Z",
+		"2025-03-29T23:30:00Z",
+		"2025-03-30T21:30:00Z",
+		"2025-03-30T22:30:00Z",
+	} {
+		clickedAt, _ := time.Parse(time.RFC3339, at)
+		if err := analyticsSvc.RecordClick(models.Click{URLShortCode: "series", IPAddress: "1.1.1.1", ClickedAt: clickedAt}); err != nil {
+			t.Fatalf("record click failed: %v", err)
+		}
+	}
+
+	getAnalytics := func(query string) (*httptest.ResponseRecorder, models.Analytics) {
+		req := httptest.NewRequest("GET", "/api/v1/analytics/series?"+query, nil)
+		req.Header.Set("Authorization", "Bearer "+aliceKey)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		var analytics models.Analytics
+		if rr.Code == http.StatusOK {
+			if err := json.NewDecoder(rr.Body).Decode(&analytics); err != nil {
+				t.Fatalf("decode analytics failed: %v", err)
+			}
+		}
+		return rr, analytics
+	}
+	type bucket struct {
+		start  string
+		clicks int
+	}
+	series := func(analytics models.Analytics) []bucket {
+		var got []bucket
+		for _, b := range analytics.ClicksOverTime {
+			got = append(got, bucket{b.Start.Format(time.RFC3339), b.Clicks})
+		}
+		return got
+	}
+
+	cases := []struct {
+		name  string
+		query url.Values
+		want  []bucket
+		total int
+	}{
+		{
+			name:  "days across a DST change",
+			query: url.Values{"from": {"2025-03-30"}, "to": {"2025-03-31"}, "tz": {"Europe/Oslo"}},
+			want:  []bucket{{"2025-03-30T00:00:00+01:00", 2}, {"2025-03-31T00:00:00+02:00", 1}},
+			total: 3,
+		},
+		{
+			name:  "the same days in UTC",
+			query: url.Values{"from": {"2025-03-30"}, "to": {"2025-03-31"}},
+			want:  []bucket{{"2025-03-30T00:00:00Z", 2}, {"2025-03-31T00:00:00Z", 0}},
+			total: 2,
+		},
+		{
+			name:  "hours half an hour off UTC",
+			query: url.Values{"from": {"2025-01-01T05:00"}, "to": {"2025-01-01T08:00"}, "tz": {"Asia/Kolkata"}, "granularity": {"hour"}},
+			want:  []bucket{{"2025-01-01T05:00:00+05:30", 1}, {"2025-01-01T06:00:00+05:30", 1}, {"2025-01-01T07:00:00+05:30", 0}},
+			total: 2,
+		},
+		{
+			name:  "minutes",
+			query: url.Values{"from": {"2025-01-01T00:19:00Z"}, "to": {"2025-01-01T00:22:00Z"}, "granularity": {"minute"}},
+			want:  []bucket{{"2025-01-01T00:19:00Z", 0}, {"2025-01-01T00:20:00Z", 1}, {"2025-01-01T00:21:00Z", 0}},
+			total: 1,
+		},
+		{
+			name:  "weeks from Monday",
+			query: url.Values{"from": {"2025-01-01"}, "to": {"2025-01-12"}, "granularity": {"week"}},
+			want:  []bucket{{"2024-12-30T00:00:00Z", 2}, {"2025-01-06T00:00:00Z", 0}},
+			total: 2,
+		},
+		{
+			name:  "months",
+			query: url.Values{"from": {"2024-12-15"}, "to": {"2025-03-31T22:00:00Z"}, "granularity": {"Month"}, "tz": {"Europe/Oslo"}},
+			want:  []bucket{{"2024-12-01T00:00:00+01:00", 0}, {"2025-01-01T00:00:00+01:00", 2}, {"2025-02-01T00:00:00+01:00", 0}, {"2025-03-01T00:00:00+01:00", 4}},
+			total: 6,
+		},
+	}
+	for _, tc := range cases {
+		rr, analytics := getAnalytics(tc.query.Encode())
+		if rr.Code != http.StatusOK {
+			t.Errorf("%s: got %d: %s", tc.name, rr.Code, rr.Body.String())
+			continue
+		}
+		got := series(analytics)
+		if len(got) != len(tc.want) {
+			t.Errorf("%s: got buckets %v, want %v", tc.name, got, tc.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tc.want[i] {
+				t.Errorf("%s: got buckets %v, want %v", tc.name, got, tc.want)
+				break
+			}
+		}
+		if analytics.TotalClicks != tc.total || len(analytics.RecentClicks) != tc.total {
+			t.Errorf("%s: got %d clicks and %d recent, want %d", tc.name, analytics.TotalClicks, len(analytics.RecentClicks), tc.total)
+		}
+	}
+
+	rr, analytics := getAnalytics("from=2025-03-30&to=2025-03-31&tz=Europe/Oslo&limit=1")
+	if rr.Code != http.StatusOK || len(analytics.RecentClicks) != 1 {
+		t.Fatalf("Expected 1 recent click, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if clicked := analytics.RecentClicks[0].ClickedAt; clicked.Format(time.RFC3339) != "2025-03-31T00:30:00+02:00" {
+		t.Errorf("Expected the latest click in Oslo time, got %s", clicked)
+	}
+	if analytics.Granularity != models.GranularityDay || analytics.Timezone != "Europe/Oslo" || analytics.ClicksOverTime[0].Label != "2025-03-30" {
+		t.Errorf("Expected the query echoed back, got %s in %s, labelled %q", analytics.Granularity, analytics.Timezone, analytics.ClicksOverTime[0].Label)
+	}
+	// The daily series of earlier versions of the API ignore the query.
+	today := time.Now().UTC().Format("2006-01-02")
+	if len(analytics.ClicksByDay) != 31 || analytics.ClicksByDay[30].Date != today || len(analytics.ConversionsByDay) != 31 {
+		t.Errorf("Expected clicks_by_day and conversions_by_day for the last 30 days in UTC, got %+v and %+v", analytics.ClicksByDay, analytics.ConversionsByDay)
+	}
+
+	rr, analytics = getAnalytics("")
+	if rr.Code != http.StatusOK || len(analytics.ClicksOverTime) != 31 || analytics.Timezone != "UTC" || analytics.TotalClicks != 0 {
+		t.Errorf("Expected the last 30 days by day in UTC, got %d with %d buckets in %s", rr.Code, len(analytics.ClicksOverTime), analytics.Timezone)
+	}
+
+	for _, query := range []string{
+		"from=2025-02-01&to=2025-01-01",
+		"from=yesterday",
+		"to=2025-13-01",
+		"granularity=year",
+		"granularity=minute&from=2025-01-01&to=2025-02-01",
+		"tz=Mars/Olympus_Mons",
+		"limit=lots",
+		"limit=5000",
+	} {
+		if rr, _ := getAnalytics(query); rr.Code != http.StatusBadRequest {
+			t.Errorf("%s: got %d, want 400", query, rr.Code)
+		}
+	}
+}
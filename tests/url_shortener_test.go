@@ -2,40 +2,94 @@ package tests
 
 import (
 	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
-	"os"
+	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"url-shortener/internal/config"
 	"url-shortener/internal/database"
 	"url-shortener/internal/handlers"
 	"url-shortener/internal/middleware"
 	"url-shortener/internal/models"
 	"url-shortener/internal/services"
+	"url-shortener/internal/store"
+
+	"github.com/gorilla/mux"
 )
 
 func setupTestDB(t *testing.T) *database.DB {
-	os.Setenv("DB_PATH", ":memory:")
-	db, err := database.InitDB()
+	db, err := database.InitDB(config.Database{Driver: database.DriverSQLite, Path: ":memory:"})
 	if err != nil {
 		t.Fatalf("database init failed: %v", err)
 	}
 	return db
 }
 
-func TestURLServiceShortenURL(t *testing.T) {
-	db := setupTestDB(t)
+// newMemoryServices returns services backed by a fresh in-memory store, so
+// tests using them can run in parallel.
+func newMemoryServices(t *testing.T) (*services.URLService, *services.AnalyticsService) {
+	t.Parallel()
+	s := store.NewMemoryStore()
+	return services.NewURLService(s, nil, config.Default().Links), services.NewAnalyticsService(s, s, nil, nil)
+}
+
+func TestInitDBAddsDeletedAtColumn(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "legacy.db")
+
+	legacy, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatalf("open legacy db failed: %v", err)
+	}
+	_, err = legacy.Exec(`
+		CREATE TABLE urls (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			short_code VARCHAR(20) UNIQUE NOT NULL,
+			original_url TEXT NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			expires_at DATETIME,
+			click_count INTEGER DEFAULT 0,
+			user_ip VARCHAR(45),
+			is_custom BOOLEAN DEFAULT FALSE
+		);`)
+	legacy.Close()
+	if err != nil {
+		t.Fatalf("create legacy table failed: %v", err)
+	}
+
+	db, err := database.InitDB(config.Database{Driver: database.DriverSQLite, Path: path})
+	if err != nil {
+		t.Fatalf("database init failed: %v", err)
+	}
 	defer db.Close()
 
-	svc := services.NewURLService(db)
+	svc := services.NewURLService(store.New(db), services.NewTeamService(db), config.Default().Links)
+	req := models.ShortenURLRequest{
+		OriginalURL: "https://example.com/legacy",
+		CustomCode:  "legacy",
+	}
+	if _, err := svc.ShortenURL(req, "alice", "127.0.0.1"); err != nil {
+		t.Fatalf("shorten on upgraded db failed: %v", err)
+	}
+	if _, err := svc.GetOriginalURL("legacy"); err != nil {
+		t.Errorf("get on upgraded db failed: %v", err)
+	}
+}
+
+func TestURLServiceShortenURL(t *testing.T) {
+	svc, _ := newMemoryServices(t)
 
 	req := models.ShortenURLRequest{
 		OriginalURL: "https://example.com/test",
 	}
 
-	result, err := svc.ShortenURL(req, "127.0.0.1")
+	result, err := svc.ShortenURL(req, "alice", "127.0.0.1")
 	if err != nil {
 		t.Errorf("expected no error, got %v", err)
 	}
@@ -54,17 +108,14 @@ func TestURLServiceShortenURL(t *testing.T) {
 }
 
 func TestURLServiceCustomCode(t *testing.T) {
-	db := setupTestDB(t)
-	defer db.Close()
-
-	svc := services.NewURLService(db)
+	svc, _ := newMemoryServices(t)
 
 	req := models.ShortenURLRequest{
 		OriginalURL: "https://example.com/custom",
 		CustomCode:  "mycode",
 	}
 
-	result, err := svc.ShortenURL(req, "127.0.0.1")
+	result, err := svc.ShortenURL(req, "alice", "127.0.0.1")
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -79,40 +130,34 @@ func TestURLServiceCustomCode(t *testing.T) {
 }
 
 func TestURLServiceDuplicateCustomCode(t *testing.T) {
-	db := setupTestDB(t)
-	defer db.Close()
-
-	svc := services.NewURLService(db)
+	svc, _ := newMemoryServices(t)
 
 	req := models.ShortenURLRequest{
 		OriginalURL: "https://example.com/first",
 		CustomCode:  "duplicate",
 	}
 
-	_, err := svc.ShortenURL(req, "127.0.0.1")
+	_, err := svc.ShortenURL(req, "alice", "127.0.0.1")
 	if err != nil {
 		t.Fatalf("first insert failed: %v", err)
 	}
 
 	req.OriginalURL = "https://example.com/second"
-	_, err = svc.ShortenURL(req, "127.0.0.1")
+	_, err = svc.ShortenURL(req, "alice", "127.0.0.1")
 	if err == nil {
 		t.Error("expected error for duplicate custom code")
 	}
 }
 
 func TestURLServiceGetOriginalURL(t *testing.T) {
-	db := setupTestDB(t)
-	defer db.Close()
-
-	svc := services.NewURLService(db)
+	svc, _ := newMemoryServices(t)
 
 	req := models.ShortenURLRequest{
 		OriginalURL: "https://example.com/retrieve",
 		CustomCode:  "retrieve",
 	}
 
-	created, err := svc.ShortenURL(req, "127.0.0.1")
+	created, err := svc.ShortenURL(req, "alice", "127.0.0.1")
 	if err != nil {
 		t.Fatalf("setup failed: %v", err)
 	}
@@ -128,10 +173,7 @@ func TestURLServiceGetOriginalURL(t *testing.T) {
 }
 
 func TestURLServiceExpiration(t *testing.T) {
-	db := setupTestDB(t)
-	defer db.Close()
-
-	svc := services.NewURLService(db)
+	svc, _ := newMemoryServices(t)
 
 	past := time.Now().Add(-1 * time.Hour)
 	req := models.ShortenURLRequest{
@@ -140,7 +182,7 @@ func TestURLServiceExpiration(t *testing.T) {
 		ExpiresAt:   &past,
 	}
 
-	created, err := svc.ShortenURL(req, "127.0.0.1")
+	created, err := svc.ShortenURL(req, "alice", "127.0.0.1")
 	if err != nil {
 		t.Fatalf("setup failed: %v", err)
 	}
@@ -152,17 +194,14 @@ func TestURLServiceExpiration(t *testing.T) {
 }
 
 func TestURLServiceIncrementClickCount(t *testing.T) {
-	db := setupTestDB(t)
-	defer db.Close()
-
-	svc := services.NewURLService(db)
+	svc, _ := newMemoryServices(t)
 
 	req := models.ShortenURLRequest{
 		OriginalURL: "https://example.com/clicks",
 		CustomCode:  "clicks",
 	}
 
-	created, _ := svc.ShortenURL(req, "127.0.0.1")
+	created, _ := svc.ShortenURL(req, "alice", "127.0.0.1")
 
 	err := svc.IncrementClickCount(created.ShortCode)
 	if err != nil {
@@ -176,23 +215,33 @@ func TestURLServiceIncrementClickCount(t *testing.T) {
 }
 
 func TestURLServiceGetUserURLs(t *testing.T) {
-	db := setupTestDB(t)
-	defer db.Close()
-
-	svc := services.NewURLService(db)
+	svc, _ := newMemoryServices(t)
 	userIP := "192.168.1.1"
 
 	for i := 0; i < 3; i++ {
 		req := models.ShortenURLRequest{
 			OriginalURL: "https://example.com/user",
 		}
-		_, err := svc.ShortenURL(req, userIP)
+		_, err := svc.ShortenURL(req, "alice", userIP)
 		if err != nil {
 			t.Fatalf("setup failed: %v", err)
 		}
 	}
 
-	urls, err := svc.GetUserURLs(userIP)
+	// Same IP, different owner: must not show up in alice's list.
+	other := models.ShortenURLRequest{OriginalURL: "https://example.com/other"}
+	if _, err := svc.ShortenURL(other, "bob", userIP); err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	if _, err := svc.ShortenURL(other, "", userIP); err != nil {
+		t.Fatalf("anonymous shorten failed: %v", err)
+	}
+
+	if _, err := svc.GetUserURLs(""); !errors.Is(err, services.ErrUnauthorized) {
+		t.Errorf("anonymous list: got %v, want ErrUnauthorized", err)
+	}
+
+	urls, err := svc.GetUserURLs("alice")
 	if err != nil {
 		t.Fatalf("get user urls failed: %v", err)
 	}
@@ -202,18 +251,341 @@ func TestURLServiceGetUserURLs(t *testing.T) {
 	}
 }
 
-func TestAnalyticsServiceRecordClick(t *testing.T) {
+func TestURLServiceUpdateURL(t *testing.T) {
+	svc, _ := newMemoryServices(t)
+
+	req := models.ShortenURLRequest{
+		OriginalURL: "https://example.com/before",
+		CustomCode:  "update",
+	}
+	if _, err := svc.ShortenURL(req, "alice", "127.0.0.1"); err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+
+	newURL := "https://example.com/after"
+	update := models.UpdateURLRequest{OriginalURL: &newURL}
+
+	if _, err := svc.UpdateURL("update", update, "bob"); !errors.Is(err, services.ErrForbidden) {
+		t.Errorf("update by non-owner: got %v, want ErrForbidden", err)
+	}
+
+	updated, err := svc.UpdateURL("update", update, "alice")
+	if err != nil {
+		t.Fatalf("update failed: %v", err)
+	}
+
+	if updated.OriginalURL != newURL {
+		t.Errorf("original URL = %s, want %s", updated.OriginalURL, newURL)
+	}
+
+	retrieved, err := svc.GetOriginalURL("update")
+	if err != nil {
+		t.Fatalf("retrieval failed: %v", err)
+	}
+	if retrieved.OriginalURL != newURL {
+		t.Errorf("stored URL = %s, want %s", retrieved.OriginalURL, newURL)
+	}
+
+	if _, err := svc.UpdateURL("missing", update, "alice"); !errors.Is(err, services.ErrURLNotFound) {
+		t.Errorf("update of missing code: got %v, want ErrURLNotFound", err)
+	}
+}
+
+func TestURLServiceUpdateExpiredURL(t *testing.T) {
+	svc, _ := newMemoryServices(t)
+
+	past := time.Now().Add(-1 * time.Hour)
+	req := models.ShortenURLRequest{
+		OriginalURL: "https://example.com/extend",
+		CustomCode:  "extend",
+		ExpiresAt:   &past,
+	}
+	if _, err := svc.ShortenURL(req, "alice", "127.0.0.1"); err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+
+	future := time.Now().Add(24 * time.Hour)
+	updated, err := svc.UpdateURL("extend", models.UpdateURLRequest{ExpiresAt: &future}, "alice")
+	if err != nil {
+		t.Fatalf("update of expired URL failed: %v", err)
+	}
+	if updated.ExpiresAt == nil || !updated.ExpiresAt.Equal(future) {
+		t.Errorf("expires at = %v, want %v", updated.ExpiresAt, future)
+	}
+
+	if _, err := svc.GetOriginalURL("extend"); err != nil {
+		t.Errorf("extended URL should resolve, got %v", err)
+	}
+
+	cleared, err := svc.UpdateURL("extend", models.UpdateURLRequest{ClearExpiresAt: true}, "alice")
+	if err != nil {
+		t.Fatalf("clearing expiry failed: %v", err)
+	}
+	if cleared.ExpiresAt != nil {
+		t.Errorf("expires at = %v, want nil", cleared.ExpiresAt)
+	}
+	if cleared.OriginalURL != req.OriginalURL {
+		t.Errorf("original URL = %s, want unchanged %s", cleared.OriginalURL, req.OriginalURL)
+	}
+}
+
+func TestURLServiceDeleteURL(t *testing.T) {
+	svc, _ := newMemoryServices(t)
+
+	req := models.ShortenURLRequest{
+		OriginalURL: "https://example.com/delete",
+		CustomCode:  "delete",
+	}
+	if _, err := svc.ShortenURL(req, "alice", "127.0.0.1"); err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+
+	if err := svc.DeleteURL("delete", "bob"); !errors.Is(err, services.ErrForbidden) {
+		t.Errorf("delete by non-owner: got %v, want ErrForbidden", err)
+	}
+
+	if err := svc.DeleteURL("delete", "alice"); err != nil {
+		t.Fatalf("delete failed: %v", err)
+	}
+
+	if _, err := svc.GetOriginalURL("delete"); !errors.Is(err, services.ErrURLNotFound) {
+		t.Errorf("get after delete: got %v, want ErrURLNotFound", err)
+	}
+
+	urls, err := svc.GetUserURLs("alice")
+	if err != nil {
+		t.Fatalf("get user urls failed: %v", err)
+	}
+	if len(urls) != 0 {
+		t.Errorf("got %d URLs after delete, want 0", len(urls))
+	}
+
+	if err := svc.DeleteURL("delete", "alice"); !errors.Is(err, services.ErrURLNotFound) {
+		t.Errorf("second delete: got %v, want ErrURLNotFound", err)
+	}
+
+	newURL := "https://example.com/revived"
+	if _, err := svc.UpdateURL("delete", models.UpdateURLRequest{OriginalURL: &newURL}, "alice"); !errors.Is(err, services.ErrURLNotFound) {
+		t.Errorf("update after delete: got %v, want ErrURLNotFound", err)
+	}
+
+	if err := svc.DeleteURL("missing", "alice"); !errors.Is(err, services.ErrURLNotFound) {
+		t.Errorf("delete of missing code: got %v, want ErrURLNotFound", err)
+	}
+
+	if _, err := svc.ShortenURL(req, "alice", "127.0.0.1"); err == nil {
+		t.Error("deleted short code should stay reserved")
+	}
+}
+
+func TestAnalyticsAfterDelete(t *testing.T) {
+	urlSvc, analyticsSvc := newMemoryServices(t)
+
+	req := models.ShortenURLRequest{
+		OriginalURL: "https://example.com/history",
+		CustomCode:  "history",
+	}
+	if _, err := urlSvc.ShortenURL(req, "alice", "127.0.0.1"); err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+
+	click := models.Click{
+		URLShortCode: "history",
+		IPAddress:    "127.0.0.1",
+		ClickedAt:    time.Now(),
+	}
+	if err := analyticsSvc.RecordClick(click); err != nil {
+		t.Fatalf("record click failed: %v", err)
+	}
+
+	if err := urlSvc.DeleteURL("history", "alice"); err != nil {
+		t.Fatalf("delete failed: %v", err)
+	}
+
+	analytics, err := analyticsSvc.GetAnalytics("history", "alice")
+	if err != nil {
+		t.Fatalf("get analytics after delete failed: %v", err)
+	}
+	if analytics.TotalClicks != 1 {
+		t.Errorf("total clicks = %d, want 1", analytics.TotalClicks)
+	}
+	if analytics.URL.DeletedAt == nil {
+		t.Error("analytics should report the link as deleted")
+	}
+}
+
+func TestUpdateDeleteHandlers(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	urlSvc := services.NewURLService(store.New(db), services.NewTeamService(db), config.Default().Links)
+	analyticsSvc := services.NewAnalyticsService(store.New(db), store.New(db), services.NewTeamService(db), nil)
+	keySvc := services.NewAPIKeyService(db)
+	handler := handlers.NewURLHandler(urlSvc, analyticsSvc, nil, nil, config.Default().QR)
+
+	router := mux.NewRouter()
+	router.Use(middleware.AuthMiddleware(keySvc))
+	router.HandleFunc("/api/v1/urls/{shortCode}", handler.UpdateURL).Methods("PATCH")
+	router.HandleFunc("/api/v1/urls/{shortCode}", handler.DeleteURL).Methods("DELETE")
+
+	aliceKey, _, err := keySvc.CreateKey("alice", "test")
+	if err != nil {
+		t.Fatalf("create key failed: %v", err)
+	}
+	bobKey, _, err := keySvc.CreateKey("bob", "test")
+	if err != nil {
+		t.Fatalf("create key failed: %v", err)
+	}
+
+	req := models.ShortenURLRequest{
+		OriginalURL: "https://example.com/http",
+		CustomCode:  "httpcode",
+	}
+	if _, err := urlSvc.ShortenURL(req, "alice", "127.0.0.1"); err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+
+	serve := func(method, path, body, key string) *httptest.ResponseRecorder {
+		httpReq := httptest.NewRequest(method, path, strings.NewReader(body))
+		if key != "" {
+			httpReq.Header.Set("Authorization", "Bearer "+key)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httpReq)
+		return rr
+	}
+
+	tests := []struct {
+		name     string
+		method   string
+		path     string
+		body     string
+		key      string
+		wantCode int
+	}{
+		{"patch invalid json", "PATCH", "/api/v1/urls/httpcode", "{", aliceKey, http.StatusBadRequest},
+		{"patch invalid url", "PATCH", "/api/v1/urls/httpcode", `{"original_url":"ftp://x"}`, aliceKey, http.StatusBadRequest},
+		{"patch anonymous", "PATCH", "/api/v1/urls/httpcode", `{"original_url":"https://example.com/x"}`, "", http.StatusUnauthorized},
+		{"patch bad key", "PATCH", "/api/v1/urls/httpcode", `{"original_url":"https://example.com/x"}`, "usk_nope", http.StatusUnauthorized},
+		{"patch missing", "PATCH", "/api/v1/urls/nothere", `{"original_url":"https://example.com/x"}`, aliceKey, http.StatusNotFound},
+		{"patch non-owner", "PATCH", "/api/v1/urls/httpcode", `{"original_url":"https://example.com/x"}`, bobKey, http.StatusForbidden},
+		{"patch owner", "PATCH", "/api/v1/urls/httpcode", `{"original_url":"https://example.com/x"}`, aliceKey, http.StatusOK},
+		{"delete non-owner", "DELETE", "/api/v1/urls/httpcode", "", bobKey, http.StatusForbidden},
+		{"delete owner", "DELETE", "/api/v1/urls/httpcode", "", aliceKey, http.StatusNoContent},
+		{"delete again", "DELETE", "/api/v1/urls/httpcode", "", aliceKey, http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		rr := serve(tt.method, tt.path, tt.body, tt.key)
+		if rr.Code != tt.wantCode {
+			t.Errorf("%s: status = %d, want %d", tt.name, rr.Code, tt.wantCode)
+		}
+	}
+}
+
+func TestAPIKeyServiceLifecycle(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	svc := services.NewAPIKeyService(db)
+
+	plaintext, key, err := svc.CreateKey("alice", "ci")
+	if err != nil {
+		t.Fatalf("create key failed: %v", err)
+	}
+
+	if !strings.HasPrefix(plaintext, key.Prefix) {
+		t.Errorf("key %s should start with prefix %s", plaintext, key.Prefix)
+	}
+	if key.KeyHash == plaintext || strings.Contains(key.KeyHash, plaintext) {
+		t.Error("key must not be stored in plaintext")
+	}
+
+	authed, err := svc.Authenticate(plaintext)
+	if err != nil {
+		t.Fatalf("authenticate failed: %v", err)
+	}
+	if authed.OwnerID != "alice" {
+		t.Errorf("owner = %s, want alice", authed.OwnerID)
+	}
+
+	keys, err := svc.ListKeys("alice")
+	if err != nil {
+		t.Fatalf("list keys failed: %v", err)
+	}
+	if len(keys) != 1 || keys[0].LastUsedAt == nil {
+		t.Errorf("expected one key with last_used_at set, got %+v", keys)
+	}
+
+	if _, err := svc.Authenticate("usk_wrong"); !errors.Is(err, services.ErrInvalidAPIKey) {
+		t.Errorf("wrong key: got %v, want ErrInvalidAPIKey", err)
+	}
+
+	if err := svc.RevokeKey("bob", key.ID); !errors.Is(err, services.ErrAPIKeyMissing) {
+		t.Errorf("revoke by non-owner: got %v, want ErrAPIKeyMissing", err)
+	}
+	if err := svc.RevokeKey("alice", key.ID); err != nil {
+		t.Fatalf("revoke failed: %v", err)
+	}
+	if _, err := svc.Authenticate(plaintext); !errors.Is(err, services.ErrAPIKeyRevoked) {
+		t.Errorf("revoked key: got %v, want ErrAPIKeyRevoked", err)
+	}
+}
+
+func TestAuthMiddleware(t *testing.T) {
 	db := setupTestDB(t)
 	defer db.Close()
 
-	urlSvc := services.NewURLService(db)
-	analyticsSvc := services.NewAnalyticsService(db)
+	svc := services.NewAPIKeyService(db)
+	plaintext, _, err := svc.CreateKey("alice", "")
+	if err != nil {
+		t.Fatalf("create key failed: %v", err)
+	}
+
+	var gotOwner string
+	handler := middleware.AuthMiddleware(svc)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotOwner = middleware.OwnerIDFromContext(r.Context())
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	tests := []struct {
+		header    string
+		wantCode  int
+		wantOwner string
+	}{
+		{"", http.StatusOK, ""},
+		{"Bearer " + plaintext, http.StatusOK, "alice"},
+		{"bearer " + plaintext, http.StatusOK, "alice"},
+		{"Basic abc", http.StatusUnauthorized, ""},
+		{"Bearer usk_wrong", http.StatusUnauthorized, ""},
+	}
+
+	for _, tt := range tests {
+		gotOwner = ""
+		req := httptest.NewRequest("GET", "/", nil)
+		if tt.header != "" {
+			req.Header.Set("Authorization", tt.header)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != tt.wantCode {
+			t.Errorf("%q: status = %d, want %d", tt.header, rr.Code, tt.wantCode)
+		}
+		if gotOwner != tt.wantOwner {
+			t.Errorf("%q: owner = %q, want %q", tt.header, gotOwner, tt.wantOwner)
+		}
+	}
+}
+
+func TestAnalyticsServiceRecordClick(t *testing.T) {
+	urlSvc, analyticsSvc := newMemoryServices(t)
 
 	req := models.ShortenURLRequest{
 		OriginalURL: "https://example.com/analytics",
 		CustomCode:  "analytics",
 	}
-	created, _ := urlSvc.ShortenURL(req, "127.0.0.1")
+	created, _ := urlSvc.ShortenURL(req, "alice", "127.0.0.1")
 
 	click := models.Click{
 		URLShortCode: created.ShortCode,
@@ -229,17 +601,13 @@ func TestAnalyticsServiceRecordClick(t *testing.T) {
 }
 
 func TestAnalyticsServiceGetAnalytics(t *testing.T) {
-	db := setupTestDB(t)
-	defer db.Close()
-
-	urlSvc := services.NewURLService(db)
-	analyticsSvc := services.NewAnalyticsService(db)
+	urlSvc, analyticsSvc := newMemoryServices(t)
 
 	req := models.ShortenURLRequest{
 		OriginalURL: "https://example.com/stats",
 		CustomCode:  "stats",
 	}
-	created, _ := urlSvc.ShortenURL(req, "127.0.0.1")
+	created, _ := urlSvc.ShortenURL(req, "alice", "127.0.0.1")
 
 	for i := 0; i < 5; i++ {
 		click := models.Click{
@@ -252,7 +620,14 @@ func TestAnalyticsServiceGetAnalytics(t *testing.T) {
 		analyticsSvc.RecordClick(click)
 	}
 
-	analytics, err := analyticsSvc.GetAnalytics(created.ShortCode)
+	if _, err := analyticsSvc.GetAnalytics(created.ShortCode, ""); !errors.Is(err, services.ErrUnauthorized) {
+		t.Errorf("anonymous analytics: got %v, want ErrUnauthorized", err)
+	}
+	if _, err := analyticsSvc.GetAnalytics(created.ShortCode, "bob"); !errors.Is(err, services.ErrForbidden) {
+		t.Errorf("analytics by non-owner: got %v, want ErrForbidden", err)
+	}
+
+	analytics, err := analyticsSvc.GetAnalytics(created.ShortCode, "alice")
 	if err != nil {
 		t.Fatalf("get analytics failed: %v", err)
 	}
@@ -271,7 +646,7 @@ func TestRateLimitMiddleware(t *testing.T) {
 		w.WriteHeader(http.StatusOK)
 	})
 
-	rateLimited := middleware.RateLimitMiddleware(handler)
+	rateLimited := middleware.RateLimitMiddleware(config.Default().RateLimit)(handler)
 
 	req := httptest.NewRequest("GET", "/test", nil)
 	req.RemoteAddr = "192.168.1.100:12345"
@@ -315,21 +690,17 @@ func TestCORSMiddleware(t *testing.T) {
 }
 
 func TestQRCodeGeneration(t *testing.T) {
-	db := setupTestDB(t)
-	defer db.Close()
-
-	urlSvc := services.NewURLService(db)
-	analyticsSvc := services.NewAnalyticsService(db)
-	handler := handlers.NewURLHandler(urlSvc, analyticsSvc)
+	urlSvc, analyticsSvc := newMemoryServices(t)
+	handler := handlers.NewURLHandler(urlSvc, analyticsSvc, nil, nil, config.Default().QR)
 
 	req := models.ShortenURLRequest{
 		OriginalURL: "https://example.com/qrtest",
 		CustomCode:  "qrtest",
 	}
-	urlSvc.ShortenURL(req, "127.0.0.1")
+	urlSvc.ShortenURL(req, "alice", "127.0.0.1")
 
 	httpReq := httptest.NewRequest("GET", "/api/v1/qr/qrtest", nil)
-	httpReq = httpReq.WithContext(httpReq.Context())
+	httpReq = mux.SetURLVars(httpReq, map[string]string{"shortCode": "qrtest"})
 	rr := httptest.NewRecorder()
 
 	handler.GenerateQRCode(rr, httpReq)
@@ -353,42 +724,359 @@ func TestQRCodeGeneration(t *testing.T) {
 	}
 }
 
+func TestRedirectTypes(t *testing.T) {
+	urlSvc, analyticsSvc := newMemoryServices(t)
+	handler := handlers.NewURLHandler(urlSvc, analyticsSvc, nil, nil, config.Default().QR)
+
+	cases := []struct {
+		code         string
+		redirectType int
+		wantStatus   int
+		wantCache    string
+	}{
+		{"deflt", 0, http.StatusFound, "no-store"},
+		{"moved", http.StatusMovedPermanently, http.StatusMovedPermanently, "max-age=86400"},
+		{"tempo", http.StatusTemporaryRedirect, http.StatusTemporaryRedirect, "no-store"},
+		{"perma", http.StatusPermanentRedirect, http.StatusPermanentRedirect, "max-age=86400"},
+	}
 
-func TestQRCodeGeneration(t *testing.T) {
-	db := setupTestDB(t)
-	defer db.Close()
+	for _, tc := range cases {
+		req := models.ShortenURLRequest{
+			OriginalURL:  "https://example.com/" + tc.code,
+			CustomCode:   tc.code,
+			RedirectType: tc.redirectType,
+		}
+		url, err := urlSvc.ShortenURL(req, "alice", "127.0.0.1")
+		if err != nil {
+			t.Fatalf("shorten %s failed: %v", tc.code, err)
+		}
+		if url.RedirectType != tc.wantStatus {
+			t.Errorf("%s: stored redirect type = %d, want %d", tc.code, url.RedirectType, tc.wantStatus)
+		}
 
-	urlSvc := services.NewURLService(db)
-	analyticsSvc := services.NewAnalyticsService(db)
-	handler := handlers.NewURLHandler(urlSvc, analyticsSvc)
+		httpReq := httptest.NewRequest("GET", "/"+tc.code, nil)
+		httpReq = mux.SetURLVars(httpReq, map[string]string{"shortCode": tc.code})
+		rr := httptest.NewRecorder()
+		handler.RedirectURL(rr, httpReq)
 
-	req := models.ShortenURLRequest{
-		OriginalURL: "https://example.com/qrtest",
-		CustomCode:  "qrtest",
+		if rr.Code != tc.wantStatus {
+			t.Errorf("%s: status = %d, want %d", tc.code, rr.Code, tc.wantStatus)
+		}
+		if cc := rr.Header().Get("Cache-Control"); !strings.Contains(cc, tc.wantCache) {
+			t.Errorf("%s: Cache-Control = %q, want it to contain %q", tc.code, cc, tc.wantCache)
+		}
+		if loc := rr.Header().Get("Location"); loc != req.OriginalURL {
+			t.Errorf("%s: Location = %q, want %q", tc.code, loc, req.OriginalURL)
+		}
 	}
-	urlSvc.ShortenURL(req, "127.0.0.1")
 
-	httpReq := httptest.NewRequest("GET", "/api/v1/qr/qrtest", nil)
-	httpReq = httpReq.WithContext(httpReq.Context())
+	bad := models.ShortenURLRequest{OriginalURL: "https://example.com/bad", RedirectType: http.StatusSeeOther}
+	if _, err := urlSvc.ShortenURL(bad, "alice", "127.0.0.1"); !errors.Is(err, services.ErrInvalidRedirectType) {
+		t.Errorf("shorten with 303: got %v, want ErrInvalidRedirectType", err)
+	}
+
+	badType := http.StatusOK
+	if _, err := urlSvc.UpdateURL("deflt", models.UpdateURLRequest{RedirectType: &badType}, "alice"); !errors.Is(err, services.ErrInvalidRedirectType) {
+		t.Errorf("update with 200: got %v, want ErrInvalidRedirectType", err)
+	}
+
+	permanent := http.StatusMovedPermanently
+	updated, err := urlSvc.UpdateURL("deflt", models.UpdateURLRequest{RedirectType: &permanent}, "alice")
+	if err != nil {
+		t.Fatalf("update failed: %v", err)
+	}
+	if updated.RedirectType != permanent {
+		t.Errorf("updated redirect type = %d, want %d", updated.RedirectType, permanent)
+	}
+}
+
+func TestConfiguredDefaultRedirectType(t *testing.T) {
+	t.Parallel()
+	links := config.Default().Links
+	links.RedirectType = http.StatusTemporaryRedirect
+	svc := services.NewURLService(store.NewMemoryStore(), nil, links)
+
+	url, err := svc.ShortenURL(models.ShortenURLRequest{OriginalURL: "https://example.com"}, "alice", "127.0.0.1")
+	if err != nil {
+		t.Fatalf("shorten failed: %v", err)
+	}
+	if url.RedirectType != http.StatusTemporaryRedirect {
+		t.Errorf("redirect type = %d, want %d", url.RedirectType, http.StatusTemporaryRedirect)
+	}
+}
+
+func TestMaxClicks(t *testing.T) {
+	urlSvc, analyticsSvc := newMemoryServices(t)
+	handler := handlers.NewURLHandler(urlSvc, analyticsSvc, nil, nil, config.Default().QR)
+
+	limit := 5
+	req := models.ShortenURLRequest{OriginalURL: "https://example.com/limited", CustomCode: "limited", MaxClicks: &limit}
+	if _, err := urlSvc.ShortenURL(req, "alice", "127.0.0.1"); err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+
+	var mu sync.Mutex
+	statuses := make(map[int]int)
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			httpReq := httptest.NewRequest("GET", "/limited", nil)
+			httpReq = mux.SetURLVars(httpReq, map[string]string{"shortCode": "limited"})
+			rr := httptest.NewRecorder()
+			handler.RedirectURL(rr, httpReq)
+
+			mu.Lock()
+			statuses[rr.Code]++
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	if statuses[http.StatusFound] != limit || statuses[http.StatusGone] != 20-limit {
+		t.Errorf("statuses = %v, want %d redirects and %d limit responses", statuses, limit, 20-limit)
+	}
+
+	analytics, err := analyticsSvc.GetAnalytics("limited", "alice")
+	if err != nil {
+		t.Fatalf("analytics failed: %v", err)
+	}
+	if analytics.TotalClicks != limit || analytics.URL.ClickCount != limit {
+		t.Errorf("clicks = %d, click count = %d, want %d", analytics.TotalClicks, analytics.URL.ClickCount, limit)
+	}
+
+	if _, err := urlSvc.GetOriginalURL("limited"); !errors.Is(err, services.ErrClickLimitReached) {
+		t.Errorf("GetOriginalURL: got %v, want ErrClickLimitReached", err)
+	}
+
+	zero := 0
+	bad := models.ShortenURLRequest{OriginalURL: "https://example.com", MaxClicks: &zero}
+	if _, err := urlSvc.ShortenURL(bad, "alice", "127.0.0.1"); !errors.Is(err, services.ErrInvalidMaxClicks) {
+		t.Errorf("max clicks 0: got %v, want ErrInvalidMaxClicks", err)
+	}
+}
+
+func TestOneTimeLink(t *testing.T) {
+	urlSvc, analyticsSvc := newMemoryServices(t)
+	handler := handlers.NewURLHandler(urlSvc, analyticsSvc, nil, nil, config.Default().QR)
+
+	once := 1
+	req := models.ShortenURLRequest{OriginalURL: "https://example.com/reset", CustomCode: "reset", MaxClicks: &once}
+	if _, err := urlSvc.ShortenURL(req, "alice", "127.0.0.1"); err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+
+	for i, want := range []int{http.StatusFound, http.StatusGone} {
+		httpReq := httptest.NewRequest("GET", "/reset", nil)
+		httpReq = mux.SetURLVars(httpReq, map[string]string{"shortCode": "reset"})
+		rr := httptest.NewRecorder()
+		handler.RedirectURL(rr, httpReq)
+
+		if rr.Code != want {
+			t.Errorf("visit %d: status = %d, want %d", i+1, rr.Code, want)
+		}
+		if cc := rr.Header().Get("Cache-Control"); !strings.Contains(cc, "no-store") {
+			t.Errorf("visit %d: Cache-Control = %q, limited links must not be cached", i+1, cc)
+		}
+	}
+}
+
+func TestURLServiceActivation(t *testing.T) {
+	svc, _ := newMemoryServices(t)
+
+	future := time.Now().Add(time.Hour)
+	req := models.ShortenURLRequest{OriginalURL: "https://example.com/launch", CustomCode: "launch", ActivatesAt: &future}
+	created, err := svc.ShortenURL(req, "alice", "127.0.0.1")
+	if err != nil {
+		t.Fatalf("shorten failed: %v", err)
+	}
+	if created.ActivatesAt == nil || !created.ActivatesAt.Equal(future) {
+		t.Errorf("activates_at = %v, want %v", created.ActivatesAt, future)
+	}
+
+	if _, err := svc.GetOriginalURL("launch"); !errors.Is(err, services.ErrURLNotActive) {
+		t.Errorf("before activation: got %v, want ErrURLNotActive", err)
+	}
+
+	past := time.Now().Add(-time.Minute)
+	if _, err := svc.UpdateURL("launch", models.UpdateURLRequest{ActivatesAt: &past}, "alice"); err != nil {
+		t.Fatalf("update failed: %v", err)
+	}
+	if _, err := svc.GetOriginalURL("launch"); err != nil {
+		t.Errorf("after activation: got %v, want the link", err)
+	}
+
+	// The window is checked against the stored expiry too.
+	expires := time.Now().Add(2 * time.Hour)
+	if _, err := svc.UpdateURL("launch", models.UpdateURLRequest{ExpiresAt: &expires}, "alice"); err != nil {
+		t.Fatalf("update failed: %v", err)
+	}
+	late := expires.Add(time.Hour)
+	if _, err := svc.UpdateURL("launch", models.UpdateURLRequest{ActivatesAt: &late}, "alice"); !errors.Is(err, services.ErrInvalidActiveWindow) {
+		t.Errorf("activation after expiry: got %v, want ErrInvalidActiveWindow", err)
+	}
+	if _, err := svc.UpdateURL("launch", models.UpdateURLRequest{ActivatesAt: &late, ClearExpiresAt: true}, "alice"); err != nil {
+		t.Errorf("activation with cleared expiry: got %v", err)
+	}
+
+	bad := models.ShortenURLRequest{OriginalURL: "https://example.com", ActivatesAt: &expires, ExpiresAt: &future}
+	if _, err := svc.ShortenURL(bad, "alice", "127.0.0.1"); !errors.Is(err, services.ErrInvalidActiveWindow) {
+		t.Errorf("inverted window: got %v, want ErrInvalidActiveWindow", err)
+	}
+}
+
+func TestScheduledLinkHandlers(t *testing.T) {
+	urlSvc, analyticsSvc := newMemoryServices(t)
+	handler := handlers.NewURLHandler(urlSvc, analyticsSvc, nil, nil, config.Default().QR)
+
+	future := time.Now().Add(time.Hour)
+	req := models.ShortenURLRequest{OriginalURL: "https://example.com/launch", CustomCode: "soon", ActivatesAt: &future}
+	if _, err := urlSvc.ShortenURL(req, "alice", "127.0.0.1"); err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+
+	httpReq := mux.SetURLVars(httptest.NewRequest("GET", "/soon", nil), map[string]string{"shortCode": "soon"})
 	rr := httptest.NewRecorder()
+	handler.RedirectURL(rr, httpReq)
+	if rr.Code != http.StatusForbidden || !strings.Contains(rr.Body.String(), "Link not yet active") {
+		t.Errorf("redirect before activation: got %d %s", rr.Code, rr.Body.String())
+	}
 
+	httpReq = mux.SetURLVars(httptest.NewRequest("GET", "/api/v1/qr/soon", nil), map[string]string{"shortCode": "soon"})
+	rr = httptest.NewRecorder()
 	handler.GenerateQRCode(rr, httpReq)
-
 	if rr.Code != http.StatusOK {
-		t.Errorf("status = %d, want %d", rr.Code, http.StatusOK)
+		t.Errorf("QR code before activation: status = %d, want %d", rr.Code, http.StatusOK)
 	}
 
-	contentType := rr.Header().Get("Content-Type")
-	if contentType != "image/png" {
-		t.Errorf("content type = %s, want image/png", contentType)
+	analytics, err := analyticsSvc.GetAnalytics("soon", "alice")
+	if err != nil {
+		t.Fatalf("analytics failed: %v", err)
+	}
+	if analytics.TotalClicks != 0 {
+		t.Errorf("visits before activation should not be clicks, got %d", analytics.TotalClicks)
 	}
+}
 
-	if rr.Body.Len() == 0 {
-		t.Error("QR code should have content")
+func TestFallbackURLs(t *testing.T) {
+	t.Parallel()
+	links := config.Default().Links
+	links.FallbackURL = "https://example.com/campaigns"
+	s := store.NewMemoryStore()
+	urlSvc := services.NewURLService(s, nil, links)
+	analyticsSvc := services.NewAnalyticsService(s, s, nil, nil)
+	handler := handlers.NewURLHandler(urlSvc, analyticsSvc, nil, nil, config.Default().QR)
+
+	past := time.Now().Add(-time.Hour)
+	future := time.Now().Add(time.Hour)
+	once := 1
+	setup := []models.ShortenURLRequest{
+		{OriginalURL: "https://example.com/a", CustomCode: "expired", ExpiresAt: &past, FallbackURL: "https://example.com/landing"},
+		{OriginalURL: "https://example.com/b", CustomCode: "global", ExpiresAt: &past},
+		{OriginalURL: "https://example.com/c", CustomCode: "usedup", MaxClicks: &once, FallbackURL: "https://example.com/sold-out"},
+		{OriginalURL: "https://example.com/d", CustomCode: "removed", FallbackURL: "https://example.com/moved"},
+		{OriginalURL: "https://example.com/e", CustomCode: "later", ActivatesAt: &future, FallbackURL: "https://example.com/landing"},
+	}
+	for _, req := range setup {
+		if _, err := urlSvc.ShortenURL(req, "alice", "127.0.0.1"); err != nil {
+			t.Fatalf("setup %s failed: %v", req.CustomCode, err)
+		}
+	}
+	if err := urlSvc.DeleteURL("removed", "alice"); err != nil {
+		t.Fatalf("delete failed: %v", err)
 	}
 
-	pngHeader := []byte{0x89, 0x50, 0x4E, 0x47}
-	if !bytes.HasPrefix(rr.Body.Bytes(), pngHeader) {
-		t.Error("response should be a valid PNG image")
+	visit := func(code string) *httptest.ResponseRecorder {
+		httpReq := mux.SetURLVars(httptest.NewRequest("GET", "/"+code, nil), map[string]string{"shortCode": code})
+		rr := httptest.NewRecorder()
+		handler.RedirectURL(rr, httpReq)
+		return rr
 	}
-}
\ No newline at end of file
+	visit("usedup")
+
+	cases := []struct {
+		code     string
+		status   int
+		location string
+	}{
+		{"expired", http.StatusFound, "https://example.com/landing"},
+		{"global", http.StatusFound, "https://example.com/campaigns"},
+		{"usedup", http.StatusFound, "https://example.com/sold-out"},
+		{"removed", http.StatusFound, "https://example.com/moved"},
+		{"later", http.StatusForbidden, ""},
+		{"unknown", http.StatusNotFound, ""},
+	}
+	for _, tc := range cases {
+		rr := visit(tc.code)
+		if rr.Code != tc.status || rr.Header().Get("Location") != tc.location {
+			t.Errorf("%s: got %d to %q, want %d to %q", tc.code, rr.Code, rr.Header().Get("Location"), tc.status, tc.location)
+		}
+		if cc := rr.Header().Get("Cache-Control"); cc != "no-store" {
+			t.Errorf("%s: Cache-Control = %q, want no-store", tc.code, cc)
+		}
+	}
+
+	empty := ""
+	if _, err := urlSvc.UpdateURL("expired", models.UpdateURLRequest{FallbackURL: &empty}, "alice"); err != nil {
+		t.Fatalf("clearing fallback failed: %v", err)
+	}
+	if rr := visit("expired"); rr.Header().Get("Location") != "https://example.com/campaigns" {
+		t.Errorf("cleared fallback: got %q, want the global fallback", rr.Header().Get("Location"))
+	}
+
+	bad := "ftp://example.com"
+	if _, err := urlSvc.UpdateURL("global", models.UpdateURLRequest{FallbackURL: &bad}, "alice"); !errors.Is(err, services.ErrInvalidFallbackURL) {
+		t.Errorf("update with %s: got %v, want ErrInvalidFallbackURL", bad, err)
+	}
+	if _, err := urlSvc.ShortenURL(models.ShortenURLRequest{OriginalURL: "https://example.com", FallbackURL: bad}, "alice", "127.0.0.1"); !errors.Is(err, services.ErrInvalidFallbackURL) {
+		t.Errorf("shorten with %s: got %v, want ErrInvalidFallbackURL", bad, err)
+	}
+}
+
+func TestUnavailableLinkContentNegotiation(t *testing.T) {
+	urlSvc, analyticsSvc := newMemoryServices(t)
+	handler := handlers.NewURLHandler(urlSvc, analyticsSvc, nil, nil, config.Default().QR)
+
+	past := time.Now().Add(-time.Hour)
+	req := models.ShortenURLRequest{OriginalURL: "https://example.com", CustomCode: "gone", ExpiresAt: &past}
+	if _, err := urlSvc.ShortenURL(req, "alice", "127.0.0.1"); err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+
+	cases := []struct {
+		accept string
+		html   bool
+	}{
+		{"", false},
+		{"*/*", false},
+		{"application/json", false},
+		{"text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8", true},
+		{"application/json, text/html;q=0.5", false},
+		{"text/*", true},
+		{"text/html;q=0, */*", false},
+	}
+	for _, tc := range cases {
+		httpReq := mux.SetURLVars(httptest.NewRequest("GET", "/gone", nil), map[string]string{"shortCode": "gone"})
+		if tc.accept != "" {
+			httpReq.Header.Set("Accept", tc.accept)
+		}
+		rr := httptest.NewRecorder()
+		handler.RedirectURL(rr, httpReq)
+
+		if rr.Code != http.StatusNotFound {
+			t.Errorf("Accept %q: status = %d, want %d", tc.accept, rr.Code, http.StatusNotFound)
+		}
+		contentType := rr.Header().Get("Content-Type")
+		if tc.html {
+			if !strings.HasPrefix(contentType, "text/html") || !strings.Contains(rr.Body.String(), "Link expired") {
+				t.Errorf("Accept %q: want the HTML error page, got %s %s", tc.accept, contentType, rr.Body.String())
+			}
+			continue
+		}
+		var resp models.ErrorResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil || resp.Error != "URL not found" {
+			t.Errorf("Accept %q: want a JSON error, got %s %s", tc.accept, contentType, rr.Body.String())
+		}
+	}
+}
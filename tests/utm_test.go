@@ -0,0 +1,160 @@
+package tests
+
+import (
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"url-shortener/internal/config"
+	"url-shortener/internal/handlers"
+	"url-shortener/internal/models"
+	"url-shortener/internal/services"
+
+	"github.com/gorilla/mux"
+)
+
+// redirectWithQuery requests /{shortCode}?query and returns the location.
+func redirectWithQuery(handler *handlers.URLHandler, shortCode, query string) string {
+	req := mux.SetURLVars(httptest.NewRequest("GET", "/"+shortCode+"?"+query, nil), map[string]string{"shortCode": shortCode})
+	rr := httptest.NewRecorder()
+	handler.RedirectURL(rr, req)
+	return rr.Header().Get("Location")
+}
+
+func TestUTMParameters(t *testing.T) {
+	urlSvc, _ := newMemoryServices(t)
+
+	req := models.ShortenURLRequest{
+		OriginalURL: "https://example.com/p?utm_source=old&x=1#pricing",
+		CustomCode:  "spring",
+		UTM:         &models.UTM{Source: "newsletter", Medium: " email ", Campaign: "spring sale"},
+		Variants: []models.Variant{
+			{Name: "a", URL: "https://example.com/a"},
+			{Name: "b", URL: "https://example.com/b?utm_campaign=old"},
+		},
+	}
+	created, err := urlSvc.ShortenURL(req, "alice", "127.0.0.1")
+	if err != nil {
+		t.Fatalf("shorten failed: %v", err)
+	}
+	if want := "https://example.com/p?x=1&utm_source=newsletter&utm_medium=email&utm_campaign=spring+sale#pricing"; created.OriginalURL != want {
+		t.Errorf("OriginalURL = %s, want %s", created.OriginalURL, want)
+	}
+	if want := "https://example.com/b?utm_source=newsletter&utm_medium=email&utm_campaign=spring+sale"; created.Variants[1].URL != want {
+		t.Errorf("variant URL = %s, want %s", created.Variants[1].URL, want)
+	}
+
+	updated, err := urlSvc.UpdateURL("spring", models.UpdateURLRequest{UTM: &models.UTM{Campaign: "summer"}}, "alice")
+	if err != nil {
+		t.Fatalf("update failed: %v", err)
+	}
+	if want := "https://example.com/p?x=1&utm_source=newsletter&utm_medium=email&utm_campaign=summer#pricing"; updated.OriginalURL != want {
+		t.Errorf("OriginalURL = %s, want %s", updated.OriginalURL, want)
+	}
+	if !strings.HasSuffix(updated.Variants[0].URL, "utm_campaign=summer") || updated.Variants[0].Weight != 1 {
+		t.Errorf("Expected the variants to keep their weights and get the new campaign, got %+v", updated.Variants)
+	}
+
+	// Someone else's link is neither read nor rewritten.
+	if _, err := urlSvc.UpdateURL("spring", models.UpdateURLRequest{UTM: &models.UTM{Campaign: "stolen"}}, "mallory"); !errors.Is(err, services.ErrForbidden) {
+		t.Errorf("UTM update by another user: got %v, want ErrForbidden", err)
+	}
+
+	newURL := "https://example.com/new"
+	updated, err = urlSvc.UpdateURL("spring", models.UpdateURLRequest{OriginalURL: &newURL, UTM: &models.UTM{Source: "ads"}}, "alice")
+	if err != nil {
+		t.Fatalf("update failed: %v", err)
+	}
+	if updated.OriginalURL != "https://example.com/new?utm_source=ads" {
+		t.Errorf("Expected the UTM merged into the new URL, got %s", updated.OriginalURL)
+	}
+
+	updated, err = urlSvc.UpdateURL("spring", models.UpdateURLRequest{Winner: "a", UTM: &models.UTM{Campaign: "won"}}, "alice")
+	if err != nil {
+		t.Fatalf("winner with UTM failed: %v", err)
+	}
+	if !strings.HasPrefix(updated.OriginalURL, "https://example.com/a?") || !strings.HasSuffix(updated.OriginalURL, "utm_campaign=won") || len(updated.Variants) != 0 {
+		t.Errorf("Expected the winner's URL with the new campaign, got %+v", updated)
+	}
+
+	long := &models.UTM{Campaign: strings.Repeat("x", 256)}
+	if _, err := urlSvc.ShortenURL(models.ShortenURLRequest{OriginalURL: "https://example.com", UTM: long}, "alice", "127.0.0.1"); !errors.Is(err, services.ErrInvalidUTM) {
+		t.Errorf("Expected ErrInvalidUTM, got %v", err)
+	}
+}
+
+func TestForwardQuery(t *testing.T) {
+	urlSvc, analyticsSvc := newMemoryServices(t)
+	handler := handlers.NewURLHandler(urlSvc, analyticsSvc, nil, nil, config.Default().QR)
+
+	links := []models.ShortenURLRequest{
+		{OriginalURL: "https://example.com/p?utm_source=site&a=1", CustomCode: "forward", ForwardQuery: true},
+		{OriginalURL: "https://example.com/p?a=1", CustomCode: "closed"},
+		{OriginalURL: "https://example.com/p", CustomCode: "tracked", ForwardQuery: true, ClickIDParam: "cid"},
+	}
+	for _, req := range links {
+		if _, err := urlSvc.ShortenURL(req, "alice", "127.0.0.1"); err != nil {
+			t.Fatalf("shorten %s failed: %v", req.CustomCode, err)
+		}
+	}
+
+	if got, want := redirectWithQuery(handler, "forward", "utm_source=twitter&b=2"), "https://example.com/p?a=1&utm_source=twitter&b=2"; got != want {
+		t.Errorf("forward: got %s, want %s", got, want)
+	}
+	if got, want := redirectWithQuery(handler, "closed", "b=2"), "https://example.com/p?a=1"; got != want {
+		t.Errorf("closed: got %s, want %s", got, want)
+	}
+	got := redirectWithQuery(handler, "tracked", "cid=forged&b=2")
+	if strings.Contains(got, "forged") || strings.Count(got, "cid=") != 1 || !strings.Contains(got, "b=2") {
+		t.Errorf("Expected the click ID to replace a forwarded one, got %s", got)
+	}
+}
+
+func TestCampaignAnalytics(t *testing.T) {
+	urlSvc, analyticsSvc := newMemoryServices(t)
+	handler := handlers.NewURLHandler(urlSvc, analyticsSvc, nil, nil, config.Default().QR)
+
+	links := []models.ShortenURLRequest{
+		{OriginalURL: "https://example.com/a", CustomCode: "mail", UTM: &models.UTM{Source: "newsletter", Campaign: "launch"}, ForwardQuery: true},
+		{OriginalURL: "https://example.com/b", CustomCode: "social", UTM: &models.UTM{Source: "twitter", Campaign: "launch"}},
+		{OriginalURL: "https://example.com/c", CustomCode: "plain"},
+	}
+	for _, req := range links {
+		if _, err := urlSvc.ShortenURL(req, "alice", "127.0.0.1"); err != nil {
+			t.Fatalf("shorten %s failed: %v", req.CustomCode, err)
+		}
+	}
+
+	redirectWithQuery(handler, "mail", "")
+	redirectWithQuery(handler, "mail", "utm_campaign=retarget")
+	redirectWithQuery(handler, "social", "")
+	redirectWithQuery(handler, "plain", "utm_campaign=ignored")
+
+	analytics, err := analyticsSvc.GetAnalytics("mail", "alice")
+	if err != nil {
+		t.Fatalf("analytics failed: %v", err)
+	}
+	if len(analytics.ClicksByCampaign) != 2 || analytics.ClicksByCampaign["launch"] != 1 || analytics.ClicksByCampaign["retarget"] != 1 {
+		t.Errorf("Expected one click each for launch and retarget, got %v", analytics.ClicksByCampaign)
+	}
+
+	campaigns, err := analyticsSvc.CampaignStats("alice")
+	if err != nil {
+		t.Fatalf("CampaignStats failed: %v", err)
+	}
+	want := []models.CampaignStats{
+		{Campaign: "launch", Links: 2, Clicks: 2, UniqueVisitors: 1},
+		{Campaign: "retarget", Links: 1, Clicks: 1, UniqueVisitors: 1},
+	}
+	if len(campaigns) != len(want) || campaigns[0] != want[0] || campaigns[1] != want[1] {
+		t.Errorf("CampaignStats = %+v, want %+v", campaigns, want)
+	}
+
+	if campaigns, err := analyticsSvc.CampaignStats("bob"); err != nil || len(campaigns) != 0 {
+		t.Errorf("Expected no campaigns for another user, got %+v, %v", campaigns, err)
+	}
+	if _, err := analyticsSvc.CampaignStats(""); !errors.Is(err, services.ErrUnauthorized) {
+		t.Errorf("Expected ErrUnauthorized for anonymous requests, got %v", err)
+	}
+}
@@ -0,0 +1,235 @@
+package tests
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"url-shortener/internal/config"
+	"url-shortener/internal/handlers"
+	"url-shortener/internal/models"
+	"url-shortener/internal/services"
+
+	"github.com/gorilla/mux"
+)
+
+// getVariant requests /{shortCode} from ip, with the variant cookie when
+// variant is set.
+func getVariant(handler *handlers.URLHandler, shortCode, ip, variant string) *httptest.ResponseRecorder {
+	req := mux.SetURLVars(httptest.NewRequest("GET", "/"+shortCode, nil), map[string]string{"shortCode": shortCode})
+	req.Header.Set("X-Forwarded-For", ip)
+	if variant != "" {
+		req.AddCookie(&http.Cookie{Name: "variant_" + shortCode, Value: variant})
+	}
+	rr := httptest.NewRecorder()
+	handler.RedirectURL(rr, req)
+	return rr
+}
+
+func variantCookie(rr *httptest.ResponseRecorder, shortCode string) *http.Cookie {
+	for _, cookie := range rr.Result().Cookies() {
+		if cookie.Name == "variant_"+shortCode {
+			return cookie
+		}
+	}
+	return nil
+}
+
+func TestVariantSplit(t *testing.T) {
+	urlSvc, analyticsSvc := newMemoryServices(t)
+	handler := handlers.NewURLHandler(urlSvc, analyticsSvc, nil, nil, config.Default().QR)
+
+	req := models.ShortenURLRequest{
+		OriginalURL:  "https://example.com/landing",
+		CustomCode:   "split",
+		RedirectType: http.StatusMovedPermanently,
+		Variants: []models.Variant{
+			{URL: "https://example.com/a"},
+			{Name: "green", URL: "https://example.com/green"},
+		},
+	}
+	created, err := urlSvc.ShortenURL(req, "alice", "127.0.0.1")
+	if err != nil {
+		t.Fatalf("shorten failed: %v", err)
+	}
+	want := []models.Variant{{Name: "a", URL: "https://example.com/a", Weight: 1}, {Name: "green", URL: "https://example.com/green", Weight: 1}}
+	if len(created.Variants) != 2 || created.Variants[0] != want[0] || created.Variants[1] != want[1] {
+		t.Errorf("Expected default names and an even split, got %+v", created.Variants)
+	}
+
+	locations := map[string]string{"a": "https://example.com/a", "green": "https://example.com/green"}
+	counts := make(map[string]int)
+	for i := 0; i < 200; i++ {
+		rr := getVariant(handler, "split", fmt.Sprintf("10.0.0.%d", i), "")
+		cookie := variantCookie(rr, "split")
+		if cookie == nil {
+			t.Fatalf("Expected a variant cookie, got headers %v", rr.Header())
+		}
+		if rr.Code != http.StatusMovedPermanently || rr.Header().Get("Location") != locations[cookie.Value] {
+			t.Fatalf("variant %q: got %d to %q", cookie.Value, rr.Code, rr.Header().Get("Location"))
+		}
+		if cookie.Path != "/split" || !cookie.HttpOnly {
+			t.Errorf("Expected an HttpOnly cookie scoped to the link, got %+v", cookie)
+		}
+		if cc := rr.Header().Get("Cache-Control"); !strings.Contains(cc, "no-store") {
+			t.Errorf("Expected split redirects not to be cached, got %q", cc)
+		}
+		counts[cookie.Value]++
+	}
+	if counts["a"] < 50 || counts["green"] < 50 {
+		t.Errorf("Expected both variants to get a share of 200 visitors, got %v", counts)
+	}
+
+	// Returning visitors keep their variant and are not sent a new cookie.
+	for i := 0; i < 10; i++ {
+		rr := getVariant(handler, "split", "10.0.1.1", "green")
+		if rr.Header().Get("Location") != "https://example.com/green" || variantCookie(rr, "split") != nil {
+			t.Fatalf("Expected a sticky visit to green, got %q, %v", rr.Header().Get("Location"), rr.Header()["Set-Cookie"])
+		}
+	}
+
+	// Pausing a variant moves its visitors to the others.
+	weights := []models.Variant{{Name: "a", URL: "https://example.com/a", Weight: 1}, {Name: "green", URL: "https://example.com/green", Weight: 0}}
+	if _, err := urlSvc.UpdateURL("split", models.UpdateURLRequest{Variants: &weights}, "alice"); err != nil {
+		t.Fatalf("updating weights failed: %v", err)
+	}
+	rr := getVariant(handler, "split", "10.0.1.1", "green")
+	if cookie := variantCookie(rr, "split"); rr.Header().Get("Location") != "https://example.com/a" || cookie == nil || cookie.Value != "a" {
+		t.Errorf("Expected a paused variant's visitor to move to a, got %q, %+v", rr.Header().Get("Location"), cookie)
+	}
+
+	analytics, err := analyticsSvc.GetAnalytics("split", "alice")
+	if err != nil {
+		t.Fatalf("analytics failed: %v", err)
+	}
+	if len(analytics.Variants) != 2 {
+		t.Fatalf("Expected stats for 2 variants, got %+v", analytics.Variants)
+	}
+	a, green := analytics.Variants[0], analytics.Variants[1]
+	if a.Name != "a" || a.Clicks != counts["a"]+1 || a.UniqueVisitors != counts["a"]+1 || a.Weight != 1 {
+		t.Errorf("Expected %d clicks and visitors for a, got %+v", counts["a"]+1, a)
+	}
+	if green.Name != "green" || green.Clicks != counts["green"]+10 || green.UniqueVisitors != counts["green"]+1 || green.Weight != 0 {
+		t.Errorf("Expected %d clicks by %d visitors for green, got %+v", counts["green"]+10, counts["green"]+1, green)
+	}
+}
+
+func TestDeclareVariantWinner(t *testing.T) {
+	urlSvc, analyticsSvc := newMemoryServices(t)
+	handler := handlers.NewURLHandler(urlSvc, analyticsSvc, nil, nil, config.Default().QR)
+
+	req := models.ShortenURLRequest{
+		OriginalURL: "https://example.com/landing",
+		CustomCode:  "winner",
+		Variants: []models.Variant{
+			{Name: "a", URL: "https://example.com/a", Weight: 1},
+			{Name: "b", URL: "https://example.com/b", Weight: 1},
+		},
+	}
+	if _, err := urlSvc.ShortenURL(req, "alice", "127.0.0.1"); err != nil {
+		t.Fatalf("shorten failed: %v", err)
+	}
+	getVariant(handler, "winner", "10.0.0.1", "a")
+	getVariant(handler, "winner", "10.0.0.2", "b")
+
+	if _, err := urlSvc.UpdateURL("winner", models.UpdateURLRequest{Winner: "c"}, "alice"); !errors.Is(err, services.ErrInvalidVariants) {
+		t.Errorf("unknown winner: got %v, want ErrInvalidVariants", err)
+	}
+	if _, err := urlSvc.UpdateURL("winner", models.UpdateURLRequest{Winner: "b"}, "mallory"); !errors.Is(err, services.ErrForbidden) {
+		t.Errorf("winner by another user: got %v, want ErrForbidden", err)
+	}
+	if _, err := urlSvc.UpdateURL("winner", models.UpdateURLRequest{Winner: "c"}, "mallory"); !errors.Is(err, services.ErrForbidden) {
+		t.Errorf("unknown winner by another user: got %v, want ErrForbidden", err)
+	}
+
+	updated, err := urlSvc.UpdateURL("winner", models.UpdateURLRequest{Winner: "b"}, "alice")
+	if err != nil {
+		t.Fatalf("declaring a winner failed: %v", err)
+	}
+	if updated.OriginalURL != "https://example.com/b" || len(updated.Variants) != 0 {
+		t.Errorf("Expected the link to collapse to b, got %+v", updated)
+	}
+
+	rr := getVariant(handler, "winner", "10.0.0.1", "a")
+	if rr.Header().Get("Location") != "https://example.com/b" || variantCookie(rr, "winner") != nil {
+		t.Errorf("Expected everyone to go to the winner, got %q, %v", rr.Header().Get("Location"), rr.Header()["Set-Cookie"])
+	}
+
+	analytics, err := analyticsSvc.GetAnalytics("winner", "alice")
+	if err != nil {
+		t.Fatalf("analytics failed: %v", err)
+	}
+	want := []models.VariantStats{{Name: "a", Clicks: 1, UniqueVisitors: 1}, {Name: "b", Clicks: 1, UniqueVisitors: 1}}
+	if len(analytics.Variants) != 2 || analytics.Variants[0] != want[0] || analytics.Variants[1] != want[1] {
+		t.Errorf("Expected the earlier variants' stats %+v, got %+v", want, analytics.Variants)
+	}
+}
+
+func TestVariantsAfterTargetingRules(t *testing.T) {
+	urlSvc, analyticsSvc := newMemoryServices(t)
+	handler := handlers.NewURLHandler(urlSvc, analyticsSvc, nil, nil, config.Default().QR)
+
+	req := models.ShortenURLRequest{
+		OriginalURL:    "https://example.com/landing",
+		CustomCode:     "ruled",
+		TargetingRules: []models.TargetingRule{{OS: "ios", URL: "https://apps.apple.com/app/id1"}},
+		Variants: []models.Variant{
+			{Name: "a", URL: "https://example.com/a", Weight: 1},
+			{Name: "b", URL: "https://example.com/b", Weight: 0},
+		},
+	}
+	if _, err := urlSvc.ShortenURL(req, "alice", "127.0.0.1"); err != nil {
+		t.Fatalf("shorten failed: %v", err)
+	}
+
+	httpReq := mux.SetURLVars(httptest.NewRequest("GET", "/ruled", nil), map[string]string{"shortCode": "ruled"})
+	httpReq.Header.Set("User-Agent", iPhoneUA)
+	rr := httptest.NewRecorder()
+	handler.RedirectURL(rr, httpReq)
+	if rr.Header().Get("Location") != "https://apps.apple.com/app/id1" || variantCookie(rr, "ruled") != nil {
+		t.Errorf("Expected a matching rule to win over the split, got %q, %v", rr.Header().Get("Location"), rr.Header()["Set-Cookie"])
+	}
+
+	rr = getVariant(handler, "ruled", "10.0.0.1", "")
+	if rr.Header().Get("Location") != "https://example.com/a" {
+		t.Errorf("Expected other visitors to be split, got %q", rr.Header().Get("Location"))
+	}
+}
+
+func TestInvalidVariants(t *testing.T) {
+	urlSvc, _ := newMemoryServices(t)
+
+	cases := map[string][]models.Variant{
+		"single":          {{URL: "https://example.com/a"}},
+		"bad url":         {{URL: "https://example.com/a"}, {URL: "ftp://example.com/b"}},
+		"negative weight": {{URL: "https://example.com/a", Weight: 1}, {URL: "https://example.com/b", Weight: -1}},
+		"duplicate name":  {{URL: "https://example.com/a"}, {Name: "a", URL: "https://example.com/b"}},
+		"bad name":        {{Name: "new page", URL: "https://example.com/a"}, {URL: "https://example.com/b"}},
+	}
+	for name, variants := range cases {
+		req := models.ShortenURLRequest{OriginalURL: "https://example.com", Variants: variants}
+		if _, err := urlSvc.ShortenURL(req, "alice", "127.0.0.1"); !errors.Is(err, services.ErrInvalidVariants) {
+			t.Errorf("%s: got %v, want ErrInvalidVariants", name, err)
+		}
+	}
+
+	req := models.ShortenURLRequest{
+		OriginalURL: "https://example.com",
+		CustomCode:  "variants",
+		Variants:    []models.Variant{{URL: "https://example.com/a"}, {URL: "https://example.com/b"}},
+	}
+	if _, err := urlSvc.ShortenURL(req, "alice", "127.0.0.1"); err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	bad := cases["single"]
+	if _, err := urlSvc.UpdateURL("variants", models.UpdateURLRequest{Variants: &bad}, "alice"); !errors.Is(err, services.ErrInvalidVariants) {
+		t.Errorf("update: got %v, want ErrInvalidVariants", err)
+	}
+	good := req.Variants
+	if _, err := urlSvc.UpdateURL("variants", models.UpdateURLRequest{Variants: &good, Winner: "a"}, "alice"); !errors.Is(err, services.ErrInvalidVariants) {
+		t.Errorf("winner with variants: got %v, want ErrInvalidVariants", err)
+	}
+}